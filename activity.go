@@ -0,0 +1,160 @@
+package ekanite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// maxQuerySummary is the most bytes of the query of a running search listed.
+const maxQuerySummary = 256
+
+// SearchActivity is a search running on the engine.
+type SearchActivity struct {
+	ID      string        `json:"id"`
+	Query   string        `json:"query"` // JSON of the query, truncated.
+	Caller  string        `json:"caller,omitempty"`
+	Indexes []string      `json:"indexes"`
+	Started time.Time     `json:"started"`
+	Elapsed time.Duration `json:"elapsed"`
+	Memory  int64         `json:"memory"` // Estimated bytes held.
+}
+
+// runningSearch is a search running, and how to cancel it.
+type runningSearch struct {
+	SearchActivity
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// activityRegistry tracks the searches running on the engine.
+type activityRegistry struct {
+	mu      sync.Mutex
+	next    uint64
+	running map[string]*runningSearch
+}
+
+type searchCallerKey struct{}
+
+// WithSearchCaller returns a context whose searches are listed as made by
+// caller, such as the role or address of a request, or a continuous query.
+func WithSearchCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, searchCallerKey{}, caller)
+}
+
+// searchCaller returns the caller of the searches with ctx, empty if unknown.
+func searchCaller(ctx context.Context) string {
+	caller, _ := ctx.Value(searchCallerKey{}).(string)
+	return caller
+}
+
+// summarizeQuery returns the JSON of the query of req, truncated.
+func summarizeQuery(req *bleve.SearchRequest) string {
+	if req.Query == nil {
+		return ""
+	}
+	bs, err := json.Marshal(req.Query)
+	if err != nil {
+		return fmt.Sprintf("%T", req.Query)
+	}
+	if len(bs) > maxQuerySummary {
+		return string(bs[:maxQuerySummary]) + "..."
+	}
+	return string(bs)
+}
+
+// start lists the search of req on the indexes, holding memory bytes, until
+// the returned function is called with the error it ended with. It returns
+// the context to run the search with, cancelled by cancel. That function
+// wraps the error as ErrSearchCancelled if the search was cancelled.
+func (a *activityRegistry) start(ctx context.Context, req *bleve.SearchRequest, indexes []*Index, memory int64) (context.Context, func(error) error) {
+	names := make([]string, len(indexes))
+	for n, i := range indexes {
+		names[n] = i.Name()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+
+	a.mu.Lock()
+	a.next++
+	id := strconv.FormatUint(a.next, 10)
+	r := &runningSearch{
+		SearchActivity: SearchActivity{
+			ID:      id,
+			Query:   summarizeQuery(req),
+			Caller:  searchCaller(ctx),
+			Indexes: names,
+			Started: time.Now(),
+			Memory:  memory,
+		},
+		cancel: cancel,
+	}
+	if a.running == nil {
+		a.running = map[string]*runningSearch{}
+	}
+	a.running[id] = r
+	a.mu.Unlock()
+
+	return ctx, func(err error) error {
+		a.mu.Lock()
+		delete(a.running, id)
+		cancelled := r.cancelled
+		a.mu.Unlock()
+		cancel()
+		if cancelled && err != nil {
+			return fmt.Errorf("%w: %v", ErrSearchCancelled, err)
+		}
+		return err
+	}
+}
+
+// list returns the searches running, oldest first.
+func (a *activityRegistry) list() []SearchActivity {
+	now := time.Now()
+	a.mu.Lock()
+	list := make([]SearchActivity, 0, len(a.running))
+	for _, r := range a.running {
+		s := r.SearchActivity
+		s.Elapsed = now.Sub(s.Started)
+		list = append(list, s)
+	}
+	a.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Started.Equal(list[j].Started) {
+			return list[i].Started.Before(list[j].Started)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// cancel cancels the search id.
+func (a *activityRegistry) cancel(id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r, ok := a.running[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSearchNotFound, id)
+	}
+	r.cancelled = true
+	r.cancel()
+	stats.Add("searchesCancelled", 1)
+	return nil
+}
+
+// Activity returns the searches running on the engine, oldest first.
+func (e *Engine) Activity() []SearchActivity {
+	return e.activity.list()
+}
+
+// CancelSearch cancels the running search id, which then fails with
+// ErrSearchCancelled.
+func (e *Engine) CancelSearch(id string) error {
+	return e.activity.cancel(id)
+}
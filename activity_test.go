@@ -0,0 +1,58 @@
+package ekanite
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+)
+
+// TestActivityRegistry tests that running searches are listed, and can be
+// cancelled.
+func TestActivityRegistry(t *testing.T) {
+	var a activityRegistry
+	req := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	indexes := []*Index{{path: "/data/20170101_0000"}}
+
+	ctx1, done1 := a.start(WithSearchCaller(context.Background(), "ops"), req, indexes, 100)
+	ctx2, done2 := a.start(context.Background(), req, nil, 200)
+	list := a.list()
+	if len(list) != 2 {
+		t.Fatalf("%d searches listed, want 2", len(list))
+	}
+	if list[0].ID != "1" || list[0].Caller != "ops" || list[0].Memory != 100 ||
+		len(list[0].Indexes) != 1 || list[0].Indexes[0] != "20170101_0000" || list[0].Query == "" {
+		t.Fatalf("first search listed as %+v", list[0])
+	}
+	if list[1].ID != "2" || list[1].Caller != "" {
+		t.Fatalf("second search listed as %+v", list[1])
+	}
+
+	if err := a.cancel("3"); !errors.Is(err, ErrSearchNotFound) {
+		t.Fatalf("cancelling unknown search returned %v", err)
+	}
+	if err := a.cancel("1"); err != nil {
+		t.Fatalf("failed to cancel search: %s", err)
+	}
+	select {
+	case <-ctx1.Done():
+	default:
+		t.Fatal("cancelled search not done")
+	}
+	if ctx2.Err() != nil {
+		t.Fatal("other search cancelled")
+	}
+	if err := done1(ctx1.Err()); !errors.Is(err, ErrSearchCancelled) {
+		t.Fatalf("cancelled search failed with %v", err)
+	}
+	if err := done2(nil); err != nil {
+		t.Fatalf("search failed with %v", err)
+	}
+	if list := a.list(); len(list) != 0 {
+		t.Fatalf("searches still listed once done: %+v", list)
+	}
+	if err := a.cancel("2"); !errors.Is(err, ErrSearchNotFound) {
+		t.Fatalf("cancelling finished search returned %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package ekanite
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// SystemActor is the actor of the operations the engine takes by itself, such
+// as retention enforcement.
+const SystemActor = "system"
+
+// AuditEntry is a mutating operation recorded in an AuditLog.
+type AuditEntry struct {
+	Time    time.Time       `json:"time"`
+	Actor   string          `json:"actor"`
+	Action  string          `json:"action"`
+	Target  string          `json:"target,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// AuditLog records the operations changing the filters, the subscriptions and
+// the indexes, whoever the actor, as lines of JSON appended to a file. Entries
+// are never rewritten. A nil AuditLog records nothing.
+type AuditLog struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// OpenAuditLog opens the audit log at path, creating it if needed.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{path: path, f: f}, nil
+}
+
+// Record appends an entry for the action of actor on target, with payload
+// encoded as JSON if not nil, and syncs it to disk.
+func (l *AuditLog) Record(actor, action, target string, payload interface{}) error {
+	if l == nil {
+		return nil
+	}
+	entry := AuditEntry{Time: time.Now().UTC(), Actor: actor, Action: action, Target: target}
+	if payload != nil {
+		bs, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		entry.Payload = bs
+	}
+	bs, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(bs, '\n')); err != nil {
+		return err
+	}
+	stats.Add("auditEntries", 1)
+	return l.f.Sync()
+}
+
+// Entries returns the entries recorded since the given time, oldest first. If
+// limit is positive, only the latest limit of them are returned.
+func (l *AuditLog) Entries(since time.Time, limit int) ([]AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		if entry.Time.Before(since) {
+			continue
+		}
+		entries = append(entries, entry)
+		if limit > 0 && len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Close closes the audit log.
+func (l *AuditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
@@ -0,0 +1,65 @@
+package ekanite
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := tempPath()
+	defer os.Remove(path)
+
+	l, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %s", err)
+	}
+	if err := l.Record("admin", "filter.create", "1", map[string]string{"name": "errors"}); err != nil {
+		t.Fatalf("failed to record entry: %s", err)
+	}
+	if err := l.Record(SystemActor, "retention.delete", "20180101_0000", nil); err != nil {
+		t.Fatalf("failed to record entry: %s", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("failed to close audit log: %s", err)
+	}
+
+	// Entries survive reopening, and new ones are appended.
+	l, err = OpenAuditLog(path)
+	if err != nil {
+		t.Fatalf("failed to reopen audit log: %s", err)
+	}
+	defer l.Close()
+	if err := l.Record("10.0.0.1:5000", "index.hold", "20180101_0000", nil); err != nil {
+		t.Fatalf("failed to record entry: %s", err)
+	}
+
+	entries, err := l.Entries(time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("failed to read entries: %s", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, expected 3", len(entries))
+	}
+	if e := entries[0]; e.Actor != "admin" || e.Action != "filter.create" || e.Target != "1" || string(e.Payload) != `{"name":"errors"}` {
+		t.Errorf("first entry is %+v", e)
+	}
+	if e := entries[1]; e.Actor != SystemActor || e.Payload != nil {
+		t.Errorf("second entry is %+v", e)
+	}
+	if e := entries[2]; e.Action != "index.hold" {
+		t.Errorf("third entry is %+v", e)
+	}
+
+	if entries, _ = l.Entries(time.Time{}, 1); len(entries) != 1 || entries[0].Action != "index.hold" {
+		t.Errorf("limited entries are %+v", entries)
+	}
+	if entries, _ = l.Entries(time.Now().Add(time.Hour), 0); len(entries) != 0 {
+		t.Errorf("got %d entries in the future", len(entries))
+	}
+
+	var nilLog *AuditLog
+	if err := nilLog.Record("admin", "filter.delete", "1", nil); err != nil {
+		t.Errorf("nil audit log failed to record: %s", err)
+	}
+}
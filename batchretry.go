@@ -0,0 +1,234 @@
+package ekanite
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultBatchRetries is how many times a batch failing to be indexed is
+	// retried in memory by default, before being spilled or dropped.
+	DefaultBatchRetries = 3
+
+	// DefaultBatchRetryBackoff is the wait before the first retry of a batch
+	// by default, doubled before each of the next.
+	DefaultBatchRetryBackoff = 500 * time.Millisecond
+
+	// spillReplayInterval is how often spilled batches are replayed while
+	// no batch is indexed to trigger it.
+	spillReplayInterval = 5 * time.Second
+
+	spillExt = ".batch"
+)
+
+func init() {
+	// Types found in the fields of the documents, registered so that they
+	// can be spilled.
+	gob.Register(time.Time{})
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+}
+
+// failedBatch is a batch waiting to be retried.
+type failedBatch struct {
+	docs     []Document
+	attempts int       // Times the batch failed to be indexed.
+	next     time.Time // When the batch is retried.
+}
+
+// spilledDoc is a document as spilled to disk, and replayed from it.
+type spilledDoc struct {
+	DocID  DocID
+	Time   time.Time
+	Fields interface{}
+}
+
+func (d *spilledDoc) ID() DocID                { return d.DocID }
+func (d *spilledDoc) Data() interface{}        { return d.Fields }
+func (d *spilledDoc) ReferenceTime() time.Time { return d.Time }
+
+// index indexes a batch, which failed attempts times before. A batch failing
+// is retried after a backoff, then spilled once out of retries; errors only
+// go to errChan once the batch is given up on.
+func (b *Batcher) index(batch []Document, attempts int, errChan chan<- error) {
+	if err := b.indexer.Index(batch); err != nil {
+		stats.Add("batchIndexedError", 1)
+		b.failed(batch, attempts+1, err, errChan)
+		return
+	}
+	b.lag.record(time.Now(), batch)
+	stats.Add("batchIndexed", 1)
+	stats.Add("eventsIndexed", int64(len(batch)))
+	release(batch)
+	// The engine is healthy again, so catch up with what was spilled.
+	b.replay()
+	if errChan != nil {
+		errChan <- nil
+	}
+}
+
+// failed handles a batch which failed to be indexed attempts times with err.
+func (b *Batcher) failed(batch []Document, attempts int, err error, errChan chan<- error) {
+	if attempts <= b.Retries {
+		backoff := b.RetryBackoff << uint(attempts-1)
+		b.retrying = append(b.retrying, &failedBatch{docs: batch, attempts: attempts, next: time.Now().Add(backoff)})
+		atomic.AddInt64(&b.retryingEvents, int64(len(batch)))
+		stats.Add("batchRetried", 1)
+		return
+	}
+
+	if b.SpillDir == "" {
+		err = fmt.Errorf("dropped batch of %d events after %d attempts: %w", len(batch), attempts, err)
+	} else if serr := b.spill(batch); serr != nil {
+		err = fmt.Errorf("dropped batch of %d events after %d attempts, %s: %w", len(batch), attempts, serr.Error(), err)
+	} else {
+		stats.Add("batchSpilled", 1)
+		stats.Add("eventsSpilled", int64(len(batch)))
+		release(batch)
+		return
+	}
+	stats.Add("batchDropped", 1)
+	stats.Add("eventsDropped", int64(len(batch)))
+	release(batch)
+	if errChan != nil {
+		errChan <- err
+	}
+}
+
+// retryDue retries the batches whose backoff is over.
+func (b *Batcher) retryDue(errChan chan<- error) {
+	now := time.Now()
+	var due []*failedBatch
+	waiting := b.retrying[:0]
+	for _, f := range b.retrying {
+		if f.next.After(now) {
+			waiting = append(waiting, f)
+		} else {
+			due = append(due, f)
+		}
+	}
+	b.retrying = waiting
+
+	for _, f := range due {
+		atomic.AddInt64(&b.retryingEvents, -int64(len(f.docs)))
+		b.index(f.docs, f.attempts, errChan)
+	}
+	if len(due) == 0 && b.spilled > 0 {
+		b.replay()
+	}
+}
+
+// retryAfter returns a channel firing once a batch is due to be retried, or
+// spilled batches are to be replayed, nil if there is none.
+func (b *Batcher) retryAfter() <-chan time.Time {
+	if len(b.retrying) == 0 {
+		if b.spilled > 0 {
+			return time.After(spillReplayInterval)
+		}
+		return nil
+	}
+	next := b.retrying[0].next
+	for _, f := range b.retrying[1:] {
+		if f.next.Before(next) {
+			next = f.next
+		}
+	}
+	return time.After(time.Until(next))
+}
+
+// spill writes a batch to a file of SpillDir, to be replayed later.
+func (b *Batcher) spill(batch []Document) error {
+	docs := make([]*spilledDoc, len(batch))
+	for i, d := range batch {
+		docs[i] = &spilledDoc{DocID: d.ID(), Time: d.ReferenceTime(), Fields: d.Data()}
+	}
+
+	b.spillSeq++
+	path := filepath.Join(b.SpillDir, fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), b.spillSeq%1000000, spillExt))
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to spill batch: %w", err)
+	}
+	if err = gob.NewEncoder(f).Encode(docs); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to spill batch to %s: %w", path, err)
+	}
+	b.spilled++
+	return nil
+}
+
+// spillFiles returns the paths of the spilled batches, oldest first.
+func (b *Batcher) spillFiles() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(b.SpillDir, "*"+spillExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// replay indexes the oldest spilled batch, removing it once indexed. Only one
+// is replayed at a time, so that events being received are not held up for
+// long while catching up.
+func (b *Batcher) replay() {
+	if b.spilled == 0 {
+		return
+	}
+	paths, err := b.spillFiles()
+	if err != nil || len(paths) == 0 {
+		b.spilled = len(paths)
+		return
+	}
+	path := paths[0]
+
+	var docs []*spilledDoc
+	f, err := os.Open(path)
+	if err == nil {
+		err = gob.NewDecoder(f).Decode(&docs)
+		f.Close()
+	}
+	if err != nil {
+		// Set aside, so that it is not replayed again.
+		os.Rename(path, path+".bad")
+		b.spilled--
+		stats.Add("batchReplayedError", 1)
+		return
+	}
+
+	batch := make([]Document, len(docs))
+	for i, d := range docs {
+		batch[i] = d
+	}
+	if err := b.indexer.Index(batch); err != nil {
+		stats.Add("batchReplayedError", 1)
+		return
+	}
+	os.Remove(path)
+	b.spilled--
+	stats.Add("batchReplayed", 1)
+	stats.Add("eventsReplayed", int64(len(batch)))
+}
+
+// release recycles the documents of an indexed or dropped batch.
+func release(batch []Document) {
+	for _, d := range batch {
+		if r, ok := d.(Releaser); ok {
+			r.Release()
+		}
+	}
+}
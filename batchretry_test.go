@@ -0,0 +1,137 @@
+package ekanite
+
+import (
+	"encoding/gob"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FlakyIndexer fails to index the first Failures batches.
+type FlakyIndexer struct {
+	mu       sync.Mutex
+	Failures int
+	EventsRx []DocID
+}
+
+func (f *FlakyIndexer) Index(b []Document) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Failures > 0 {
+		f.Failures--
+		return errors.New("index unavailable")
+	}
+	for _, d := range b {
+		f.EventsRx = append(f.EventsRx, d.ID())
+	}
+	return nil
+}
+
+func (f *FlakyIndexer) received() []DocID {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]DocID(nil), f.EventsRx...)
+}
+
+// TestBatcher_Retry tests that a batch failing to be indexed is retried.
+func TestBatcher_Retry(t *testing.T) {
+	i := &FlakyIndexer{Failures: 2}
+	b := NewBatcher(i, 1, time.Hour, 0)
+	b.RetryBackoff = time.Millisecond
+
+	c := make(chan error)
+	if err := b.Start(c); err != nil {
+		t.Fatalf("failed start batcher: %s", err.Error())
+	}
+	e := newInputEvent("", time.Now())
+	b.C() <- e
+	if err := <-c; err != nil {
+		t.Fatalf("failed to index retried event: %s", err.Error())
+	}
+	if rx := i.received(); len(rx) != 1 || rx[0] != e.ID() {
+		t.Fatalf("indexer received %v", rx)
+	}
+}
+
+// TestBatcher_Drop tests that a batch out of retries is dropped without a spill directory.
+func TestBatcher_Drop(t *testing.T) {
+	i := &FlakyIndexer{Failures: 2}
+	b := NewBatcher(i, 1, time.Hour, 0)
+	b.Retries = 1
+	b.RetryBackoff = time.Millisecond
+
+	c := make(chan error)
+	if err := b.Start(c); err != nil {
+		t.Fatalf("failed start batcher: %s", err.Error())
+	}
+	b.C() <- newInputEvent("", time.Now())
+	if err := <-c; err == nil {
+		t.Fatal("dropped batch not reported")
+	}
+	b.C() <- newInputEvent("", time.Now())
+	if err := <-c; err != nil {
+		t.Fatalf("failed to index event after dropped batch: %s", err.Error())
+	}
+	if rx := i.received(); len(rx) != 1 {
+		t.Fatalf("indexer received %v", rx)
+	}
+}
+
+// TestBatcher_Spill tests that a batch out of retries is spilled, and replayed
+// once indexing works again, also after a restart.
+func TestBatcher_Spill(t *testing.T) {
+	spillDir, err := ioutil.TempDir("", "ekanite-spill-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(spillDir)
+	gob.Register(&testEvent{})
+	spilled := func() int {
+		paths, _ := filepath.Glob(filepath.Join(spillDir, "*.batch"))
+		return len(paths)
+	}
+
+	i := &FlakyIndexer{Failures: 2}
+	b := NewBatcher(i, 2, time.Hour, 0)
+	b.Retries = 1
+	b.RetryBackoff = time.Millisecond
+	b.SpillDir = spillDir
+	c := make(chan error)
+	if err := b.Start(c); err != nil {
+		t.Fatalf("failed start batcher: %s", err.Error())
+	}
+
+	lost := []Document{newInputEvent("", time.Now()), newInputEvent("", time.Now())}
+	for _, e := range lost {
+		b.C() <- e
+	}
+	for deadline := time.Now().Add(5 * time.Second); spilled() != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("failed batch not spilled")
+		}
+	}
+
+	// A restarted batcher replays the spilled batch after indexing a batch.
+	i = &FlakyIndexer{}
+	b = NewBatcher(i, 1, time.Hour, 0)
+	b.SpillDir = spillDir
+	if err := b.Start(c); err != nil {
+		t.Fatalf("failed start batcher: %s", err.Error())
+	}
+	e := newInputEvent("", time.Now())
+	b.C() <- e
+	if err := <-c; err != nil {
+		t.Fatalf("failed to index event: %s", err.Error())
+	}
+	if spilled() != 0 {
+		t.Fatal("spilled batch not removed once replayed")
+	}
+	rx := i.received()
+	if len(rx) != 3 || rx[0] != e.ID() || rx[1] != lost[0].ID() || rx[2] != lost[1].ID() {
+		t.Fatalf("indexer received %v", rx)
+	}
+}
@@ -0,0 +1,54 @@
+package ekanite
+
+import (
+	"sync"
+
+	"github.com/blevesearch/bleve"
+)
+
+// searchHitCost is the estimated number of bytes held per hit, per index,
+// while a search is executing.
+const searchHitCost = 2 << 10
+
+// searchCost estimates the memory needed to run req across n indexes.
+func searchCost(req *bleve.SearchRequest, n int) int64 {
+	hits := req.From + req.Size
+	if hits < 1 {
+		hits = 1
+	}
+	return int64(hits) * int64(n) * searchHitCost
+}
+
+// memoryBudget tracks the estimated memory held by running searches.
+type memoryBudget struct {
+	mu   sync.Mutex
+	used int64
+}
+
+// acquire reserves n bytes if that keeps usage within limit. A limit of 0
+// means no limit.
+func (b *memoryBudget) acquire(n, limit int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if limit > 0 && b.used+n > limit {
+		return false
+	}
+	b.used += n
+	stats.Add("searchMemoryInUse", n)
+	return true
+}
+
+// release returns n bytes reserved by acquire.
+func (b *memoryBudget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	stats.Add("searchMemoryInUse", -n)
+}
+
+// inUse returns the number of bytes currently reserved.
+func (b *memoryBudget) inUse() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
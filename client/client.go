@@ -0,0 +1,291 @@
+// Package client is a client of the ekanite HTTP API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ekanite/ekanite"
+	"github.com/ekanite/ekanite/input"
+	"github.com/ekanite/ekanite/service"
+)
+
+// Error is returned for requests the server did not complete.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ekanite: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Client calls the ekanite HTTP API. It is safe for concurrent use.
+type Client struct {
+	URL        string       // Base URL of the API, including any prefix the server is mounted at.
+	HTTPClient *http.Client // Client used for requests, http.DefaultClient if nil.
+	Token      string       // Bearer token sent with requests, if set.
+}
+
+// New returns a Client of the API at the given base URL.
+func New(baseURL string) *Client {
+	return &Client{URL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// SearchOptions narrows and shapes a search. The zero value searches today's
+// indexes with the server defaults.
+type SearchOptions struct {
+	Start, End time.Time // Time range searched, ignored if zero.
+	Indexes    []string  // Names of the indexes searched, instead of those covering the time range.
+	Limit      int       // Maximum documents returned, 0 for the server default.
+	Offset     int       // Documents skipped.
+	Sort       []string  // Fields sorted by, prefixed with - for descending order.
+	Fields     []string  // Fields returned, all of them if empty.
+	Where      string    // Expression returned documents must match.
+	Compute    []string  // Fields added to the documents, as name=expression.
+	Meta       bool      // Whether to add the _index, _shard and _id fields to the documents.
+}
+
+func (o *SearchOptions) values() url.Values {
+	params := url.Values{}
+	if o == nil {
+		return params
+	}
+	if !o.Start.IsZero() {
+		params.Set("start_at", formatTime(o.Start))
+	}
+	if !o.End.IsZero() {
+		params.Set("end_at", formatTime(o.End))
+	}
+	if len(o.Indexes) > 0 {
+		params.Set("indexes", strings.Join(o.Indexes, ","))
+	}
+	if o.Limit > 0 {
+		params.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		params.Set("offset", strconv.Itoa(o.Offset))
+	}
+	for _, sort := range o.Sort {
+		params.Add("sort", sort)
+	}
+	for _, field := range o.Fields {
+		params.Add("fields", field)
+	}
+	if o.Where != "" {
+		params.Set("where", o.Where)
+	}
+	for _, compute := range o.Compute {
+		params.Add("compute", compute)
+	}
+	if o.Meta {
+		params.Set("meta", "true")
+	}
+	return params
+}
+
+// formatTime formats t as epoch milliseconds, which the server parses
+// whatever its time zone.
+func formatTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
+
+// SearchResult holds the documents found by a search.
+type SearchResult struct {
+	Total     uint64                   `json:"total"`
+	Documents []map[string]interface{} `json:"documents"`
+	Warning   string                   `json:"warning,omitempty"` // Why fewer documents may be found than asked for.
+}
+
+// Search returns the documents matching the filters of q.
+func (c *Client) Search(ctx context.Context, q service.Query, opts *SearchOptions) (*SearchResult, error) {
+	var result SearchResult
+	if err := c.do(ctx, "POST", "/query", opts.values(), q, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SearchByFilter returns the documents matching the filter stored with the
+// given ID.
+func (c *Client) SearchByFilter(ctx context.Context, id string, opts *SearchOptions) (*SearchResult, error) {
+	var result SearchResult
+	if err := c.do(ctx, "GET", "/query/"+url.PathEscape(id), opts.values(), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Count returns the number of documents matching the filters of q.
+func (c *Client) Count(ctx context.Context, q service.Query, opts *SearchOptions) (uint64, error) {
+	var total uint64
+	if err := c.do(ctx, "POST", "/query/count", opts.values(), q, &total); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// Estimate returns what searching for the filters of q would cost, without
+// running the search.
+func (c *Client) Estimate(ctx context.Context, q service.Query, opts *SearchOptions) (*ekanite.QueryEstimate, error) {
+	var estimate ekanite.QueryEstimate
+	if err := c.do(ctx, "POST", "/query/_estimate", opts.values(), q, &estimate); err != nil {
+		return nil, err
+	}
+	return &estimate, nil
+}
+
+// ListFilters returns the stored filters.
+func (c *Client) ListFilters(ctx context.Context) ([]service.Query, error) {
+	var filters []service.Query
+	if err := c.do(ctx, "GET", "/filters", nil, nil, &filters); err != nil {
+		return nil, err
+	}
+	return filters, nil
+}
+
+// ReadFilter returns the filter stored with the given ID.
+func (c *Client) ReadFilter(ctx context.Context, id string) (*service.Query, error) {
+	var q service.Query
+	if err := c.do(ctx, "GET", "/filters/"+url.PathEscape(id), nil, nil, &q); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// CreateFilter stores q as a new filter, returning its ID.
+func (c *Client) CreateFilter(ctx context.Context, q service.Query) (string, error) {
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := c.do(ctx, "POST", "/filters", nil, q, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// UpdateFilter replaces the filter stored with the given ID by q.
+func (c *Client) UpdateFilter(ctx context.Context, id string, q service.Query) error {
+	return c.do(ctx, "PUT", "/filters/"+url.PathEscape(id), nil, q, nil)
+}
+
+// DeleteFilter deletes the filter stored with the given ID.
+func (c *Client) DeleteFilter(ctx context.Context, id string) error {
+	return c.do(ctx, "DELETE", "/filters/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// SetContinuousQuery adds, or replaces, the named continuous query of the
+// filter stored with the given ID. The filter is read and written back, so
+// concurrent changes to it may be lost.
+func (c *Client) SetContinuousQuery(ctx context.Context, id, name string, cq service.ContinuousQuery) error {
+	q, err := c.ReadFilter(ctx, id)
+	if err != nil {
+		return err
+	}
+	if q.ContinuousQueries == nil {
+		q.ContinuousQueries = map[string]service.ContinuousQuery{}
+	}
+	q.ContinuousQueries[name] = cq
+	return c.UpdateFilter(ctx, id, *q)
+}
+
+// DeleteContinuousQuery removes the named continuous query of the filter
+// stored with the given ID.
+func (c *Client) DeleteContinuousQuery(ctx context.Context, id, name string) error {
+	q, err := c.ReadFilter(ctx, id)
+	if err != nil {
+		return err
+	}
+	if _, ok := q.ContinuousQueries[name]; !ok {
+		return &Error{StatusCode: http.StatusNotFound, Message: "continuous query " + name + " not found"}
+	}
+	delete(q.ContinuousQueries, name)
+	return c.UpdateFilter(ctx, id, *q)
+}
+
+// Ingest sends events to be indexed.
+func (c *Client) Ingest(ctx context.Context, events []input.Event) error {
+	return c.do(ctx, "POST", "/syslogs", nil, events, nil)
+}
+
+// Backfill sends the historical log files of r, a log file, a tar archive of
+// them, or either gzipped, to be indexed by their own timestamps. Their lines
+// are parsed in format, the server default if empty.
+func (c *Client) Backfill(ctx context.Context, name string, r io.Reader, format string) (*input.BackfillResult, error) {
+	params := url.Values{}
+	params.Set("name", name)
+	if format != "" {
+		params.Set("format", format)
+	}
+	var result input.BackfillResult
+	if err := c.send(ctx, "POST", "/admin/backfill", params, "application/octet-stream", r, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// do sends a request with in encoded as JSON in its body, if not nil, and
+// decodes the JSON response into out, if not nil.
+func (c *Client) do(ctx context.Context, method, path string, params url.Values, in, out interface{}) error {
+	if in == nil {
+		return c.send(ctx, method, path, params, "", nil, out)
+	}
+	bs, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return c.send(ctx, method, path, params, "application/json", bytes.NewReader(bs), out)
+}
+
+// send sends a request with body, of contentType, and decodes the JSON
+// response into out, if not nil.
+func (c *Client) send(ctx context.Context, method, path string, params url.Values, contentType string, body io.Reader, out interface{}) error {
+	u := c.URL + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		bs, _ := ioutil.ReadAll(resp.Body)
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(bs))}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ekanite/ekanite/input"
+	"github.com/ekanite/ekanite/service"
+)
+
+func TestClient_Search(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/query" {
+			http.Error(w, "unexpected request", http.StatusNotFound)
+			return
+		}
+		params := r.URL.Query()
+		if params.Get("start_at") != "381730980000" || params.Get("limit") != "5" ||
+			params.Get("indexes") != "a,b" || params.Get("meta") != "true" {
+			http.Error(w, "unexpected parameters "+r.URL.RawQuery, http.StatusBadRequest)
+			return
+		}
+		var q service.Query
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil || len(q.Filters) != 1 {
+			http.Error(w, "unexpected body", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"total": 1, "documents": [{"message": "auth password accepted"}]}`))
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL + "/api/")
+	q := service.Query{Filters: []service.Filter{{Field: "message", Op: service.OpTerm, Values: []string{"auth"}}}}
+	result, err := c.Search(context.Background(), q, &SearchOptions{
+		Start:   time.Date(1982, 2, 5, 4, 23, 0, 0, time.UTC),
+		Indexes: []string{"a", "b"},
+		Limit:   5,
+		Meta:    true,
+	})
+	if err != nil {
+		t.Fatalf("failed to search: %s", err.Error())
+	}
+	if result.Total != 1 || result.Documents[0]["message"] != "auth password accepted" {
+		t.Fatalf("unexpected search result: %+v", result)
+	}
+}
+
+func TestClient_ContinuousQuery(t *testing.T) {
+	stored := service.Query{ID: "1", Name: "auth"}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/filters/1" {
+			http.Error(w, "record isnot found", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			json.NewEncoder(w).Encode(stored)
+		case "PUT":
+			var q service.Query
+			json.NewDecoder(r.Body).Decode(&q)
+			stored = q
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte("OK"))
+		}
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL)
+	ctx := context.Background()
+	if err := c.SetContinuousQuery(ctx, "1", "by_host", service.ContinuousQuery{GroupBy: "host"}); err != nil {
+		t.Fatalf("failed to set continuous query: %s", err.Error())
+	}
+	if cq, ok := stored.ContinuousQueries["by_host"]; !ok || cq.GroupBy != "host" {
+		t.Fatalf("continuous query not stored: %+v", stored)
+	}
+	if err := c.DeleteContinuousQuery(ctx, "1", "by_host"); err != nil {
+		t.Fatalf("failed to delete continuous query: %s", err.Error())
+	}
+	if len(stored.ContinuousQueries) != 0 {
+		t.Fatalf("continuous query not deleted: %+v", stored)
+	}
+
+	err := c.Ingest(ctx, []input.Event{{Text: "auth password accepted"}})
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusNotFound || e.Message != "record isnot found" {
+		t.Fatalf("unexpected error %#v", err)
+	}
+}
+
+func TestClient_Backfill(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		if r.Method != "POST" || r.URL.Path != "/admin/backfill" ||
+			params.Get("name") != "app.log" || params.Get("format") != "rfc3164" {
+			http.Error(w, "unexpected request "+r.URL.String(), http.StatusNotFound)
+			return
+		}
+		bs, _ := ioutil.ReadAll(r.Body)
+		if string(bs) != "<134>Oct 11 22:14:15 host1 app: first\n" {
+			http.Error(w, "unexpected body", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"files": 1, "lines": 1, "events": 1}`))
+	}))
+	defer ts.Close()
+
+	result, err := New(ts.URL).Backfill(context.Background(), "app.log",
+		strings.NewReader("<134>Oct 11 22:14:15 host1 app: first\n"), "rfc3164")
+	if err != nil {
+		t.Fatalf("failed to backfill: %s", err.Error())
+	}
+	if result.Files != 1 || result.Events != 1 {
+		t.Fatalf("unexpected backfill result: %+v", result)
+	}
+}
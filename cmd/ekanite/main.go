@@ -1,27 +1,67 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/ekanite/ekanite"
+	"github.com/ekanite/ekanite/client"
 )
 
 func main() {
 	var delta time.Duration
 	var format string
+	var repair bool
+	var backfill, parser, token string
 	flag.DurationVar(&delta, "delta", 0, "")
 	flag.StringVar(&format, "format", "", "")
+	flag.BoolVar(&repair, "repair", false, "repair overlapping indexes in the data directory")
+	flag.StringVar(&backfill, "backfill", "", "URL of the ekanite API the log archives are backfilled into")
+	flag.StringVar(&parser, "parser", "", "format the backfilled lines are parsed in, the server default if empty")
+	flag.StringVar(&token, "token", "", "bearer token sent with backfills")
 	flag.CommandLine.Usage = func() {
 		fmt.Println("使用方法：", os.Args[0], "日志目录")
 		fmt.Println("         ", os.Args[0], "-format=csv  日志目录")
+		fmt.Println("         ", os.Args[0], "-repair  数据目录")
+		fmt.Println("         ", os.Args[0], "-backfill=http://127.0.0.1:8080 -parser=rfc3164  日志文件或归档")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 	args := flag.CommandLine.Args()
 
+	if backfill != "" {
+		c := client.New(backfill)
+		c.Token = token
+		for _, name := range args {
+			fmt.Println("*", name)
+			if err := backfillArchive(c, name, parser); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+				return
+			}
+		}
+		fmt.Println("all is ok")
+		return
+	}
+
+	if repair {
+		for _, name := range args {
+			fmt.Println("*", name)
+			if err := repairOverlaps(name); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+				return
+			}
+			fmt.Println("*", name, "is ok")
+		}
+		fmt.Println("all is ok")
+		return
+	}
+
 	create := ekanite.NewShardWriter
 	if format == "csv" {
 		create = func(pa string) (ekanite.Writer, error) {
@@ -41,3 +81,34 @@ func main() {
 
 	fmt.Println("all is ok")
 }
+
+// repairOverlaps opens and closes the engine at path, repairing any
+// overlapping indexes it holds.
+func repairOverlaps(path string) error {
+	engine := ekanite.NewEngine(path)
+	engine.RepairOverlaps = true
+	if err := engine.Open(); err != nil {
+		return err
+	}
+	return engine.Close()
+}
+
+// backfillArchive sends the log file or archive at path to be indexed through
+// c, printing what was read.
+func backfillArchive(c *client.Client, path, format string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	result, err := c.Backfill(context.Background(), filepath.Base(path), f, format)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("* %s: %d events from %d files, %s to %s, %d lines rejected\n", path,
+		result.Events, result.Files, result.Oldest.Format(time.RFC3339), result.Newest.Format(time.RFC3339), result.Rejected)
+	for _, e := range result.Errors {
+		fmt.Println("  ", e)
+	}
+	return nil
+}
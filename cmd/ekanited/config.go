@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// reloadableFlags are the flags whose changes are applied on reload. Changes
+// to the others are only reported, as they need a restart.
+var reloadableFlags = map[string]bool{
+	"retention":     true,
+	"searchmem":     true,
+	"rolloverdocs":  true,
+	"rolloverbytes": true,
+	"fieldtypes":    true,
+	"severityrules": true,
+}
+
+// config tracks the flags set by a config file, which holds one flag per line
+// in the form name = value. Blank lines and lines starting with # are ignored.
+// Flags set on the command line take precedence over the file.
+type config struct {
+	path    string
+	fs      *flag.FlagSet
+	cmdline map[string]bool   // Flags set on the command line.
+	values  map[string]string // Flags last read from the file.
+}
+
+// newConfig returns the config read from the file at path, after setting the
+// flags it holds. fs must already be parsed.
+func newConfig(fs *flag.FlagSet, path string) (*config, error) {
+	c := &config{path: path, fs: fs, cmdline: map[string]bool{}, values: map[string]string{}}
+	fs.Visit(func(f *flag.Flag) {
+		c.cmdline[f.Name] = true
+	})
+	values, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range values {
+		if err := c.set(name, value); err != nil {
+			return nil, err
+		}
+	}
+	c.values = values
+	return c, nil
+}
+
+// read reads the flags held by the config file.
+func (c *config) read() (map[string]string, error) {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ss := strings.SplitN(line, "=", 2)
+		if len(ss) != 2 {
+			return nil, fmt.Errorf("%s:%d: not in the form name = value", c.path, n)
+		}
+		name := strings.TrimSpace(ss[0])
+		if c.fs.Lookup(name) == nil || name == "config" {
+			return nil, fmt.Errorf("%s:%d: unknown flag %s", c.path, n, name)
+		}
+		values[name] = strings.TrimSpace(ss[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return values, nil
+}
+
+// set sets the flag, unless it was set on the command line.
+func (c *config) set(name, value string) error {
+	if c.cmdline[name] {
+		return nil
+	}
+	if err := c.fs.Set(name, value); err != nil {
+		return fmt.Errorf("invalid value %q for flag %s: %w", value, name, err)
+	}
+	return nil
+}
+
+// reload re-reads the config file, and sets the reloadable flags which changed,
+// a flag removed from the file reverting to its default. It returns the names
+// of the flags set, and of the changed flags which need a restart.
+func (c *config) reload() (applied, restart []string, err error) {
+	values, err := c.read()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changed := map[string]string{}
+	for name, value := range values {
+		if old, ok := c.values[name]; !ok || old != value {
+			changed[name] = value
+		}
+	}
+	for name := range c.values {
+		if _, ok := values[name]; !ok {
+			changed[name] = c.fs.Lookup(name).DefValue
+		}
+	}
+
+	for name, value := range changed {
+		if c.cmdline[name] {
+			continue
+		}
+		if !reloadableFlags[name] {
+			restart = append(restart, name)
+			continue
+		}
+		if err := c.set(name, value); err != nil {
+			return nil, nil, err
+		}
+		applied = append(applied, name)
+	}
+	c.values = values
+
+	sort.Strings(applied)
+	sort.Strings(restart)
+	return applied, restart, nil
+}
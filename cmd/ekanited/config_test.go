@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestConfig_Reload(t *testing.T) {
+	f, err := ioutil.TempFile("", "ekanite_config")
+	if err != nil {
+		t.Fatalf("failed to create config file: %s", err.Error())
+	}
+	defer os.Remove(f.Name())
+	write := func(s string) {
+		if err := ioutil.WriteFile(f.Name(), []byte(s), 0644); err != nil {
+			t.Fatalf("failed to write config file: %s", err.Error())
+		}
+	}
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	retention := fs.String("retention", "168h", "")
+	searchMemory := fs.Int64("searchmem", 1024, "")
+	numShards := fs.Int("numshards", 4, "")
+	datadir := fs.String("datadir", "/var/opt/ekanite", "")
+	fs.Parse([]string{"-datadir", "/tmp/ekanite"})
+
+	write("# ekanite\nretention = 24h\nsearchmem=2048\nnumshards = 8\ndatadir = /data\n")
+	c, err := newConfig(fs, f.Name())
+	if err != nil {
+		t.Fatalf("failed to read config: %s", err.Error())
+	}
+	if *retention != "24h" || *searchMemory != 2048 || *numShards != 8 {
+		t.Fatalf("config not applied: %s, %d, %d", *retention, *searchMemory, *numShards)
+	}
+	if *datadir != "/tmp/ekanite" {
+		t.Fatalf("config overrode command line datadir with %s", *datadir)
+	}
+
+	write("retention = 48h\nnumshards = 16\ndatadir = /other\n")
+	applied, restart, err := c.reload()
+	if err != nil {
+		t.Fatalf("failed to reload config: %s", err.Error())
+	}
+	if exp := []string{"retention", "searchmem"}; !reflect.DeepEqual(applied, exp) {
+		t.Fatalf("reload applied %v, expected %v", applied, exp)
+	}
+	if exp := []string{"numshards"}; !reflect.DeepEqual(restart, exp) {
+		t.Fatalf("reload needs restart for %v, expected %v", restart, exp)
+	}
+	if *retention != "48h" || *searchMemory != 1024 || *numShards != 8 {
+		t.Fatalf("reload not applied: %s, %d, %d", *retention, *searchMemory, *numShards)
+	}
+
+	write("retention 48h\n")
+	if _, _, err := c.reload(); err == nil {
+		t.Fatal("malformed config reloaded")
+	}
+	write("shards = 8\n")
+	if _, _, err := c.reload(); err == nil {
+		t.Fatal("config with unknown flag reloaded")
+	}
+}
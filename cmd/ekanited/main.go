@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
@@ -15,6 +16,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strings"
 	"syscall"
 	"time"
 
@@ -65,26 +67,75 @@ const (
 func main() {
 	fs = flag.NewFlagSet("", flag.ExitOnError)
 	var (
+		configPath      = fs.String("config", "", "Config file holding flags as name = value lines, reloaded on SIGHUP. Command line flags take precedence")
 		datadir         = fs.String("datadir", DefaultDataDir, "Set data directory")
 		batchSize       = fs.Int("batchsize", DefaultBatchSize, "Indexing batch size")
 		batchTimeout    = fs.Int("batchtime", DefaultBatchTimeout, "Indexing batch timeout, in milliseconds")
 		indexMaxPending = fs.Int("maxpending", DefaultIndexMaxPending, "Maximum pending index events")
+		batchRetries    = fs.Int("batchretries", ekanite.DefaultBatchRetries, "Times a batch failing to be indexed is retried in memory, with a backoff, before being spilled or dropped")
+		spillDir        = fs.String("spilldir", "", "Directory batches out of retries are written to, and replayed from once indexing works again. If not set, such batches are dropped")
 		tcpIface        = fs.String("tcp", DefaultTCPServer, "Syslog server TCP bind address in the form host:port. To disable set to empty string")
+		tcpMaxConns     = fs.Int("tcpmaxconns", input.DefaultMaxTCPConnections, "Maximum TCP connections served at once. 0 means no limit")
+		tcpIdleTimeout  = fs.Duration("tcpidle", input.DefaultTCPIdleTimeout, "Close TCP connections idle for this long. 0 means never")
+		tcpStrict       = fs.Bool("tcpstrict", false, "Drop TCP messages not conforming to RFC5424 instead of parsing them best-effort")
 		udpIface        = fs.String("udp", "", "Syslog server UDP bind address in the form host:port. If not set, not started")
+		udpStrict       = fs.Bool("udpstrict", false, "Drop UDP messages not conforming to RFC5424 instead of parsing them best-effort")
+		reusePort       = fs.Bool("reuseport", false, "Bind the syslog ports with SO_REUSEPORT before opening the engine, so that a new ekanite can bind them while the old one drains. Linux only")
+		drainTimeout    = fs.Duration("draintimeout", 10*time.Second, "Longest wait on shutdown for syslog connections to go quiet, and again for pending events to be indexed")
+		forwardTo       = fs.String("forward", "", "Forward collected events to the engine process at this address, as [unix:|tcp:]address, instead of indexing them. If set, no engine is opened")
+		forwardIface    = fs.String("forwardlisten", "", "Accept events forwarded by collector processes at this address, as [unix:|tcp:]address, a unix socket or a loopback address as forwarded events are not authenticated. If not set, not started")
 		diagIface       = fs.String("diag", DefaultDiagsIface, "expvar and pprof bind address in the form host:port. If not set, not started")
 		caPemPath       = fs.String("tlspem", "", "path to CA PEM file for TLS-enabled TCP server. If not set, TLS not activated")
 		caKeyPath       = fs.String("tlskey", "", "path to CA key file for TLS-enabled TCP server. If not set, TLS not activated")
 		queryIface      = fs.String("query", DefaultQueryAddr, "TCP Bind address for query server in the form host:port. To disable set to empty string")
 		queryIfaceHttp  = fs.String("queryhttp", DefaultHTTPQueryAddr, "TCP Bind address for http query server in the form host:port. To disable set to empty string")
 		numShards       = fs.Int("numshards", DefaultNumShards, "Set number of shards per index")
+		indexWorkers    = fs.Int("indexworkers", 0, "Sub-batches indexed at once across all indexes. 0 means twice the number of shards")
+		maxBatchSize    = fs.Int("maxbatch", ekanite.DefaultMaxBatchSize, "Maximum documents per shard write batch. 0 means no limit")
+		durability      = fs.String("durability", "batch", "When shard writes are persisted: batch, os, or an interval such as 5s")
+		rolloverDocs    = fs.Uint64("rolloverdocs", 0, "Documents after which the current index is ended and a new one started. 0 means no limit")
+		rolloverBytes   = fs.Int64("rolloverbytes", 0, "Bytes on disk after which the current index is ended and a new one started. 0 means no limit")
+		findDups        = fs.Bool("dups", false, "Scan adjacent indexes for documents indexed twice, report them and exit")
+		removeDups      = fs.Bool("removedups", false, "With -dups, also remove the older copy of each duplicate")
+		warmupQueries   = fs.String("warmup", "", "Semicolon-separated query strings run against each index when opened, to warm its caches. * matches all documents")
+		searchMemory    = fs.Int64("searchmem", ekanite.DefaultSearchMemory, "Estimated memory, in bytes, all running searches may use. 0 means no limit")
 		retentionPeriod = fs.String("retention", DefaultRetentionPeriod, "Data retention period. Minimum is 24 hours")
+		tiers           = fs.String("tiers", "", "Comma-separated list of name:severity:retention tiers, such as errors:err:2160h,info:debug:168h, routing documents up to each severity to indexes kept for that long. If not set, a single tier is kept for the retention period")
+		auditLog        = fs.String("auditlog", "", "Path of the append-only log of the indexes deleted and the documents removed. Not written if not set")
+		minFree         = fs.Uint64("minfree", 0, "Free bytes on the data path below which events are refused, so that shards are not corrupted by running out of space. 0 means never")
+		diskCheck       = fs.Duration("diskcheck", ekanite.DefaultDiskCheckInterval, "How often to check the free space of the data path against -minfree")
+		maintBandwidth  = fs.Int64("maintbw", 0, "Bytes per second of disk I/O maintenance tasks such as retention deletions may use. 0 means no limit")
+		maintYield      = fs.Duration("maintyield", ekanite.DefaultMaintenanceYield, "Longest maintenance tasks wait for indexing in progress between steps. 0 means no wait")
+		heartbeat       = fs.Duration("heartbeat", 0, "How often to index a heartbeat about ekanite's own health in the _monitoring indexes. 0 means never")
+		retentionCheck  = fs.Duration("retentioncheck", ekanite.DefaultRetentionCheckInterval, "How often to check for expired indexes")
+		compactAfter    = fs.Duration("compactafter", 0, "How long after an index ends to rebuild its shards into merged segments in the background, if it stores every field it indexes. 0 means never")
+		compactCheck    = fs.Duration("compactcheck", ekanite.DefaultCompactionCheckInterval, "How often to check for indexes to compact")
 		cpuProfile      = fs.String("cpuprof", "", "Where to write CPU profiling data. Not written if not set")
 		memProfile      = fs.String("memprof", "", "Where to write memory profiling data. Not written if not set")
 		inputFormat     = fs.String("input", DefaultInputFormat, "Message format of input (only syslog supported)")
+		detectLang      = fs.Bool("detectlang", false, "Also index Chinese messages through the CJK analyzer")
+		storeMessage    = fs.Bool("storemessage", true, "Store the original line of each event in the indexes created, besides indexing it. If false, messages are still searched but hits only carry the parsed fields")
+		qualityWindow   = fs.Int("qualitywindow", input.DefaultQualityWindow, "Number of messages of each sender whose parse quality is judged together. 0 means parse quality is not tracked")
+		qualityDrift    = fs.Float64("qualitydrift", input.DefaultQualityDrift, "Rise of the fraction of the messages of a sender without a host or timestamp, or failing to parse, above its baseline which raises a parse_quality_degraded event")
+		fieldTypes      = fs.String("fieldtypes", "", "Comma-separated list of field:type coerced at ingest, type being int, float, string or time")
+		caseFold        = fs.String("casefold", "", "Comma-separated list of keyword fields matched case-insensitively, e.g. host,app,tag")
+		lookups         = fs.String("lookups", "", "Comma-separated list of name:key:path lookup tables, CSV or JSON files whose rows add fields to the events by their key field, e.g. dc:address:/etc/ekanite/dc.csv")
+		lookupReload    = fs.Duration("lookupreload", time.Minute, "How often to reload the lookup tables whose file changed. 0 means never")
+		severityRules   = fs.String("severityrules", "", "JSON file of rules rewriting the severity of the events matching their app, message pattern and severity at ingest, e.g. [{\"app\": \"nginx\", \"message\": \"upstream timed out\", \"from\": \"err\", \"severity\": \"info\"}]. Reread on reload")
+		listenerSpecs   = fs.String("listeners", "", "Comma-separated list of tag:protocol:format:address collectors started besides -tcp and -udp, stamping their events with a listener field holding their tag, e.g. firewalls:udp:rfc3164:0.0.0.0:5514")
+		plugins         = fs.String("plugins", "", "Semicolon-separated list of enrichment plugins run in order over each batch before it is indexed, each plugin:path.so [args] for a Go plugin exporting NewEnrichmentPlugin, or exec:command [args] for a subprocess exchanging JSON lines on its stdin and stdout")
 	)
 	fs.Usage = printHelp
 	fs.Parse(os.Args[1:])
 
+	var conf *config
+	if *configPath != "" {
+		var err error
+		if conf, err = newConfig(fs, *configPath); err != nil {
+			log.Fatalf("failed to read config: %s", err.Error())
+		}
+	}
+
 	absDataDir, err := filepath.Abs(*datadir)
 	if err != nil {
 		log.Fatalf("failed to get absolute data path for '%s': %s", *datadir, err.Error())
@@ -108,10 +159,116 @@ func main() {
 		startDiagServer(*diagIface)
 	}
 
+	types, err := input.ParseFieldTypes(*fieldTypes)
+	if err != nil {
+		log.Fatalf("failed to parse field types: %s", err.Error())
+	}
+
+	rules, err := input.LoadSeverityRules(*severityRules)
+	if err != nil {
+		log.Fatalf("failed to load severity rules: %s", err.Error())
+	}
+	pipeline := input.NewPipeline(input.PipelineSettings{
+		FieldTypes:    types,
+		SeverityRules: rules,
+	})
+	pipeline.QualityWindow = *qualityWindow
+	pipeline.QualityDrift = *qualityDrift
+	breaker := new(input.Breaker)
+
+	tables, err := input.ParseLookupTables(*lookups)
+	if err != nil {
+		log.Fatalf("failed to load lookup tables: %s", err.Error())
+	}
+	for _, t := range tables {
+		pipeline.SetLookupTable(t)
+	}
+	if len(tables) > 0 && *lookupReload > 0 {
+		go reloadLookupTables(pipeline, *lookupReload)
+	}
+
+	// Only run the collectors if events are forwarded to another process.
+	if *forwardTo != "" {
+		network, addr := input.ParseForwardAddr(*forwardTo)
+		forwarder := input.NewForwarder(network, addr, *indexMaxPending)
+		forwarder.Start()
+		log.Printf("forwarding events to %s %s", network, addr)
+
+		collectors := startCollectors(*tcpIface, *udpIface, *caPemPath, *caKeyPath, *inputFormat,
+			*tcpMaxConns, *tcpIdleTimeout, *tcpStrict, *udpStrict, *reusePort, pipeline, breaker, forwarder.C())
+		listeners := startListeners(*listenerSpecs, *drainTimeout, pipeline, breaker, forwarder.C())
+		waitForSignals(func() {
+			log.Println("config reload is not supported when forwarding events")
+		})
+		drainCollectors(collectors, *drainTimeout)
+		listeners.StopAll()
+		return
+	}
+
 	// Create and open the Engine.
 	engine := ekanite.NewEngine(absDataDir)
 	engine.NumShards = *numShards
 	engine.RetentionPeriod = retention
+	engine.RetentionCheck = *retentionCheck
+	engine.CompactAfter = *compactAfter
+	engine.CompactionCheck = *compactCheck
+	engine.MaxBatchSize = *maxBatchSize
+	engine.IndexWorkers = *indexWorkers
+	if engine.WarmupQueries, err = ekanite.ParseWarmupQueries(*warmupQueries); err != nil {
+		log.Fatalf("failed to parse warm-up queries: %s", err.Error())
+	}
+	if engine.Tiers, err = ekanite.ParseTiers(*tiers); err != nil {
+		log.Fatalf("failed to parse tiers: %s", err.Error())
+	}
+	engine.CaseFold = ekanite.ParseCaseFold(*caseFold)
+	engine.StoreMessage = *storeMessage
+	engine.DetectLanguage = *detectLang
+	if engine.Durability, engine.SyncInterval, err = ekanite.ParseDurability(*durability); err != nil {
+		log.Fatalf("failed to parse durability: %s", err.Error())
+	}
+	engine.SearchMemory = *searchMemory
+	engine.RolloverDocs = *rolloverDocs
+	engine.RolloverBytes = *rolloverBytes
+	engine.MaintenanceBandwidth = *maintBandwidth
+	engine.MaintenanceYield = *maintYield
+	if *auditLog != "" {
+		if engine.Audit, err = ekanite.OpenAuditLog(*auditLog); err != nil {
+			log.Fatalf("failed to open audit log: %s", err.Error())
+		}
+		defer engine.Audit.Close()
+	}
+
+	// Refuse events while the data path is short of space, searches and
+	// retention going on.
+	if *minFree > 0 {
+		if err := os.MkdirAll(absDataDir, 0755); err != nil {
+			log.Fatalf("failed to create data path %s: %s", absDataDir, err.Error())
+		}
+		watchdog := ekanite.NewDiskWatchdog(absDataDir, *minFree)
+		watchdog.Interval = *diskCheck
+		watchdog.OnChange = breaker.SetError
+		if err := watchdog.Check(); err != nil {
+			log.Fatalf("failed to start disk watchdog: %s", err.Error())
+		}
+		go watchdog.Run(nil)
+	}
+
+	// With SO_REUSEPORT, the syslog ports are bound while the engine is still
+	// held by the ekanite being replaced, events waiting in the batcher until
+	// it is released.
+	batcherTimeout := time.Duration(*batchTimeout) * time.Millisecond
+	batcher := ekanite.NewBatcher(engine, *batchSize, batcherTimeout, *indexMaxPending)
+	batcher.LaneDuration = engine.IndexDuration
+	batcher.Retries = *batchRetries
+	batcher.SpillDir = *spillDir
+	if batcher.Enrichment, err = ekanite.ParseEnrichmentPlugins(*plugins); err != nil {
+		log.Fatalf("failed to load enrichment plugins: %s", err.Error())
+	}
+	var collectors []input.Collector
+	if *reusePort && !*findDups {
+		collectors = startCollectors(*tcpIface, *udpIface, *caPemPath, *caKeyPath, *inputFormat,
+			*tcpMaxConns, *tcpIdleTimeout, *tcpStrict, *udpStrict, true, pipeline, breaker, batcher.C())
+	}
 
 	if err := engine.Open(); err != nil {
 		log.Fatalf("failed to open engine: %s", err.Error())
@@ -119,6 +276,28 @@ func main() {
 	log.Printf("engine opened with shard number of %d, retention period of %s",
 		engine.NumShards, engine.RetentionPeriod)
 
+	// Only verify the indexes if requested.
+	if *findDups {
+		duplicates, err := engine.FindDuplicates(context.Background(), *removeDups)
+		if err != nil {
+			log.Fatalf("failed to scan for duplicates: %s", err.Error())
+		}
+		for _, d := range duplicates {
+			fmt.Printf("%s\t%s\t%s\n", d.ID, d.Older, d.Newer)
+		}
+		if *removeDups {
+			log.Printf("removed %d duplicate(s) from the older indexes", len(duplicates))
+		} else {
+			log.Printf("found %d duplicate(s)", len(duplicates))
+		}
+		engine.Close()
+		return
+	}
+
+	if err := pipeline.OpenSequence(filepath.Join(absDataDir, ".sequence")); err != nil {
+		log.Fatalf("failed to open event sequence: %s", err.Error())
+	}
+
 	// Start the simple query server if requested.
 	if *queryIface != "" {
 		startQueryServer(*queryIface, engine)
@@ -129,10 +308,7 @@ func main() {
 		startHTTPQueryServer(*queryIfaceHttp, engine)
 	}
 
-	// Create and start the batcher.
-	batcherTimeout := time.Duration(*batchTimeout) * time.Millisecond
-	batcher := ekanite.NewBatcher(engine, *batchSize, batcherTimeout, *indexMaxPending)
-
+	// Start the batcher.
 	errChan := make(chan error)
 	if err := batcher.Start(errChan); err != nil {
 		log.Fatalf("failed to start indexing batcher: %s", err.Error())
@@ -143,64 +319,212 @@ func main() {
 	// Start draining batcher errors.
 	go drainLog("error indexing batch", errChan)
 
+	// Start indexing heartbeats if requested.
+	var monitor *ekanite.Monitor
+	if *heartbeat > 0 {
+		monitor = ekanite.NewMonitor(engine, batcher, *heartbeat)
+		monitor.Start()
+		log.Printf("heartbeats indexed every %s", *heartbeat)
+	}
+
+	// Start the collectors.
+	if !*reusePort {
+		collectors = startCollectors(*tcpIface, *udpIface, *caPemPath, *caKeyPath, *inputFormat,
+			*tcpMaxConns, *tcpIdleTimeout, *tcpStrict, *udpStrict, false, pipeline, breaker, batcher.C())
+	}
+	listeners := startListeners(*listenerSpecs, *drainTimeout, pipeline, breaker, batcher.C())
+
+	// Start the collector of forwarded events if requested.
+	if *forwardIface != "" {
+		network, addr := input.ParseForwardAddr(*forwardIface)
+		collector := input.NewForwardCollector(network, addr)
+		collector.Pipeline = pipeline
+		collector.Breaker = breaker
+		if err := collector.Start(batcher.C()); err != nil {
+			log.Fatalf("failed to start forward collector: %s", err.Error())
+		}
+		log.Printf("forward collector listening to %s %s", network, addr)
+	}
+
+	// Start profiling.
+	startProfile(*cpuProfile, *memProfile)
+
+	// Wait for signals, reloading the config file on SIGHUP.
+	waitForSignals(func() {
+		if conf == nil {
+			log.Println("no config file to reload")
+			return
+		}
+		applied, restart, err := conf.reload()
+		if err != nil {
+			log.Printf("failed to reload config: %s", err.Error())
+			return
+		}
+		if err := reconfigure(engine, pipeline, *retentionPeriod, *searchMemory, *rolloverDocs, *rolloverBytes, *fieldTypes, *severityRules); err != nil {
+			log.Printf("failed to apply reloaded config: %s", err.Error())
+			return
+		}
+		log.Printf("config reloaded, applied %v, restart required for %v", applied, restart)
+	})
+
+	// Let the events in flight be indexed before closing the engine.
+	drainCollectors(collectors, *drainTimeout)
+	if err := listeners.StopAll(); err != nil {
+		log.Printf("failed to stop listeners: %s", err.Error())
+	}
+	if !waitBatcher(batcher, *drainTimeout) {
+		log.Printf("closing engine with %d event(s) not indexed", batcher.QueueDepth())
+	}
+	if monitor != nil {
+		monitor.Stop()
+	}
+	for _, p := range batcher.Enrichment {
+		if err := p.Close(); err != nil {
+			log.Printf("failed to close enrichment plugin %s: %s", p.Name(), err.Error())
+		}
+	}
+	engine.Close()
+
+	stopProfile()
+}
+
+// startCollectors starts the TCP and UDP collectors requested, sending their
+// events through pipeline to c while breaker accepts them.
+func startCollectors(tcpIface, udpIface, caPemPath, caKeyPath, inputFormat string,
+	tcpMaxConns int, tcpIdleTimeout time.Duration, tcpStrict, udpStrict, reusePort bool,
+	pipeline *input.Pipeline, breaker *input.Breaker, c chan<- ekanite.Document) []input.Collector {
+	var collectors []input.Collector
+
 	// Start TCP collector if requested.
-	if *tcpIface != "" {
+	if tcpIface != "" {
 		var tlsConfig *tls.Config
-		if *caPemPath != "" && *caKeyPath != "" {
-			tlsConfig, err = newTLSConfig(*caPemPath, *caKeyPath)
+		if caPemPath != "" && caKeyPath != "" {
+			var err error
+			tlsConfig, err = newTLSConfig(caPemPath, caKeyPath)
 			if err != nil {
 				log.Fatalf("failed to configure TLS: %s", err.Error())
 			}
 			log.Printf("TLS successfully configured")
 		}
 
-		if err := startTCPCollector(*tcpIface, *inputFormat, tlsConfig, batcher); err != nil {
+		collector, err := startTCPCollector(tcpIface, inputFormat, tlsConfig, tcpMaxConns, tcpIdleTimeout, tcpStrict, reusePort, pipeline, breaker, c)
+		if err != nil {
 			log.Fatalf("failed to start TCP collector: %s", err.Error())
 		}
-		log.Printf("TCP collector listening to %s", *tcpIface)
+		collectors = append(collectors, collector)
+		log.Printf("TCP collector listening to %s", tcpIface)
 	}
 
 	// Start UDP collector if requested.
-	if *udpIface != "" {
-		if err := startUDPCollector(*udpIface, *inputFormat, batcher); err != nil {
+	if udpIface != "" {
+		collector, err := startUDPCollector(udpIface, inputFormat, udpStrict, reusePort, pipeline, breaker, c)
+		if err != nil {
 			log.Fatalf("failed to start UDP collector: %s", err.Error())
 		}
-		log.Printf("UDP collector listening to %s", *udpIface)
+		collectors = append(collectors, collector)
+		log.Printf("UDP collector listening to %s", udpIface)
 	}
+	return collectors
+}
 
-	// Start profiling.
-	startProfile(*cpuProfile, *memProfile)
+// startListeners starts the tagged listeners of specs, sending their events
+// through pipeline to c while breaker accepts them.
+func startListeners(specs string, drainTimeout time.Duration, pipeline *input.Pipeline, breaker *input.Breaker, c chan<- ekanite.Document) *input.Listeners {
+	configs, err := input.ParseListenerConfigs(specs)
+	if err != nil {
+		log.Fatalf("failed to parse listeners: %s", err.Error())
+	}
+	listeners := input.NewListeners(c)
+	listeners.DrainTimeout = drainTimeout
+	listeners.Pipeline = pipeline
+	listeners.Breaker = breaker
+	for _, config := range configs {
+		if err := listeners.Add(config); err != nil {
+			log.Fatalf("failed to add listener: %s", err.Error())
+		}
+		if err := listeners.Start(config.Tag); err != nil {
+			log.Fatalf("failed to start listener: %s", err.Error())
+		}
+		log.Printf("%s listener %s listening to %s", strings.ToUpper(config.Protocol), config.Tag, config.Address)
+	}
+	return listeners
+}
 
-	// Wait forever for signals.
-	waitForSignals()
+// drainCollectors has the collectors which can stop taking in events and
+// finish with those in flight, each within timeout.
+func drainCollectors(collectors []input.Collector, timeout time.Duration) {
+	for _, collector := range collectors {
+		d, ok := collector.(interface {
+			Drain(time.Duration) error
+		})
+		if !ok {
+			continue
+		}
+		if err := d.Drain(timeout); err != nil {
+			log.Printf("failed to drain collector on %s: %s", collector.Addr(), err.Error())
+		}
+	}
+}
 
-	engine.Close()
+// waitBatcher waits up to timeout for the events of the batcher to be
+// indexed, returning whether they all were.
+func waitBatcher(batcher *ekanite.Batcher, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for batcher.QueueDepth() > 0 || batcher.OldestPending() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
 
-	stopProfile()
+// reloadLookupTables reloads the lookup tables of pipeline whose file changed
+// every interval.
+func reloadLookupTables(pipeline *input.Pipeline, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := pipeline.ReloadLookupTables(); err != nil {
+			log.Printf("failed to reload lookup table: %s", err.Error())
+		}
+	}
 }
 
-func startTCPCollector(iface, format string, tls *tls.Config, batcher *ekanite.Batcher) error {
+func startTCPCollector(iface, format string, tls *tls.Config, maxConns int, idleTimeout time.Duration, strict, reusePort bool, pipeline *input.Pipeline, breaker *input.Breaker, c chan<- ekanite.Document) (input.Collector, error) {
 	collector, err := input.NewCollector("tcp", iface, format, tls)
 	if err != nil {
-		return fmt.Errorf(("failed to create TCP collector: %s"), err.Error())
+		return nil, fmt.Errorf(("failed to create TCP collector: %w"), err)
 	}
-	if err := collector.Start(batcher.C()); err != nil {
-		return fmt.Errorf("failed to start TCP collector: %s", err.Error())
+	if tcp, ok := collector.(*input.TCPCollector); ok {
+		tcp.MaxConnections = maxConns
+		tcp.IdleTimeout = idleTimeout
+		tcp.Strict = strict
+		tcp.ReusePort = reusePort
+		tcp.Pipeline = pipeline
+		tcp.Breaker = breaker
+	}
+	if err := collector.Start(c); err != nil {
+		return nil, fmt.Errorf("failed to start TCP collector: %w", err)
 	}
 
-	return nil
+	return collector, nil
 }
 
-func startUDPCollector(iface, format string, batcher *ekanite.Batcher) error {
+func startUDPCollector(iface, format string, strict, reusePort bool, pipeline *input.Pipeline, breaker *input.Breaker, c chan<- ekanite.Document) (input.Collector, error) {
 	collector, err := input.NewCollector("udp", iface, format, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create UDP collector: %s", err.Error())
+		return nil, fmt.Errorf("failed to create UDP collector: %w", err)
+	}
+	if udp, ok := collector.(*input.UDPCollector); ok {
+		udp.Strict = strict
+		udp.ReusePort = reusePort
+		udp.Pipeline = pipeline
+		udp.Breaker = breaker
 	}
-	if err := collector.Start(batcher.C()); err != nil {
-		return fmt.Errorf("failed to start UDP collector: %s", err.Error())
+	if err := collector.Start(c); err != nil {
+		return nil, fmt.Errorf("failed to start UDP collector: %w", err)
 	}
 
-	return nil
+	return collector, nil
 }
 
 func startQueryServer(iface string, engine *ekanite.Engine) {
@@ -284,17 +608,52 @@ func drainLog(msg string, errChan <-chan error) {
 	}
 }
 
-// waitForSignals blocks until a signal is received.
-func waitForSignals() {
+// waitForSignals blocks until a signal other than SIGHUP is received, calling
+// reload on each SIGHUP.
+func waitForSignals(reload func()) {
 	// Set up signal handling.
 	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
-
-	// Block until one of the signals above is received
-	select {
-	case <-signalCh:
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	// Block until a signal other than SIGHUP is received.
+	for sig := range signalCh {
+		if sig == syscall.SIGHUP {
+			log.Println("SIGHUP received, reloading config...")
+			reload()
+			continue
+		}
 		log.Println("signal received, shutting down...")
+		return
+	}
+}
+
+// reconfigure applies the flags which may change while running to the engine
+// and the pipeline of the inputs.
+func reconfigure(engine *ekanite.Engine, pipeline *input.Pipeline, retentionPeriod string, searchMemory int64,
+	rolloverDocs uint64, rolloverBytes int64, fieldTypes, severityRules string) error {
+	retention, err := time.ParseDuration(retentionPeriod)
+	if err != nil {
+		return fmt.Errorf("failed to parse retention period '%s'", retentionPeriod)
+	}
+	types, err := input.ParseFieldTypes(fieldTypes)
+	if err != nil {
+		return fmt.Errorf("failed to parse field types: %w", err)
 	}
+	rules, err := input.LoadSeverityRules(severityRules)
+	if err != nil {
+		return fmt.Errorf("failed to load severity rules: %w", err)
+	}
+	engine.Reconfigure(ekanite.Settings{
+		RetentionPeriod: retention,
+		SearchMemory:    searchMemory,
+		RolloverDocs:    rolloverDocs,
+		RolloverBytes:   rolloverBytes,
+	})
+	pipeline.Reconfigure(input.PipelineSettings{
+		FieldTypes:    types,
+		SeverityRules: rules,
+	})
+	return nil
 }
 
 // prof stores the file locations of active profiles.
@@ -0,0 +1,423 @@
+package ekanite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/document"
+	"github.com/blevesearch/bleve/index/scorch"
+	"github.com/blevesearch/bleve/mapping"
+)
+
+const (
+	// compactedFileName is the file of an index recording when its shards
+	// were compacted, so that they are compacted only once.
+	compactedFileName = "compacted"
+
+	// Suffixes of the hidden directories a shard is rebuilt into, and the
+	// old shard is moved to before its files are removed. OpenIndex finishes
+	// or undoes a compaction interrupted between the two.
+	compactingSuffix = ".compacting"
+	compactedSuffix  = ".old"
+
+	// compactionBatchSize is the number of documents copied per batch.
+	compactionBatchSize = 1000
+)
+
+// errCompactionRaced is returned when the index or shard being compacted
+// changed while it was copied, the compaction then being tried again later.
+var errCompactionRaced = errors.New("index changed while compacted")
+
+// runCompaction periodically compacts the indexes which ended CompactAfter
+// ago, every CompactionCheck.
+func (e *Engine) runCompaction() {
+	defer e.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-e.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		select {
+		case <-e.done:
+			return
+
+		case <-time.After(jitter(e.CompactionCheck)):
+			stats.Add("compactionRun", 1)
+			e.compactIndexes(ctx)
+		}
+	}
+}
+
+// compactIndexes compacts the indexes due, one at a time, as a maintenance
+// task.
+func (e *Engine) compactIndexes(ctx context.Context) {
+	now := time.Now().UTC()
+	e.mu.RLock()
+	var due []*Index
+	for _, i := range e.indexes {
+		if i.compactionDue(now, e.CompactAfter) {
+			due = append(due, i)
+		}
+	}
+	e.mu.RUnlock()
+
+	for _, i := range due {
+		before, _ := i.Size()
+		if err := e.compactIndex(ctx, i); err != nil {
+			if errors.Is(err, errCompactionRaced) {
+				stats.Add("compactionRaced", 1)
+			} else {
+				stats.Add("compactionFailures", 1)
+			}
+			e.Logger.Printf("failed to compact index %s: %s", i.path, err.Error())
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		after, _ := i.Size()
+		e.Logger.Printf("compacted index %s from %d to %d bytes", i.path, before, after)
+		stats.Add("indexesCompacted", 1)
+		stats.Add("compactionBytesSaved", before-after)
+		e.audit("compaction.compact", i.Name(), map[string]interface{}{
+			"end_time":     i.endTime,
+			"bytes_before": before,
+			"bytes_after":  after,
+		})
+	}
+}
+
+// compactionDue returns whether the index ended grace before t, is neither
+// held nor compacted yet, and stores every field it indexes, so that its
+// documents can be indexed again from their stored fields without loss.
+func (i *Index) compactionDue(t time.Time, grace time.Duration) bool {
+	if grace <= 0 || i.hold != "" || len(i.Shards) == 0 || !i.Expired(t, grace) {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(i.path, compactedFileName)); !os.IsNotExist(err) {
+		return false
+	}
+	for _, s := range i.Shards {
+		if s.b == nil || !storesAllFields(s.b.Mapping()) {
+			return false
+		}
+	}
+	return true
+}
+
+// storesAllFields returns whether the mapping stores every field it indexes,
+// but for the CJK copy of the message, which is derived at index time.
+func storesAllFields(m mapping.IndexMapping) bool {
+	impl, ok := m.(*mapping.IndexMappingImpl)
+	if !ok {
+		return false
+	}
+	if !documentStoresAllFields(impl.DefaultMapping, impl.StoreDynamic) {
+		return false
+	}
+	for _, dm := range impl.TypeMapping {
+		if !documentStoresAllFields(dm, impl.StoreDynamic) {
+			return false
+		}
+	}
+	return true
+}
+
+func documentStoresAllFields(dm *mapping.DocumentMapping, storeDynamic bool) bool {
+	if dm == nil || !dm.Enabled {
+		return true
+	}
+	if dm.Dynamic && !storeDynamic {
+		return false
+	}
+	for name, p := range dm.Properties {
+		if name == cjkMessageField {
+			continue
+		}
+		for _, f := range p.Fields {
+			if f.Index && !f.Store {
+				return false
+			}
+		}
+		if !documentStoresAllFields(p, storeDynamic) {
+			return false
+		}
+	}
+	return true
+}
+
+// compactIndex rebuilds the shards of the index one by one, each into a
+// single merged segment without the deleted documents, then marks the index
+// as compacted. Searches go on against the old shard while it is copied, and
+// only wait for the new one to be swapped in.
+func (e *Engine) compactIndex(ctx context.Context, i *Index) error {
+	for _, s := range i.Shards {
+		if err := e.compactShard(ctx, i, s); err != nil {
+			return fmt.Errorf("shard %s: %w", filepath.Base(s.path), err)
+		}
+	}
+	return ioutil.WriteFile(filepath.Join(i.path, compactedFileName),
+		[]byte(time.Now().UTC().Format(time.RFC3339)), 0666)
+}
+
+// compactShard copies the documents of the shard into a new one, swaps it in
+// and removes the old one. The index is pinned like a snapshot meanwhile, so
+// that retention does not delete it from under the copy.
+func (e *Engine) compactShard(ctx context.Context, i *Index, s *Shard) error {
+	e.mu.RLock()
+	if e.indexByName(i.Name()) != i {
+		e.mu.RUnlock()
+		return errCompactionRaced
+	}
+	i.readers.Add(1)
+	e.mu.RUnlock()
+
+	dir, name := filepath.Dir(s.path), filepath.Base(s.path)
+	tmp := filepath.Join(dir, "."+name+compactingSuffix)
+	n, err := e.copyShardCompacted(ctx, s, tmp)
+	if err != nil {
+		i.readers.Done()
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := e.swapShard(i, s, tmp, n); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	return e.removeIndexFiles(ctx, filepath.Join(dir, "."+name+compactedSuffix))
+}
+
+// copyShardCompacted indexes the stored fields of the documents of the shard
+// into a new shard at path, with the same mapping, and returns the number of
+// documents copied. Each batch is a maintenance step of the bytes it holds.
+func (e *Engine) copyShardCompacted(ctx context.Context, s *Shard, path string) (uint64, error) {
+	if err := os.RemoveAll(path); err != nil {
+		return 0, err
+	}
+	ids, err := shardDocIDs(s)
+	if err != nil {
+		return 0, err
+	}
+
+	b, err := bleve.NewUsing(path, s.b.Mapping(), scorch.Name, bleve.Config.DefaultKVStore, nil)
+	if err != nil {
+		return 0, fmt.Errorf("bleve new: %w", err)
+	}
+	var n uint64
+	var size int64
+	batch := b.NewBatch()
+	for _, id := range ids {
+		doc, err := s.b.Document(id)
+		if err != nil {
+			b.Close()
+			return 0, err
+		}
+		if doc == nil {
+			continue // Deleted since listed, the counts then telling.
+		}
+		values, bytes := storedValues(doc)
+		if err := batch.Index(id, s.routeLanguage(values)); err != nil {
+			b.Close()
+			return 0, err
+		}
+		n++
+		size += bytes
+		if batch.Size() >= compactionBatchSize {
+			if err := e.maintenanceStep(ctx, size); err != nil {
+				b.Close()
+				return 0, err
+			}
+			if err := b.Batch(batch); err != nil {
+				b.Close()
+				return 0, err
+			}
+			batch, size = b.NewBatch(), 0
+		}
+	}
+	if batch.Size() > 0 {
+		if err := e.maintenanceStep(ctx, size); err != nil {
+			b.Close()
+			return 0, err
+		}
+		if err := b.Batch(batch); err != nil {
+			b.Close()
+			return 0, err
+		}
+	}
+	return n, b.Close()
+}
+
+// shardDocIDs returns the IDs of the documents of the shard.
+func shardDocIDs(s *Shard) ([]string, error) {
+	i, _, err := s.b.Advanced()
+	if err != nil {
+		return nil, err
+	}
+	r, err := i.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	all, err := r.DocIDReaderAll()
+	if err != nil {
+		return nil, err
+	}
+	defer all.Close()
+
+	var ids []string
+	for {
+		id, err := all.Next()
+		if err != nil {
+			return nil, err
+		}
+		if id == nil {
+			return ids, nil
+		}
+		ext, err := r.ExternalID(id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, ext)
+	}
+}
+
+// storedValues returns the stored fields of the document as values to index
+// it again, a field stored more than once becoming an array, and the bytes
+// they take.
+func storedValues(doc *document.Document) (map[string]interface{}, int64) {
+	values := make(map[string]interface{}, len(doc.Fields))
+	var size int64
+	for _, f := range doc.Fields {
+		var value interface{}
+		switch field := f.(type) {
+		case *document.TextField:
+			value = string(field.Value())
+		case *document.NumericField:
+			num, err := field.Number()
+			if err != nil {
+				continue
+			}
+			value = num
+		case *document.DateTimeField:
+			t, err := field.DateTime()
+			if err != nil {
+				continue
+			}
+			value = t
+		case *document.BooleanField:
+			b, err := field.Boolean()
+			if err != nil {
+				continue
+			}
+			value = b
+		default:
+			continue
+		}
+		size += int64(len(f.Name()) + len(f.Value()))
+
+		switch old := values[f.Name()].(type) {
+		case nil:
+			values[f.Name()] = value
+		case []interface{}:
+			values[f.Name()] = append(old, value)
+		default:
+			values[f.Name()] = []interface{}{old, value}
+		}
+	}
+	return values, size
+}
+
+// swapShard swaps the compacted copy at tmp in for the shard, with the index
+// offline once the snapshots reading it are closed, unless the index was
+// deleted or the shard written to since it was copied. It releases the pin of
+// compactShard on the index.
+func (e *Engine) swapShard(i *Index, s *Shard, tmp string, n uint64) error {
+	i.readers.Done()
+	err := e.takeOffline(i.Name(), func(idx *Index) error {
+		if idx != i {
+			return errCompactionRaced
+		}
+		if err := s.Flush(); err != nil {
+			return err
+		}
+		if count, err := s.b.DocCount(); err != nil {
+			return err
+		} else if count != n {
+			return fmt.Errorf("%w: %d documents copied of %d", errCompactionRaced, n, count)
+		}
+
+		if err := s.Close(); err != nil {
+			return fmt.Errorf("failed to close shard before swapping it: %w", err)
+		}
+		old := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+compactedSuffix)
+		err := os.Rename(s.path, old)
+		if err == nil {
+			if err = os.Rename(tmp, s.path); err != nil {
+				os.Rename(old, s.path)
+			}
+		}
+		s.b = nil
+		if openErr := s.Open(); openErr != nil {
+			return fmt.Errorf("shard open fail: %w", openErr)
+		}
+		alias := bleve.NewIndexAlias()
+		for _, s := range i.Shards {
+			alias.Add(s.b)
+		}
+		i.Alias = alias
+		return err
+	})
+	if errors.Is(err, ErrUnknownIndex) {
+		return errCompactionRaced
+	}
+	return err
+}
+
+// recoverCompaction finishes or undoes the compactions of the shards of the
+// index at path which were interrupted: a shard moved away without its copy
+// being moved in is moved back, and the leftover copies and old shards are
+// removed.
+func recoverCompaction(path string) error {
+	fis, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		name := fi.Name()
+		if !fi.IsDir() || !strings.HasPrefix(name, ".") {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(name, compactingSuffix):
+			stats.Add("compactionRecovered", 1)
+			if err := os.RemoveAll(filepath.Join(path, name)); err != nil {
+				return err
+			}
+		case strings.HasSuffix(name, compactedSuffix):
+			stats.Add("compactionRecovered", 1)
+			shard := filepath.Join(path, strings.TrimSuffix(name[1:], compactedSuffix))
+			if _, err := os.Stat(shard); os.IsNotExist(err) {
+				if err := os.Rename(filepath.Join(path, name), shard); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(path, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package ekanite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestStoresAllFields tests that only indexes storing their messages are
+// compacted.
+func TestStoresAllFields(t *testing.T) {
+	m, err := buildIndexMapping(nil, true)
+	if err != nil {
+		t.Fatalf("failed to get index mapping: %s", err)
+	}
+	if !storesAllFields(m) {
+		t.Fatal("built-in mapping not found to store all fields")
+	}
+	if m, err = buildIndexMapping(nil, false); err != nil {
+		t.Fatalf("failed to get index mapping: %s", err)
+	}
+	if storesAllFields(m) {
+		t.Fatal("mapping without stored message found to store all fields")
+	}
+}
+
+// TestRecoverCompaction tests that interrupted compactions are undone or
+// finished when an index is opened.
+func TestRecoverCompaction(t *testing.T) {
+	path, err := ioutil.TempDir("", "ekanite-compaction-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(path)
+	mkdir := func(name string) {
+		if err := os.MkdirAll(filepath.Join(path, name, "store"), 0755); err != nil {
+			t.Fatalf("failed to create %s: %s", name, err.Error())
+		}
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(path, name))
+		return err == nil
+	}
+
+	// Shard 0000 was moved away, its copy not moved in yet. Shard 0001 was
+	// swapped, its old files not removed yet. Shard 0002 was being copied.
+	mkdir(".0000.old")
+	mkdir(".0000.compacting")
+	mkdir("0001")
+	mkdir(".0001.old")
+	mkdir("0002")
+	mkdir(".0002.compacting")
+	mkdir(".0003.err.0")
+
+	if err := recoverCompaction(path); err != nil {
+		t.Fatalf("failed to recover compaction: %s", err.Error())
+	}
+	for _, name := range []string{"0000", "0001", "0002", ".0003.err.0"} {
+		if !exists(name) {
+			t.Fatalf("%s missing after recovery", name)
+		}
+	}
+	for _, name := range []string{".0000.old", ".0000.compacting", ".0001.old", ".0002.compacting"} {
+		if exists(name) {
+			t.Fatalf("%s left after recovery", name)
+		}
+	}
+}
+
+// TestEngine_CompactIndexes tests that ended indexes are compacted without
+// the engine waiting for the snapshots reading them.
+func TestEngine_CompactIndexes(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := newEngine(dataDir, 1, 24*time.Hour)
+	defer e.Close()
+	e.CompactAfter = time.Hour
+
+	if err := e.Index([]Document{
+		newIndexableEvent("auth password accepted", parseTime("1982-02-05T04:43:00Z")),
+		newIndexableEvent("auth password rejected", parseTime("1982-02-05T04:44:00Z")),
+	}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+	i := e.indexes[0]
+	snap, err := e.Snapshot(time.Time{}, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("failed to take snapshot: %s", err.Error())
+	}
+
+	compacted := make(chan struct{})
+	go func() {
+		e.compactIndexes(context.Background())
+		close(compacted)
+	}()
+	for {
+		e.mu.RLock()
+		n := len(e.staged)
+		e.mu.RUnlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := e.Indexes(); err != nil {
+		t.Fatalf("failed to list indexes while compacting: %s", err.Error())
+	}
+	snap.Close()
+	<-compacted
+
+	if _, err := os.Stat(filepath.Join(i.path, compactedFileName)); err != nil {
+		t.Fatalf("index not compacted: %s", err.Error())
+	}
+	if total, err := e.Total(); err != nil || total != 2 {
+		t.Fatalf("engine total doc count %d, %v, expected 2", total, err)
+	}
+}
+
+// TestEngine_CompactionCheck tests that the engine does not open with
+// compaction checked for without pause.
+func TestEngine_CompactionCheck(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	e := NewEngine(dataDir)
+	e.CompactAfter = time.Hour
+	e.CompactionCheck = 0
+	if err := e.Open(); err == nil {
+		e.Close()
+		t.Fatal("engine opened with a compaction check interval of 0")
+	}
+}
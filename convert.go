@@ -18,7 +18,7 @@ import (
 func Convert(pa string, delta time.Duration, create func(pa string) (Writer, error)) error {
 	fi, err := os.Stat(pa)
 	if err != nil {
-		return fmt.Errorf("failed to access index at %s: %v", pa, err)
+		return fmt.Errorf("failed to access index at %s: %w", pa, err)
 	}
 	if !fi.IsDir() {
 		return fmt.Errorf("index %s path is not a directory", pa)
@@ -29,7 +29,7 @@ func Convert(pa string, delta time.Duration, create func(pa string) (Writer, err
 		if os.IsNotExist(err) {
 			names, err := ioutil.ReadDir(pa)
 			if err != nil {
-				return fmt.Errorf("failed to access index at %s: %v", pa, err)
+				return fmt.Errorf("failed to access index at %s: %w", pa, err)
 			}
 
 			for _, name := range names {
@@ -54,7 +54,7 @@ func Convert(pa string, delta time.Duration, create func(pa string) (Writer, err
 			}
 			return nil
 		}
-		return fmt.Errorf("failed to access index at %s: %v", pa, err)
+		return fmt.Errorf("failed to access index at %s: %w", pa, err)
 	}
 
 	return copyIndex(pa, delta, create)
@@ -70,30 +70,30 @@ func copyIndex(pa string, delta time.Duration, create func(pa string) (Writer, e
 	newPath := filepath.Join(dir, filepath.Base(pa)+".new")
 
 	if err := os.RemoveAll(newPath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("ensure directory is empty: %s", err.Error())
+		return fmt.Errorf("ensure directory is empty: %w", err)
 	}
 	fmt.Println("remove ", newPath)
 
 	if err := os.MkdirAll(newPath, 0777); err != nil {
-		return fmt.Errorf("ensure directory is exists: %s", err.Error())
+		return fmt.Errorf("ensure directory is exists: %w", err)
 	}
 
 	for _, name := range names {
 		fmt.Println("'" + name + "' is converting...")
 		oldShard := NewShard(filepath.Join(pa, name))
 		if err := oldShard.Open(); err != nil {
-			return fmt.Errorf("old shard open fail: %s", err.Error())
+			return fmt.Errorf("old shard open fail: %w", err)
 		}
 
 		//newShard := NewShard(filepath.Join(newPath, name))
 		//if err := newShard.Open(); err != nil {
 		newShard, err := create(filepath.Join(newPath, name))
 		if err != nil {
-			return fmt.Errorf("new shard open fail: %s", err.Error())
+			return fmt.Errorf("new shard open fail: %w", err)
 		}
 
 		if err := copyShard(oldShard, newShard, delta); err != nil {
-			return fmt.Errorf("copy shard fail: %s", err.Error())
+			return fmt.Errorf("copy shard fail: %w", err)
 		}
 		oldShard.Close()
 		newShard.Close()
@@ -101,17 +101,17 @@ func copyIndex(pa string, delta time.Duration, create func(pa string) (Writer, e
 
 	bs, err := ioutil.ReadFile(filepath.Join(pa, endTimeFileName))
 	if err != nil {
-		return fmt.Errorf("read old endtime : %v", err)
+		return fmt.Errorf("read old endtime : %w", err)
 	}
 
 	tt, err := time.Parse(indexNameLayout, string(bs))
 	if err != nil {
-		return fmt.Errorf("read old endtime : %v", err)
+		return fmt.Errorf("read old endtime : %w", err)
 	}
 
 	err = ioutil.WriteFile(filepath.Join(newPath, endTimeFileName), []byte(tt.Format(indexNameLayout)), 0666)
 	if err != nil {
-		return fmt.Errorf("write new endtime : %v", err)
+		return fmt.Errorf("write new endtime : %w", err)
 	}
 
 	return nil
@@ -145,7 +145,7 @@ func (sw *shardWriter) Output(id string, doc *document.Document, values map[stri
 
 	err := sw.batch.IndexAdvanced(doc)
 	if err != nil {
-		return fmt.Errorf("IndexAdvanced(%s) : %v", id, err)
+		return fmt.Errorf("IndexAdvanced(%s) : %w", id, err)
 	}
 	return sw.batch.Index(id, values)
 }
@@ -153,7 +153,7 @@ func (sw *shardWriter) Output(id string, doc *document.Document, values map[stri
 func (sw *shardWriter) Close() error {
 	err := sw.newShard.b.Batch(sw.batch)
 	if err != nil {
-		return fmt.Errorf("Batch : %v", err)
+		return fmt.Errorf("Batch : %w", err)
 	}
 	return sw.newShard.Close()
 }
@@ -187,7 +187,7 @@ func (sw *csvWriter) Close() error {
 func copyShard(oldShard *Shard, writer Writer, delta time.Duration) error {
 	i, a, err := oldShard.b.Advanced()
 	if err != nil {
-		return fmt.Errorf("Advanced : %v", err)
+		return fmt.Errorf("Advanced : %w", err)
 	}
 	if a != nil {
 		defer a.Close()
@@ -196,12 +196,12 @@ func copyShard(oldShard *Shard, writer Writer, delta time.Duration) error {
 
 	r, err := i.Reader()
 	if err != nil {
-		return fmt.Errorf("Advanced.Reader() : %v", err)
+		return fmt.Errorf("Advanced.Reader() : %w", err)
 	}
 	defer r.Close()
 	all, err := r.DocIDReaderAll()
 	if err != nil {
-		return fmt.Errorf("Advanced.Reader().All() : %v", err)
+		return fmt.Errorf("Advanced.Reader().All() : %w", err)
 	}
 	defer all.Close()
 
@@ -211,7 +211,7 @@ func copyShard(oldShard *Shard, writer Writer, delta time.Duration) error {
 	for {
 		id, err := all.Next()
 		if err != nil {
-			return fmt.Errorf("Advanced.Reader().All().Next() : %v", err)
+			return fmt.Errorf("Advanced.Reader().All().Next() : %w", err)
 		}
 
 		if id == nil {
@@ -220,7 +220,7 @@ func copyShard(oldShard *Shard, writer Writer, delta time.Duration) error {
 
 		idStr, err := r.ExternalID(id)
 		if err != nil {
-			return fmt.Errorf("ExternalID(%s).Next() : %v", id, err)
+			return fmt.Errorf("ExternalID(%s).Next() : %w", id, err)
 		}
 
 		docIDs = append(docIDs, idStr)
@@ -232,7 +232,7 @@ func copyShard(oldShard *Shard, writer Writer, delta time.Duration) error {
 	for idx, idStr := range docIDs {
 		doc, err := oldShard.b.Document(idStr)
 		if err != nil {
-			return fmt.Errorf("Document(%s) : %v", idStr, err)
+			return fmt.Errorf("Document(%s) : %w", idStr, err)
 		}
 		if doc == nil {
 			return fmt.Errorf("Document(%s) : empty", idStr)
@@ -299,7 +299,7 @@ func copyShard(oldShard *Shard, writer Writer, delta time.Duration) error {
 		err = writer.Output(idStr, doc, values)
 		// err = b.Index(idStr, values)
 		if err != nil {
-			return fmt.Errorf("IndexAdvanced(%d: %s) : %v", idx, idStr, err)
+			return fmt.Errorf("IndexAdvanced(%d: %s) : %w", idx, idStr, err)
 		}
 
 		// fmt.Println(idStr, doc.GoString())
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package ekanite
+
+import "errors"
+
+// diskFree fails, free space only being checked on Linux, macOS and FreeBSD.
+func diskFree(path string) (uint64, error) {
+	return 0, errors.New("checking free disk space is not supported on this platform")
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package ekanite
+
+import "syscall"
+
+// diskFree returns the bytes of the file system of path available to
+// unprivileged users.
+func diskFree(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
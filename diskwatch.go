@@ -0,0 +1,112 @@
+package ekanite
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultDiskCheckInterval is how often a DiskWatchdog checks the free space
+// by default.
+const DefaultDiskCheckInterval = 10 * time.Second
+
+var diskFreeBytes = new(expvar.Int)
+
+func init() {
+	stats.Set("diskFreeBytes", diskFreeBytes)
+}
+
+// DiskWatchdog checks the free space of the file system of a path, tripping
+// once it falls below MinFree bytes so that events are refused before writes
+// fail midway through a shard. Searches and retention go on meanwhile, the
+// latter freeing space. It resets once the free space is back above MinFree
+// by a tenth, so that it does not flap around the threshold.
+type DiskWatchdog struct {
+	Path     string
+	MinFree  uint64
+	Interval time.Duration // How often the free space is checked.
+
+	// OnChange, if set, is called with an error wrapping ErrDiskFull when
+	// the watchdog trips, and with nil when it resets.
+	OnChange func(err error)
+
+	Logger *log.Logger
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewDiskWatchdog returns a watchdog of the free space of path, tripping below
+// minFree bytes.
+func NewDiskWatchdog(path string, minFree uint64) *DiskWatchdog {
+	return &DiskWatchdog{
+		Path:     path,
+		MinFree:  minFree,
+		Interval: DefaultDiskCheckInterval,
+		Logger:   log.New(os.Stderr, "[disk] ", log.LstdFlags),
+	}
+}
+
+// Err returns an error wrapping ErrDiskFull while the watchdog is tripped,
+// nil otherwise.
+func (w *DiskWatchdog) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Check checks the free space once, tripping or resetting the watchdog. It
+// returns the error of the check, the watchdog being left as it is then.
+func (w *DiskWatchdog) Check() error {
+	free, err := diskFree(w.Path)
+	if err != nil {
+		return fmt.Errorf("failed to check free space of %s: %w", w.Path, err)
+	}
+	diskFreeBytes.Set(int64(free))
+
+	resetAt := w.MinFree + w.MinFree/10
+	if resetAt < w.MinFree {
+		resetAt = math.MaxUint64
+	}
+
+	w.mu.Lock()
+	var changed bool
+	switch {
+	case w.err == nil && free < w.MinFree:
+		w.err = fmt.Errorf("%w: %d bytes free on %s, below %d", ErrDiskFull, free, w.Path, w.MinFree)
+		changed = true
+		stats.Add("diskWatchdogTrips", 1)
+		w.Logger.Printf("refusing events, %d bytes free on %s, below %d", free, w.Path, w.MinFree)
+	case w.err != nil && free >= resetAt:
+		w.err = nil
+		changed = true
+		w.Logger.Printf("accepting events again, %d bytes free on %s", free, w.Path)
+	}
+	err = w.err
+	w.mu.Unlock()
+
+	if changed && w.OnChange != nil {
+		w.OnChange(err)
+	}
+	return nil
+}
+
+// Run checks the free space every Interval until done is closed.
+func (w *DiskWatchdog) Run(done <-chan struct{}) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.Check(); err != nil {
+				w.Logger.Println(err.Error())
+			}
+		case <-done:
+			return
+		}
+	}
+}
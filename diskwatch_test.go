@@ -0,0 +1,38 @@
+package ekanite
+
+import (
+	"errors"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestDiskWatchdog(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		t.Fatalf("failed to create data dir: %s", err.Error())
+	}
+
+	var changes []error
+	w := NewDiskWatchdog(dataDir, math.MaxUint64)
+	w.OnChange = func(err error) { changes = append(changes, err) }
+	if err := w.Check(); err != nil {
+		t.Skipf("free space cannot be checked: %s", err.Error())
+	}
+	if !errors.Is(w.Err(), ErrDiskFull) || len(changes) != 1 || !errors.Is(changes[0], ErrDiskFull) {
+		t.Fatalf("watchdog not tripped below threshold: %v, %v", w.Err(), changes)
+	}
+	if err := w.Check(); err != nil || len(changes) != 1 {
+		t.Fatalf("tripped watchdog changed again: %v, %v", err, changes)
+	}
+
+	w.MinFree = 1
+	if err := w.Check(); err != nil || w.Err() != nil || len(changes) != 2 || changes[1] != nil {
+		t.Fatalf("watchdog not reset above threshold: %v, %v", w.Err(), changes)
+	}
+	w.Path = dataDir + "/missing"
+	if err := w.Check(); err == nil {
+		t.Fatal("free space of missing path checked")
+	}
+}
@@ -0,0 +1,135 @@
+package ekanite
+
+import (
+	"context"
+	"fmt"
+)
+
+// Duplicate is a document indexed in two adjacent indexes, as happens when
+// clocks are skewed or a write-ahead log is replayed.
+type Duplicate struct {
+	ID    DocID  `json:"id"`
+	Older string `json:"older"` // Name of the earlier index, holding the older copy.
+	Newer string `json:"newer"` // Name of the later index.
+}
+
+// FindDuplicates scans the document IDs of each pair of adjacent indexes of a
+// tier for those indexed in both, removing the older copies if remove is set.
+// The IDs of one index are held in memory at a time.
+func (e *Engine) FindDuplicates(ctx context.Context, remove bool) ([]Duplicate, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	// Indexes are ordered by decreasing end time, so those of a tier follow
+	// the one after them in time.
+	var tiers []string
+	byTier := map[string][]*Index{}
+	for _, i := range e.indexes {
+		if _, ok := byTier[i.tier]; !ok {
+			tiers = append(tiers, i.tier)
+		}
+		byTier[i.tier] = append(byTier[i.tier], i)
+	}
+
+	var duplicates []Duplicate
+	for _, tier := range tiers {
+		found, err := e.findDuplicates(ctx, byTier[tier], remove)
+		if err != nil {
+			return nil, err
+		}
+		duplicates = append(duplicates, found...)
+	}
+	if remove {
+		removed := map[string]int{}
+		var names []string
+		for _, d := range duplicates {
+			if removed[d.Older] == 0 {
+				names = append(names, d.Older)
+			}
+			removed[d.Older]++
+		}
+		for _, name := range names {
+			e.audit("duplicates.remove", name, map[string]int{"documents": removed[name]})
+		}
+	}
+	return duplicates, nil
+}
+
+// findDuplicates finds the duplicates in adjacent indexes, ordered from the
+// latest. Removing them is a maintenance task.
+func (e *Engine) findDuplicates(ctx context.Context, indexes []*Index, remove bool) ([]Duplicate, error) {
+	var duplicates []Duplicate
+	var newerIDs map[DocID]struct{}
+	for k := 0; k+1 < len(indexes); k++ {
+		newer, older := indexes[k], indexes[k+1]
+		if newerIDs == nil {
+			ids, err := newer.DocIDs(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read IDs of index %s: %w", newer.Name(), err)
+			}
+			newerIDs = ids
+		}
+		olderIDs, err := older.DocIDs(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read IDs of index %s: %w", older.Name(), err)
+		}
+
+		var found DocIDs
+		for id := range olderIDs {
+			if _, ok := newerIDs[id]; ok {
+				found = append(found, id)
+				duplicates = append(duplicates, Duplicate{ID: id, Older: older.Name(), Newer: newer.Name()})
+			}
+		}
+		stats.Add("duplicatesFound", int64(len(found)))
+		if remove && len(found) > 0 {
+			if err := e.maintenanceStep(ctx, 0); err != nil {
+				return nil, err
+			}
+			if err := older.Delete(found); err != nil {
+				return nil, fmt.Errorf("failed to remove duplicates from index %s: %w", older.Name(), err)
+			}
+			for _, id := range found {
+				delete(olderIDs, id)
+			}
+			stats.Add("duplicatesRemoved", int64(len(found)))
+		}
+		newerIDs = olderIDs
+	}
+	return duplicates, nil
+}
+
+// DocIDs returns the IDs of the documents in the index. They are read from
+// the live documents of the shards rather than their term dictionaries, which
+// keep the IDs of deleted documents until their segments are merged.
+func (i *Index) DocIDs(ctx context.Context) (map[DocID]struct{}, error) {
+	ids := map[DocID]struct{}{}
+	for _, s := range i.Shards {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		shardIDs, err := shardDocIDs(s)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range shardIDs {
+			ids[DocID(id)] = struct{}{}
+		}
+	}
+	return ids, nil
+}
+
+// Delete removes the documents with the given IDs from the index.
+func (i *Index) Delete(ids DocIDs) error {
+	byShard := map[*Shard][]DocID{}
+	for _, id := range ids {
+		s := i.Shard(id)
+		byShard[s] = append(byShard[s], id)
+	}
+	for s, ids := range byShard {
+		if err := s.Delete(ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package ekanite
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Durability controls when writes to a shard are persisted to disk.
+type Durability int
+
+const (
+	// DurabilityBatch waits for every batch to be persisted before the write
+	// returns. This is the default.
+	DurabilityBatch Durability = iota
+
+	// DurabilityInterval buffers writes in memory and persists them at most
+	// every sync interval. Buffered writes are not searchable, and are lost on
+	// a crash, until they are persisted.
+	DurabilityInterval
+
+	// DurabilityOS returns as soon as a batch is searchable, leaving bleve to
+	// persist it in the background.
+	DurabilityOS
+)
+
+// ParseDurability parses a durability setting: "batch", "os", or a duration
+// such as "5s" for DurabilityInterval.
+func ParseDurability(s string) (Durability, time.Duration, error) {
+	switch s = strings.TrimSpace(strings.ToLower(s)); s {
+	case "", "batch":
+		return DurabilityBatch, 0, nil
+	case "os":
+		return DurabilityOS, 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return DurabilityBatch, 0, fmt.Errorf("invalid durability %q, expected batch, os or a positive duration", s)
+	}
+	return DurabilityInterval, d, nil
+}
@@ -6,16 +6,20 @@ import (
 	"errors"
 	"expvar"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/blevesearch/bleve"
 	bleve_index "github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/mapping"
 )
 
 // Engine defaults
@@ -23,8 +27,23 @@ const (
 	DefaultNumShards       = 4
 	DefaultIndexDuration   = 24 * time.Hour
 	DefaultRetentionPeriod = 7 * 24 * time.Hour
+	DefaultMaxBatchSize    = 1000
+	DefaultSearchMemory    = 1 << 30
 
-	RetentionCheckInterval = time.Hour
+	DefaultRetentionCheckInterval  = time.Hour
+	DefaultCompactionCheckInterval = time.Hour
+
+	// DefaultMaintenanceYield is the longest maintenance tasks wait for
+	// indexing in progress between steps.
+	DefaultMaintenanceYield = time.Second
+
+	// indexWorkersPerShard is the number of sub-batches indexed at once per
+	// shard of an index, when IndexWorkers is not set.
+	indexWorkersPerShard = 2
+
+	// rolloverCheckInterval is how often the size of the current index is
+	// checked against the rollover thresholds.
+	rolloverCheckInterval = 10 * time.Second
 )
 
 // Engine stats
@@ -40,21 +59,159 @@ type Searcher interface {
 	FieldDict(ctx context.Context, startTime, endTime time.Time, field string) ([]bleve_index.DictEntry, error)
 }
 
+// IndexSearcher is implemented by searchers which can list their indexes and
+// search specific ones by name, rather than by time range.
+type IndexSearcher interface {
+	Indexes() ([]IndexInfo, error)
+	QueryIndexes(ctx context.Context, names []string, req *bleve.SearchRequest,
+		cb func(*bleve.SearchRequest, *bleve.SearchResult) error) error
+	SampleIndex(ctx context.Context, name, field string, n int) (*IndexSample, error)
+	IndexStorage(ctx context.Context, name string) (*IndexStorage, error)
+	IndexMetadata(name string) (*IndexMetadata, error)
+}
+
+// Estimator is implemented by searchers which can estimate the cost of a search
+// request without running it.
+type Estimator interface {
+	Estimate(startTime, endTime time.Time, names []string, req *bleve.SearchRequest) (*QueryEstimate, error)
+}
+
+// IndexHolder is implemented by searchers whose indexes can be held, exempting
+// them from retention enforcement until released.
+type IndexHolder interface {
+	HoldIndex(name, reason string) error
+	ReleaseIndex(name string) error
+}
+
+// IndexMapper is implemented by searchers whose mapping of the indexes created
+// next can be exported, and replaced.
+type IndexMapper interface {
+	IndexMapping() (*mapping.IndexMappingImpl, error)
+	StageIndexMapping(m *mapping.IndexMappingImpl) error
+}
+
+// FieldReporter is implemented by searchers which can report the types and
+// cardinality of the fields of their recent documents.
+type FieldReporter interface {
+	FieldReport(ctx context.Context, startTime, endTime time.Time, n int) (*FieldReport, error)
+}
+
+// RetentionReporter is implemented by searchers which can report the start of
+// the oldest data they retain.
+type RetentionReporter interface {
+	EarliestRetained() (time.Time, bool)
+}
+
+// Snapshotter is implemented by searchers which can take point-in-time
+// snapshots of their indexes, for reads too long to hold them meanwhile.
+type Snapshotter interface {
+	Snapshot(startTime, endTime time.Time, names []string) (*IndexSnapshot, error)
+}
+
+// IndexArchiver is implemented by searchers which can archive an index, with
+// a manifest of the checksums of its files checked when it is restored.
+type IndexArchiver interface {
+	ArchiveIndex(ctx context.Context, name string, w io.Writer) error
+}
+
+// ActivityMonitor is implemented by searchers which can list the searches
+// they are running, and cancel them.
+type ActivityMonitor interface {
+	Activity() []SearchActivity
+	CancelSearch(id string) error
+}
+
+// Refresher is implemented by what can make the documents it received so far
+// searchable in the indexes covering a time range, so that searches read
+// their writes.
+type Refresher interface {
+	Refresh(ctx context.Context, startTime, endTime time.Time) error
+}
+
+// CaseFolder is implemented by searchers which lower-case the values of some
+// keyword fields, so that queries of them are to be lower-cased too.
+type CaseFolder interface {
+	CaseFoldFields() CaseFold
+}
+
+// CaseFoldOf returns the keyword fields the searcher lower-cases, none if it
+// is not a CaseFolder.
+func CaseFoldOf(searcher Searcher) CaseFold {
+	if f, ok := searcher.(CaseFolder); ok {
+		return f.CaseFoldFields()
+	}
+	return nil
+}
+
+// QueryEstimate describes the cost of a search request.
+type QueryEstimate struct {
+	Indexes   []string `json:"indexes"`          // Names of the indexes searched.
+	Shards    int      `json:"shards"`           // Number of shards searched.
+	Documents uint64   `json:"documents"`        // Documents in those shards, an upper bound of those scanned.
+	Memory    int64    `json:"memory"`           // Estimated bytes held while searching.
+	Rejected  bool     `json:"rejected"`         // Whether the search would be shed right now.
+	Reason    string   `json:"reason,omitempty"` // Why the search would be shed.
+}
+
+// IndexInfo describes an index.
+type IndexInfo struct {
+	Name      string    `json:"name"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Shards    int       `json:"shards"`
+	Documents uint64    `json:"documents"`
+	Hold      string    `json:"hold,omitempty"` // Why the index is exempt from retention, if held.
+	Tier      string    `json:"tier,omitempty"` // Name of the tier of the index, if in one.
+
+	// Settings the index was created with, without its mapping, if recorded.
+	Metadata *IndexMetadata `json:"metadata,omitempty"`
+}
+
 // EventIndexer is the interface a system than can index events must implement.
 type EventIndexer interface {
 	Index(events []Document) error
 }
 
+// Releaser is implemented by documents which can be recycled once they have
+// been indexed.
+type Releaser interface {
+	Release()
+}
+
 // Batcher accepts "input events", and once it has a certain number, or a certain amount
 // of time has passed, sends those as indexable Events to an Indexer. It also supports a
 // maximum number of unprocessed Events it will keep pending. Once this limit is reached,
 // it will not accept anymore until outstanding Events are processed.
+//
+// If LaneDuration is set, events are batched in separate lanes keyed by their reference
+// time truncated to LaneDuration, so that each batch sent to the Indexer falls within a
+// single index, even around index boundaries.
+//
+// A batch failing to be indexed is retried Retries times, with a backoff. It is then
+// written to SpillDir if set, and replayed from there once batches are indexed again;
+// otherwise it is dropped, the error being sent on the channel passed to Start.
 type Batcher struct {
 	indexer  EventIndexer
 	size     int
 	duration time.Duration
 
-	c chan Document
+	LaneDuration time.Duration      // Width of each batching lane, usually the index duration.
+	Enrichment   []EnrichmentPlugin // Plugins run in order over each batch before it is indexed.
+	Retries      int                // Times a batch failing to be indexed is retried in memory.
+	RetryBackoff time.Duration      // Wait before the first retry of a batch, doubled before each of the next.
+	SpillDir     string             // Where batches out of retries are written to be replayed, if set.
+
+	c       chan Document
+	refresh chan chan struct{}
+
+	oldest int64 // Unix nanoseconds at which the oldest pending event was batched, 0 if none
+	lag    lagRecorder
+
+	// Only used by the batching goroutine, but retryingEvents.
+	retrying       []*failedBatch
+	retryingEvents int64
+	spilled        int // Number of batches in SpillDir.
+	spillSeq       int
 }
 
 // NewBatcher returns a Batcher for EventIndexer e, a batching size of sz, a maximum duration
@@ -65,44 +222,150 @@ func NewBatcher(e EventIndexer, sz int, dur time.Duration, max int) *Batcher {
 		size:     sz,
 		duration: dur,
 		c:        make(chan Document, max),
+		refresh:  make(chan chan struct{}),
+
+		Retries:      DefaultBatchRetries,
+		RetryBackoff: DefaultBatchRetryBackoff,
 	}
 }
 
-// Start starts the batching process.
+// lane returns the key of the lane the document is batched in.
+func (b *Batcher) lane(d Document) int64 {
+	if b.LaneDuration <= 0 {
+		return 0
+	}
+	return d.ReferenceTime().Truncate(b.LaneDuration).UnixNano()
+}
+
+// QueueDepth returns the number of events waiting to be batched.
+func (b *Batcher) QueueDepth() int {
+	return len(b.c)
+}
+
+// OldestPending returns how long the oldest event batched but not yet indexed
+// has been waiting, or zero if there is none.
+func (b *Batcher) OldestPending() time.Duration {
+	oldest := atomic.LoadInt64(&b.oldest)
+	if oldest == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, oldest))
+}
+
+// Lag returns the 50th, 90th and 99th percentiles of the time from reception
+// to being indexed, over recent events implementing Receiver.
+func (b *Batcher) Lag() (p50, p90, p99 time.Duration) {
+	ps := b.lag.percentiles(50, 90, 99)
+	return ps[0], ps[1], ps[2]
+}
+
+// publish exposes the batcher gauges in the engine stats.
+func (b *Batcher) publish() {
+	stats.Set("batcherQueueDepth", expvar.Func(func() interface{} {
+		return b.QueueDepth()
+	}))
+	stats.Set("batcherOldestPendingMs", expvar.Func(func() interface{} {
+		return int64(b.OldestPending() / time.Millisecond)
+	}))
+	stats.Set("batcherRetryingEvents", expvar.Func(func() interface{} {
+		return atomic.LoadInt64(&b.retryingEvents)
+	}))
+	stats.Set("batcherLagMs", expvar.Func(func() interface{} {
+		p50, p90, p99 := b.Lag()
+		return map[string]int64{
+			"p50": int64(p50 / time.Millisecond),
+			"p90": int64(p90 / time.Millisecond),
+			"p99": int64(p99 / time.Millisecond),
+		}
+	}))
+}
+
+// Start starts the batching process. Batches spilled to SpillDir before are
+// replayed once a batch is indexed.
 func (b *Batcher) Start(errChan chan<- error) error {
+	if b.SpillDir != "" {
+		if err := os.MkdirAll(b.SpillDir, 0755); err != nil {
+			return err
+		}
+		paths, err := b.spillFiles()
+		if err != nil {
+			return err
+		}
+		b.spilled = len(paths)
+	}
+	b.publish()
+
 	go func() {
-		batch := make([]Document, 0, b.size)
+		lanes := make(map[int64][]Document)
+		since := make(map[int64]time.Time) // When each lane got its first event
 		timer := time.NewTimer(b.duration)
 		timer.Stop() // Stop any first firing.
+		retry := b.retryAfter()
 
-		send := func() {
-			err := b.indexer.Index(batch)
-			if err != nil {
-				stats.Add("batchIndexedError", 1)
-				return
+		updateOldest := func() {
+			var oldest int64
+			for _, t := range since {
+				if oldest == 0 || t.UnixNano() < oldest {
+					oldest = t.UnixNano()
+				}
 			}
-			stats.Add("batchIndexed", 1)
-			stats.Add("eventsIndexed", int64(len(batch)))
-			if errChan != nil {
-				errChan <- err
+			atomic.StoreInt64(&b.oldest, oldest)
+		}
+
+		send := func(lane int64) {
+			batch := lanes[lane]
+			delete(lanes, lane)
+			delete(since, lane)
+			updateOldest()
+			enrichBatch(b.Enrichment, batch)
+			b.index(batch, 0, errChan)
+			retry = b.retryAfter()
+		}
+
+		add := func(event Document) {
+			lane := b.lane(event)
+			batch := append(lanes[lane], event)
+			if len(lanes) == 0 {
+				timer.Reset(b.duration)
+			}
+			if _, ok := since[lane]; !ok {
+				since[lane] = time.Now()
+				updateOldest()
+			}
+			lanes[lane] = batch
+			if len(batch) >= b.size {
+				send(lane)
+				if len(lanes) == 0 {
+					timer.Stop()
+				}
 			}
-			batch = make([]Document, 0, b.size)
 		}
 
 		for {
 			select {
 			case event := <-b.c:
-				batch = append(batch, event)
-				if len(batch) == 1 {
-					timer.Reset(b.duration)
+				add(event)
+			case done := <-b.refresh:
+				// Batch the events queued so far, then send all lanes.
+				for n := len(b.c); n > 0; n-- {
+					add(<-b.c)
 				}
-				if len(batch) == b.size {
-					timer.Stop()
-					send()
+				for lane := range lanes {
+					send(lane)
 				}
+				timer.Stop()
+				close(done)
 			case <-timer.C:
 				stats.Add("batchTimeout", 1)
-				send()
+				for lane := range lanes {
+					send(lane)
+				}
+				if len(lanes) != 0 {
+					timer.Reset(b.duration)
+				}
+			case <-retry:
+				b.retryDue(errChan)
+				retry = b.retryAfter()
 			}
 		}
 	}()
@@ -110,6 +373,28 @@ func (b *Batcher) Start(errChan chan<- error) error {
 	return nil
 }
 
+// Refresh sends the events queued and batched so far to the indexer, without
+// waiting for their batches to fill up or time out, then refreshes the
+// indexer if it is a Refresher. Batches being retried are not waited for.
+func (b *Batcher) Refresh(ctx context.Context, startTime, endTime time.Time) error {
+	done := make(chan struct{})
+	select {
+	case b.refresh <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	stats.Add("batcherRefreshed", 1)
+	if r, ok := b.indexer.(Refresher); ok {
+		return r.Refresh(ctx, startTime, endTime)
+	}
+	return nil
+}
+
 // Stop stops the batching process.
 func (b *Batcher) Stop() {
 	close(b.c)
@@ -127,9 +412,45 @@ type Engine struct {
 	IndexDuration   time.Duration // Duration of created indexes.
 	NumCaches       int           // Number of caches to use when search in index.
 	RetentionPeriod time.Duration // How long after Index end-time to hang onto data.
+	RetentionCheck  time.Duration // How often to check for expired indexes, plus up to 10% jitter.
+	CompactAfter    time.Duration // How long after Index end-time to rebuild its shards compactly, 0 for never.
+	CompactionCheck time.Duration // How often to check for indexes to compact, plus up to 10% jitter.
+	RepairOverlaps  bool          // Repair overlapping indexes on open, instead of failing.
+	MaxBatchSize    int           // Maximum documents per bleve batch on a shard, 0 for no limit.
+	SearchMemory    int64         // Estimated bytes all running searches may hold, 0 for no limit.
+	RolloverDocs    uint64        // Documents after which the current index is ended early, 0 for no limit.
+	RolloverBytes   int64         // Bytes on disk after which the current index is ended early, 0 for no limit.
+	IndexWorkers    int           // Sub-batches indexed at once across all indexes, 0 for NumShards×2.
+	WarmupQueries   []string      // Query strings run against each index opened, to warm its caches.
+	Tiers           []Tier        // Tiers documents are routed to by severity, most severe first, none for a single tier.
+	CaseFold        CaseFold      // Keyword fields lower-cased in the indexes created, and in the queries of them.
+	StoreMessage    bool          // Store the message of each event in the indexes created, not only index it.
+	DetectLanguage  bool          // Also index Chinese messages through the CJK analyzer.
+	Durability      Durability    // When writes to the shards are persisted to disk.
+	SyncInterval    time.Duration // How often writes are persisted under DurabilityInterval.
+	Audit           *AuditLog     // Records the indexes deleted and the documents removed, if set.
+
+	// Maintenance tasks, such as retention deletions, duplicate removal and
+	// warm-up queries, yield to indexing in progress for up to
+	// MaintenanceYield between steps, and go through at most
+	// MaintenanceBandwidth bytes per second of disk I/O, 0 for no limit.
+	MaintenanceBandwidth int64
+	MaintenanceYield     time.Duration
 
 	mu      sync.RWMutex
 	indexes Indexes
+	staged  map[*Index]chan struct{} // Indexes taken offline, each channel closed once back.
+	budget  memoryBudget
+
+	activity activityRegistry // Searches running.
+
+	mappingMu     sync.RWMutex
+	stagedMapping []byte // Mapping of the indexes created next, nil for the built-in one.
+
+	workersOnce sync.Once
+	workers     chan struct{} // Slots of the indexing workers.
+	indexing    int64         // Calls to Index in progress.
+	maintenance tokenBucket   // Limits the disk I/O of maintenance tasks.
 
 	open bool
 	done chan struct{}
@@ -141,23 +462,41 @@ type Engine struct {
 // NewEngine returns a new indexing engine, which will use any data located at path.
 func NewEngine(path string) *Engine {
 	return &Engine{
-		path:            path,
-		NumShards:       DefaultNumShards,
-		IndexDuration:   DefaultIndexDuration,
-		RetentionPeriod: DefaultRetentionPeriod,
-		done:            make(chan struct{}),
-		Logger:          log.New(os.Stderr, "[engine] ", log.LstdFlags),
+		path:             path,
+		NumShards:        DefaultNumShards,
+		IndexDuration:    DefaultIndexDuration,
+		RetentionPeriod:  DefaultRetentionPeriod,
+		RetentionCheck:   DefaultRetentionCheckInterval,
+		CompactionCheck:  DefaultCompactionCheckInterval,
+		MaxBatchSize:     DefaultMaxBatchSize,
+		StoreMessage:     true,
+		SearchMemory:     DefaultSearchMemory,
+		MaintenanceYield: DefaultMaintenanceYield,
+		done:             make(chan struct{}),
+		Logger:           log.New(os.Stderr, "[engine] ", log.LstdFlags),
 	}
 }
 
 // Open opens the engine.
 func (e *Engine) Open() error {
+	if e.RetentionCheck <= 0 {
+		return fmt.Errorf("retention check interval must be positive, got %s", e.RetentionCheck)
+	}
+	if e.CompactAfter > 0 && e.CompactionCheck <= 0 {
+		return fmt.Errorf("compaction check interval must be positive, got %s", e.CompactionCheck)
+	}
+	if e.Durability == DurabilityInterval && e.SyncInterval <= 0 {
+		return fmt.Errorf("sync interval must be positive, got %s", e.SyncInterval)
+	}
 	if err := os.MkdirAll(e.path, 0755); err != nil {
 		return err
 	}
+	if err := e.loadStagedMapping(); err != nil {
+		return fmt.Errorf("failed to open engine: %w", err)
+	}
 	d, err := os.Open(e.path)
 	if err != nil {
-		return fmt.Errorf("failed to open engine: %s", err.Error())
+		return fmt.Errorf("failed to open engine: %w", err)
 	}
 
 	fis, err := d.Readdir(0)
@@ -166,43 +505,123 @@ func (e *Engine) Open() error {
 	}
 	d.Close()
 
-	// Open all indexes.
+	opts, err := e.indexOptions()
+	if err != nil {
+		return fmt.Errorf("failed to open engine: %w", err)
+	}
+
+	// Open all indexes, finishing the deletion of those left half-deleted.
 	for _, fi := range fis {
+		if fi.IsDir() && strings.HasPrefix(fi.Name(), deletingPrefix) {
+			if err := os.RemoveAll(filepath.Join(e.path, fi.Name())); err != nil {
+				return fmt.Errorf("engine failed to finish deleting %s: %w", fi.Name(), err)
+			}
+			continue
+		}
 		if !fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
 			continue
 		}
 		indexPath := filepath.Join(e.path, fi.Name())
-		i, err := OpenIndex(indexPath)
+		i, err := openIndex(indexPath, e.IndexDuration, opts)
 		if err != nil {
-			return fmt.Errorf("engine failed to open at index %s: %s", indexPath, err.Error())
+			return fmt.Errorf("engine failed to open at index %s: %w", indexPath, err)
 		}
+		i.SetMaxBatchSize(e.MaxBatchSize)
 		log.Printf("engine opened index with %d shard(s) at %s", len(i.Shards), indexPath)
 		e.indexes = append(e.indexes, i)
 		sort.Sort(e.indexes)
 	}
 
+	if err := e.resolveOverlaps(); err != nil {
+		for _, i := range e.indexes {
+			i.Close()
+		}
+		e.indexes = nil
+		return err
+	}
+
 	e.wg.Add(1)
 	go e.runRetentionEnforcement()
 
+	if e.CompactAfter > 0 {
+		e.wg.Add(1)
+		go e.runCompaction()
+	}
+
+	if e.Durability == DurabilityInterval {
+		e.wg.Add(1)
+		go e.runSync(e.SyncInterval)
+	}
+
+	// Always check for rollover, as Reconfigure may set thresholds later.
+	e.wg.Add(1)
+	go e.runRollover()
+
+	if len(e.WarmupQueries) > 0 {
+		e.wg.Add(1)
+		go e.runWarmup()
+	}
+
 	e.open = true
 	return nil
 }
 
+// resolveOverlaps checks that no two indexes cover the same time range. If
+// RepairOverlaps is set, the earlier of two overlapping indexes is made to end
+// where the later one starts. Otherwise the overlap is reported as an error.
+func (e *Engine) resolveOverlaps() error {
+	for _, o := range e.indexes.Overlaps() {
+		stats.Add("indexOverlaps", 1)
+		if !e.RepairOverlaps {
+			return fmt.Errorf("index %s overlaps index %s, repair with `ekanite -repair %s`",
+				o[0].Path(), o[1].Path(), e.path)
+		}
+		if err := o[0].SetEndTime(o[1].StartTime()); err != nil {
+			return fmt.Errorf("failed to repair overlap of index %s: %w", o[0].Path(), err)
+		}
+		e.Logger.Printf("index %s overlapped index %s, end time set to %s",
+			o[0].Path(), o[1].Path(), o[0].EndTime())
+	}
+	sort.Sort(e.indexes)
+	return nil
+}
+
+// Settings are the engine settings which may be changed while it is open.
+type Settings struct {
+	RetentionPeriod time.Duration
+	SearchMemory    int64
+	RolloverDocs    uint64
+	RolloverBytes   int64
+}
+
+// Reconfigure applies s to the engine. It may be called while the engine is
+// open, the new settings being used by the next retention check, search and
+// rollover check.
+func (e *Engine) Reconfigure(s Settings) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.RetentionPeriod = s.RetentionPeriod
+	e.SearchMemory = s.SearchMemory
+	e.RolloverDocs = s.RolloverDocs
+	e.RolloverBytes = s.RolloverBytes
+	stats.Add("reconfigured", 1)
+}
+
 // Close closes the engine.
 func (e *Engine) Close() error {
 	if !e.open {
 		return nil
 	}
 
+	close(e.done)
+	e.wg.Wait()
+
 	for _, i := range e.indexes {
 		if err := i.Close(); err != nil {
 			return err
 		}
 	}
 
-	close(e.done)
-	e.wg.Wait()
-
 	e.open = false
 	return nil
 }
@@ -231,46 +650,202 @@ func (e *Engine) runRetentionEnforcement() {
 		case <-e.done:
 			return
 
-		case <-time.After(RetentionCheckInterval):
+		case <-time.After(jitter(e.RetentionCheck)):
 			stats.Add("retentionEnforcementRun", 1)
 			e.enforceRetention()
 		}
 	}
 }
 
-// enforceRetention removes indexes which have aged out.
-func (e *Engine) enforceRetention() {
+// runSync periodically persists writes buffered under DurabilityInterval.
+func (e *Engine) runSync(interval time.Duration) {
+	defer e.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+
+		case <-ticker.C:
+			stats.Add("syncRun", 1)
+			e.mu.RLock()
+			for _, i := range e.indexes {
+				if err := i.Flush(); err != nil {
+					e.Logger.Printf("failed to sync index %s: %s", i.Path(), err.Error())
+				}
+			}
+			e.mu.RUnlock()
+		}
+	}
+}
+
+// runRollover periodically rolls the current index over if it grew too big.
+func (e *Engine) runRollover() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(rolloverCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+
+		case <-ticker.C:
+			e.rollover(time.Now().UTC())
+		}
+	}
+}
+
+// rollover ends the index of each tier covering now early, and creates the
+// index taking over the rest of its time range, if it holds more than
+// RolloverDocs documents or RolloverBytes bytes. The index ends at the minute
+// after now or after the latest reference time it holds, index names having a
+// minute resolution, so that the documents it already holds stay within its
+// time range. It is not rolled over if that leaves no time to take over.
+func (e *Engine) rollover(now time.Time) {
+	for _, tier := range e.tierNames() {
+		e.rolloverTier(tier, now)
+	}
+}
+
+// rolloverTier rolls the index of the tier covering now over, if oversized.
+func (e *Engine) rolloverTier(tier string, now time.Time) {
+	// Sizing the index walks its files, so keep writes going meanwhile.
+	e.mu.RLock()
+	i := e.indexForReferenceTime(tier, now)
+	maxDocs, maxBytes := e.RolloverDocs, e.RolloverBytes
+	e.mu.RUnlock()
+	if i == nil || !oversized(i, maxDocs, maxBytes) {
+		return
+	}
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
+	if e.indexForReferenceTime(tier, now) != i {
+		return
+	}
+	// Documents may have reference times later than now, so split past the
+	// latest held for them to stay within the time range of the index.
+	latest, err := i.latestReferenceTime()
+	if err != nil {
+		e.Logger.Printf("failed to roll over index %s: %s", i.Path(), err.Error())
+		return
+	}
+	if latest.Before(now) {
+		latest = now
+	}
+	split := latest.Truncate(time.Minute).Add(time.Minute)
+	if !split.Before(i.endTime) {
+		return
+	}
+	end := i.endTime
+	if err := i.SetEndTime(split); err != nil {
+		e.Logger.Printf("failed to roll over index %s: %s", i.Path(), err.Error())
+		return
+	}
+	sort.Sort(e.indexes)
+	stats.Add("indexRollovers", 1)
+	e.Logger.Printf("index %s rolled over, end time set to %s", i.Path(), split)
+
+	// Should this fail, an index is created when the first event past split
+	// arrives.
+	if _, err := e.createTierIndex(tier, split, split, end); err != nil {
+		e.Logger.Printf("failed to create index after rollover of %s: %s", i.Path(), err.Error())
+	}
+}
 
+// oversized returns whether the index holds more than maxDocs documents or
+// maxBytes bytes, 0 meaning no limit.
+func oversized(i *Index, maxDocs uint64, maxBytes int64) bool {
+	if maxDocs > 0 {
+		if total, err := i.Total(); err == nil && total > maxDocs {
+			return true
+		}
+	}
+	if maxBytes > 0 {
+		if size, err := i.Size(); err == nil && size > maxBytes {
+			return true
+		}
+	}
+	return false
+}
+
+// jitter returns d plus a random duration of up to 10% of d, so that engines
+// started together do not run their periodic work in lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d/10 <= 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int63n(int64(d/10)))
+}
+
+// enforceRetention removes indexes which have aged out.
+func (e *Engine) enforceRetention() {
+	// Evict expired indexes first. Searches and writes hold the read lock
+	// while they use an index, so once the write lock is taken no one is
+	// using the evicted indexes any more.
+	e.mu.Lock()
+	var expired []*Index
+	retentions := map[*Index]time.Duration{} // Taken under the lock, as Reconfigure changes them.
 	filtered := e.indexes[:0]
 	for _, i := range e.indexes {
-		if i.Expired(time.Now().UTC(), e.RetentionPeriod) {
-			if err := DeleteIndex(i); err != nil {
-				e.Logger.Printf("retention enforcement failed to delete index %s: %s", i.path, err.Error())
-			} else {
-				e.Logger.Printf("retention enforcement deleted index %s", i.path)
-				stats.Add("retentionEnforcementDeletions", 1)
-			}
+		retention := e.retention(i)
+		if i.Expired(time.Now().UTC(), retention) && i.Hold() == "" {
+			expired = append(expired, i)
+			retentions[i] = retention
 		} else {
 			filtered = append(filtered, i)
 		}
 	}
 	e.indexes = filtered
-	return
+	e.mu.Unlock()
+
+	// Close and remove them without blocking searches, nor contending with
+	// indexing for the disk.
+	for _, i := range expired {
+		if err := e.deleteIndex(i); err != nil {
+			e.Logger.Printf("retention enforcement failed to delete index %s: %s", i.path, err.Error())
+		} else {
+			e.Logger.Printf("retention enforcement deleted index %s", i.path)
+			stats.Add("retentionEnforcementDeletions", 1)
+			e.audit("retention.delete", i.Name(), map[string]interface{}{
+				"end_time":  i.endTime,
+				"retention": retentions[i].String(),
+			})
+		}
+	}
+}
+
+// audit records an action the engine took by itself in its audit log.
+func (e *Engine) audit(action, target string, payload interface{}) {
+	if err := e.Audit.Record(SystemActor, action, target, payload); err != nil {
+		e.Logger.Printf("failed to record %s of %s in audit log: %s", action, target, err.Error())
+	}
 }
 
-// indexForReferenceTime returns an index suitable for indexing an event
-// for the given reference time. Must be called under RLock.
-func (e *Engine) indexForReferenceTime(t time.Time) *Index {
+// indexForReferenceTime returns an index of the tier suitable for indexing an
+// event for the given reference time. Must be called under RLock.
+func (e *Engine) indexForReferenceTime(tier string, t time.Time) *Index {
 	for _, i := range e.indexes {
-		if i.Contains(t) {
+		if i.tier == tier && i.Contains(t) {
 			return i
 		}
 	}
 	return nil
 }
 
+// stagedForReferenceTime returns the channel closed once the index of the tier
+// for the given reference time, taken offline, is back. It returns nil if that
+// index is not offline. Must be called under RLock.
+func (e *Engine) stagedForReferenceTime(tier string, t time.Time) chan struct{} {
+	for i, back := range e.staged {
+		if i.tier == tier && i.Contains(t) {
+			return back
+		}
+	}
+	return nil
+}
+
 // getIndexs get all index with a given start and end time and it must be called under lock.
 func (e *Engine) getIndexs(startTime, endTime time.Time) []*Index {
 	if startTime.IsZero() {
@@ -320,25 +895,44 @@ func (e *Engine) getIndexs(startTime, endTime time.Time) []*Index {
 // createIndex creates an index with a given start and end time and adds the
 // created index to the Engine's store. It must be called under lock.
 func (e *Engine) createIndex(startTime, endTime time.Time) (*Index, error) {
-	// There cannot be two indexes with the same start time, since this would mean
-	// two indexes with the same path. So if an index already exists with the requested
-	// start time, use that index's end time as the start time.
-	var idx *Index
-	for _, i := range e.indexes {
-		if i.startTime == startTime {
-			idx = i
-			break
-		}
+	return e.createTierIndex("", startTime, startTime, endTime)
+}
+
+// createTierIndex is like createIndex, for an index of the named tier which
+// must contain rt.
+func (e *Engine) createTierIndex(tier string, rt, startTime, endTime time.Time) (*Index, error) {
+	// An event must belong to exactly one index of its tier, and there cannot
+	// be two indexes of a tier with the same start time, since this would mean
+	// two indexes with the same path. So clip the requested range to the gap
+	// between the existing indexes of the tier around rt, staged ones included.
+	existing := e.indexes
+	for i := range e.staged {
+		existing = append(existing[:len(existing):len(existing)], i)
 	}
-	if idx != nil {
-		startTime = idx.endTime // XXX This could still align with another start time! Needs some sort of loop.
-		assert(!startTime.After(endTime), "new start time after end time")
+	for _, i := range existing {
+		if i.tier != tier {
+			continue
+		}
+		if i.Contains(rt) {
+			return nil, fmt.Errorf("no room for an index at %s, taken by index %s", rt, i.Path())
+		}
+		if !i.endTime.After(rt) && i.endTime.After(startTime) {
+			startTime = i.endTime
+		}
+		if i.startTime.After(rt) && i.startTime.Before(endTime) {
+			endTime = i.startTime
+		}
 	}
 
-	i, err := NewIndex(e.path, startTime, endTime, e.NumShards)
+	opts, err := e.indexOptions()
+	if err != nil {
+		return nil, err
+	}
+	i, err := newTierIndex(e.path, tier, startTime, endTime, e.NumShards, opts)
 	if err != nil {
 		return nil, err
 	}
+	i.SetMaxBatchSize(e.MaxBatchSize)
 	e.indexes = append(e.indexes, i)
 	sort.Sort(e.indexes)
 
@@ -347,16 +941,19 @@ func (e *Engine) createIndex(startTime, endTime time.Time) (*Index, error) {
 	return i, nil
 }
 
-// createIndexForReferenceTime creates an index suitable for indexing an event at the given
-// reference time.
-func (e *Engine) createIndexForReferenceTime(rt time.Time) (*Index, error) {
+// createIndexForReferenceTime creates an index of the tier suitable for indexing an event at
+// the given reference time.
+func (e *Engine) createIndexForReferenceTime(tier string, rt time.Time) (*Index, error) {
 	start := rt.Truncate(e.IndexDuration).UTC()
 	end := start.Add(e.IndexDuration).UTC()
-	return e.createIndex(start, end)
+	return e.createTierIndex(tier, rt, start, end)
 }
 
 // Index indexes a batch of Events. It blocks until all processing has completed.
 func (e *Engine) Index(events []Document) error {
+	atomic.AddInt64(&e.indexing, 1)
+	defer atomic.AddInt64(&e.indexing, -1)
+
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
@@ -365,10 +962,15 @@ func (e *Engine) Index(events []Document) error {
 	// De-multiplex the batch into sub-batches, one sub-batch for each Index.
 	subBatches := make(map[*Index][]Document, 0)
 
+	var mu sync.Mutex
+	var errList []error
+events:
 	for _, ev := range events {
-		index := e.indexForReferenceTime(ev.ReferenceTime())
-		if index == nil {
-			func() {
+		tier := e.tierOf(ev)
+		index := e.indexForReferenceTime(tier, ev.ReferenceTime())
+		for index == nil {
+			var staged chan struct{}
+			err := func() error {
 				// Take a RWLock, check again, and create a new index if necessary.
 				// Doing this in a function makes lock management foolproof.
 				e.mu.RUnlock()
@@ -376,15 +978,28 @@ func (e *Engine) Index(events []Document) error {
 				e.mu.Lock()
 				defer e.mu.Unlock()
 
-				index = e.indexForReferenceTime(ev.ReferenceTime())
-				if index == nil {
-					var err error
-					index, err = e.createIndexForReferenceTime(ev.ReferenceTime())
-					if err != nil || index == nil {
-						panic(fmt.Sprintf("failed to create index for %s: %s", ev.ReferenceTime(), err))
-					}
+				index = e.indexForReferenceTime(tier, ev.ReferenceTime())
+				if index != nil {
+					return nil
 				}
+				if staged = e.stagedForReferenceTime(tier, ev.ReferenceTime()); staged != nil {
+					return nil
+				}
+				var err error
+				index, err = e.createIndexForReferenceTime(tier, ev.ReferenceTime())
+				return err
 			}()
+			if err != nil {
+				stats.Add("eventsIndexFailed", 1)
+				errList = append(errList, fmt.Errorf("failed to create index for %s: %w", ev.ReferenceTime(), err))
+				continue events
+			}
+			if staged != nil {
+				// The index is offline, wait for it to be back.
+				e.mu.RUnlock()
+				<-staged
+				e.mu.RLock()
+			}
 		}
 
 		if _, ok := subBatches[index]; !ok {
@@ -393,13 +1008,17 @@ func (e *Engine) Index(events []Document) error {
 		subBatches[index] = append(subBatches[index], ev)
 	}
 
-	var mu sync.Mutex
-	var errList []error
-	// Index each batch in parallel.
+	// Index each batch in parallel, on a bounded number of workers. An index
+	// gets a single sub-batch, so its documents are still written in order.
+	workers := e.indexWorkers()
 	for index, subBatch := range subBatches {
+		workers <- struct{}{}
 		wg.Add(1)
 		go func(i *Index, b []Document) {
-			defer wg.Done()
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
 			if err := i.Index(b); err != nil {
 				mu.Lock()
 				errList = append(errList, err)
@@ -415,6 +1034,22 @@ func (e *Engine) Index(events []Document) error {
 	return nil
 }
 
+// indexWorkers returns the slots of the indexing workers, shared by all calls
+// to Index.
+func (e *Engine) indexWorkers() chan struct{} {
+	e.workersOnce.Do(func() {
+		n := e.IndexWorkers
+		if n <= 0 {
+			n = e.NumShards * indexWorkersPerShard
+		}
+		if n <= 0 {
+			n = 1
+		}
+		e.workers = make(chan struct{}, n)
+	})
+	return e.workers
+}
+
 func (e *Engine) Query(ctx context.Context, startTime, endTime time.Time, req *bleve.SearchRequest, cb func(*bleve.SearchRequest, *bleve.SearchResult) error) error {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
@@ -422,21 +1057,284 @@ func (e *Engine) Query(ctx context.Context, startTime, endTime time.Time, req *b
 
 	indexes := e.getIndexs(startTime, endTime)
 	if len(indexes) == 0 {
-		return bleve.ErrorAliasEmpty
+		return ErrIndexNotFound
+	}
+	return e.search(ctx, indexes, req, cb)
+}
+
+// Refresh persists the writes buffered under DurabilityInterval to the indexes
+// covering the time range, so that they are searchable. Under the other
+// durabilities, writes are searchable once indexed, and it does nothing.
+func (e *Engine) Refresh(ctx context.Context, startTime, endTime time.Time) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, i := range e.getIndexs(startTime, endTime) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := i.Flush(); err != nil {
+			return err
+		}
+	}
+	stats.Add("refreshed", 1)
+	return nil
+}
+
+// QueryIndexes performs the search request on the named indexes, whatever
+// their time range.
+func (e *Engine) QueryIndexes(ctx context.Context, names []string, req *bleve.SearchRequest, cb func(*bleve.SearchRequest, *bleve.SearchResult) error) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	stats.Add("queriesRx", 1)
+
+	indexes, err := e.indexesByName(names)
+	if err != nil {
+		return err
+	}
+	if len(indexes) == 0 {
+		return ErrIndexNotFound
+	}
+	return e.search(ctx, indexes, req, cb)
+}
+
+// Estimate reports what running the search request on the named indexes, or
+// if names is empty on the indexes covering the time range, would cost.
+func (e *Engine) Estimate(startTime, endTime time.Time, names []string, req *bleve.SearchRequest) (*QueryEstimate, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	indexes := e.getIndexs(startTime, endTime)
+	if len(names) > 0 {
+		var err error
+		if indexes, err = e.indexesByName(names); err != nil {
+			return nil, err
+		}
 	}
 
+	estimate := &QueryEstimate{Indexes: make([]string, 0, len(indexes))}
+	for _, i := range indexes {
+		total, err := i.Total()
+		if err != nil {
+			return nil, err
+		}
+		estimate.Indexes = append(estimate.Indexes, i.Name())
+		estimate.Shards += len(i.Shards)
+		estimate.Documents += total
+	}
+	estimate.Memory = searchCost(req, estimate.Shards)
+
+	if e.SearchMemory > 0 {
+		if estimate.Memory > e.SearchMemory {
+			estimate.Rejected = true
+			estimate.Reason = "exceeds the search memory budget"
+		} else if e.budget.inUse()+estimate.Memory > e.SearchMemory {
+			estimate.Rejected = true
+			estimate.Reason = "search memory budget is in use by other searches"
+		}
+	}
+	return estimate, nil
+}
+
+// indexesByName returns the named indexes, ignoring duplicate names. It must be
+// called under lock.
+func (e *Engine) indexesByName(names []string) ([]*Index, error) {
+	var indexes []*Index
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		i := e.indexByName(name)
+		if i == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownIndex, name)
+		}
+		indexes = append(indexes, i)
+	}
+	return indexes, nil
+}
+
+// EarliestRetained returns the start time of the oldest index of events,
+// false if there is none. Heartbeat indexes, kept apart, are not counted.
+func (e *Engine) EarliestRetained() (time.Time, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var earliest time.Time
+	for _, i := range e.indexes {
+		if i.Tier() == MonitoringTier {
+			continue
+		}
+		if earliest.IsZero() || i.StartTime().Before(earliest) {
+			earliest = i.StartTime()
+		}
+	}
+	return earliest, !earliest.IsZero()
+}
+
+// Indexes describes all indexes of the engine, latest first.
+func (e *Engine) Indexes() ([]IndexInfo, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	infos := make([]IndexInfo, 0, len(e.indexes))
+	for _, i := range e.indexes {
+		total, err := i.Total()
+		if err != nil {
+			return nil, err
+		}
+		info := IndexInfo{
+			Name:      i.Name(),
+			StartTime: i.StartTime(),
+			EndTime:   i.EndTime(),
+			Shards:    len(i.Shards),
+			Documents: total,
+			Hold:      i.Hold(),
+			Tier:      i.Tier(),
+		}
+		if md := i.Metadata(); md != nil {
+			summary := *md
+			summary.Mapping = nil
+			info.Metadata = &summary
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// HoldIndex holds the named index for the given reason, exempting it from
+// retention enforcement until ReleaseIndex is called.
+func (e *Engine) HoldIndex(name, reason string) error {
+	if reason == "" {
+		reason = "held"
+	}
+	return e.setHold(name, reason)
+}
+
+// ReleaseIndex releases the hold on the named index, which retention
+// enforcement deletes on its next run if it has aged out.
+func (e *Engine) ReleaseIndex(name string) error {
+	return e.setHold(name, "")
+}
+
+func (e *Engine) setHold(name, reason string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	i := e.indexByName(name)
+	if i == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownIndex, name)
+	}
+	if err := i.SetHold(reason); err != nil {
+		return fmt.Errorf("failed to set hold of index %s: %w", name, err)
+	}
+	if reason == "" {
+		e.Logger.Printf("index %s released", name)
+	} else {
+		e.Logger.Printf("index %s held: %s", name, reason)
+	}
+	return nil
+}
+
+// indexByName returns the index with the given name, or nil. It must be called
+// under lock.
+func (e *Engine) indexByName(name string) *Index {
+	for _, i := range e.indexes {
+		if i.Name() == name {
+			return i
+		}
+	}
+	return nil
+}
+
+// unroute removes the index from the indexes searched and written to. It must
+// be called under lock.
+func (e *Engine) unroute(i *Index) {
+	filtered := make(Indexes, 0, len(e.indexes))
+	for _, idx := range e.indexes {
+		if idx != i {
+			filtered = append(filtered, idx)
+		}
+	}
+	e.indexes = filtered
+}
+
+// takeOffline takes the named index out of routing, runs fn on it once the
+// snapshots reading it are closed, then puts it back. Searches skip the index
+// meanwhile, and events for it wait for it to be back, without the engine
+// lock being held while the snapshots are waited for.
+func (e *Engine) takeOffline(name string, fn func(*Index) error) error {
+	e.mu.Lock()
+	i := e.indexByName(name)
+	if i == nil {
+		e.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrUnknownIndex, name)
+	}
+	e.unroute(i)
+	if e.staged == nil {
+		e.staged = make(map[*Index]chan struct{})
+	}
+	back := make(chan struct{})
+	e.staged[i] = back
+	e.mu.Unlock()
+
+	// Searches and writes hold the read lock while they use an index, so
+	// once out of routing only snapshots may still be reading it.
+	i.readers.Wait()
+	err := fn(i)
+
+	e.mu.Lock()
+	delete(e.staged, i)
+	e.indexes = append(e.indexes, i)
+	sort.Sort(e.indexes)
+	e.mu.Unlock()
+	close(back)
+	return err
+}
+
+// search performs the search request on the given indexes. The Index of each
+// hit is set to the location of its document, as index/shard. It must be
+// called under lock, or on the indexes of a snapshot.
+func (e *Engine) search(ctx context.Context, indexes []*Index, req *bleve.SearchRequest, cb func(*bleve.SearchRequest, *bleve.SearchResult) error) error {
 	var indexAlias = make([]bleve.Index, 0, len(indexes)*e.NumShards)
+	var locations = make(map[string]string, len(indexes)*e.NumShards)
 	for _, idx := range indexes {
 		for _, shard := range idx.Shards {
 			indexAlias = append(indexAlias, shard.b)
+			locations[shard.b.Name()] = idx.Name() + "/" + filepath.Base(shard.path)
 		}
 	}
 
-	result, err := bleve.MultiSearch(ctx, req, indexAlias...)
-	if err != nil {
-		return err
+	cost := searchCost(req, len(indexAlias))
+	if !e.budget.acquire(cost, e.SearchMemory) {
+		stats.Add("queriesRejected", 1)
+		return ErrOverBudget
+	}
+	defer e.budget.release(cost)
+
+	ctx, done := e.activity.start(ctx, req, indexes, cost)
+	result, err := MultiSearch(ctx, req, indexAlias...)
+	if err = done(err); err != nil {
+		return wrapQueryError(ctx, err)
+	}
+
+	// Hits name the shard they come from by its path, make that the index
+	// and shard names instead.
+	for _, hit := range result.Hits {
+		if location, ok := locations[hit.Index]; ok {
+			hit.Index = location
+		}
+	}
+	if result.Profile != nil {
+		result.Profile.groupShards(locations)
 	}
-	return cb(req, result)
+	return cb(req, result.SearchResult)
+}
+
+// SearchMemoryInUse returns the estimated number of bytes held by the
+// searches currently running.
+func (e *Engine) SearchMemoryInUse() int64 {
+	return e.budget.inUse()
 }
 
 func (e *Engine) Fields(ctx context.Context, startTime, endTime time.Time) ([]string, error) {
@@ -446,7 +1344,7 @@ func (e *Engine) Fields(ctx context.Context, startTime, endTime time.Time) ([]st
 
 	indexes := e.getIndexs(startTime, endTime)
 	if len(indexes) == 0 {
-		return nil, bleve.ErrorAliasEmpty
+		return nil, ErrIndexNotFound
 	}
 
 	var indexAlias = 0
@@ -512,7 +1410,7 @@ func (e *Engine) FieldDict(ctx context.Context, startTime, endTime time.Time, fi
 
 	indexes := e.getIndexs(startTime, endTime)
 	if len(indexes) == 0 {
-		return nil, bleve.ErrorAliasEmpty
+		return nil, ErrIndexNotFound
 	}
 
 	var indexAlias = 0
@@ -652,8 +1550,8 @@ func assert(condition bool, msg string, v ...interface{}) {
 
 func SearchString(ctx context.Context, logger *log.Logger, searcher Searcher, q string) (<-chan string, error) {
 	query := bleve.NewQueryStringQuery(q)
-	searchRequest := bleve.NewSearchRequest(query)
-	searchRequest.Size = MaxSearchHitSize
+	searchRequest := NewSearchRequest(query, []string{"message"}, nil)
+	SetPage(searchRequest, MaxSearchHitSize, 0)
 
 	// validate the query
 	err := query.Validate()
@@ -667,7 +1565,7 @@ func SearchString(ctx context.Context, logger *log.Logger, searcher Searcher, q
 		defer close(c)
 
 		// execute the query
-		err := searcher.Query(ctx, time.Time{}, time.Now(), searchRequest, func(req *bleve.SearchRequest, resp *bleve.SearchResult) error {
+		err := Execute(ctx, searcher, time.Time{}, time.Now(), nil, searchRequest, func(req *bleve.SearchRequest, resp *bleve.SearchResult) error {
 			for _, doc := range resp.Hits {
 				// bs, err := doc.Index.GetInternal([]byte(doc.Doc.ID))
 				// if err != nil {
@@ -3,11 +3,14 @@ package ekanite
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -171,6 +174,99 @@ func TestBatcher_AllModes(t *testing.T) {
 	}
 }
 
+type laneIndexer struct {
+	batches [][]Document
+}
+
+func (l *laneIndexer) Index(b []Document) error {
+	l.batches = append(l.batches, b)
+	return nil
+}
+
+// TestBatcher_Lanes tests that events are batched per lane of reference time.
+func TestBatcher_Lanes(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	i := &laneIndexer{}
+	b := NewBatcher(i, 2, time.Hour, 0)
+	b.LaneDuration = time.Hour
+
+	c := make(chan error, 100)
+	err := b.Start(c)
+	if err != nil {
+		t.Fatalf("failed start batcher: %s", err.Error())
+	}
+
+	for n := 0; n < 4; n++ {
+		b.C() <- newInputEvent("", now.Add(time.Duration(n%2)*time.Hour))
+	}
+	for n := 0; n < 2; n++ {
+		if err := <-c; err != nil {
+			t.Fatalf("failed to send events: %s", err.Error())
+		}
+	}
+
+	if len(i.batches) != 2 {
+		t.Fatalf("indexer failed to receive correct number of batches, got %d, expected 2", len(i.batches))
+	}
+	for _, batch := range i.batches {
+		if len(batch) != 2 {
+			t.Fatalf("batch has wrong number of events, got %d, expected 2", len(batch))
+		}
+		if !batch[0].ReferenceTime().Equal(batch[1].ReferenceTime()) {
+			t.Fatalf("batch spans lanes: %s and %s", batch[0].ReferenceTime(), batch[1].ReferenceTime())
+		}
+	}
+}
+
+// TestBatcher_Metrics tests the pending and lag gauges of the batcher.
+func TestBatcher_Metrics(t *testing.T) {
+	b := NewBatcher(&TestIndexer{}, 2, time.Hour, 10)
+	c := make(chan error, 10)
+	if err := b.Start(c); err != nil {
+		t.Fatalf("failed start batcher: %s", err.Error())
+	}
+
+	b.C() <- newInputEvent("", time.Now().Add(-time.Second))
+	for n := 0; b.OldestPending() == 0; n++ {
+		if n == 100 {
+			t.Fatal("pending event not reported")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	b.C() <- newInputEvent("", time.Now().Add(-time.Second))
+	if err := <-c; err != nil {
+		t.Fatalf("failed to send events: %s", err.Error())
+	}
+	if d := b.OldestPending(); d != 0 {
+		t.Fatalf("oldest pending event reported after indexing, got %s", d)
+	}
+	if p50, _, p99 := b.Lag(); p50 < time.Second || p99 < p50 {
+		t.Fatalf("unexpected lag percentiles, got p50 %s, p99 %s", p50, p99)
+	}
+	if d := b.QueueDepth(); d != 0 {
+		t.Fatalf("queue depth incorrect, got %d, expected 0", d)
+	}
+}
+
+// TestBatcher_Refresh tests that refreshing sends the events batched so far.
+func TestBatcher_Refresh(t *testing.T) {
+	i := &TestIndexer{}
+	b := NewBatcher(i, 10, time.Hour, 10)
+	if err := b.Start(nil); err != nil {
+		t.Fatalf("failed start batcher: %s", err.Error())
+	}
+
+	b.C() <- newInputEvent("", time.Now())
+	b.C() <- newInputEvent("", time.Now())
+	if err := b.Refresh(context.Background(), time.Time{}, time.Time{}); err != nil {
+		t.Fatalf("failed to refresh batcher: %s", err.Error())
+	}
+	if i.BatchesRx != 1 || i.EventsRx != 2 {
+		t.Fatalf("indexer failed to receive correct number of events: batches: %d, events: %d", i.BatchesRx, i.EventsRx)
+	}
+}
+
 func TestEngine_New(t *testing.T) {
 	dataDir := tempPath()
 	defer os.RemoveAll(dataDir)
@@ -198,6 +294,42 @@ func TestEngine_Open(t *testing.T) {
 	}
 }
 
+func TestEngine_QueryNoIndex(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	e := NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine: %s", err.Error())
+	}
+	defer e.Close()
+
+	now := time.Now()
+	req := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	err := e.Query(context.Background(), now.Add(-time.Hour), now, req,
+		func(*bleve.SearchRequest, *bleve.SearchResult) error { return nil })
+	if !errors.Is(err, ErrIndexNotFound) {
+		t.Fatalf("query of empty engine returned %v, expected ErrIndexNotFound", err)
+	}
+	if _, err := e.Fields(context.Background(), now.Add(-time.Hour), now); !errors.Is(err, ErrIndexNotFound) {
+		t.Fatalf("fields of empty engine returned %v, expected ErrIndexNotFound", err)
+	}
+}
+
+func TestWrapQueryError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	err := wrapQueryError(ctx, ctx.Err())
+	if !errors.Is(err, ErrQueryTimeout) {
+		t.Fatalf("expired query returned %v, expected ErrQueryTimeout", err)
+	}
+	if err := wrapQueryError(context.Background(), io.EOF); err != io.EOF {
+		t.Fatalf("unrelated error was wrapped: %v", err)
+	}
+}
+
 func TestEngine_Close(t *testing.T) {
 	dataDir := tempPath()
 	defer os.RemoveAll(dataDir)
@@ -311,6 +443,186 @@ func TestEngine_IndexThenSearch(t *testing.T) {
 	}
 }
 
+func TestEngine_SearchMemory(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+
+	ts := parseTime("1982-02-05T04:43:00Z")
+	if err := e.Index([]Document{newIndexableEvent("auth password accepted", ts)}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), 100, 0, false)
+	e.SearchMemory = searchCost(req, e.NumShards) - 1
+	err := e.Query(context.Background(), ts.Add(-time.Hour), ts.Add(time.Hour), req,
+		func(*bleve.SearchRequest, *bleve.SearchResult) error { return nil })
+	if !errors.Is(err, ErrOverBudget) {
+		t.Fatalf("search over budget returned %v, expected ErrOverBudget", err)
+	}
+
+	e.SearchMemory = searchCost(req, e.NumShards)
+	err = e.Query(context.Background(), ts.Add(-time.Hour), ts.Add(time.Hour), req,
+		func(*bleve.SearchRequest, *bleve.SearchResult) error {
+			if n := e.SearchMemoryInUse(); n != e.SearchMemory {
+				t.Errorf("search memory in use, got %d, expected %d", n, e.SearchMemory)
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("search within budget failed: %s", err.Error())
+	}
+	if n := e.SearchMemoryInUse(); n != 0 {
+		t.Fatalf("search memory not released, got %d in use", n)
+	}
+}
+
+func TestEngine_QueryIndexes(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+	e.IndexDuration = time.Hour
+
+	ev1 := newIndexableEvent("auth password accepted", parseTime("1982-02-05T04:43:00Z"))
+	ev2 := newIndexableEvent("auth password rejected", parseTime("1982-02-05T05:43:00Z"))
+	if err := e.Index([]Document{ev1, ev2}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	infos, err := e.Indexes()
+	if err != nil {
+		t.Fatalf("failed to list indexes: %s", err.Error())
+	}
+	if len(infos) != 2 || infos[0].Name != "19820205_0500" || infos[0].Documents != 1 {
+		t.Fatalf("unexpected indexes listed: %+v", infos)
+	}
+
+	req := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	err = e.QueryIndexes(context.Background(), []string{"19820205_0400", "19820205_0400"}, req,
+		func(req *bleve.SearchRequest, resp *bleve.SearchResult) error {
+			if resp.Total != 1 {
+				t.Errorf("search of one index got %d hits, expected 1", resp.Total)
+			} else if loc := resp.Hits[0].Index; !strings.HasPrefix(loc, "19820205_0400/") {
+				t.Errorf("hit located at %s, expected in index 19820205_0400", loc)
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("failed to search index by name: %s", err.Error())
+	}
+
+	err = e.QueryIndexes(context.Background(), []string{"19820205_0600"}, req,
+		func(*bleve.SearchRequest, *bleve.SearchResult) error { return nil })
+	if !errors.Is(err, ErrUnknownIndex) {
+		t.Fatalf("search of unknown index returned %v, expected ErrUnknownIndex", err)
+	}
+}
+
+func TestEngine_SampleIndex(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+	e.IndexDuration = time.Hour
+
+	ts := parseTime("1982-02-05T04:43:00Z")
+	if err := e.Index([]Document{
+		newIndexableEvent("auth password accepted", ts),
+		newIndexableEvent("auth password rejected", ts),
+		newIndexableEvent("auth key accepted", ts),
+	}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	sample, err := e.SampleIndex(context.Background(), "19820205_0400", "Text", 2)
+	if err != nil {
+		t.Fatalf("failed to sample index: %s", err.Error())
+	}
+	if sample.Documents != 3 || len(sample.Samples) != 2 {
+		t.Fatalf("unexpected sample of %d documents, %d samples", sample.Documents, len(sample.Samples))
+	}
+	if len(sample.Terms) == 0 || sample.Terms[0].Term != "auth" || sample.Terms[0].Count != 3 {
+		t.Fatalf("unexpected top terms: %+v", sample.Terms)
+	}
+
+	if sample, err = e.SampleIndex(context.Background(), "19820205_0400", "", 10); err != nil {
+		t.Fatalf("failed to sample index: %s", err.Error())
+	}
+	if len(sample.Samples) != 3 || sample.Terms != nil {
+		t.Fatalf("unexpected sample of %d samples, terms %+v", len(sample.Samples), sample.Terms)
+	}
+}
+
+func TestEngine_IndexStorage(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+	e.IndexDuration = time.Hour
+
+	ts := parseTime("1982-02-05T04:43:00Z")
+	if err := e.Index([]Document{
+		newIndexableEvent("auth password accepted", ts),
+		newIndexableEvent("auth key accepted", ts),
+	}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	storage, err := e.IndexStorage(context.Background(), "19820205_0400")
+	if err != nil {
+		t.Fatalf("failed to read index storage: %s", err.Error())
+	}
+	if storage.Documents != 2 || storage.DiskBytes == 0 {
+		t.Fatalf("unexpected storage of %d documents in %d bytes", storage.Documents, storage.DiskBytes)
+	}
+	var text *FieldStorage
+	for k := range storage.Fields {
+		if storage.Fields[k].Field == "Text" {
+			text = &storage.Fields[k]
+		}
+	}
+	if text == nil || text.Terms < 4 || text.Postings != 6 {
+		t.Fatalf("unexpected storage of the Text field: %+v", text)
+	}
+	if text.StoredBytes != int64(len("auth password accepted")+len("auth key accepted")) {
+		t.Fatalf("unexpected stored bytes %d of the Text field", text.StoredBytes)
+	}
+
+	if _, err := e.IndexStorage(context.Background(), "19820205_0600"); !errors.Is(err, ErrUnknownIndex) {
+		t.Fatalf("storage of unknown index returned %v, expected ErrUnknownIndex", err)
+	}
+}
+
+func TestEngine_Estimate(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+	e.IndexDuration = time.Hour
+
+	ts := parseTime("1982-02-05T04:43:00Z")
+	if err := e.Index([]Document{newIndexableEvent("auth password accepted", ts)}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), 10, 0, false)
+	estimate, err := e.Estimate(ts.Add(-time.Hour), ts.Add(time.Hour), nil, req)
+	if err != nil {
+		t.Fatalf("failed to estimate query: %s", err.Error())
+	}
+	if len(estimate.Indexes) != 1 || estimate.Shards != e.NumShards || estimate.Documents != 1 {
+		t.Fatalf("unexpected estimate: %+v", estimate)
+	}
+	if estimate.Memory != searchCost(req, e.NumShards) || estimate.Rejected {
+		t.Fatalf("unexpected estimate of memory: %+v", estimate)
+	}
+
+	e.SearchMemory = estimate.Memory - 1
+	if estimate, err = e.Estimate(time.Time{}, time.Time{}, []string{"19820205_0400"}, req); err != nil {
+		t.Fatalf("failed to estimate query: %s", err.Error())
+	}
+	if !estimate.Rejected || estimate.Reason == "" {
+		t.Fatalf("query over budget not rejected: %+v", estimate)
+	}
+}
+
 func TestEngine_createIndexForReferenceTime(t *testing.T) {
 	dataDir := tempPath()
 	defer os.RemoveAll(dataDir)
@@ -322,7 +634,7 @@ func TestEngine_createIndexForReferenceTime(t *testing.T) {
 	e.IndexDuration = 2 * time.Hour
 
 	rt := parseTime("1982-02-05T04:43:00Z")
-	idx, err := e.createIndexForReferenceTime(rt)
+	idx, err := e.createIndexForReferenceTime("", rt)
 	if err != nil {
 		t.Fatalf("failed to create index for reference time %s", rt)
 	}
@@ -335,6 +647,73 @@ func TestEngine_createIndexForReferenceTime(t *testing.T) {
 	}
 }
 
+func TestEngine_createIndexClipsOverlap(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	e := NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine at %s: %s", dataDir, err.Error())
+	}
+	defer e.Close()
+
+	start := parseTime("1982-02-05T04:00:00Z").UTC()
+	_, _ = e.createIndex(start, start.Add(10*time.Minute))
+	_, _ = e.createIndex(start.Add(20*time.Minute), start.Add(30*time.Minute))
+	_, _ = e.createIndex(start.Add(time.Hour), start.Add(2*time.Hour))
+	e.IndexDuration = 2 * time.Hour
+
+	// The gap holding the event is taken, not the first one of its range.
+	rt := start.Add(35 * time.Minute)
+	idx, err := e.createIndexForReferenceTime("", rt)
+	if err != nil {
+		t.Fatalf("failed to create clipped index: %s", err.Error())
+	}
+	if !idx.StartTime().Equal(start.Add(30*time.Minute)) || !idx.EndTime().Equal(start.Add(time.Hour)) {
+		t.Fatalf("clipped index has wrong limits, got %s - %s", idx.StartTime(), idx.EndTime())
+	}
+	if !idx.Contains(rt) {
+		t.Fatalf("clipped index %s - %s does not contain %s", idx.StartTime(), idx.EndTime(), rt)
+	}
+	if o := e.indexes.Overlaps(); len(o) != 0 {
+		t.Fatalf("engine has %d overlapping indexes", len(o))
+	}
+	if _, err := e.createIndex(start.Add(90*time.Minute), start.Add(3*time.Hour)); err == nil {
+		t.Fatal("created index over a covered time")
+	}
+}
+
+func TestEngine_OpenOverlaps(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	start := parseTime("1982-02-05T04:00:00Z").UTC()
+	for _, r := range [][2]time.Duration{{0, 2 * time.Hour}, {time.Hour, 3 * time.Hour}} {
+		i, err := NewIndex(dataDir, start.Add(r[0]), start.Add(r[1]), 1)
+		if err != nil {
+			t.Fatalf("failed to create index: %s", err.Error())
+		}
+		i.Close()
+	}
+
+	e := NewEngine(dataDir)
+	if err := e.Open(); err == nil {
+		e.Close()
+		t.Fatal("opened engine with overlapping indexes")
+	}
+
+	e = NewEngine(dataDir)
+	e.RepairOverlaps = true
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine with overlap repair: %s", err.Error())
+	}
+	defer e.Close()
+	if o := e.indexes.Overlaps(); len(o) != 0 {
+		t.Fatalf("engine has %d overlapping indexes after repair", len(o))
+	}
+	containsOrFail(t, dataDir+"/19820205_0400/endtime", "19820205_0500")
+}
+
 func TestEngine_RetentionEnforcement(t *testing.T) {
 	dataDir := tempPath()
 	defer os.RemoveAll(dataDir)
@@ -362,6 +741,408 @@ func TestEngine_RetentionEnforcement(t *testing.T) {
 	}
 }
 
+func TestEngine_HoldIndex(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	e := NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine at %s: %s", dataDir, err.Error())
+	}
+	e.RetentionPeriod = 24 * time.Hour
+
+	now := time.Now().UTC()
+	old, _ := e.createIndex(now.Add(-48*time.Hour), now.Add(-47*time.Hour))
+	if err := e.HoldIndex(old.Name(), "incident 42"); err != nil {
+		t.Fatalf("failed to hold index: %s", err.Error())
+	}
+	if err := e.HoldIndex("19820205_0400", "incident 42"); !errors.Is(err, ErrUnknownIndex) {
+		t.Fatalf("hold of unknown index returned %v, expected ErrUnknownIndex", err)
+	}
+	e.enforceRetention()
+	if len(e.indexes) != 1 {
+		t.Fatal("retention enforcement deleted held index")
+	}
+
+	// Holds persist across restarts.
+	if err := e.Close(); err != nil {
+		t.Fatalf("failed to close engine: %s", err.Error())
+	}
+	e = NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to reopen engine at %s: %s", dataDir, err.Error())
+	}
+	defer e.Close()
+	e.RetentionPeriod = 24 * time.Hour
+	infos, err := e.Indexes()
+	if err != nil || len(infos) != 1 || infos[0].Hold != "incident 42" {
+		t.Fatalf("hold not reopened: %+v, %v", infos, err)
+	}
+
+	if err := e.ReleaseIndex(old.Name()); err != nil {
+		t.Fatalf("failed to release index: %s", err.Error())
+	}
+	e.enforceRetention()
+	if len(e.indexes) != 0 {
+		t.Fatal("retention enforcement kept released index")
+	}
+}
+
+func TestEngine_WarmIndexes(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	e := NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine at %s: %s", dataDir, err.Error())
+	}
+	if err := e.Index([]Document{newIndexableEvent("auth password accepted", time.Now().UTC())}); err != nil {
+		t.Fatalf("failed to index event: %s", err.Error())
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("failed to close engine: %s", err.Error())
+	}
+
+	queries, err := ParseWarmupQueries("*; Text:password ;")
+	if err != nil || len(queries) != 2 {
+		t.Fatalf("parsed warm-up queries %v, %v, expected 2 queries", queries, err)
+	}
+	if _, err := ParseWarmupQueries("Text:\"password"); err == nil {
+		t.Fatal("invalid warm-up query parsed")
+	}
+
+	e = NewEngine(dataDir)
+	e.WarmupQueries = queries
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to reopen engine at %s: %s", dataDir, err.Error())
+	}
+	defer e.Close()
+	if err := e.WarmIndexes(context.Background()); err != nil {
+		t.Fatalf("failed to warm indexes: %s", err.Error())
+	}
+}
+
+func TestEngine_FindDuplicates(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	e := NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine at %s: %s", dataDir, err.Error())
+	}
+	defer e.Close()
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	older, _ := e.createIndex(now.Add(-2*time.Hour), now.Add(-time.Hour))
+	newer, _ := e.createIndex(now.Add(-time.Hour), now)
+	skewed := newIndexableEvent("auth password accepted", now.Add(-time.Hour))
+	if err := older.Index([]Document{skewed, newIndexableEvent("auth key accepted", now.Add(-90*time.Minute))}); err != nil {
+		t.Fatalf("failed to index older events: %s", err.Error())
+	}
+	if err := newer.Index([]Document{skewed}); err != nil {
+		t.Fatalf("failed to index newer event: %s", err.Error())
+	}
+
+	duplicates, err := e.FindDuplicates(context.Background(), false)
+	if err != nil {
+		t.Fatalf("failed to find duplicates: %s", err.Error())
+	}
+	expected := []Duplicate{{ID: skewed.ID(), Older: older.Name(), Newer: newer.Name()}}
+	if !reflect.DeepEqual(duplicates, expected) {
+		t.Fatalf("found duplicates %v, expected %v", duplicates, expected)
+	}
+
+	if _, err := e.FindDuplicates(context.Background(), true); err != nil {
+		t.Fatalf("failed to remove duplicates: %s", err.Error())
+	}
+	if total, _ := older.Total(); total != 1 {
+		t.Fatalf("older index holds %d documents after removal, expected 1", total)
+	}
+	if total, _ := newer.Total(); total != 1 {
+		t.Fatalf("newer index holds %d documents after removal, expected 1", total)
+	}
+	if duplicates, _ := e.FindDuplicates(context.Background(), false); len(duplicates) != 0 {
+		t.Fatalf("found duplicates %v after removal, expected none", duplicates)
+	}
+}
+
+func TestEngine_RetentionCheck(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	e := NewEngine(dataDir)
+	e.RetentionPeriod = 24 * time.Hour
+	e.RetentionCheck = 10 * time.Millisecond
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine at %s: %s", dataDir, err.Error())
+	}
+	defer e.Close()
+
+	now := time.Now().UTC()
+	e.mu.Lock()
+	_, _ = e.createIndex(now.Add(-48*time.Hour), now.Add(-47*time.Hour))
+	e.mu.Unlock()
+
+	for n := 0; ; n++ {
+		e.mu.RLock()
+		remaining := len(e.indexes)
+		e.mu.RUnlock()
+		if remaining == 0 {
+			break
+		}
+		if n == 100 {
+			t.Fatal("expired index not deleted by periodic retention check")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Checking without pause is refused.
+	e = NewEngine(tempPath())
+	e.RetentionCheck = 0
+	if err := e.Open(); err == nil {
+		e.Close()
+		os.RemoveAll(e.Path())
+		t.Fatal("engine opened with a retention check interval of 0")
+	}
+}
+
+func TestEngine_Rollover(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	e := NewEngine(dataDir)
+	e.RolloverDocs = 2
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine at %s: %s", dataDir, err.Error())
+	}
+	defer e.Close()
+
+	now := parseTime("1982-02-05T04:43:20Z")
+	events := []Document{
+		newIndexableEvent("auth password accepted", now),
+		newIndexableEvent("auth password rejected", now),
+	}
+	if err := e.Index(events); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+	e.rollover(now)
+	if len(e.indexes) != 1 {
+		t.Fatalf("index under threshold rolled over, %d indexes", len(e.indexes))
+	}
+
+	if err := e.Index([]Document{newIndexableEvent("auth key accepted", now)}); err != nil {
+		t.Fatalf("failed to index event: %s", err.Error())
+	}
+	e.rollover(now)
+	if len(e.indexes) != 2 {
+		t.Fatalf("index over threshold not rolled over, %d indexes", len(e.indexes))
+	}
+	split := parseTime("1982-02-05T04:44:00Z")
+	old, next := e.indexes[1], e.indexes[0]
+	if !old.EndTime().Equal(split) || !next.StartTime().Equal(split) {
+		t.Fatalf("rollover split at %s and %s, expected %s", old.EndTime(), next.StartTime(), split)
+	}
+	if !next.EndTime().Equal(parseTime("1982-02-06T00:00:00Z")) {
+		t.Fatalf("new index ends at %s, expected end of day", next.EndTime())
+	}
+	if i := e.indexForReferenceTime("", split.Add(time.Second)); i != next {
+		t.Fatal("event after rollover not routed to new index")
+	}
+}
+
+// TestEngine_RolloverLaterDocs tests that indexes are rolled over past the
+// latest reference time of their documents, later than now, or not at all.
+func TestEngine_RolloverLaterDocs(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	e := NewEngine(dataDir)
+	e.RolloverDocs = 1
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine at %s: %s", dataDir, err.Error())
+	}
+	defer e.Close()
+
+	now := parseTime("1982-02-05T04:43:20Z")
+	later := parseTime("1982-02-05T06:10:30Z")
+	if err := e.Index([]Document{
+		newIndexableEvent("auth password accepted", now),
+		newIndexableEvent("auth password rejected", later),
+	}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+	e.rollover(now)
+	if len(e.indexes) != 2 {
+		t.Fatalf("index over threshold not rolled over, %d indexes", len(e.indexes))
+	}
+	split := parseTime("1982-02-05T06:11:00Z")
+	if old := e.indexes[1]; !old.EndTime().Equal(split) || !old.Contains(later) {
+		t.Fatalf("rollover split at %s, expected %s", old.EndTime(), split)
+	}
+
+	// An index holding documents up to its end is not rolled over.
+	if err := e.Index([]Document{
+		newIndexableEvent("auth key accepted", parseTime("1982-02-05T23:59:30Z")),
+		newIndexableEvent("auth key rejected", parseTime("1982-02-05T23:59:40Z")),
+	}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+	e.rollover(now.Add(3 * time.Hour))
+	if len(e.indexes) != 2 {
+		t.Fatalf("index holding documents up to its end rolled over, %d indexes", len(e.indexes))
+	}
+}
+
+func TestEngine_Reconfigure(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	e := NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine at %s: %s", dataDir, err.Error())
+	}
+	defer e.Close()
+
+	now := time.Now().UTC()
+	e.mu.Lock()
+	_, _ = e.createIndex(now.Add(-48*time.Hour), now.Add(-47*time.Hour))
+	e.mu.Unlock()
+
+	e.enforceRetention()
+	if len(e.indexes) != 1 {
+		t.Fatal("index within retention period deleted")
+	}
+	e.Reconfigure(Settings{RetentionPeriod: 24 * time.Hour})
+	e.enforceRetention()
+	if len(e.indexes) != 0 {
+		t.Fatal("index expired by reconfigured retention period not deleted")
+	}
+}
+
+func TestEngine_IndexWorkers(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	e := NewEngine(dataDir)
+	e.IndexWorkers = 1
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine at %s: %s", dataDir, err.Error())
+	}
+	defer e.Close()
+
+	// Events spread over more indexes than there are workers.
+	var events []Document
+	for d := 1; d <= 5; d++ {
+		ts := parseTime(fmt.Sprintf("1982-02-%02dT04:43:00Z", d))
+		events = append(events, newIndexableEvent("auth password accepted", ts))
+	}
+	if err := e.Index(events); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+	if n := len(e.indexes); n != 5 {
+		t.Fatalf("events indexed into %d indexes, expected 5", n)
+	}
+	if total, err := e.Total(); err != nil || total != 5 {
+		t.Fatalf("engine holds %d documents, expected 5, err %v", total, err)
+	}
+	if n := cap(e.indexWorkers()); n != 1 {
+		t.Fatalf("engine has %d index workers, expected 1", n)
+	}
+
+	if n := cap(NewEngine(dataDir).indexWorkers()); n != DefaultNumShards*indexWorkersPerShard {
+		t.Fatalf("engine has %d index workers by default, expected %d", n, DefaultNumShards*indexWorkersPerShard)
+	}
+}
+
+func TestGroupBy_Facet(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	e := NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine at %s: %s", dataDir, err.Error())
+	}
+	defer e.Close()
+
+	ts := parseTime("1982-02-05T04:43:00Z")
+	if err := e.Index([]Document{
+		newIndexableEvent("auth password accepted", ts),
+		newIndexableEvent("auth key accepted", ts),
+	}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	var stats map[string]uint64
+	err := groupByFacet(e, context.Background(), ts.Add(-time.Hour), ts.Add(time.Hour), bleve.NewMatchAllQuery(), "Text",
+		func(s map[string]uint64) error {
+			stats = s
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("failed to group by facet: %s", err.Error())
+	}
+	if stats["auth"] != 2 || stats["password"] != 1 {
+		t.Fatalf("unexpected facet counts: %v", stats)
+	}
+
+	err = GroupBy(e, context.Background(), ts.Add(-time.Hour), ts.Add(time.Hour), bleve.NewMatchAllQuery(), "nosuchfield",
+		func(map[string]uint64) error {
+			t.Fatal("group by of absent field returned a result")
+			return nil
+		})
+	if !errors.Is(err, ErrFieldNotFound) {
+		t.Fatalf("group by of absent field returned %v, expected ErrFieldNotFound", err)
+	}
+}
+
+func TestGroupByTimeAndField(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	// A single shard, since the top terms of each shard are merged.
+	e := newEngine(dataDir, 1, 24*time.Hour)
+	defer e.Close()
+
+	if err := e.Index([]Document{
+		newIndexableEvent("auth password accepted", parseTime("1982-02-05T04:43:00Z")),
+		newIndexableEvent("auth key accepted", parseTime("1982-02-05T04:44:00Z")),
+		newIndexableEvent("auth password rejected", parseTime("1982-02-05T04:51:00Z")),
+	}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	start, end := parseTime("1982-02-05T04:40:00Z"), parseTime("1982-02-05T04:56:00Z")
+	var matrix *CountMatrix
+	err := GroupByTimeAndField(e, context.Background(), start, end, bleve.NewMatchAllQuery(),
+		"ReceptionTime", 5*time.Minute, nil, "Text", 1, func(m *CountMatrix) error {
+			matrix = m
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("failed to group by time and field: %s", err.Error())
+	}
+	if len(matrix.Buckets) != 3 || !matrix.Buckets[0].Equal(start) {
+		t.Fatalf("unexpected buckets: %v", matrix.Buckets)
+	}
+	if len(matrix.Terms) != 1 || matrix.Terms[0] != "auth" {
+		t.Fatalf("unexpected terms: %v", matrix.Terms)
+	}
+	if fmt.Sprint(matrix.Counts) != "[[2 0 1]]" || fmt.Sprint(matrix.Totals) != "[2 0 1]" {
+		t.Fatalf("unexpected counts %v, totals %v", matrix.Counts, matrix.Totals)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for n := 0; n < 100; n++ {
+		if d := jitter(time.Second); d < time.Second || d >= 1100*time.Millisecond {
+			t.Fatalf("jittered duration %s out of range", d)
+		}
+	}
+	if d := jitter(5); d != 5 {
+		t.Fatalf("tiny duration jittered to %s", d)
+	}
+}
+
 func testEngineIndexForReferenceTime(t *testing.T, e *Engine) {
 	start1 := parseTime("1982-02-05T04:00:00Z")
 	start2 := parseTime("1982-02-05T05:00:00Z")
@@ -419,7 +1200,7 @@ func testEngineIndexForReferenceTime(t *testing.T, e *Engine) {
 	}
 
 	for n, tt := range tests {
-		if i := e.indexForReferenceTime(tt.timestamp); i != tt.index {
+		if i := e.indexForReferenceTime("", tt.timestamp); i != tt.index {
 			t.Fatalf("Test %d: got wrong index for timestamp %s", n, tt.timestamp)
 		}
 	}
@@ -552,6 +1333,11 @@ func (e *testEvent) ReferenceTime() time.Time {
 	return e.ReceptionTime
 }
 
+// Received returns the time the event was received.
+func (e *testEvent) Received() time.Time {
+	return e.ReceptionTime
+}
+
 func searchString(logger *log.Logger, searcher Searcher, field, q string) (<-chan string, error) {
 	query := bleve.NewQueryStringQuery(q)
 	searchRequest := bleve.NewSearchRequest(query)
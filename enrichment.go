@@ -0,0 +1,260 @@
+package ekanite
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"plugin"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEnrichmentTimeout is how long a subprocess enrichment plugin has to
+// answer for a batch before it is restarted.
+const DefaultEnrichmentTimeout = 5 * time.Second
+
+// EnrichmentSymbol is the name of the constructor Go enrichment plugins
+// export, of type func(args []string) (ekanite.EnrichmentPlugin, error),
+// args being those following the plugin path.
+const EnrichmentSymbol = "NewEnrichmentPlugin"
+
+// EnrichmentPlugin adds fields to the events of each batch before it is
+// indexed, so that proprietary enrichment runs without forking the ingest
+// code. Plugins are either Go plugins or subprocesses, loaded by
+// ParseEnrichmentPlugins.
+type EnrichmentPlugin interface {
+	// Name identifies the plugin in errors.
+	Name() string
+
+	// Enrich sets fields on the fields of the events of a batch. The batch
+	// is indexed even if it fails.
+	Enrich(events []map[string]interface{}) error
+
+	// Close releases the plugin, which is not used afterwards.
+	Close() error
+}
+
+// ParseEnrichmentPlugins loads a semicolon-separated list of enrichment
+// plugins, applied in order, each either plugin:path.so [args], a Go plugin
+// exporting EnrichmentSymbol, or exec:command [args], a subprocess speaking
+// JSON lines on its stdin and stdout.
+func ParseEnrichmentPlugins(s string) ([]EnrichmentPlugin, error) {
+	var plugins []EnrichmentPlugin
+	for _, spec := range strings.Split(s, ";") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		p, err := loadEnrichmentPlugin(spec)
+		if err != nil {
+			for _, loaded := range plugins {
+				loaded.Close()
+			}
+			return nil, err
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
+
+// loadEnrichmentPlugin loads the plugin of spec.
+func loadEnrichmentPlugin(spec string) (EnrichmentPlugin, error) {
+	i := strings.Index(spec, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("enrichment plugin '%s' is neither plugin:path.so nor exec:command", spec)
+	}
+	args := strings.Fields(spec[i+1:])
+	if len(args) == 0 {
+		return nil, fmt.Errorf("enrichment plugin '%s' has no path or command", spec)
+	}
+	switch spec[:i] {
+	case "plugin":
+		p, err := plugin.Open(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("enrichment plugin %s: %w", args[0], err)
+		}
+		sym, err := p.Lookup(EnrichmentSymbol)
+		if err != nil {
+			return nil, fmt.Errorf("enrichment plugin %s: %w", args[0], err)
+		}
+		newPlugin, ok := sym.(func([]string) (EnrichmentPlugin, error))
+		if !ok {
+			return nil, fmt.Errorf("enrichment plugin %s: %s is a %T", args[0], EnrichmentSymbol, sym)
+		}
+		return newPlugin(args[1:])
+	case "exec":
+		return NewExecEnrichmentPlugin(args, DefaultEnrichmentTimeout), nil
+	default:
+		return nil, fmt.Errorf("enrichment plugin '%s' is neither plugin:path.so nor exec:command", spec)
+	}
+}
+
+// ExecEnrichmentPlugin is an enrichment plugin run as a subprocess. It is
+// sent each batch as a line holding the JSON array of the fields of its
+// events, and answers with a line holding a JSON array of as many objects, the
+// fields set on each event. It is started on the first batch, and restarted
+// after failing.
+type ExecEnrichmentPlugin struct {
+	args    []string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// NewExecEnrichmentPlugin returns a plugin running the command of args, which
+// is restarted when it takes longer than timeout to answer.
+func NewExecEnrichmentPlugin(args []string, timeout time.Duration) *ExecEnrichmentPlugin {
+	return &ExecEnrichmentPlugin{args: args, timeout: timeout}
+}
+
+// Name returns the command of the plugin.
+func (p *ExecEnrichmentPlugin) Name() string {
+	return p.args[0]
+}
+
+// start starts the command of the plugin.
+func (p *ExecEnrichmentPlugin) start() error {
+	cmd := exec.Command(p.args[0], p.args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	p.cmd, p.stdin, p.stdout = cmd, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+// stop kills the command of the plugin, if started.
+func (p *ExecEnrichmentPlugin) stop() {
+	if p.cmd == nil {
+		return
+	}
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+	p.cmd, p.stdin, p.stdout = nil, nil, nil
+}
+
+// Enrich sends the events to the command, and sets the fields it answers
+// with.
+func (p *ExecEnrichmentPlugin) Enrich(events []map[string]interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		if err := p.start(); err != nil {
+			return fmt.Errorf("enrichment plugin %s: %w", p.Name(), err)
+		}
+	}
+
+	bs, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("enrichment plugin %s: %w", p.Name(), err)
+	}
+	var fields []map[string]interface{}
+	done := make(chan error, 1)
+	stdin, stdout := p.stdin, p.stdout
+	go func() {
+		if _, err := stdin.Write(append(bs, '\n')); err != nil {
+			done <- err
+			return
+		}
+		line, err := stdout.ReadBytes('\n')
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- json.Unmarshal(line, &fields)
+	}()
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case err = <-done:
+	case <-timer.C:
+		err = fmt.Errorf("no answer within %s", p.timeout)
+	}
+	if err == nil && len(fields) != len(events) {
+		err = fmt.Errorf("answered %d objects for %d events", len(fields), len(events))
+	}
+	if err != nil {
+		// The command can no longer be told which answer is for which
+		// batch.
+		p.stop()
+		return fmt.Errorf("enrichment plugin %s: %w", p.Name(), err)
+	}
+	for i, f := range fields {
+		for k, v := range f {
+			events[i][k] = v
+		}
+	}
+	return nil
+}
+
+// Close closes the stdin of the command, killing it if it does not exit
+// within the timeout.
+func (p *ExecEnrichmentPlugin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		return nil
+	}
+	p.stdin.Close()
+	exited := make(chan error, 1)
+	go func() { exited <- p.cmd.Wait() }()
+	var err error
+	select {
+	case err = <-exited:
+	case <-time.After(p.timeout):
+		p.cmd.Process.Kill()
+		err = <-exited
+	}
+	p.cmd, p.stdin, p.stdout = nil, nil, nil
+	return err
+}
+
+// enrichBatch runs the plugins in order over the fields of the documents of
+// batch. A plugin failing, or panicking, leaves the batch as enriched so far.
+func enrichBatch(plugins []EnrichmentPlugin, batch []Document) {
+	if len(plugins) == 0 {
+		return
+	}
+	events := make([]map[string]interface{}, 0, len(batch))
+	for _, d := range batch {
+		if fields, ok := d.Data().(map[string]interface{}); ok {
+			events = append(events, fields)
+		}
+	}
+	if len(events) == 0 {
+		return
+	}
+	for _, p := range plugins {
+		if err := enrichSafely(p, events); err != nil {
+			stats.Add("enrichmentError", 1)
+			log.Printf("[ekanite] failed to enrich batch of %d events: %s", len(events), err.Error())
+		}
+	}
+}
+
+// enrichSafely runs p over events, returning its panic as an error.
+func enrichSafely(p EnrichmentPlugin, events []map[string]interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("enrichment plugin %s panicked: %v", p.Name(), r)
+		}
+	}()
+	return p.Enrich(events)
+}
@@ -0,0 +1,100 @@
+package ekanite
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// enrichedDoc is a document of fields.
+type enrichedDoc map[string]interface{}
+
+func (d enrichedDoc) ID() DocID                { return DocID(fmt.Sprint(d["id"])) }
+func (d enrichedDoc) Data() interface{}        { return map[string]interface{}(d) }
+func (d enrichedDoc) ReferenceTime() time.Time { return time.Time{} }
+
+// TestHelperEnrichmentProcess is the command of the exec enrichment plugins
+// tested, adding the owner of the host of each event, and never answering for
+// the host hang.
+func TestHelperEnrichmentProcess(t *testing.T) {
+	if os.Getenv("EKANITE_TEST_ENRICHMENT") != "1" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var events []map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &events); err != nil {
+			os.Exit(2)
+		}
+		fields := make([]map[string]interface{}, len(events))
+		for i, e := range events {
+			if e["host"] == "hang" {
+				time.Sleep(time.Hour)
+			}
+			fields[i] = map[string]interface{}{"owner": fmt.Sprintf("team-%v", e["host"])}
+		}
+		bs, _ := json.Marshal(fields)
+		fmt.Println(string(bs))
+	}
+	os.Exit(0)
+}
+
+type panickingPlugin struct{}
+
+func (panickingPlugin) Name() string                                 { return "panicking" }
+func (panickingPlugin) Enrich(events []map[string]interface{}) error { panic("boom") }
+func (panickingPlugin) Close() error                                 { return nil }
+
+func TestExecEnrichmentPlugin(t *testing.T) {
+	os.Setenv("EKANITE_TEST_ENRICHMENT", "1")
+	defer os.Unsetenv("EKANITE_TEST_ENRICHMENT")
+	p := NewExecEnrichmentPlugin([]string{os.Args[0], "-test.run=TestHelperEnrichmentProcess"}, time.Second)
+	defer p.Close()
+
+	batch := []Document{
+		enrichedDoc{"id": 1, "host": "web1"},
+		enrichedDoc{"id": 2, "host": "db1", "owner": "dba"},
+	}
+	enrichBatch([]EnrichmentPlugin{panickingPlugin{}, p}, batch)
+	if owner := batch[0].Data().(map[string]interface{})["owner"]; owner != "team-web1" {
+		t.Fatalf("first event enriched with owner %v, expected team-web1", owner)
+	}
+	if owner := batch[1].Data().(map[string]interface{})["owner"]; owner != "team-db1" {
+		t.Fatalf("second event enriched with owner %v, expected team-db1", owner)
+	}
+
+	// A plugin not answering is restarted for the next batch.
+	hung := []map[string]interface{}{{"host": "hang"}}
+	if err := p.Enrich(hung); err == nil || !strings.Contains(err.Error(), "no answer") {
+		t.Fatalf("hung plugin returned %v", err)
+	}
+	events := []map[string]interface{}{{"host": "web2"}}
+	if err := p.Enrich(events); err != nil {
+		t.Fatalf("restarted plugin failed: %s", err)
+	}
+	if events[0]["owner"] != "team-web2" {
+		t.Fatalf("restarted plugin enriched %v", events[0])
+	}
+}
+
+func TestParseEnrichmentPlugins(t *testing.T) {
+	plugins, err := ParseEnrichmentPlugins(" exec:/usr/bin/geoip --db /var/geo.db ; ")
+	if err != nil {
+		t.Fatalf("failed to parse plugins: %s", err)
+	}
+	if len(plugins) != 1 || plugins[0].Name() != "/usr/bin/geoip" {
+		t.Fatalf("parsed %v", plugins)
+	}
+	for _, s := range []string{"geoip", "exec:", "lua:enrich.lua"} {
+		if _, err := ParseEnrichmentPlugins(s); err == nil {
+			t.Errorf("plugins %q parsed", s)
+		}
+	}
+	if _, err := ParseEnrichmentPlugins("plugin:/nonexistent.so"); err == nil {
+		t.Error("missing Go plugin loaded")
+	}
+}
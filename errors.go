@@ -0,0 +1,72 @@
+package ekanite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the engine. Callers should test for them with errors.Is,
+// since they are usually wrapped with more context.
+var (
+	// ErrIndexNotFound is returned when no index covers the requested time range.
+	ErrIndexNotFound = errors.New("no index found for the requested time range")
+
+	// ErrUnknownIndex is returned when an index requested by name does not
+	// exist.
+	ErrUnknownIndex = errors.New("unknown index")
+
+	// ErrIndexesNotSupported is returned when searching indexes by name with a
+	// searcher which cannot.
+	ErrIndexesNotSupported = errors.New("searching indexes by name is not supported")
+
+	// ErrQueryTimeout is returned when a search does not complete before the
+	// deadline of its context.
+	ErrQueryTimeout = errors.New("query timeout")
+
+	// ErrSearchCancelled is returned by a search cancelled while it ran.
+	ErrSearchCancelled = errors.New("search cancelled")
+
+	// ErrSearchNotFound is returned when cancelling a search which is not
+	// running.
+	ErrSearchNotFound = errors.New("search not running")
+
+	// ErrFieldNotFound is returned when no document in the requested time
+	// range has the field grouped by.
+	ErrFieldNotFound = errors.New("field not found in the requested time range")
+
+	// ErrOverBudget is returned when running a search would take the engine
+	// over its search memory budget.
+	ErrOverBudget = errors.New("search memory budget exceeded")
+
+	// ErrIncompatibleIndex is returned when opening an index created with
+	// settings the engine would search it wrongly with.
+	ErrIncompatibleIndex = errors.New("index incompatible with engine")
+
+	// ErrCorruptIndex is returned when opening an index restored from an
+	// archive whose files do not match the manifest written with it.
+	ErrCorruptIndex = errors.New("index files do not match their manifest")
+
+	// ErrInvalidMapping is returned when staging an index mapping which is
+	// invalid, or refers to analysis components the engine does not have.
+	ErrInvalidMapping = errors.New("invalid mapping")
+
+	// ErrRefreshNotSupported is returned when refreshing before a search
+	// with a searcher which cannot.
+	ErrRefreshNotSupported = errors.New("refreshing is not supported")
+
+	// ErrDiskFull is returned when events are refused as the free space of
+	// the data path is below the threshold of its DiskWatchdog.
+	ErrDiskFull = errors.New("free disk space below threshold")
+)
+
+// wrapQueryError wraps err as ErrQueryTimeout if the deadline of ctx has passed.
+func wrapQueryError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) || ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w: %v", ErrQueryTimeout, err)
+	}
+	return err
+}
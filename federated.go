@@ -0,0 +1,270 @@
+package ekanite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	bleve_index "github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/search"
+)
+
+// FederatedSearcher is a Searcher fanning searches out to the HTTP query
+// servers of other nodes, each ingesting on its own, and merging their results
+// as MultiSearch does those of indexes. A node failing a search is reported in
+// the errors of the search status, the others' results being returned.
+type FederatedSearcher struct {
+	Nodes  []string     // Base URLs of the query servers of the nodes, with any URL prefix.
+	Token  string       // Bearer token sent to the nodes, if set.
+	Client *http.Client // Client the nodes are requested with.
+}
+
+// NewFederatedSearcher returns a FederatedSearcher over the nodes at the given
+// base URLs.
+func NewFederatedSearcher(nodes []string) *FederatedSearcher {
+	return &FederatedSearcher{Nodes: nodes, Client: http.DefaultClient}
+}
+
+// NodeField is the field of the hits of a FederatedSearcher holding the base
+// URL of the node each comes from, their index being named as by the node.
+const NodeField = "_node"
+
+// remoteSearchResult is a bleve.SearchResult as encoded by a node, its errors
+// being strings.
+type remoteSearchResult struct {
+	Status struct {
+		Total      int               `json:"total"`
+		Failed     int               `json:"failed"`
+		Successful int               `json:"successful"`
+		Errors     map[string]string `json:"errors"`
+	} `json:"status"`
+	Hits     search.DocumentMatchCollection `json:"hits"`
+	Total    uint64                         `json:"total_hits"`
+	MaxScore float64                        `json:"max_score"`
+	Took     time.Duration                  `json:"took"`
+	Facets   search.FacetResults            `json:"facets"`
+}
+
+// Query searches the nodes, the hits naming the node they come from in their
+// NodeField.
+func (f *FederatedSearcher) Query(ctx context.Context, startTime, endTime time.Time, req *bleve.SearchRequest, cb func(*bleve.SearchRequest, *bleve.SearchResult) error) error {
+	stats.Add("federatedQueriesRx", 1)
+
+	children := make([]childSearch, 0, len(f.Nodes))
+	for _, node := range f.Nodes {
+		node := node
+		children = append(children, childSearch{
+			Name: node,
+			Search: func(ctx context.Context, req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+				return f.search(ctx, node, startTime, endTime, req)
+			},
+		})
+	}
+	result, err := multiSearch(ctx, req, children)
+	if err != nil {
+		return wrapQueryError(ctx, err)
+	}
+
+	// Nodes without an index for the time range are not failures, unless
+	// none of them has one.
+	var notFound int
+	for node, err := range result.Status.Errors {
+		if errors.Is(err, ErrIndexNotFound) {
+			delete(result.Status.Errors, node)
+			result.Status.Total--
+			result.Status.Failed--
+			notFound++
+		}
+	}
+	if notFound == len(f.Nodes) {
+		return ErrIndexNotFound
+	}
+	return cb(req, result.SearchResult)
+}
+
+// search sends req to the raw search endpoint of node.
+func (f *FederatedSearcher) search(ctx context.Context, node string, startTime, endTime time.Time, req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var remote remoteSearchResult
+	if err := f.do(ctx, "POST", node, "/raw/_search", timeRangeParams(startTime, endTime), body, &remote); err != nil {
+		return nil, err
+	}
+
+	result := &bleve.SearchResult{
+		Status: &bleve.SearchStatus{
+			Total:      remote.Status.Total,
+			Failed:     remote.Status.Failed,
+			Successful: remote.Status.Successful,
+		},
+		Hits:     remote.Hits,
+		Total:    remote.Total,
+		MaxScore: remote.MaxScore,
+		Took:     remote.Took,
+		Facets:   remote.Facets,
+	}
+	if len(remote.Status.Errors) > 0 {
+		result.Status.Errors = make(map[string]error, len(remote.Status.Errors))
+		for name, msg := range remote.Status.Errors {
+			result.Status.Errors[node+"/"+name] = errors.New(msg)
+		}
+	}
+	for _, hit := range result.Hits {
+		if hit.Fields == nil {
+			hit.Fields = map[string]interface{}{}
+		}
+		hit.Fields[NodeField] = node
+	}
+	return result, nil
+}
+
+// Fields returns the fields of the indexes of all the nodes.
+func (f *FederatedSearcher) Fields(ctx context.Context, startTime, endTime time.Time) ([]string, error) {
+	var mu sync.Mutex
+	allFields := map[string]struct{}{}
+	err := f.each(func(node string) error {
+		var fields []string
+		if err := f.do(ctx, "GET", node, "/fields", timeRangeParams(startTime, endTime), nil, &fields); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, field := range fields {
+			allFields[field] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(allFields) == 0 {
+		return nil, ErrIndexNotFound
+	}
+
+	fields := make([]string, 0, len(allFields))
+	for field := range allFields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// FieldDict returns the terms of the field in the indexes of all the nodes,
+// with their counts summed.
+func (f *FederatedSearcher) FieldDict(ctx context.Context, startTime, endTime time.Time, field string) ([]bleve_index.DictEntry, error) {
+	var mu sync.Mutex
+	counts := map[string]uint64{}
+	err := f.each(func(node string) error {
+		var entries []bleve_index.DictEntry
+		if err := f.do(ctx, "GET", node, "/fields/"+url.PathEscape(field), timeRangeParams(startTime, endTime), nil, &entries); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, entry := range entries {
+			counts[entry.Term] += entry.Count
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]bleve_index.DictEntry, 0, len(counts))
+	for term, count := range counts {
+		entries = append(entries, bleve_index.DictEntry{Term: term, Count: count})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Term < entries[j].Term })
+	return entries, nil
+}
+
+// each calls fn for each node at once, returning the errors of the nodes
+// which failed.
+func (f *FederatedSearcher) each(fn func(node string) error) error {
+	errs := make([]error, len(f.Nodes))
+	var wg sync.WaitGroup
+	wg.Add(len(f.Nodes))
+	for i, node := range f.Nodes {
+		go func(i int, node string) {
+			defer wg.Done()
+			if err := fn(node); err != nil && !errors.Is(err, ErrIndexNotFound) {
+				errs[i] = fmt.Errorf("node %s: %w", node, err)
+			}
+		}(i, node)
+	}
+	wg.Wait()
+
+	var errList []error
+	for _, err := range errs {
+		if err != nil {
+			errList = append(errList, err)
+		}
+	}
+	return ErrArray(errList)
+}
+
+// do sends a request to the path of node, decoding the JSON response into v.
+// A node answering that it has no index returns ErrIndexNotFound.
+func (f *FederatedSearcher) do(ctx context.Context, method, node, path string, params url.Values, body []byte, v interface{}) error {
+	u := strings.TrimSuffix(node, "/") + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if f.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.Token)
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return wrapQueryError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(resp.Body).Decode(v)
+	case http.StatusNoContent:
+		return ErrIndexNotFound
+	case http.StatusGatewayTimeout:
+		return ErrQueryTimeout
+	default:
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s: %s", method, u, resp.Status, strings.TrimSpace(string(msg)))
+	}
+}
+
+// timeRangeParams returns the start_at and end_at parameters of a time range.
+func timeRangeParams(startTime, endTime time.Time) url.Values {
+	params := url.Values{}
+	if !startTime.IsZero() {
+		params.Set("start_at", startTime.Format(time.RFC3339Nano))
+	}
+	if !endTime.IsZero() {
+		params.Set("end_at", endTime.Format(time.RFC3339Nano))
+	}
+	return params
+}
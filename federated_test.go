@@ -0,0 +1,136 @@
+package ekanite
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	bleve_index "github.com/blevesearch/bleve/index"
+)
+
+// newTestNode returns a server answering the federated searches like a node
+// whose hits have the given scores, fields and terms of the host field.
+func newTestNode(t *testing.T, scores map[string]float64, fields []string, hosts map[string]uint64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/raw/_search":
+			if req.URL.Query().Get("start_at") == "" {
+				t.Errorf("search request has no start_at")
+			}
+			var hits []map[string]interface{}
+			for id, score := range scores {
+				hits = append(hits, map[string]interface{}{"index": "20180101_0000/shard_0", "id": id, "score": score})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":     map[string]interface{}{"total": 1, "failed": 0, "successful": 1},
+				"hits":       hits,
+				"total_hits": len(hits),
+			})
+		case "/fields":
+			json.NewEncoder(w).Encode(fields)
+		case "/fields/host":
+			var entries []bleve_index.DictEntry
+			for term, count := range hosts {
+				entries = append(entries, bleve_index.DictEntry{Term: term, Count: count})
+			}
+			json.NewEncoder(w).Encode(entries)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+}
+
+func TestFederatedSearcher(t *testing.T) {
+	a := newTestNode(t, map[string]float64{"a1": 3, "a2": 1}, []string{"host", "message"}, map[string]uint64{"web": 2, "db": 1})
+	defer a.Close()
+	b := newTestNode(t, map[string]float64{"b1": 2}, []string{"app", "host"}, map[string]uint64{"web": 5})
+	defer b.Close()
+	empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer empty.Close()
+
+	f := NewFederatedSearcher([]string{a.URL, b.URL, empty.URL})
+	ctx := context.Background()
+	start, end := time.Now().Add(-time.Hour), time.Now()
+
+	req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), 2, 0, false)
+	err := f.Query(ctx, start, end, req, func(req *bleve.SearchRequest, resp *bleve.SearchResult) error {
+		if resp.Total != 3 {
+			t.Errorf("got %d total hits, expected 3", resp.Total)
+		}
+		var ids []string
+		for _, hit := range resp.Hits {
+			ids = append(ids, hit.ID)
+		}
+		if !reflect.DeepEqual(ids, []string{"a1", "b1"}) {
+			t.Errorf("got hits %v, expected the best two of all nodes", ids)
+		}
+		if resp.Hits[1].Index != "20180101_0000/shard_0" || resp.Hits[1].Fields[NodeField] != b.URL {
+			t.Errorf("hit index is %s of node %v, expected index 20180101_0000/shard_0 of node %s",
+				resp.Hits[1].Index, resp.Hits[1].Fields[NodeField], b.URL)
+		}
+		if len(resp.Status.Errors) != 0 {
+			t.Errorf("node without index reported as failed: %v", resp.Status.Errors)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to query nodes: %s", err)
+	}
+
+	fields, err := f.Fields(ctx, start, end)
+	if err != nil {
+		t.Fatalf("failed to get fields: %s", err)
+	}
+	if !reflect.DeepEqual(fields, []string{"app", "host", "message"}) {
+		t.Errorf("got fields %v", fields)
+	}
+
+	entries, err := f.FieldDict(ctx, start, end, "host")
+	if err != nil {
+		t.Fatalf("failed to get field dictionary: %s", err)
+	}
+	expected := []bleve_index.DictEntry{{Term: "db", Count: 1}, {Term: "web", Count: 7}}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("got terms %v, expected %v", entries, expected)
+	}
+}
+
+func TestFederatedSearcher_NodeFailure(t *testing.T) {
+	a := newTestNode(t, map[string]float64{"a1": 1}, nil, nil)
+	defer a.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	f := NewFederatedSearcher([]string{a.URL, down.URL})
+	req := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	err := f.Query(context.Background(), time.Now().Add(-time.Hour), time.Time{}, req, func(req *bleve.SearchRequest, resp *bleve.SearchResult) error {
+		if len(resp.Hits) != 1 {
+			t.Errorf("got %d hits, expected those of the node up", len(resp.Hits))
+		}
+		if _, ok := resp.Status.Errors[down.URL]; !ok {
+			t.Errorf("failing node not reported, errors are %v", resp.Status.Errors)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to query nodes: %s", err)
+	}
+
+	if _, err := f.Fields(context.Background(), time.Time{}, time.Time{}); err == nil {
+		t.Errorf("fields of a failing node returned no error")
+	}
+
+	f = NewFederatedSearcher([]string{a.URL})
+	if _, err := f.Fields(context.Background(), time.Time{}, time.Time{}); err != ErrIndexNotFound {
+		t.Errorf("fields of nodes without any returned %v, expected ErrIndexNotFound", err)
+	}
+}
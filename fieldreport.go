@@ -0,0 +1,149 @@
+package ekanite
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// Field report limits
+const (
+	DefaultFieldReportSize = 1000
+	MaxFieldReportSize     = 10000
+
+	// explosiveRatio is the share of distinct values above which a field
+	// held by at least explosiveMinValues sampled documents is reported as
+	// of explosive cardinality.
+	explosiveRatio     = 0.9
+	explosiveMinValues = 50
+)
+
+// Value types of a field report.
+const (
+	FieldTypeText     = "text"
+	FieldTypeNumber   = "number"
+	FieldTypeDatetime = "datetime"
+	FieldTypeBoolean  = "boolean"
+)
+
+// uniqueFields are expected to have a distinct value in nearly every
+// document, and so are never reported as of explosive cardinality.
+var uniqueFields = map[string]bool{
+	"message":   true,
+	"timestamp": true,
+	"reception": true,
+}
+
+// FieldReport describes the fields of sampled documents, so that the mapping
+// and the field types coerced at ingest can be tuned before indexes degrade.
+type FieldReport struct {
+	Documents int          `json:"documents"` // Number of documents sampled.
+	Fields    []FieldStats `json:"fields"`    // Fields of the documents, by name.
+}
+
+// FieldStats describes the values of a field in sampled documents.
+type FieldStats struct {
+	Name         string         `json:"name"`
+	Documents    int            `json:"documents"`              // Sampled documents holding the field.
+	Types        map[string]int `json:"types"`                  // Values by type: text, number, datetime or boolean.
+	Distinct     int            `json:"distinct"`               // Distinct values.
+	Inconsistent bool           `json:"inconsistent,omitempty"` // Whether the values have more than one type.
+	Explosive    bool           `json:"explosive,omitempty"`    // Whether nearly every value is distinct.
+}
+
+// FieldReport samples the n most recent documents of the indexes covering the
+// time range, and reports the types and cardinality of their fields.
+func (e *Engine) FieldReport(ctx context.Context, startTime, endTime time.Time, n int) (*FieldReport, error) {
+	if n <= 0 {
+		n = DefaultFieldReportSize
+	} else if n > MaxFieldReportSize {
+		n = MaxFieldReportSize
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	stats.Add("queriesRx", 1)
+
+	indexes := e.getIndexs(startTime, endTime)
+	if len(indexes) == 0 {
+		return nil, ErrIndexNotFound
+	}
+
+	req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), n, 0, false)
+	req.SortBy([]string{"-_id"})
+	req.Fields = []string{"*"}
+	var docs []map[string]interface{}
+	err := e.search(ctx, indexes, req, func(req *bleve.SearchRequest, resp *bleve.SearchResult) error {
+		for _, hit := range resp.Hits {
+			docs = append(docs, hit.Fields)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return analyzeFields(docs), nil
+}
+
+// analyzeFields reports the types and cardinality of the fields of docs.
+func analyzeFields(docs []map[string]interface{}) *FieldReport {
+	type fieldValues struct {
+		stats    FieldStats
+		distinct map[interface{}]struct{}
+		values   int
+	}
+	fields := map[string]*fieldValues{}
+	for _, doc := range docs {
+		for name, v := range doc {
+			f := fields[name]
+			if f == nil {
+				f = &fieldValues{
+					stats:    FieldStats{Name: name, Types: map[string]int{}},
+					distinct: map[interface{}]struct{}{},
+				}
+				fields[name] = f
+			}
+			f.stats.Documents++
+			values, ok := v.([]interface{})
+			if !ok {
+				values = []interface{}{v}
+			}
+			for _, value := range values {
+				f.stats.Types[valueType(value)]++
+				f.distinct[value] = struct{}{}
+				f.values++
+			}
+		}
+	}
+
+	report := &FieldReport{Documents: len(docs), Fields: make([]FieldStats, 0, len(fields))}
+	for name, f := range fields {
+		f.stats.Distinct = len(f.distinct)
+		f.stats.Inconsistent = len(f.stats.Types) > 1
+		f.stats.Explosive = !uniqueFields[name] && f.values >= explosiveMinValues &&
+			float64(f.stats.Distinct) > explosiveRatio*float64(f.values)
+		report.Fields = append(report.Fields, f.stats)
+	}
+	sort.Slice(report.Fields, func(i, j int) bool { return report.Fields[i].Name < report.Fields[j].Name })
+	return report
+}
+
+// valueType returns the type of a stored field value. Dates are stored as
+// RFC3339 strings.
+func valueType(v interface{}) string {
+	switch v := v.(type) {
+	case float64, float32, int, int64:
+		return FieldTypeNumber
+	case bool:
+		return FieldTypeBoolean
+	case time.Time:
+		return FieldTypeDatetime
+	case string:
+		if _, err := time.Parse(time.RFC3339, v); err == nil {
+			return FieldTypeDatetime
+		}
+	}
+	return FieldTypeText
+}
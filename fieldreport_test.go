@@ -0,0 +1,54 @@
+package ekanite
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAnalyzeFields(t *testing.T) {
+	var docs []map[string]interface{}
+	for i := 0; i < 100; i++ {
+		doc := map[string]interface{}{
+			"message":    fmt.Sprintf("request %d served", i),
+			"timestamp":  "2017-06-01T10:00:00Z",
+			"host":       fmt.Sprintf("web%d", i%3),
+			"request_id": fmt.Sprintf("%08x", i),
+			"tags":       []interface{}{"http", "prod"},
+		}
+		// Some shippers send the status as a number, others as text.
+		if i%2 == 0 {
+			doc["status"] = float64(200)
+		} else {
+			doc["status"] = "OK"
+		}
+		docs = append(docs, doc)
+	}
+
+	report := analyzeFields(docs)
+	if report.Documents != 100 || len(report.Fields) != 6 {
+		t.Fatalf("report of %d documents has %d fields", report.Documents, len(report.Fields))
+	}
+	byName := map[string]FieldStats{}
+	for _, f := range report.Fields {
+		byName[f.Name] = f
+	}
+
+	if f := byName["status"]; !f.Inconsistent || f.Types[FieldTypeNumber] != 50 || f.Types[FieldTypeText] != 50 {
+		t.Errorf("status not reported as of inconsistent types: %+v", f)
+	}
+	if f := byName["request_id"]; !f.Explosive || f.Distinct != 100 {
+		t.Errorf("request_id not reported as of explosive cardinality: %+v", f)
+	}
+	if f := byName["host"]; f.Explosive || f.Inconsistent || f.Distinct != 3 {
+		t.Errorf("host reported wrongly: %+v", f)
+	}
+	if f := byName["message"]; f.Explosive {
+		t.Errorf("message reported as of explosive cardinality: %+v", f)
+	}
+	if f := byName["timestamp"]; f.Types[FieldTypeDatetime] != 100 {
+		t.Errorf("timestamp not reported as datetime: %+v", f)
+	}
+	if f := byName["tags"]; f.Documents != 100 || f.Types[FieldTypeText] != 200 || f.Distinct != 2 {
+		t.Errorf("tags values reported wrongly: %+v", f)
+	}
+}
@@ -12,24 +12,94 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/analysis/analyzer/custom"
 	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
 	"github.com/blevesearch/bleve/analysis/tokenizer/regexp"
+	"github.com/blevesearch/bleve/analysis/tokenizer/single"
 	"github.com/blevesearch/bleve/index/scorch"
 	"github.com/blevesearch/bleve/mapping"
 )
 
 const (
 	endTimeFileName = "endtime"
+	holdFileName    = "hold"
 	indexNameLayout = "20060102_1504"
 	// MaxSearchHitSize the max search record in results
 	MaxSearchHitSize = 10000
 	maxShardCount    = 9999
 )
 
+// keywordFields are identifier-like fields which are indexed as a single,
+// un-analyzed term, so that term queries on them match exactly.
+var keywordFields = []string{"host", "app", "tag", "message_id", "tenant"}
+
+// IsKeywordField returns whether the given field is indexed as a single term,
+// rather than analyzed into words.
+func IsKeywordField(field string) bool {
+	if field == "address" || field == "source" {
+		return true
+	}
+	for _, name := range keywordFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// CaseFold is a set of keyword fields whose values are lower-cased when
+// indexed and queried, so that `app:SSHD` and `app:sshd` match the same
+// documents. The nil CaseFold folds no field.
+type CaseFold map[string]struct{}
+
+// ParseCaseFold parses a comma-separated list of keyword fields, such as
+// "host,app,tag".
+func ParseCaseFold(s string) CaseFold {
+	fold := CaseFold{}
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fold[f] = struct{}{}
+		}
+	}
+	return fold
+}
+
+// Has returns whether values of the given field are case folded.
+func (f CaseFold) Has(field string) bool {
+	_, ok := f[field]
+	return ok
+}
+
+// Fold returns the term as it is stored in the index for the given field.
+func (f CaseFold) Fold(field, term string) string {
+	if f.Has(field) {
+		return strings.ToLower(term)
+	}
+	return term
+}
+
+// Fields returns the case folded fields, sorted.
+func (f CaseFold) Fields() []string {
+	var fields []string
+	for field := range f {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// keywordAnalyzer returns the name of the analyzer to use for a keyword field.
+func keywordAnalyzer(fold CaseFold, field string) string {
+	if fold.Has(field) {
+		return "ekanite_keyword_lower"
+	}
+	return keyword.Name
+}
+
 // DocID is a string, with the following configuration. It's 32-characters long, encoding 2
 // 64-bit unsigned integers. When sorting DocIDs, the first 16 characters, reading from the
 // left hand side represent the most significant 64-bit number. And therefore the next 16
@@ -73,9 +143,13 @@ type Document interface {
 
 // Index represents a collection of shards. It contains data for a specific time range.
 type Index struct {
-	path      string    // Path to shard data
-	startTime time.Time // Start-time inclusive for this index
-	endTime   time.Time // End-time exclusive for this index
+	path      string         // Path to shard data
+	startTime time.Time      // Start-time inclusive for this index
+	endTime   time.Time      // End-time exclusive for this index
+	hold      string         // Why the index is exempt from retention, empty if it is not
+	tier      string         // Name of the tier of the index, empty if not in one
+	metadata  *IndexMetadata // Settings the index was created with, nil if not recorded
+	readers   sync.WaitGroup // Snapshots reading the index, which is not deleted under them
 
 	Shards []*Shard         // Individual bleve indexes
 	Alias  bleve.IndexAlias // All bleve indexes as one reference, for search
@@ -96,10 +170,55 @@ func (i Indexes) Less(u, v int) bool {
 }
 func (i Indexes) Swap(u, v int) { i[u], i[v] = i[v], i[u] }
 
+// Overlaps returns the pairs of indexes of the same tier whose time ranges
+// overlap. Each pair holds an index, and the earliest starting index which
+// starts before it ends.
+func (i Indexes) Overlaps() [][2]*Index {
+	byStart := make(Indexes, len(i))
+	copy(byStart, i)
+	sort.Slice(byStart, func(u, v int) bool {
+		if byStart[u].tier != byStart[v].tier {
+			return byStart[u].tier < byStart[v].tier
+		}
+		return byStart[u].startTime.Before(byStart[v].startTime)
+	})
+
+	var overlaps [][2]*Index
+	for u := 0; u+1 < len(byStart); u++ {
+		if byStart[u+1].tier == byStart[u].tier && byStart[u+1].startTime.Before(byStart[u].endTime) {
+			overlaps = append(overlaps, [2]*Index{byStart[u], byStart[u+1]})
+		}
+	}
+	return overlaps
+}
+
+// indexOptions are the settings the shards of an index are created and opened
+// with, those of the engine for its indexes.
+type indexOptions struct {
+	mapping        *mapping.IndexMappingImpl // Of the shards created, the built-in one if nil.
+	caseFold       CaseFold                  // Recorded in the metadata of the indexes created.
+	detectLanguage bool                      // Also index Chinese messages through the CJK analyzer.
+	durability     Durability
+	syncInterval   time.Duration // Only used by DurabilityInterval.
+}
+
 // NewIndex returns an Index for the given start and end time, with the requested shards. It
 // returns an error if an index already exists at the path.
 func NewIndex(path string, startTime, endTime time.Time, numShards int) (*Index, error) {
+	return NewTierIndex(path, "", startTime, endTime, numShards)
+}
+
+// NewTierIndex is like NewIndex, for an index of the named tier.
+func NewTierIndex(path, tier string, startTime, endTime time.Time, numShards int) (*Index, error) {
+	return newTierIndex(path, tier, startTime, endTime, numShards, indexOptions{})
+}
+
+// newTierIndex is like NewTierIndex, for an index created with opts.
+func newTierIndex(path, tier string, startTime, endTime time.Time, numShards int, opts indexOptions) (*Index, error) {
 	indexName := startTime.UTC().Format(indexNameLayout)
+	if tier != "" {
+		indexName += tierSeparator + tier
+	}
 	indexPath := filepath.Join(path, indexName)
 	durationPath := filepath.Join(indexPath, endTimeFileName)
 
@@ -116,30 +235,40 @@ func NewIndex(path string, startTime, endTime time.Time, numShards int) (*Index,
 	}
 
 	// Insert the file with the duration information.
-	f, err := os.Create(durationPath)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	_, err = f.WriteString(endTime.UTC().Format(indexNameLayout))
-	if err != nil {
+	if err := writeEndTime(durationPath, endTime); err != nil {
 		return nil, err
 	}
 	if numShards == 0 {
 		numShards = 1
 	}
 
+	if opts.mapping == nil {
+		m, err := buildIndexMapping(nil, true)
+		if err != nil {
+			return nil, err
+		}
+		opts.mapping = m
+	}
+
 	// Create the shards.
 	shards := make([]*Shard, 0, numShards)
 	for n := 0; n < numShards; n++ {
-		s := NewShard(filepath.Join(indexPath, fmt.Sprintf("%04d", n)))
+		s := newShard(filepath.Join(indexPath, fmt.Sprintf("%04d", n)), opts)
 		if err := s.Open(); err != nil {
 			return nil, err
 		}
 		shards = append(shards, s)
 	}
 
+	// Record the settings the index is created with.
+	md, err := newIndexMetadata(numShards, opts.mapping, opts.caseFold)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMetadata(metadataFile(indexPath), md); err != nil {
+		return nil, err
+	}
+
 	// Create alias for searching.
 	alias := bleve.NewIndexAlias()
 	for _, s := range shards {
@@ -153,11 +282,23 @@ func NewIndex(path string, startTime, endTime time.Time, numShards int) (*Index,
 		Alias:     alias,
 		startTime: startTime,
 		endTime:   endTime,
+		tier:      tier,
+		metadata:  md,
 	}, nil
 }
 
-// OpenIndex opens an existing index, at the given path.
-func OpenIndex(path string) (*Index, error) {
+// OpenIndex opens an existing index, at the given path. If the end time file
+// of the index is missing or corrupt, the end time is taken to be duration
+// after the start time, and the file is rewritten. An index whose metadata
+// show it was created with settings this engine would search it wrongly with
+// is refused with ErrIncompatibleIndex, and one restored from an archive whose
+// files do not match its manifest with ErrCorruptIndex.
+func OpenIndex(path string, duration time.Duration) (*Index, error) {
+	return openIndex(path, duration, indexOptions{})
+}
+
+// openIndex is like OpenIndex, for an index opened with opts.
+func openIndex(path string, duration time.Duration, opts indexOptions) (*Index, error) {
 	fi, err := os.Stat(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to access index at %s", path)
@@ -166,47 +307,73 @@ func OpenIndex(path string) (*Index, error) {
 		return nil, fmt.Errorf("index %s path is not a directory", path)
 	}
 
-	// Get the start time and end time.
-	startTime, err := time.Parse(indexNameLayout, fi.Name())
-	if err != nil {
-		return nil, fmt.Errorf("unable to determine start time of index: %s", err.Error())
+	if err := verifyIndexFiles(path); err != nil {
+		return nil, fmt.Errorf("index %s: %w", path, err)
 	}
 
-	var endTime time.Time
-	f, err := os.Open(filepath.Join(path, endTimeFileName))
+	// Get the tier, start time and end time.
+	name, tier := fi.Name(), ""
+	if n := strings.Index(name, tierSeparator); n >= 0 {
+		name, tier = name[:n], name[n+len(tierSeparator):]
+	}
+	startTime, err := time.Parse(indexNameLayout, name)
 	if err != nil {
-		return nil, fmt.Errorf("unable to open end time file for index: %s", err.Error())
+		return nil, fmt.Errorf("unable to determine start time of index: %w", err)
 	}
-	defer f.Close()
-	r := bufio.NewReader(f)
-	s, err := r.ReadString('\n')
-	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("unable to determine end time of index: %s", err.Error())
+
+	durationPath := filepath.Join(path, endTimeFileName)
+	endTime, err := readEndTime(durationPath)
+	if err != nil {
+		if duration <= 0 {
+			return nil, err
+		}
+		endTime = startTime.Add(duration)
+		log.Printf("[ekanite] %s, recovering end time %s of index %s",
+			err, endTime.UTC().Format(indexNameLayout), path)
+		stats.Add("indexEndTimeRecovered", 1)
+		if err := writeEndTime(durationPath, endTime); err != nil {
+			return nil, err
+		}
 	}
-	endTime, err = time.Parse(indexNameLayout, s)
+
+	hold, err := readHold(filepath.Join(path, holdFileName))
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse end time from '%s': %s", s, err.Error())
+		return nil, err
 	}
 
-	// Open the shards.
+	// Open the shards, as they were before any compaction interrupted.
+	if err := recoverCompaction(path); err != nil {
+		return nil, fmt.Errorf("index %s: %w", path, err)
+	}
 	names, err := listShards(path)
 	if err != nil {
 		return nil, err
 	}
 
+	md, err := readMetadata(metadataFile(path))
+	if err != nil {
+		return nil, err
+	}
+	if md != nil {
+		if err := md.compatible(len(names)); err != nil {
+			return nil, fmt.Errorf("index %s: %w", path, err)
+		}
+	}
+
 	var shards = make([]*Shard, 0)
 	for _, name := range names {
-		s := NewShard(filepath.Join(path, name))
+		s := newShard(filepath.Join(path, name), opts)
 		if err := s.Open(); err != nil {
-			return nil, fmt.Errorf("shard open fail: %s", err.Error())
+			return nil, fmt.Errorf("shard open fail: %w", err)
 		}
 		shards = append(shards, s)
 	}
 
-	if len(shards) < DefaultNumShards {
+	// Indexes without metadata may have lost shards, made up for empty.
+	if md == nil && len(shards) < DefaultNumShards {
 		maxID := getMaxShardID(path)
 		for n := 0; n < (DefaultNumShards - len(shards)); n++ {
-			s := NewShard(filepath.Join(path, fmt.Sprintf("%04d", maxID+n+1)))
+			s := newShard(filepath.Join(path, fmt.Sprintf("%04d", maxID+n+1)), opts)
 			if err := s.Open(); err != nil {
 				return nil, err
 			}
@@ -227,9 +394,110 @@ func OpenIndex(path string) (*Index, error) {
 		Alias:     alias,
 		startTime: startTime,
 		endTime:   endTime,
+		hold:      hold,
+		tier:      tier,
+		metadata:  md,
 	}, nil
 }
 
+// readHold reads why an index is held from the file at path, returning an
+// empty reason if there is no such file.
+func readHold(path string) (string, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("unable to read hold of index: %w", err)
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
+
+// readEndTime reads the end time of an index from the file at path.
+func readEndTime(path string) (time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to open end time file for index: %w", err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	s, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return time.Time{}, fmt.Errorf("unable to determine end time of index: %w", err)
+	}
+	endTime, err := time.Parse(indexNameLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to parse end time from '%s': %w", s, err)
+	}
+	return endTime, nil
+}
+
+// writeEndTime atomically writes the end time of an index to the file at
+// path, through a temporary file which is renamed into place.
+func writeEndTime(path string, endTime time.Time) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(endTime.UTC().Format(indexNameLayout)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// SetEndTime changes the exclusive end time of the index. Documents already in
+// the index are kept, but new documents after the end time go elsewhere.
+func (i *Index) SetEndTime(t time.Time) error {
+	if err := writeEndTime(filepath.Join(i.path, endTimeFileName), t); err != nil {
+		return err
+	}
+	i.endTime = t
+	return nil
+}
+
+// SetHold holds the index for the given reason, exempting it from retention
+// enforcement. An empty reason releases the hold. Holds persist across
+// restarts.
+func (i *Index) SetHold(reason string) error {
+	path := filepath.Join(i.path, holdFileName)
+	if reason == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		i.hold = ""
+		return nil
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(reason), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	i.hold = reason
+	return nil
+}
+
+// Hold returns why the index is held, empty if it is not.
+func (i *Index) Hold() string { return i.hold }
+
+// Name returns the name of the index, which is derived from its start time
+// and tier.
+func (i *Index) Name() string { return filepath.Base(i.path) }
+
+// Tier returns the name of the tier of the index, empty if it is not in one.
+func (i *Index) Tier() string { return i.tier }
+
 // Path returns the path to storage for the index.
 func (i *Index) Path() string { return i.path }
 
@@ -258,12 +526,57 @@ func (i *Index) Total() (uint64, error) {
 	return total, nil
 }
 
+// Size returns the bytes the index takes on disk.
+func (i *Index) Size() (int64, error) {
+	var size int64
+	err := filepath.Walk(i.path, func(_ string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil // Segment files come and go as shards are written.
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
 // Contains returns whether the index's time range includes the given
 // reference time.
 func (i *Index) Contains(t time.Time) bool {
 	return (t.Equal(i.startTime) || t.After(i.startTime)) && t.Before(i.endTime)
 }
 
+// latestReferenceTime returns the latest reference time of the documents in
+// the index, read from their IDs, zero if it holds none. Writes buffered on
+// its shards are flushed first, so that they are accounted for.
+func (i *Index) latestReferenceTime() (time.Time, error) {
+	if err := i.Flush(); err != nil {
+		return time.Time{}, err
+	}
+	req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), 1, 0, false)
+	req.SortBy([]string{"-_id"})
+	resp, err := i.Alias.Search(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(resp.Hits) == 0 {
+		return time.Time{}, nil
+	}
+	id := resp.Hits[0].ID
+	if len(id) < 16 {
+		return time.Time{}, fmt.Errorf("document ID %s holds no reference time", id)
+	}
+	ns, err := strconv.ParseUint(id[:16], 16, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("document ID %s holds no reference time: %w", id, err)
+	}
+	return time.Unix(0, int64(ns)).UTC(), nil
+}
+
 // Index indexes the slice of documents in the index. It takes care of all shard routing.
 func (i *Index) Index(documents []Document) error {
 	shardBatches := make(map[*Shard][]Document, 0)
@@ -318,6 +631,24 @@ func (i *Index) Document(id DocID) ([]byte, error) {
 	return s.Document(id)
 }
 
+// SetMaxBatchSize sets the maximum number of documents per bleve batch on all
+// shards of the index.
+func (i *Index) SetMaxBatchSize(n int) {
+	for _, s := range i.Shards {
+		s.SetMaxBatchSize(n)
+	}
+}
+
+// Flush persists writes buffered on all shards of the index.
+func (i *Index) Flush() error {
+	for _, s := range i.Shards {
+		if err := s.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Close closes the index.
 func (i *Index) Close() error {
 	for _, s := range i.Shards {
@@ -328,9 +659,28 @@ func (i *Index) Close() error {
 	return nil
 }
 
-// DeleteIndex deletes the index.
+// reopen opens the shards of the closed index again, and replaces its alias
+// with one of the reopened shards.
+func (i *Index) reopen() error {
+	alias := bleve.NewIndexAlias()
+	for _, s := range i.Shards {
+		s.b = nil
+		if err := s.Open(); err != nil {
+			return fmt.Errorf("shard open fail: %w", err)
+		}
+		alias.Add(s.b)
+	}
+	i.Alias = alias
+	return nil
+}
+
+// DeleteIndex closes and deletes the index. The index is not removed if it
+// cannot be closed, as open files would keep it from being removed on some
+// platforms.
 func DeleteIndex(i *Index) error {
-	_ = i.Close()
+	if err := i.Close(); err != nil {
+		return fmt.Errorf("failed to close index before deleting it: %w", err)
+	}
 	return os.RemoveAll(i.path)
 }
 
@@ -345,8 +695,19 @@ func (i *Index) Shard(docId DocID) *Shard {
 // Shard is a the basic data store for indexed data. Indexing operations are not
 // goroutine safe, and only 1 indexing operation should occur at one time.
 type Shard struct {
-	path string
-	b    bleve.Index // Underlying bleve index
+	path         string
+	b            bleve.Index // Underlying bleve index
+	maxBatchSize int         // Maximum documents per bleve batch, 0 for no limit
+
+	mapping        *mapping.IndexMappingImpl // Of the shard if created, the built-in one if nil
+	detectLanguage bool                      // Also index Chinese messages through the CJK analyzer
+
+	durability   Durability
+	syncInterval time.Duration
+
+	mu       sync.Mutex   // Protects pending and lastSync
+	pending  *bleve.Batch // Writes buffered under DurabilityInterval
+	lastSync time.Time
 }
 
 // NewShard returns a shard using the data at the given path.
@@ -356,22 +717,35 @@ func NewShard(path string) *Shard {
 	}
 }
 
+// newShard returns a shard using the data at the given path, opened with opts.
+func newShard(path string, opts indexOptions) *Shard {
+	s := NewShard(path)
+	s.mapping = opts.mapping
+	s.detectLanguage = opts.detectLanguage
+	s.durability, s.syncInterval = opts.durability, opts.syncInterval
+	return s
+}
+
 // Open opens the shard. If no data exists at the shard's path, an empty shard
 // will be created.
 func (s *Shard) Open() error {
+	config := map[string]interface{}{
+		"unsafe_batch": s.durability == DurabilityOS,
+	}
+
 	_, err := os.Stat(s.path)
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to check existence of shard")
 	} else if !os.IsNotExist(err) {
 
-		s.b, err = bleve.Open(s.path)
+		s.b, err = bleve.OpenUsing(s.path, config)
 		if err != nil {
 			for i := 0; ; i++ {
 				if i >= 100 {
 					if e := ioutil.WriteFile(s.path+".deleted", []byte("deleted"), 0666); e != nil {
 						log.Println("[ekanite]", e)
 					}
-					return fmt.Errorf("bleve open: %s", err.Error())
+					return fmt.Errorf("bleve open: %w", err)
 				}
 
 				newPath := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".err."+strconv.Itoa(i))
@@ -383,32 +757,107 @@ func (s *Shard) Open() error {
 	}
 
 	if s.b == nil {
-		mapping, err := buildIndexMapping()
-		if err != nil {
-			return err
+		mapping := s.mapping
+		if mapping == nil {
+			if mapping, err = buildIndexMapping(nil, true); err != nil {
+				return err
+			}
 		}
 
 		// bleve.Config.DefaultIndexType = upsidedown.Name
 		// bleve.Config.DefaultIndexType = scorch.Name
-		s.b, err = bleve.NewUsing(s.path, mapping, scorch.Name, bleve.Config.DefaultKVStore, nil)
+		s.b, err = bleve.NewUsing(s.path, mapping, scorch.Name, bleve.Config.DefaultKVStore, config)
 		if err != nil {
-			return fmt.Errorf("bleve new: %s", err.Error())
+			return fmt.Errorf("bleve new: %w", err)
 		}
 	}
 	return nil
 }
 
-// Close closes the shard.
+// Close flushes any buffered writes and closes the shard.
 func (s *Shard) Close() error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
 	return s.b.Close()
 }
 
-// Index indexes a slice of Documents into the shard.
+// Flush persists writes buffered under DurabilityInterval.
+func (s *Shard) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+// flush persists the pending batch. s.mu must be held.
+func (s *Shard) flush() error {
+	s.lastSync = time.Now()
+	if s.pending == nil || s.pending.Size() == 0 {
+		return nil
+	}
+	batch := s.pending
+	s.pending = nil
+	return s.b.Batch(batch)
+}
+
+// indexPending adds documents to the pending batch, persisting it once it
+// holds maxBatchSize documents or the sync interval has passed.
+func (s *Shard) indexPending(documents []Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending == nil {
+		s.pending = s.b.NewBatch()
+	}
+	for _, d := range documents {
+		if err := s.pending.Index(string(d.ID()), s.routeLanguage(d.Data())); err != nil {
+			return err
+		}
+		if s.maxBatchSize > 0 && s.pending.Size() >= s.maxBatchSize {
+			if err := s.flush(); err != nil {
+				return err
+			}
+			s.pending = s.b.NewBatch()
+		}
+	}
+	if time.Since(s.lastSync) >= s.syncInterval {
+		return s.flush()
+	}
+	return nil
+}
+
+// SetMaxBatchSize sets the maximum number of documents written to the shard in a
+// single bleve batch. Zero means no limit.
+func (s *Shard) SetMaxBatchSize(n int) {
+	s.maxBatchSize = n
+}
+
+// Index indexes a slice of Documents into the shard, using one bleve batch for
+// every maxBatchSize documents. Under DurabilityInterval the documents are
+// buffered until the next flush.
 func (s *Shard) Index(documents []Document) error {
+	if s.durability == DurabilityInterval {
+		return s.indexPending(documents)
+	}
+	for len(documents) > 0 {
+		n := len(documents)
+		if s.maxBatchSize > 0 && n > s.maxBatchSize {
+			n = s.maxBatchSize
+		}
+		if err := s.indexBatch(documents[:n]); err != nil {
+			return err
+		}
+		documents = documents[n:]
+	}
+	return nil
+}
+
+// indexBatch indexes a slice of Documents into the shard in a single bleve batch.
+func (s *Shard) indexBatch(documents []Document) error {
 	batch := s.b.NewBatch()
 
 	for _, d := range documents {
-		if err := batch.Index(string(d.ID()), d.Data()); err != nil {
+		if err := batch.Index(string(d.ID()), s.routeLanguage(d.Data())); err != nil {
 			return err // XXX return errors en-masse
 		}
 		//batch.SetInternal([]byte(d.ID()), d.Source())
@@ -416,6 +865,21 @@ func (s *Shard) Index(documents []Document) error {
 	return s.b.Batch(batch)
 }
 
+// Delete removes the documents with the given IDs from the shard, after
+// persisting any buffered writes.
+func (s *Shard) Delete(ids []DocID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flush(); err != nil {
+		return err
+	}
+	batch := s.b.NewBatch()
+	for _, id := range ids {
+		batch.Delete(string(id))
+	}
+	return s.b.Batch(batch)
+}
+
 // Total returns the number of events in the shard.
 func (s *Shard) Total() (uint64, error) {
 	return s.b.DocCount()
@@ -492,7 +956,10 @@ func getMaxShardID(path string) int {
 	return maxID
 }
 
-func buildIndexMapping() (*mapping.IndexMappingImpl, error) {
+// buildIndexMapping returns the built-in mapping, lower-casing the values of
+// the keyword fields of fold, and storing the message of each event if
+// storeMessage.
+func buildIndexMapping(fold CaseFold, storeMessage bool) (*mapping.IndexMappingImpl, error) {
 	var err error
 
 	// Create the index mapping, configure the analyzer, and set as default.
@@ -518,10 +985,21 @@ func buildIndexMapping() (*mapping.IndexMappingImpl, error) {
 	}
 	indexMapping.DefaultAnalyzer = "ekanite"
 
+	err = indexMapping.AddCustomAnalyzer("ekanite_keyword_lower",
+		map[string]interface{}{
+			"type":          custom.Name,
+			"char_filters":  []interface{}{},
+			"tokenizer":     single.Name,
+			"token_filters": []interface{}{`to_lower`},
+		})
+	if err != nil {
+		return nil, err
+	}
+
 	// Create field-specific mappings.
 
 	messageIndexed := bleve.NewTextFieldMapping()
-	messageIndexed.Store = true
+	messageIndexed.Store = storeMessage
 	messageIndexed.IncludeInAll = true // XXX Move to false when using AST
 	messageIndexed.IncludeTermVectors = false
 
@@ -536,13 +1014,13 @@ func buildIndexMapping() (*mapping.IndexMappingImpl, error) {
 	timestampIndexed.IncludeTermVectors = false
 
 	addressIndexed := bleve.NewTextFieldMapping()
-	addressIndexed.Analyzer = keyword.Name
+	addressIndexed.Analyzer = keywordAnalyzer(fold, "address")
 	addressIndexed.Store = true
 	addressIndexed.IncludeInAll = true // XXX Move to false when using AST
 	addressIndexed.IncludeTermVectors = false
 
 	sourceIndexed := bleve.NewTextFieldMapping()
-	sourceIndexed.Analyzer = keyword.Name
+	sourceIndexed.Analyzer = keywordAnalyzer(fold, "source")
 	sourceIndexed.Store = true
 	sourceIndexed.IncludeInAll = true // XXX Move to false when using AST
 	sourceIndexed.IncludeTermVectors = false
@@ -561,6 +1039,16 @@ func buildIndexMapping() (*mapping.IndexMappingImpl, error) {
 	articleMapping.AddFieldMappingsAt("reception", receptionIndexed)
 	articleMapping.AddFieldMappingsAt("facility", facilityIndexed)
 	articleMapping.AddFieldMappingsAt("severity", severityIndexed)
+	articleMapping.AddFieldMappingsAt(cjkMessageField, newCJKMessageMapping())
+
+	for _, name := range keywordFields {
+		keywordIndexed := bleve.NewTextFieldMapping()
+		keywordIndexed.Analyzer = keywordAnalyzer(fold, name)
+		keywordIndexed.Store = true
+		keywordIndexed.IncludeInAll = true // XXX Move to false when using AST
+		keywordIndexed.IncludeTermVectors = false
+		articleMapping.AddFieldMappingsAt(name, keywordIndexed)
+	}
 
 	// Tell the index about field mappings.
 	indexMapping.DefaultMapping = articleMapping
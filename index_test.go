@@ -1,10 +1,14 @@
 package ekanite
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"sort"
 	"testing"
 	"time"
+
+	"github.com/blevesearch/bleve"
 )
 
 type testDoc struct {
@@ -139,7 +143,7 @@ func TestIndex_OpenIndex(t *testing.T) {
 	}
 	n.Close() // Close it, or it can't be opened.
 
-	i, err := OpenIndex(path + "/20060104_0004")
+	i, err := OpenIndex(path+"/20060104_0004", time.Hour)
 	if err != nil {
 		t.Fatalf("failed to open index for Open() test at %s %s", path, err)
 	}
@@ -162,6 +166,36 @@ func TestIndex_OpenIndex(t *testing.T) {
 	}
 }
 
+func TestIndex_OpenIndexCorruptEndTime(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+
+	startTime := parseTime("2006-01-04T00:04:00Z").UTC()
+	n, err := NewIndex(path, startTime, startTime.Add(time.Hour), 1)
+	if err != nil {
+		t.Fatalf("failed to create new index at %s %s", path, err)
+	}
+	n.Close()
+
+	indexPath := path + "/20060104_0004"
+	if err := ioutil.WriteFile(indexPath+"/endtime", []byte("2006010"), 0644); err != nil {
+		t.Fatalf("failed to corrupt end time file: %s", err)
+	}
+	if _, err := OpenIndex(indexPath, 0); err == nil {
+		t.Fatal("opened index with corrupt end time and no duration")
+	}
+
+	i, err := OpenIndex(indexPath, 2*time.Hour)
+	if err != nil {
+		t.Fatalf("failed to open index with corrupt end time: %s", err)
+	}
+	defer i.Close()
+	if exp := startTime.Add(2 * time.Hour); i.EndTime() != exp {
+		t.Fatalf("recovered end time is wrong, expected %s, got %s", exp, i.EndTime())
+	}
+	containsOrFail(t, indexPath+"/endtime", "20060104_0204")
+}
+
 func TestIndex_DeleteIndex(t *testing.T) {
 	path := tempPath()
 	defer os.RemoveAll(path)
@@ -345,7 +379,7 @@ func TestIndex_IndexSimpleSearch(t *testing.T) {
 
 	// Close, re-open the index, and try all searches again.
 	i.Close()
-	i, err := OpenIndex(i.Path())
+	i, err := OpenIndex(i.Path(), time.Hour)
 	if err != nil {
 		t.Fatalf("failed to re-open index at %s: %s", i.Path(), err.Error())
 	}
@@ -446,3 +480,218 @@ func TestShard_Index(t *testing.T) {
 	*/
 	s.Close()
 }
+
+func TestShard_IndexMaxBatchSize(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+
+	s := NewShard(path)
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open shard at %s: %s", path, err.Error())
+	}
+	defer s.Close()
+	s.SetMaxBatchSize(2)
+
+	var docs []Document
+	for n := 0; n < 5; n++ {
+		docs = append(docs, testDoc{id: DocID(fmt.Sprintf("%032x", n+1)), line: "this is a log line"})
+	}
+	if err := s.Index(docs); err != nil {
+		t.Fatalf("failed to index documents: %s", err.Error())
+	}
+	if c, _ := s.Total(); c != 5 {
+		t.Fatalf("shard doc count incorrect, got %d, expected 5", c)
+	}
+}
+
+func TestShard_DurabilityInterval(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+
+	s := newShard(path, indexOptions{durability: DurabilityInterval, syncInterval: time.Hour})
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open shard at %s: %s", path, err.Error())
+	}
+	defer s.Close()
+
+	for n := 0; n < 3; n++ {
+		d := testDoc{id: DocID(fmt.Sprintf("%032x", n+1)), line: "this is a log line"}
+		if err := s.Index([]Document{d}); err != nil {
+			t.Fatalf("failed to index document: %s", err.Error())
+		}
+	}
+	// The first write is persisted at once, later ones wait for the interval.
+	if c, _ := s.Total(); c != 1 {
+		t.Fatalf("shard doc count before flush incorrect, got %d, expected 1", c)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("failed to flush shard: %s", err.Error())
+	}
+	if c, _ := s.Total(); c != 3 {
+		t.Fatalf("shard doc count after flush incorrect, got %d, expected 3", c)
+	}
+}
+
+func TestParseDurability(t *testing.T) {
+	tests := []struct {
+		s        string
+		d        Durability
+		interval time.Duration
+		err      bool
+	}{
+		{s: "", d: DurabilityBatch},
+		{s: "batch", d: DurabilityBatch},
+		{s: "OS", d: DurabilityOS},
+		{s: "5s", d: DurabilityInterval, interval: 5 * time.Second},
+		{s: "-5s", err: true},
+		{s: "sometimes", err: true},
+	}
+	for _, tt := range tests {
+		d, interval, err := ParseDurability(tt.s)
+		if (err != nil) != tt.err {
+			t.Fatalf("ParseDurability(%q) error %v, expected error: %v", tt.s, err, tt.err)
+		}
+		if !tt.err && (d != tt.d || interval != tt.interval) {
+			t.Fatalf("ParseDurability(%q) got %d/%s, expected %d/%s", tt.s, d, interval, tt.d, tt.interval)
+		}
+	}
+}
+
+func TestShard_KeywordFields(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+
+	s := NewShard(path)
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open shard at %s: %s", path, err.Error())
+	}
+	defer s.Close()
+
+	d := mapDoc{id: DocID("00000000000000000000000000001234"), fields: map[string]interface{}{
+		"host":    "web-01.example.com",
+		"app":     "sshd",
+		"message": "Accepted password for root from web-01",
+	}}
+	if err := s.Index([]Document{d}); err != nil {
+		t.Fatalf("failed to index document: %s", err.Error())
+	}
+
+	for _, tt := range []struct {
+		field string
+		term  string
+		total uint64
+	}{
+		{field: "host", term: "web-01.example.com", total: 1},
+		{field: "host", term: "web", total: 0},
+		{field: "app", term: "sshd", total: 1},
+		{field: "message", term: "accepted", total: 1},
+	} {
+		q := bleve.NewTermQuery(tt.term)
+		q.SetField(tt.field)
+		result, err := s.b.Search(bleve.NewSearchRequest(q))
+		if err != nil {
+			t.Fatalf("failed to search %s:%s: %s", tt.field, tt.term, err.Error())
+		}
+		if result.Total != tt.total {
+			t.Errorf("search %s:%s, got %d hits, expected %d", tt.field, tt.term, result.Total, tt.total)
+		}
+	}
+}
+
+func TestShard_CaseFoldFields(t *testing.T) {
+	fold := ParseCaseFold("app")
+	m, err := buildIndexMapping(fold, true)
+	if err != nil {
+		t.Fatalf("failed to get index mapping: %s", err.Error())
+	}
+
+	path := tempPath()
+	defer os.RemoveAll(path)
+
+	s := newShard(path, indexOptions{mapping: m, caseFold: fold})
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open shard at %s: %s", path, err.Error())
+	}
+	defer s.Close()
+
+	d := mapDoc{id: DocID("00000000000000000000000000001234"), fields: map[string]interface{}{
+		"host": "WEB-01",
+		"app":  "SSHD",
+	}}
+	if err := s.Index([]Document{d}); err != nil {
+		t.Fatalf("failed to index document: %s", err.Error())
+	}
+
+	for _, tt := range []struct {
+		field string
+		term  string
+		total uint64
+	}{
+		{field: "app", term: "sshd", total: 1},
+		{field: "app", term: "SSHD", total: 1},
+		{field: "host", term: "WEB-01", total: 1},
+		{field: "host", term: "web-01", total: 0},
+	} {
+		q := bleve.NewTermQuery(fold.Fold(tt.field, tt.term))
+		q.SetField(tt.field)
+		result, err := s.b.Search(bleve.NewSearchRequest(q))
+		if err != nil {
+			t.Fatalf("failed to search %s:%s: %s", tt.field, tt.term, err.Error())
+		}
+		if result.Total != tt.total {
+			t.Errorf("search %s:%s, got %d hits, expected %d", tt.field, tt.term, result.Total, tt.total)
+		}
+	}
+}
+
+func TestShard_MessageLanguageDetection(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+
+	s := newShard(path, indexOptions{detectLanguage: true})
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open shard at %s: %s", path, err.Error())
+	}
+	defer s.Close()
+
+	d1 := mapDoc{id: DocID("00000000000000000000000000001234"), fields: map[string]interface{}{
+		"message": "用户登录失败",
+	}}
+	d2 := mapDoc{id: DocID("00000000000000000000000000005678"), fields: map[string]interface{}{
+		"message": "user login failed",
+	}}
+	if err := s.Index([]Document{d1, d2}); err != nil {
+		t.Fatalf("failed to index documents: %s", err.Error())
+	}
+	if _, ok := d1.fields[cjkMessageField]; ok {
+		t.Fatal("CJK copy of the message added to the fields of the document")
+	}
+
+	for _, tt := range []struct {
+		field string
+		term  string
+		total uint64
+	}{
+		{field: cjkMessageField, term: "登录", total: 1},
+		{field: "message", term: "login", total: 1},
+	} {
+		q := bleve.NewTermQuery(tt.term)
+		q.SetField(tt.field)
+		result, err := s.b.Search(bleve.NewSearchRequest(q))
+		if err != nil {
+			t.Fatalf("failed to search %s:%s: %s", tt.field, tt.term, err.Error())
+		}
+		if result.Total != tt.total {
+			t.Errorf("search %s:%s, got %d hits, expected %d", tt.field, tt.term, result.Total, tt.total)
+		}
+	}
+}
+
+type mapDoc struct {
+	id     DocID
+	fields map[string]interface{}
+}
+
+func (m mapDoc) ID() DocID                { return m.id }
+func (m mapDoc) Data() interface{}        { return m.fields }
+func (m mapDoc) ReferenceTime() time.Time { return time.Time{} }
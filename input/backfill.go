@@ -0,0 +1,162 @@
+package input
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxBackfillErrors is the number of rejected lines a backfill reports.
+const maxBackfillErrors = 100
+
+// errNoTimestamp rejects backfilled lines, which would be indexed as of the
+// time they are read at.
+var errNoTimestamp = errors.New("no timestamp found")
+
+// BackfillResult reports what a backfill read and sent to be indexed.
+type BackfillResult struct {
+	Files    int       `json:"files"`            // Log files read.
+	Lines    int64     `json:"lines"`            // Non-empty lines read.
+	Events   int64     `json:"events"`           // Events sent to be indexed.
+	Rejected int64     `json:"rejected"`         // Lines which failed to parse, and were skipped.
+	Oldest   time.Time `json:"oldest"`           // Reference time of the oldest event.
+	Newest   time.Time `json:"newest"`           // Reference time of the newest event.
+	Errors   []string  `json:"errors,omitempty"` // Why the first lines were rejected, by file and line.
+}
+
+// Backfill parses historical log files, such as those of a flat file store
+// being migrated, into events indexed by their own timestamp rather than the
+// time they are read at. Lines without a timestamp, or timestamped later than
+// they are read, are rejected rather than indexed as received now.
+type Backfill struct {
+	Pipeline *Pipeline // Coerces and enriches the fields of the events.
+
+	parser *LogParser
+	send   func(*Event) error
+	result BackfillResult
+}
+
+// NewBackfill returns a Backfill parsing lines in format and passing their
+// events to send, which stops the backfill by returning an error.
+func NewBackfill(format string, send func(*Event) error) (*Backfill, error) {
+	p, err := NewLogParser(format)
+	if err != nil {
+		return nil, err
+	}
+	return &Backfill{parser: p, send: send}, nil
+}
+
+// Result returns what the backfill read and sent so far.
+func (b *Backfill) Result() *BackfillResult {
+	return &b.result
+}
+
+// ReadArchive reads the log files of r named name, which is either a log
+// file, a tar archive of log files, or either of those gzipped. Archives may
+// hold gzipped files and archives in turn.
+func (b *Backfill) ReadArchive(name string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		defer gz.Close()
+		return b.ReadArchive(strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".tgz"), gz)
+	}
+	if header, _ := br.Peek(262); len(header) == 262 && string(header[257:262]) == "ustar" {
+		tr := tar.NewReader(br)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+				continue
+			}
+			if err := b.ReadArchive(path.Join(name, hdr.Name), tr); err != nil {
+				return err
+			}
+		}
+	}
+	return b.readLines(name, br)
+}
+
+// readLines sends the events of the lines of the log file name.
+func (b *Backfill) readLines(name string, r *bufio.Reader) error {
+	b.result.Files++
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if line = bytes.TrimRight(line, "\r\n"); len(line) > 0 {
+			if sendErr := b.sendLine(name, n, line); sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// sendLine sends the event of line n of the log file name, unless it fails to
+// parse or has no timestamp, its time then being unknown.
+func (b *Backfill) sendLine(name string, n int, line []byte) error {
+	b.result.Lines++
+	stats.Add("backfillEventsRx", 1)
+	start := time.Now()
+	parsed, err := b.parser.Parse("", line)
+	if err == nil {
+		// The parsers stamp lines they find no timestamp in with the time
+		// they parse them at.
+		if ts, ok := parsed["timestamp"].(time.Time); !ok || !ts.Before(start) {
+			err = errNoTimestamp
+		}
+	}
+	if err != nil {
+		stats.Add("backfillEventsRejected", 1)
+		b.result.Rejected++
+		if len(b.result.Errors) < maxBackfillErrors {
+			b.result.Errors = append(b.result.Errors, fmt.Sprintf("%s:%d: %s", name, n, err))
+		}
+		return nil
+	}
+	sequence, err := b.Pipeline.NextSequence()
+	if err != nil {
+		return err
+	}
+	b.Pipeline.Coerce(parsed)
+	e := NewEvent()
+	e.Text = string(line)
+	e.Parsed = parsed
+	e.ReceptionTime = time.Now().UTC()
+	e.Sequence = sequence
+	e.Parsed["reception"] = e.ReceptionTime
+	e.Parsed["message"] = e.Text
+	b.Pipeline.Enrich(e.Parsed)
+
+	t := e.ReferenceTime()
+	if err := b.send(e); err != nil {
+		return err
+	}
+	b.result.Events++
+	if b.result.Oldest.IsZero() || t.Before(b.result.Oldest) {
+		b.result.Oldest = t
+	}
+	if t.After(b.result.Newest) {
+		b.result.Newest = t
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package input
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+	"time"
+)
+
+func gzipped(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(b); err != nil {
+		t.Fatalf("failed to gzip: %s", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to gzip: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBackfill_ReadArchive(t *testing.T) {
+	files := map[string][]byte{
+		"app.log": []byte("<134>1 2003-10-11T22:14:15.003Z host1 app - - - first\r\n" +
+			"not syslog\n\n" +
+			"<134>1 2003-10-12T22:14:15.003Z host1 app - - - second"),
+		"old/app.log.gz": gzipped(t, []byte("<134>1 2003-10-10T22:14:15.003Z host2 app - - - rotated\n")),
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "old/", Typeflag: tar.TypeDir, Mode: 0755})
+	for _, name := range []string{"app.log", "old/app.log.gz"} {
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(files[name]))})
+		tw.Write(files[name])
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to write archive: %s", err)
+	}
+
+	var events []*Event
+	b, err := NewBackfill("rfc5424", func(e *Event) error {
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create backfill: %s", err)
+	}
+	if err := b.ReadArchive("logs.tar.gz", bytes.NewReader(gzipped(t, buf.Bytes()))); err != nil {
+		t.Fatalf("failed to read archive: %s", err)
+	}
+
+	r := b.Result()
+	if r.Files != 2 || r.Lines != 4 || r.Events != 3 || r.Rejected != 1 {
+		t.Fatalf("backfill read %+v, expected 4 lines of 2 files, 1 rejected", r)
+	}
+	if len(r.Errors) != 1 || r.Errors[0] != "logs.tar/app.log:2: no timestamp found" {
+		t.Errorf("backfill errors are %q", r.Errors)
+	}
+	if !r.Oldest.Equal(time.Date(2003, 10, 10, 22, 14, 15, 3e6, time.UTC)) ||
+		!r.Newest.Equal(time.Date(2003, 10, 12, 22, 14, 15, 3e6, time.UTC)) {
+		t.Errorf("backfill spans %s to %s", r.Oldest, r.Newest)
+	}
+	if events[0].Parsed["message"] != "<134>1 2003-10-11T22:14:15.003Z host1 app - - - first" {
+		t.Errorf("event has message %q", events[0].Parsed["message"])
+	}
+
+	stop := errors.New("stop")
+	b, _ = NewBackfill("rfc5424", func(*Event) error { return stop })
+	if err := b.ReadArchive("app.log", bytes.NewReader(files["app.log"])); err != stop {
+		t.Errorf("backfill stopped by %v, expected its sender's error", err)
+	}
+}
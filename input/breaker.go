@@ -0,0 +1,30 @@
+package input
+
+import "sync"
+
+// Breaker refuses the events of the collectors it is set on while it holds an
+// error, such as when the data path runs out of space. TCP and forward
+// connections are closed and refused, so that senders keep their events
+// queued; UDP datagrams are dropped. A nil Breaker never refuses events.
+type Breaker struct {
+	mu  sync.RWMutex
+	err error
+}
+
+// SetError makes the breaker refuse events with err, until it is set back to
+// nil.
+func (b *Breaker) SetError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.err = err
+}
+
+// Err returns why events are refused, nil if they are accepted.
+func (b *Breaker) Err() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.err
+}
@@ -0,0 +1,170 @@
+package input
+
+import (
+	"expvar"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ekanite/ekanite"
+)
+
+// FieldType is the type a field is coerced to at ingest.
+type FieldType string
+
+// Field types accepted by ParseFieldTypes.
+const (
+	TypeInt    FieldType = "int"
+	TypeFloat  FieldType = "float"
+	TypeString FieldType = "string"
+	TypeTime   FieldType = "time"
+)
+
+// defaultFieldTypes are the fields coerced at ingest unless configured
+// otherwise.
+var defaultFieldTypes = map[string]FieldType{
+	"priority": TypeInt,
+	"facility": TypeInt,
+	"severity": TypeInt,
+}
+
+var coerceErrors = new(expvar.Map).Init()
+
+func init() {
+	stats.Set("fieldCoerceErrors", coerceErrors)
+}
+
+// ParseFieldTypes parses a comma-separated list of field:type pairs, such as
+// "severity:int,bytes:float".
+func ParseFieldTypes(s string) (map[string]FieldType, error) {
+	types := map[string]FieldType{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		ss := strings.SplitN(pair, ":", 2)
+		if len(ss) != 2 {
+			return nil, fmt.Errorf("field type '%s' is not in the form field:type", pair)
+		}
+		field, typ := strings.TrimSpace(ss[0]), FieldType(strings.TrimSpace(ss[1]))
+		switch typ {
+		case TypeInt, TypeFloat, TypeString, TypeTime:
+		default:
+			return nil, fmt.Errorf("field '%s' has unknown type '%s'", field, typ)
+		}
+		types[field] = typ
+	}
+	return types, nil
+}
+
+// Coerce converts the fields of a parsed event to their configured types.
+// Values which cannot be converted are left unchanged and counted in the
+// fieldCoerceErrors stat of the field.
+func (p *Pipeline) Coerce(fields map[string]interface{}) {
+	for field := range p.coerceFields(fields) {
+		coerceErrors.Add(field, 1)
+	}
+}
+
+// coerceFields converts the fields of a parsed event to their configured
+// types, returning the errors of those which cannot be, by field.
+func (p *Pipeline) coerceFields(fields map[string]interface{}) map[string]error {
+	var errs map[string]error
+	for field, typ := range p.fieldTypes() {
+		v, ok := fields[field]
+		if !ok || v == nil {
+			continue
+		}
+		cv, err := coerce(v, typ)
+		if err != nil {
+			if errs == nil {
+				errs = map[string]error{}
+			}
+			errs[field] = err
+			continue
+		}
+		fields[field] = cv
+	}
+	return errs
+}
+
+// coerce converts v to typ.
+func coerce(v interface{}, typ FieldType) (interface{}, error) {
+	switch typ {
+	case TypeInt:
+		f, err := toFloat(v)
+		if err != nil {
+			return nil, err
+		}
+		if f != math.Trunc(f) {
+			return nil, fmt.Errorf("%v is not an integer", v)
+		}
+		return int(f), nil
+	case TypeFloat:
+		return toFloat(v)
+	case TypeString:
+		switch value := v.(type) {
+		case string:
+			return value, nil
+		case time.Time:
+			return value.Format(time.RFC3339Nano), nil
+		default:
+			return fmt.Sprint(v), nil
+		}
+	case TypeTime:
+		switch value := v.(type) {
+		case time.Time:
+			return value, nil
+		case string:
+			if t := ekanite.ParseTime(value); !t.IsZero() {
+				return t, nil
+			}
+			return nil, fmt.Errorf("'%s' is not a time", value)
+		default:
+			f, err := toFloat(v)
+			if err != nil {
+				return nil, err
+			}
+			if t := ekanite.ParseTime(strconv.FormatInt(int64(f), 10)); !t.IsZero() {
+				return t, nil
+			}
+			return nil, fmt.Errorf("%v is not a time", v)
+		}
+	}
+	return nil, fmt.Errorf("unknown type '%s'", typ)
+}
+
+// toFloat converts a number, or a string holding one, to float64.
+func toFloat(v interface{}) (float64, error) {
+	switch value := v.(type) {
+	case int:
+		return float64(value), nil
+	case int8:
+		return float64(value), nil
+	case int16:
+		return float64(value), nil
+	case int32:
+		return float64(value), nil
+	case int64:
+		return float64(value), nil
+	case uint:
+		return float64(value), nil
+	case uint8:
+		return float64(value), nil
+	case uint16:
+		return float64(value), nil
+	case uint32:
+		return float64(value), nil
+	case uint64:
+		return float64(value), nil
+	case float32:
+		return float64(value), nil
+	case float64:
+		return value, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(value), 64)
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
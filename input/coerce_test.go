@@ -0,0 +1,83 @@
+package input
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoerce(t *testing.T) {
+	types, err := ParseFieldTypes("bytes:float, code:string,at:time")
+	if err != nil {
+		t.Fatalf("failed to parse field types: %s", err.Error())
+	}
+	p := NewPipeline(PipelineSettings{FieldTypes: types})
+
+	fields := map[string]interface{}{
+		"severity": "3",
+		"facility": float64(4),
+		"priority": "high",
+		"bytes":    "1024",
+		"code":     404,
+		"at":       float64(1136214245),
+	}
+	p.Coerce(fields)
+
+	if v, ok := fields["severity"].(int); !ok || v != 3 {
+		t.Errorf("severity coerced to %#v, expected 3", fields["severity"])
+	}
+	if v, ok := fields["facility"].(int); !ok || v != 4 {
+		t.Errorf("facility coerced to %#v, expected 4", fields["facility"])
+	}
+	if v := fields["priority"]; v != "high" {
+		t.Errorf("uncoercible priority changed to %#v", v)
+	}
+	if n := coerceErrors.Get("priority"); n == nil || n.String() != "1" {
+		t.Errorf("priority coercion failure not counted, got %v", n)
+	}
+	if v, ok := fields["bytes"].(float64); !ok || v != 1024 {
+		t.Errorf("bytes coerced to %#v, expected 1024.0", fields["bytes"])
+	}
+	if v := fields["code"]; v != "404" {
+		t.Errorf("code coerced to %#v, expected \"404\"", v)
+	}
+	if v, ok := fields["at"].(time.Time); !ok || !v.Equal(time.Unix(1136214245, 0)) {
+		t.Errorf("at coerced to %#v, expected time", fields["at"])
+	}
+}
+
+func TestParseFieldTypes_Invalid(t *testing.T) {
+	for _, s := range []string{"bytes", "bytes:long"} {
+		if _, err := ParseFieldTypes(s); err == nil {
+			t.Errorf("ParseFieldTypes(%q) did not fail", s)
+		}
+	}
+}
+
+func TestPipeline_ReconfigureFieldTypes(t *testing.T) {
+	p := NewPipeline(PipelineSettings{FieldTypes: map[string]FieldType{"bytes": TypeFloat, "severity": TypeString}})
+	p.Reconfigure(PipelineSettings{FieldTypes: map[string]FieldType{"code": TypeString}})
+
+	fields := map[string]interface{}{"bytes": "1024", "severity": "3", "code": 404}
+	p.Coerce(fields)
+	if v := fields["bytes"]; v != "1024" {
+		t.Errorf("bytes removed from the field types coerced to %#v", v)
+	}
+	if v, ok := fields["severity"].(int); !ok || v != 3 {
+		t.Errorf("severity coerced to %#v once its type is removed, expected 3", fields["severity"])
+	}
+	if v := fields["code"]; v != "404" {
+		t.Errorf("code coerced to %#v, expected \"404\"", v)
+	}
+}
+
+func TestPipeline_Nil(t *testing.T) {
+	var p *Pipeline
+	fields := map[string]interface{}{"severity": "3", "bytes": "1024"}
+	p.Coerce(fields)
+	if v, ok := fields["severity"].(int); !ok || v != 3 {
+		t.Errorf("severity coerced to %#v by the nil pipeline, expected 3", fields["severity"])
+	}
+	if v := fields["bytes"]; v != "1024" {
+		t.Errorf("bytes coerced to %#v by the nil pipeline", v)
+	}
+}
@@ -1,30 +1,40 @@
 package input
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"expvar"
 	"fmt"
 	"io"
 	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/ekanite/ekanite"
 )
 
-var sequenceNumber int64
 var stats = expvar.NewMap("input")
 
-func init() {
-	sequenceNumber = time.Now().UnixNano()
-}
-
 const (
 	newlineTimeout = time.Duration(1000 * time.Millisecond)
 	msgBufSize     = 256
+
+	// DefaultMaxTCPConnections is the default number of TCP connections
+	// served at once.
+	DefaultMaxTCPConnections = 1024
+
+	// DefaultTCPIdleTimeout is the default time after which a TCP connection
+	// which sent nothing is closed.
+	DefaultTCPIdleTimeout = 5 * time.Minute
+
+	maxAcceptBackoff = time.Second
+
+	// udpDrainIdle is how long a draining UDP collector waits for another
+	// packet before closing its socket.
+	udpDrainIdle = 100 * time.Millisecond
 )
 
 // Collector specifies the interface all network collectors must implement.
@@ -36,34 +46,59 @@ type Collector interface {
 // TCPCollector represents a network collector that accepts and handler TCP connections.
 type TCPCollector struct {
 	iface  string
-	format string
+	parser *LogParser
+
+	MaxConnections int           // Connections served at once, 0 for no limit. Others wait to be accepted.
+	IdleTimeout    time.Duration // Close connections idle for this long, 0 to never close them.
+	Strict         bool          // Drop messages not conforming to RFC5424, rather than parsing what can be.
+	ReusePort      bool          // Bind with SO_REUSEPORT, so that another process may take the port over.
+	Tag            string        // Set as the listener field of the events, if not empty.
+	Pipeline       *Pipeline     // Coerces and enriches the fields of the events.
+	Breaker        *Breaker      // Refuses the events while it holds an error.
 
 	addr      net.Addr
 	tlsConfig *tls.Config
+	slots     chan struct{}
+
+	ln       net.Listener
+	draining int32 // Set once Drain is called
+	wg       sync.WaitGroup
+	mu       sync.Mutex // Protects conns
+	conns    map[net.Conn]struct{}
 }
 
 // UDPCollector represents a network collector that accepts UDP packets.
 type UDPCollector struct {
-	format string
-	addr   *net.UDPAddr
+	Strict    bool      // Drop messages not conforming to RFC5424, rather than parsing what can be.
+	ReusePort bool      // Bind with SO_REUSEPORT, so that another process may take the port over.
+	Tag       string    // Set as the listener field of the events, if not empty.
+	Pipeline  *Pipeline // Coerces and enriches the fields of the events.
+	Breaker   *Breaker  // Refuses the events while it holds an error.
+
+	parser   *LogParser
+	addr     *net.UDPAddr
+	conn     *net.UDPConn
+	draining int32 // Set once Drain is called
+	done     chan struct{}
 }
 
 // NewCollector returns a network collector of the specified type, that will bind
 // to the given inteface on Start(). If config is non-nil, a secure Collector will
 // be returned. Secure Collectors require the protocol be TCP.
 func NewCollector(proto, iface, format string, tlsConfig *tls.Config) (Collector, error) {
-	// Verify that a parser can be instantiated. The actual parser that is used will
-	// be created by the connection handler.
-	_, err := NewLogParser(format)
+	// The parser keeps no per-message state, so it is shared by all connections.
+	parser, err := NewLogParser(format)
 	if err != nil {
 		return nil, err
 	}
 
 	if strings.ToLower(proto) == "tcp" {
 		return &TCPCollector{
-			iface:     iface,
-			format:    format,
-			tlsConfig: tlsConfig,
+			iface:          iface,
+			parser:         parser,
+			MaxConnections: DefaultMaxTCPConnections,
+			IdleTimeout:    DefaultTCPIdleTimeout,
+			tlsConfig:      tlsConfig,
 		}, nil
 	} else if strings.ToLower(proto) == "udp" {
 		addr, err := net.ResolveUDPAddr("udp", iface)
@@ -71,42 +106,141 @@ func NewCollector(proto, iface, format string, tlsConfig *tls.Config) (Collector
 			return nil, err
 		}
 
-		return &UDPCollector{addr: addr, format: format}, nil
+		return &UDPCollector{addr: addr, parser: parser}, nil
 	}
 	return nil, fmt.Errorf("unsupport collector protocol")
 }
 
+// listenConfig returns the config the sockets of the collectors are bound
+// with.
+func listenConfig(reuse bool) *net.ListenConfig {
+	lc := &net.ListenConfig{}
+	if reuse {
+		lc.Control = reusePort
+	}
+	return lc
+}
+
 // Start instructs the TCPCollector to bind to the interface and accept connections.
 func (s *TCPCollector) Start(c chan<- ekanite.Document) error {
-	var ln net.Listener
-	var err error
-	if s.tlsConfig == nil {
-		ln, err = net.Listen("tcp", s.iface)
-	} else {
-		ln, err = tls.Listen("tcp", s.iface, s.tlsConfig)
-	}
+	ln, err := listenConfig(s.ReusePort).Listen(context.Background(), "tcp", s.iface)
 	if err != nil {
 		return err
 	}
+	if s.tlsConfig != nil {
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
+	s.ln = ln
 	s.addr = ln.Addr()
+	s.conns = make(map[net.Conn]struct{})
+	if s.MaxConnections > 0 {
+		s.slots = make(chan struct{}, s.MaxConnections)
+	}
 
 	go func() {
+		var backoff time.Duration
 		for {
+			// Wait for a free slot, leaving further connections in the
+			// listen backlog.
+			s.acquire()
 			conn, err := ln.Accept()
 			if err != nil {
+				s.release()
+				if s.isDraining() {
+					return
+				}
+				stats.Add("tcpAcceptError", 1)
+				if backoff == 0 {
+					backoff = 5 * time.Millisecond
+				} else {
+					backoff *= 2
+				}
+				if backoff > maxAcceptBackoff {
+					backoff = maxAcceptBackoff
+				}
+				time.Sleep(backoff)
 				continue
 			}
-			go s.handleConnection(conn, c)
+			backoff = 0
+			if s.Breaker.Err() != nil {
+				stats.Add("tcpConnRefused", 1)
+				conn.Close()
+				s.release()
+				continue
+			}
+			s.track(conn, true)
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer s.release()
+				defer s.track(conn, false)
+				s.handleConnection(conn, c)
+			}()
 		}
 	}()
 	return nil
 }
 
+// acquire takes a connection slot, waiting for one if all are in use.
+func (s *TCPCollector) acquire() {
+	if s.slots != nil {
+		s.slots <- struct{}{}
+	}
+}
+
+// release frees a connection slot taken by acquire.
+func (s *TCPCollector) release() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
 // Addr returns the net.Addr that the Collector is bound to, in a race-say manner.
 func (s *TCPCollector) Addr() net.Addr {
 	return s.addr
 }
 
+// track adds the connection to those open, or removes it.
+func (s *TCPCollector) track(conn net.Conn, open bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if open {
+		s.conns[conn] = struct{}{}
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+// isDraining returns whether Drain was called.
+func (s *TCPCollector) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) != 0
+}
+
+// Drain stops accepting connections, and closes those open once they are
+// quiet, or else after timeout. Senders reconnecting then reach the process
+// which bound the port since, with ReusePort.
+func (s *TCPCollector) Drain(timeout time.Duration) error {
+	atomic.StoreInt32(&s.draining, 1)
+	err := s.ln.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		<-done
+	}
+	return err
+}
+
 func (s *TCPCollector) handleConnection(conn net.Conn, c chan<- ekanite.Document) {
 	stats.Add("tcpConnections", 1)
 	defer func() {
@@ -114,76 +248,142 @@ func (s *TCPCollector) handleConnection(conn net.Conn, c chan<- ekanite.Document
 		conn.Close()
 	}()
 
-	parser, err := NewLogParser(s.format)
-	if err != nil {
-		panic(fmt.Sprintf("failed to create TCP connection parser:%s", err.Error()))
-	}
-
 	delimiter := NewSyslogDelimiter(msgBufSize)
-	reader := bufio.NewReader(conn)
-	var log string
-	var match bool
+	reader := getReader(conn)
+	defer putReader(reader)
 	var address = conn.RemoteAddr().String()
 	if addr, _, err := net.SplitHostPort(address); err == nil {
 		address = addr
 	}
 
+	lastRead := time.Now()
 	for {
+		if s.Breaker.Err() != nil {
+			// Closing the connection leaves the sender to queue the
+			// events until they are accepted again.
+			stats.Add("tcpConnRefused", 1)
+			return
+		}
+		idle := false
 		conn.SetReadDeadline(time.Now().Add(newlineTimeout))
-		b, err := reader.ReadByte()
+
+		// Read whatever is available at once, a read returning no byte
+		// only being retried.
+		_, err := reader.Peek(1)
+		var lines []string
+		if n := reader.Buffered(); n > 0 {
+			p, _ := reader.Peek(n)
+			stats.Add("tcpBytesRead", int64(n))
+			lastRead = time.Now()
+			lines = delimiter.Feed(p)
+			reader.Discard(n)
+		}
+
+		var closed, drained bool
 		if err != nil {
 			stats.Add("tcpConnReadError", 1)
+			var log string
+			var match bool
 			if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
 				stats.Add("tcpConnReadTimeout", 1)
+				idle = s.IdleTimeout > 0 && time.Since(lastRead) >= s.IdleTimeout
+				drained = s.isDraining()
+				if idle || drained {
+					log, match = delimiter.Vestige()
+				} else {
+					// The sender may be in the middle of a line.
+					log, match = delimiter.Flush()
+				}
 			} else if err == io.EOF {
 				stats.Add("tcpConnReadEOF", 1)
+				log, match = delimiter.Vestige()
+				closed = true
+			} else if err == io.ErrNoProgress {
+				stats.Add("tcpConnReadEmpty", 1)
 			} else {
+				// The connection was reset, its last line is only kept
+				// if received whole.
 				stats.Add("tcpConnUnrecoverError", 1)
-				return
-			}
-
-			log, match = delimiter.Vestige()
-		} else {
-			stats.Add("tcpBytesRead", 1)
-			log, match = delimiter.Push(b)
-		}
-
-		// Log line available?
-		if match {
-			stats.Add("tcpEventsRx", 1)
-
-			parser.Parse(address, bytes.NewBufferString(log).Bytes())
-			e := &Event{
-				Text:          log,
-				Parsed:        parser.Result,
-				ReceptionTime: time.Now().UTC(),
-				Sequence:      atomic.AddInt64(&sequenceNumber, 1),
-				SourceIP:      address,
+				log, match = delimiter.Flush()
+				closed = true
 			}
-
-			if _, ok := e.Parsed["timestamp"]; !ok {
-				e.Parsed["timestamp"] = time.Now()
+			if match {
+				lines = append(lines, log)
 			}
-			e.Parsed["address"] = address
-			e.Parsed["reception"] = e.ReceptionTime
-			e.Parsed["message"] = e.Text
+		}
 
-			c <- e
+		for _, log := range lines {
+			s.handleLine(address, log, c)
 		}
 
-		// Was the connection closed?
-		if err == io.EOF {
+		if closed {
+			return
+		}
+		if idle {
+			stats.Add("tcpConnIdleClosed", 1)
 			return
 		}
+		if drained {
+			stats.Add("tcpConnDrained", 1)
+			return
+		}
+	}
+}
+
+// handleLine sends the event of a syslog line received from address to c.
+func (s *TCPCollector) handleLine(address, log string, c chan<- ekanite.Document) {
+	// Drop non-conforming lines when strict.
+	if s.Strict && rejectRFC5424([]byte(log)) {
+		stats.Add("tcpEventsRejected", 1)
+		return
+	}
+
+	stats.Add("tcpEventsRx", 1)
+	parsed, err := s.parser.Parse(address, []byte(log))
+	if err != nil {
+		stats.Add("tcpEventsParseError", 1)
+	}
+	s.Pipeline.countSender(address, len(log), err)
+	drift := s.Pipeline.countQuality(address, parsed, err)
+	sequence, err := s.Pipeline.NextSequence()
+	if err != nil {
+		stats.Add("tcpEventsDropped", 1)
+		return
+	}
+	s.Pipeline.Coerce(parsed)
+	e := NewEvent()
+	e.Text = log
+	e.Parsed = parsed
+	e.ReceptionTime = time.Now().UTC()
+	e.Sequence = sequence
+	e.SourceIP = address
+
+	if _, ok := e.Parsed["timestamp"]; !ok {
+		e.Parsed["timestamp"] = time.Now()
+	}
+	e.Parsed["address"] = address
+	e.Parsed["reception"] = e.ReceptionTime
+	e.Parsed["message"] = e.Text
+	if s.Tag != "" {
+		e.Parsed[ListenerField] = s.Tag
+	}
+	s.Pipeline.Enrich(e.Parsed)
+
+	c <- e
+	if drift != nil {
+		c <- drift
 	}
 }
 
 // Start instructs the UDPCollector to start reading packets from the interface.
 func (s *UDPCollector) Start(c chan<- ekanite.Document) error {
-	conn, err := net.ListenUDP("udp", s.addr)
+	pc, err := listenConfig(s.ReusePort).ListenPacket(context.Background(), "udp", s.addr.String())
 	if err != nil {
 		return err
 	}
+	conn := pc.(*net.UDPConn)
+	s.conn = conn
+	s.done = make(chan struct{})
 	var udpBytesRead *expvar.Int
 	if v := stats.Get("udpBytesRead"); v != nil {
 		udpBytesRead, _ = v.(*expvar.Int)
@@ -202,30 +402,52 @@ func (s *UDPCollector) Start(c chan<- ekanite.Document) error {
 		stats.Set("udpEventsRx", udpEventsRx)
 	}
 
-	parser, err := NewLogParser(s.format)
-	if err != nil {
-		panic(fmt.Sprintf("failed to create UDP parser:%s", err.Error()))
-	}
-
 	go func() {
 		buf := make([]byte, msgBufSize)
 		for {
+			draining := atomic.LoadInt32(&s.draining) != 0
+			if draining {
+				conn.SetReadDeadline(time.Now().Add(udpDrainIdle))
+			}
 			n, addr, err := conn.ReadFromUDP(buf)
 			udpBytesRead.Add(int64(n))
 			if err != nil {
+				if draining || atomic.LoadInt32(&s.draining) != 0 {
+					conn.Close()
+					close(s.done)
+					return
+				}
+				continue
+			}
+			if s.Breaker.Err() != nil {
+				stats.Add("udpEventsRefused", 1)
 				continue
 			}
 			address := addr.IP.String()
 			log := bytes.TrimSpace(buf[:n])
-			parser.Parse(address, log)
-
-			e := &Event{
-				Text:          string(log),
-				Parsed:        parser.Result,
-				ReceptionTime: time.Now().UTC(),
-				Sequence:      atomic.AddInt64(&sequenceNumber, 1),
-				SourceIP:      address,
+			if s.Strict && rejectRFC5424(log) {
+				stats.Add("udpEventsRejected", 1)
+				continue
+			}
+			parsed, err := s.parser.Parse(address, log)
+			if err != nil {
+				stats.Add("udpEventsParseError", 1)
 			}
+			s.Pipeline.countSender(address, len(log), err)
+			drift := s.Pipeline.countQuality(address, parsed, err)
+			sequence, err := s.Pipeline.NextSequence()
+			if err != nil {
+				stats.Add("udpEventsDropped", 1)
+				continue
+			}
+			s.Pipeline.Coerce(parsed)
+
+			e := NewEvent()
+			e.Text = string(log)
+			e.Parsed = parsed
+			e.ReceptionTime = time.Now().UTC()
+			e.Sequence = sequence
+			e.SourceIP = address
 
 			if _, ok := e.Parsed["timestamp"]; !ok {
 				e.Parsed["timestamp"] = time.Now()
@@ -233,9 +455,16 @@ func (s *UDPCollector) Start(c chan<- ekanite.Document) error {
 			e.Parsed["address"] = address
 			e.Parsed["reception"] = e.ReceptionTime
 			e.Parsed["message"] = e.Text
+			if s.Tag != "" {
+				e.Parsed[ListenerField] = s.Tag
+			}
+			s.Pipeline.Enrich(e.Parsed)
 
 			c <- e
 			udpEventsRx.Add(1)
+			if drift != nil {
+				c <- drift
+			}
 		}
 	}()
 	return nil
@@ -243,5 +472,23 @@ func (s *UDPCollector) Start(c chan<- ekanite.Document) error {
 
 // Addr returns the net.Addr to which the UDP collector is bound.
 func (s *UDPCollector) Addr() net.Addr {
+	if s.conn != nil {
+		return s.conn.LocalAddr()
+	}
 	return s.addr
 }
+
+// Drain reads the packets already received, then closes the socket once none
+// comes for a while, or else after timeout. Packets then only reach the
+// process which bound the port since, with ReusePort.
+func (s *UDPCollector) Drain(timeout time.Duration) error {
+	atomic.StoreInt32(&s.draining, 1)
+	s.conn.SetReadDeadline(time.Now().Add(udpDrainIdle))
+	select {
+	case <-s.done:
+	case <-time.After(timeout):
+		s.conn.Close()
+		<-s.done
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package input
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ekanite/ekanite"
+)
+
+func TestTCPCollector_Idle(t *testing.T) {
+	collector, err := NewCollector("tcp", "127.0.0.1:0", "syslog", nil)
+	if err != nil {
+		t.Fatalf("failed to create collector: %s", err.Error())
+	}
+	tcp := collector.(*TCPCollector)
+	tcp.MaxConnections = 1
+	tcp.IdleTimeout = time.Millisecond
+
+	c := make(chan ekanite.Document, 10)
+	if err := tcp.Start(c); err != nil {
+		t.Fatalf("failed to start collector: %s", err.Error())
+	}
+
+	// Both connections are reaped once idle, the second only being served
+	// after the first is closed.
+	for n := 0; n < 2; n++ {
+		conn, err := net.Dial("tcp", tcp.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect to collector: %s", err.Error())
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Fatal("idle connection received data")
+		} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Fatal("idle connection was not closed")
+		}
+	}
+}
+
+func TestTCPCollector_PartialLine(t *testing.T) {
+	collector, err := NewCollector("tcp", "127.0.0.1:0", "syslog", nil)
+	if err != nil {
+		t.Fatalf("failed to create collector: %s", err.Error())
+	}
+	tcp := collector.(*TCPCollector)
+	c := make(chan ekanite.Document, 10)
+	if err := tcp.Start(c); err != nil {
+		t.Fatalf("failed to start collector: %s", err.Error())
+	}
+
+	conn, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to collector: %s", err.Error())
+	}
+	// Pausing in the middle of a line, past the read timeout, neither
+	// splits nor duplicates it.
+	conn.Write([]byte("<12>1 2018-01-01T00:00:00Z host app - - - sshd is"))
+	time.Sleep(newlineTimeout + 200*time.Millisecond)
+	conn.Write([]byte(" down\n<13>1 2018-01-01T00:00:01Z host app - - - sshd is up\n"))
+	conn.Close()
+
+	expected := []string{
+		"<12>1 2018-01-01T00:00:00Z host app - - - sshd is down",
+		"<13>1 2018-01-01T00:00:01Z host app - - - sshd is up",
+	}
+	for _, text := range expected {
+		select {
+		case doc := <-c:
+			if e := doc.(*Event); e.Text != text {
+				t.Fatalf("got event %q, expected %q", e.Text, text)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", text)
+		}
+	}
+	select {
+	case doc := <-c:
+		t.Fatalf("got unexpected event %q", doc.(*Event).Text)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// BenchmarkTCPCollector measures the CPU the collector takes to receive a
+// second's worth of lines at 50k msgs/s over one connection.
+func BenchmarkTCPCollector(b *testing.B) {
+	collector, err := NewCollector("tcp", "127.0.0.1:0", "syslog", nil)
+	if err != nil {
+		b.Fatalf("failed to create collector: %s", err.Error())
+	}
+	tcp := collector.(*TCPCollector)
+	c := make(chan ekanite.Document, 1024)
+	if err := tcp.Start(c); err != nil {
+		b.Fatalf("failed to start collector: %s", err.Error())
+	}
+	conn, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		b.Fatalf("failed to connect to collector: %s", err.Error())
+	}
+	defer conn.Close()
+
+	stream, n := benchmarkStream()
+	b.SetBytes(int64(len(stream)))
+	b.ResetTimer()
+	// The last line of a stream is only delimited once the next one starts.
+	var received int
+	for i := 0; i < b.N; i++ {
+		written := make(chan struct{})
+		go func() {
+			conn.Write(stream)
+			close(written)
+		}()
+		for ; received < (i+1)*n-1; received++ {
+			(<-c).(*Event).Release()
+		}
+		<-written
+	}
+	b.ReportMetric(float64(n)*float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+}
+
+func TestTCPCollector_Drain(t *testing.T) {
+	collector, err := NewCollector("tcp", "127.0.0.1:0", "syslog", nil)
+	if err != nil {
+		t.Fatalf("failed to create collector: %s", err.Error())
+	}
+	tcp := collector.(*TCPCollector)
+	c := make(chan ekanite.Document, 10)
+	if err := tcp.Start(c); err != nil {
+		t.Fatalf("failed to start collector: %s", err.Error())
+	}
+
+	conn, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to collector: %s", err.Error())
+	}
+	defer conn.Close()
+	conn.Write([]byte("<12>1 2018-01-01T00:00:00Z host app - - - sshd is down\n"))
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+
+	// The quiet connection is closed, and no other accepted.
+	start := time.Now()
+	if err := tcp.Drain(10 * time.Second); err != nil {
+		t.Fatalf("failed to drain collector: %s", err.Error())
+	}
+	if d := time.Since(start); d > newlineTimeout+time.Second {
+		t.Errorf("drain took %s, expected it to close the quiet connection", d)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("drained connection received data")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Error("drained connection was not closed")
+	}
+	if conn, err := net.Dial("tcp", tcp.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("drained collector accepted a connection")
+	}
+}
+
+func TestTCPCollector_IngestError(t *testing.T) {
+	collector, err := NewCollector("tcp", "127.0.0.1:0", "syslog", nil)
+	if err != nil {
+		t.Fatalf("failed to create collector: %s", err.Error())
+	}
+	tcp := collector.(*TCPCollector)
+	tcp.Breaker = &Breaker{}
+	c := make(chan ekanite.Document, 10)
+	if err := tcp.Start(c); err != nil {
+		t.Fatalf("failed to start collector: %s", err.Error())
+	}
+	defer tcp.Drain(time.Second)
+
+	open, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to collector: %s", err.Error())
+	}
+	defer open.Close()
+	tcp.Breaker.SetError(errors.New("disk full"))
+
+	// Connections open are closed, and new ones refused.
+	refused, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to collector: %s", err.Error())
+	}
+	defer refused.Close()
+	for _, conn := range []net.Conn{open, refused} {
+		conn.SetReadDeadline(time.Now().Add(newlineTimeout + 5*time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Fatal("refused connection received data")
+		} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Fatal("connection not closed while events are refused")
+		}
+	}
+
+	tcp.Breaker.SetError(nil)
+	conn, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to collector: %s", err.Error())
+	}
+	defer conn.Close()
+	conn.Write([]byte("<12>1 2018-01-01T00:00:00Z host app - - - sshd is down\n"))
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not accepted again")
+	}
+}
+
+func TestUDPCollector_ReusePort(t *testing.T) {
+	collector, err := NewCollector("udp", "127.0.0.1:0", "syslog", nil)
+	if err != nil {
+		t.Fatalf("failed to create collector: %s", err.Error())
+	}
+	old := collector.(*UDPCollector)
+	old.ReusePort = true
+	oldC := make(chan ekanite.Document, 10)
+	if err := old.Start(oldC); err != nil {
+		t.Skipf("SO_REUSEPORT unavailable: %s", err.Error())
+	}
+
+	// A new collector binds the port before the old one is drained, and
+	// gets all packets after.
+	collector, err = NewCollector("udp", old.Addr().String(), "syslog", nil)
+	if err != nil {
+		t.Fatalf("failed to create collector: %s", err.Error())
+	}
+	udp := collector.(*UDPCollector)
+	udp.ReusePort = true
+	c := make(chan ekanite.Document, 10)
+	if err := udp.Start(c); err != nil {
+		t.Fatalf("failed to bind port taken with SO_REUSEPORT: %s", err.Error())
+	}
+	if err := old.Drain(5 * time.Second); err != nil {
+		t.Fatalf("failed to drain collector: %s", err.Error())
+	}
+
+	conn, err := net.Dial("udp", old.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial collector: %s", err.Error())
+	}
+	defer conn.Close()
+	for n := 0; n < 5; n++ {
+		conn.Write([]byte("<12>1 2018-01-01T00:00:00Z host app - - - sshd is down"))
+	}
+	for n := 0; n < 5; n++ {
+		select {
+		case <-c:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("new collector got %d of 5 events", n)
+		}
+	}
+	if len(oldC) != 0 {
+		t.Errorf("drained collector got %d events", len(oldC))
+	}
+}
@@ -8,64 +8,110 @@ import (
 const (
 	// SYSLOG_DELIMITER indicates the start of a syslog line
 	SYSLOG_DELIMITER = `<[0-9]{1,3}>[0-9]\s`
+
+	// maxDelimiterLen is the length of the longest newline and
+	// SYSLOG_DELIMITER, which may straddle two fed chunks.
+	maxDelimiterLen = len("\n<123>1 ")
 )
 
 var syslogRegex *regexp.Regexp
-var startRegex *regexp.Regexp
 var runRegex *regexp.Regexp
 
 func init() {
 	syslogRegex = regexp.MustCompile(SYSLOG_DELIMITER)
-	startRegex = regexp.MustCompile(SYSLOG_DELIMITER + `$`)
 	runRegex = regexp.MustCompile(`\n` + SYSLOG_DELIMITER)
 }
 
-// A SyslogDelimiter detects when Syslog lines start.
+// A SyslogDelimiter detects when Syslog lines start. Bytes are fed to it as
+// they are read, and it returns the lines they complete, a line being complete
+// once the start of the next one is read.
 type SyslogDelimiter struct {
-	buffer []byte
-	regex  *regexp.Regexp
+	buffer  []byte
+	scanned int // Bytes of buffer known not to start a delimiter.
+	regex   *regexp.Regexp
 }
 
 // NewSyslogDelimiter returns an initialized SyslogDelimiter.
 func NewSyslogDelimiter(maxSize int) *SyslogDelimiter {
 	s := &SyslogDelimiter{}
 	s.buffer = make([]byte, 0, maxSize)
-	s.regex = startRegex
+	s.regex = syslogRegex
 	return s
 }
 
 // Push a byte into the SyslogDelimiter. If the byte results in a
 // a new Syslog message, it'll be flagged via the bool.
 func (s *SyslogDelimiter) Push(b byte) (string, bool) {
-	s.buffer = append(s.buffer, b)
-	delimiter := s.regex.FindIndex(s.buffer)
-	if delimiter == nil {
+	lines := s.Feed([]byte{b})
+	if len(lines) == 0 {
 		return "", false
 	}
+	return lines[0], true
+}
 
-	if s.regex == startRegex {
-		// First match -- switch to the regex for embedded lines, and
-		// drop any leading characters.
-		s.buffer = s.buffer[delimiter[0]:]
-		s.regex = runRegex
-		return "", false
-	}
+// Feed pushes the bytes read into the SyslogDelimiter, returning the Syslog
+// lines they complete, in order. Feeding bytes at once or one at a time
+// returns the same lines.
+func (s *SyslogDelimiter) Feed(p []byte) []string {
+	var lines []string
+	s.buffer = append(s.buffer, p...)
+	for {
+		from := s.scanned
+		delimiter := s.regex.FindIndex(s.buffer[from:])
+		if delimiter == nil {
+			// The start of a delimiter may have been read, but not yet
+			// the rest of it.
+			if s.scanned = len(s.buffer) - maxDelimiterLen; s.scanned < 0 {
+				s.scanned = 0
+			}
+			return lines
+		}
+		start := from + delimiter[0]
 
-	dispatch := strings.TrimRight(string(s.buffer[:delimiter[0]]), "\r")
-	s.buffer = s.buffer[delimiter[0]+1:]
-	return dispatch, true
+		if s.regex == syslogRegex {
+			// First match -- switch to the regex for embedded lines, and
+			// drop any leading characters.
+			s.buffer = s.buffer[start:]
+			s.regex = runRegex
+			s.scanned = 0
+			continue
+		}
+
+		lines = append(lines, strings.TrimRight(string(s.buffer[:start]), "\r"))
+		s.buffer = s.buffer[start+1:]
+		s.scanned = 0
+	}
 }
 
 // Vestige returns the bytes which have been pushed to SyslogDelimiter, since
 // the last Syslog message was returned, but only if the buffer appears
-// to be a valid syslog message.
+// to be a valid syslog message. The next line must then start with a
+// delimiter, anything before it being dropped.
 func (s *SyslogDelimiter) Vestige() (string, bool) {
 	delimiter := syslogRegex.FindIndex(s.buffer)
+	dispatch := strings.TrimRight(string(s.buffer), "\r\n")
+	s.reset()
 	if delimiter == nil {
-		s.buffer = nil
 		return "", false
 	}
-	dispatch := strings.TrimRight(string(s.buffer), "\r\n")
-	s.buffer = nil
 	return dispatch, true
 }
+
+// Flush returns the Vestige if it is a complete line, ended by a newline. A
+// partial line is kept, so that the rest of it read later is not returned as
+// a line of its own. It is called when reading pauses, while Vestige is
+// called once reading is over.
+func (s *SyslogDelimiter) Flush() (string, bool) {
+	if len(s.buffer) == 0 || s.buffer[len(s.buffer)-1] != '\n' {
+		return "", false
+	}
+	return s.Vestige()
+}
+
+// reset empties the buffer, the next line being looked for from its
+// delimiter.
+func (s *SyslogDelimiter) reset() {
+	s.buffer = s.buffer[:0]
+	s.scanned = 0
+	s.regex = syslogRegex
+}
@@ -1,6 +1,8 @@
 package input
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
 )
 
@@ -108,3 +110,113 @@ func TestSyslogDelimiter_Vestige(t *testing.T) {
 		}
 	}
 }
+
+func TestSyslogDelimiter_Feed(t *testing.T) {
+	line := "junk<12>1 sshd is down\r\n<145>1 OOM on line 42, dummy.java\n\tclass_loader.jar\n<33>4 a\n<67>2 password accepted"
+	expected := []string{"<12>1 sshd is down", "<145>1 OOM on line 42, dummy.java\n\tclass_loader.jar", "<33>4 a"}
+
+	// The lines are the same however the bytes are split.
+	for size := 1; size <= len(line); size++ {
+		d := NewSyslogDelimiter(256)
+		var events []string
+		for i := 0; i < len(line); i += size {
+			end := i + size
+			if end > len(line) {
+				end = len(line)
+			}
+			events = append(events, d.Feed([]byte(line[i:end]))...)
+		}
+		if len(events) != len(expected) {
+			t.Fatalf("chunks of %d: got %q, expected %q", size, events, expected)
+		}
+		for i := range events {
+			if events[i] != expected[i] {
+				t.Fatalf("chunks of %d: got %q, expected %q", size, events, expected)
+			}
+		}
+		if e, m := d.Vestige(); !m || e != "<67>2 password accepted" {
+			t.Fatalf("chunks of %d: vestige is %q %v", size, e, m)
+		}
+	}
+}
+
+func TestSyslogDelimiter_Flush(t *testing.T) {
+	d := NewSyslogDelimiter(256)
+
+	// A partial line is kept when reading pauses, and returned whole once
+	// the next line starts.
+	d.Feed([]byte("<12>1 sshd is"))
+	if e, m := d.Flush(); m {
+		t.Fatalf("partial line flushed as %q", e)
+	}
+	lines := d.Feed([]byte(" down\n<13>1 sshd is up\n"))
+	if len(lines) != 1 || lines[0] != "<12>1 sshd is down" {
+		t.Fatalf("got %q, expected the whole first line", lines)
+	}
+
+	// A complete line is flushed when reading pauses.
+	if e, m := d.Flush(); !m || e != "<13>1 sshd is up" {
+		t.Fatalf("flushed %q %v, expected the complete line", e, m)
+	}
+	if e, m := d.Flush(); m {
+		t.Fatalf("flushed %q twice", e)
+	}
+}
+
+func TestSyslogDelimiter_NoPartialAfterVestige(t *testing.T) {
+	d := NewSyslogDelimiter(256)
+	d.Feed([]byte("<12>1 sshd is"))
+	if e, m := d.Vestige(); !m || e != "<12>1 sshd is" {
+		t.Fatalf("vestige is %q %v", e, m)
+	}
+
+	// The rest of the line is not returned as a line of its own.
+	lines := d.Feed([]byte(" down\n<13>1 sshd is up\n<14>1 next"))
+	if len(lines) != 1 || lines[0] != "<13>1 sshd is up" {
+		t.Fatalf("got %q, expected only the line after the vestige", lines)
+	}
+}
+
+// benchmarkStream returns a second's worth of RFC5424 lines at 50k msgs/s.
+func benchmarkStream() ([]byte, int) {
+	const n = 50000
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "<134>1 2018-01-01T00:00:00.%06dZ web-%d nginx 123 - - GET /index.html 200 612\n", i%1000000, i%10)
+	}
+	return buf.Bytes(), n
+}
+
+// BenchmarkSyslogDelimiter_Push delimits the stream a byte at a time, as the
+// TCP collector used to.
+func BenchmarkSyslogDelimiter_Push(b *testing.B) {
+	stream, n := benchmarkStream()
+	b.SetBytes(int64(len(stream)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := NewSyslogDelimiter(msgBufSize)
+		for _, c := range stream {
+			d.Push(c)
+		}
+	}
+	b.ReportMetric(float64(n)*float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+}
+
+// BenchmarkSyslogDelimiter_Feed delimits the stream in blocks the size of the
+// collector read buffer.
+func BenchmarkSyslogDelimiter_Feed(b *testing.B) {
+	stream, n := benchmarkStream()
+	b.SetBytes(int64(len(stream)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := NewSyslogDelimiter(msgBufSize)
+		for j := 0; j < len(stream); j += readerBufSize {
+			end := j + readerBufSize
+			if end > len(stream) {
+				end = len(stream)
+			}
+			d.Feed(stream[j:end])
+		}
+	}
+	b.ReportMetric(float64(n)*float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+}
@@ -0,0 +1,55 @@
+package input
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ParseResult is how a message would be indexed, as reported by DryRun.
+type ParseResult struct {
+	Fields        map[string]interface{} `json:"fields"`
+	ReferenceTime time.Time              `json:"reference_time"`   // Time the event would be indexed at.
+	Errors        []string               `json:"errors,omitempty"` // Parse and field coercion errors.
+	Strict        string                 `json:"strict,omitempty"` // Why collectors in strict mode would drop the message.
+}
+
+// DryRun parses and enriches message in format as the collectors with the
+// pipeline do, without indexing it or counting it in the stats, so that the
+// messages of a device can be checked before it is pointed at ekanite.
+func (p *Pipeline) DryRun(format, message string) (*ParseResult, error) {
+	parser, err := NewLogParser(format)
+	if err != nil {
+		return nil, err
+	}
+
+	var r ParseResult
+	fields, err := parser.Parse("", []byte(message))
+	if err != nil {
+		r.Errors = append(r.Errors, err.Error())
+	}
+	errs := p.coerceFields(fields)
+	coerced := make([]string, 0, len(errs))
+	for field := range errs {
+		coerced = append(coerced, field)
+	}
+	sort.Strings(coerced)
+	for _, field := range coerced {
+		r.Errors = append(r.Errors, fmt.Sprintf("field '%s': %s", field, errs[field]))
+	}
+
+	e := &Event{Text: message, Parsed: fields, ReceptionTime: time.Now().UTC()}
+	if _, ok := e.Parsed["timestamp"]; !ok {
+		e.Parsed["timestamp"] = time.Now()
+	}
+	e.Parsed["reception"] = e.ReceptionTime
+	e.Parsed["message"] = e.Text
+	p.Enrich(e.Parsed)
+	r.Fields = e.Parsed
+	r.ReferenceTime = e.ReferenceTime()
+
+	if err := ValidateRFC5424([]byte(message)); err != nil {
+		r.Strict = err.Error()
+	}
+	return &r, nil
+}
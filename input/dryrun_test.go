@@ -0,0 +1,36 @@
+package input
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDryRun(t *testing.T) {
+	p := NewPipeline(PipelineSettings{})
+	r, err := p.DryRun("rfc5424", "<134>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 - BOMAn application event log entry")
+	if err != nil {
+		t.Fatalf("failed to dry run parser: %s", err)
+	}
+	if len(r.Errors) != 0 || r.Strict != "" {
+		t.Errorf("valid message has errors %v, strict %q", r.Errors, r.Strict)
+	}
+	if r.Fields["host"] != "mymachine.example.com" || r.Fields["severity"] != 6 {
+		t.Errorf("wrong fields parsed: %v", r.Fields)
+	}
+	if !r.ReferenceTime.Equal(time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.UTC)) {
+		t.Errorf("reference time is %s", r.ReferenceTime)
+	}
+
+	r, err = p.DryRun("rfc5424", "not syslog")
+	if err != nil {
+		t.Fatalf("failed to dry run parser: %s", err)
+	}
+	// The best-effort parser indexes what it can, which strict mode drops.
+	if r.Strict == "" || r.Fields["message"] != "not syslog" {
+		t.Errorf("invalid message has strict %q, fields %v", r.Strict, r.Fields)
+	}
+
+	if _, err := p.DryRun("json", "{}"); err == nil {
+		t.Error("dry run with unknown format did not fail")
+	}
+}
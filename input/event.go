@@ -2,14 +2,11 @@ package input
 
 import (
 	"fmt"
-	"sync/atomic"
 	"time"
 
 	"github.com/ekanite/ekanite"
 )
 
-var sequenceID int32
-
 // Event is a log message, with a reception timestamp and sequence number.
 type Event struct {
 	Text          string                 // Delimited log line
@@ -24,7 +21,8 @@ type Event struct {
 // ID returns a unique ID for the event.
 func (e *Event) ID() ekanite.DocID {
 	if e.Sequence == 0 {
-		e.Sequence = int64(atomic.AddInt32(&sequenceID, 1))
+		// Not numbered by a pipeline.
+		e.Sequence = time.Now().UnixNano()
 	}
 	return ekanite.DocID(fmt.Sprintf("%016x%016x",
 		uint64(e.ReferenceTime().UnixNano()), uint64(e.Sequence)))
@@ -35,6 +33,11 @@ func (e *Event) Data() interface{} {
 	return e.Parsed
 }
 
+// Received returns the time the event was received.
+func (e *Event) Received() time.Time {
+	return e.ReceptionTime
+}
+
 // ReferenceTime returns the reference time of an event.
 func (e *Event) ReferenceTime() time.Time {
 	if e.referenceTime.IsZero() {
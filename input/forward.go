@@ -0,0 +1,446 @@
+package input
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ekanite/ekanite"
+)
+
+// Events are forwarded from a Forwarder to a ForwardCollector as frames, each
+// a uvarint length followed by an event encoded by Event.MarshalBinary. This
+// lets collectors run in processes other than the engine's, such as one bound
+// to port 514 as root. The connections are not authenticated, so that the
+// ForwardCollector only listens on unix sockets and loopback addresses.
+// Collectors on other hosts forward through a tunnel, such as ssh's.
+
+const (
+	// forwardVersion is the version of the event encoding, the first byte of
+	// every encoded event.
+	forwardVersion = 1
+
+	// maxForwardFrame bounds the size of a frame read, so that a corrupt
+	// length cannot exhaust memory.
+	maxForwardFrame = 16 << 20
+
+	// DefaultForwardQueue is the number of events a Forwarder queues while
+	// it cannot send them.
+	DefaultForwardQueue = 10000
+
+	maxForwardBackoff = 5 * time.Second
+)
+
+// Tags of the parsed field values in encoded events.
+const (
+	tagString byte = iota + 1
+	tagInt
+	tagFloat
+	tagBool
+	tagTime
+)
+
+// ErrInvalidFrame is returned when decoding a forwarded event fails.
+var ErrInvalidFrame = errors.New("invalid forwarded event")
+
+// ParseForwardAddr splits an address of the form network:address, such as
+// unix:/run/ekanite.sock or tcp:indexer:5515, the network defaulting to tcp.
+func ParseForwardAddr(s string) (network, address string) {
+	if i := strings.Index(s, ":"); i > 0 {
+		switch s[:i] {
+		case "unix", "tcp", "tcp4", "tcp6":
+			return s[:i], s[i+1:]
+		}
+	}
+	return "tcp", s
+}
+
+// MarshalBinary encodes the event, its parsed fields holding strings, numbers,
+// booleans and times. Values of other types are encoded as strings.
+func (e *Event) MarshalBinary() ([]byte, error) {
+	b := []byte{forwardVersion}
+	b = appendVarint(b, e.ReceptionTime.UnixNano())
+	b = appendString(b, e.Text)
+	b = appendString(b, e.SourceIP)
+	b = appendUvarint(b, uint64(len(e.Parsed)))
+	for k, v := range e.Parsed {
+		b = appendString(b, k)
+		switch v := v.(type) {
+		case string:
+			b = append(b, tagString)
+			b = appendString(b, v)
+		case int:
+			b = append(b, tagInt)
+			b = appendVarint(b, int64(v))
+		case int64:
+			b = append(b, tagInt)
+			b = appendVarint(b, v)
+		case float64:
+			b = append(b, tagFloat)
+			b = appendUvarint(b, math.Float64bits(v))
+		case bool:
+			b = append(b, tagBool)
+			if v {
+				b = append(b, 1)
+			} else {
+				b = append(b, 0)
+			}
+		case time.Time:
+			b = append(b, tagTime)
+			b = appendVarint(b, v.UnixNano())
+		default:
+			b = append(b, tagString)
+			b = appendString(b, fmt.Sprint(v))
+		}
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes an event encoded by MarshalBinary. Times are decoded
+// in UTC and integers as int, as parsers return them. The sequence number is
+// not encoded, the receiving side numbering events itself.
+func (e *Event) UnmarshalBinary(b []byte) error {
+	d := decoder{b: b}
+	if v := d.byte(); v != forwardVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidFrame, v)
+	}
+	e.ReceptionTime = time.Unix(0, d.varint()).UTC()
+	e.Text = d.string()
+	e.SourceIP = d.string()
+	n := d.uvarint()
+	if d.err == nil && n > uint64(len(d.b)) {
+		d.err = ErrInvalidFrame
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if e.Parsed == nil {
+		e.Parsed = newResult()
+	}
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		k := d.string()
+		switch tag := d.byte(); tag {
+		case tagString:
+			e.Parsed[k] = d.string()
+		case tagInt:
+			e.Parsed[k] = int(d.varint())
+		case tagFloat:
+			e.Parsed[k] = math.Float64frombits(d.uvarint())
+		case tagBool:
+			e.Parsed[k] = d.byte() != 0
+		case tagTime:
+			e.Parsed[k] = time.Unix(0, d.varint()).UTC()
+		default:
+			if d.err == nil {
+				d.err = fmt.Errorf("%w: unknown tag %d of field %s", ErrInvalidFrame, tag, k)
+			}
+		}
+	}
+	return d.err
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUvarint(b, uint64(len(s))), s...)
+}
+
+// decoder reads the values of an encoded event, the first error sticking.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.b) == 0 {
+		d.fail()
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if d.err != nil || n > uint64(len(d.b)) {
+		d.fail()
+		return ""
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: truncated", ErrInvalidFrame)
+	}
+}
+
+// Forwarder sends the events written to its channel to a ForwardCollector,
+// reconnecting, with backoff, whenever the connection fails. Events are
+// queued meanwhile, and collectors block once the queue is full. Events
+// buffered for a connection when it fails may be lost.
+type Forwarder struct {
+	network string
+	addr    string
+	c       chan ekanite.Document
+}
+
+// NewForwarder returns a Forwarder to the collector listening on addr, queuing
+// up to queue events.
+func NewForwarder(network, addr string, queue int) *Forwarder {
+	if queue <= 0 {
+		queue = DefaultForwardQueue
+	}
+	return &Forwarder{network: network, addr: addr, c: make(chan ekanite.Document, queue)}
+}
+
+// C returns the channel collectors write events to.
+func (f *Forwarder) C() chan<- ekanite.Document {
+	return f.c
+}
+
+// Start starts sending events, in the background.
+func (f *Forwarder) Start() {
+	go f.run()
+}
+
+func (f *Forwarder) run() {
+	var conn net.Conn
+	var w *bufio.Writer
+	var backoff time.Duration
+	var frame []byte
+
+	for doc := range f.c {
+		e, ok := doc.(*Event)
+		if !ok {
+			stats.Add("forwardEventsDropped", 1)
+			continue
+		}
+		b, _ := e.MarshalBinary()
+		frame = appendUvarint(frame[:0], uint64(len(b)))
+		frame = append(frame, b...)
+
+		for {
+			if conn == nil {
+				var err error
+				conn, err = net.Dial(f.network, f.addr)
+				if err != nil {
+					stats.Add("forwardDialError", 1)
+					backoff = nextBackoff(backoff)
+					time.Sleep(backoff)
+					continue
+				}
+				backoff = 0
+				w = bufio.NewWriter(conn)
+			}
+
+			_, err := w.Write(frame)
+			if err == nil && len(f.c) == 0 {
+				// Nothing else to send right now.
+				err = w.Flush()
+			}
+			if err != nil {
+				// The frame may or may not have made it, send it again
+				// on a new connection.
+				stats.Add("forwardWriteError", 1)
+				conn.Close()
+				conn = nil
+				continue
+			}
+			break
+		}
+		stats.Add("forwardEventsTx", 1)
+		e.Release()
+	}
+	if conn != nil {
+		w.Flush()
+		conn.Close()
+	}
+}
+
+// nextBackoff doubles backoff, up to maxForwardBackoff.
+func nextBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return 5 * time.Millisecond
+	}
+	if backoff *= 2; backoff > maxForwardBackoff {
+		return maxForwardBackoff
+	}
+	return backoff
+}
+
+// loopbackAddr returns whether the host of addr, of the form host:port, is
+// localhost or a loopback address. The empty host binds all interfaces.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ForwardCollector accepts the events sent by Forwarders, on a unix socket or
+// a loopback address.
+type ForwardCollector struct {
+	Pipeline *Pipeline // Numbers the events.
+	Breaker  *Breaker  // Refuses the events while it holds an error.
+
+	network string
+	iface   string
+	addr    net.Addr
+}
+
+// NewForwardCollector returns a collector of forwarded events, that will bind
+// to the given loopback interface, or unix socket path, on Start().
+func NewForwardCollector(network, iface string) *ForwardCollector {
+	return &ForwardCollector{network: network, iface: iface}
+}
+
+// Start instructs the ForwardCollector to bind to the interface and accept
+// connections. A stale unix socket left by a previous run is removed. Other
+// interfaces than loopback ones are refused, as anyone reaching them could
+// forward events.
+func (s *ForwardCollector) Start(c chan<- ekanite.Document) error {
+	if s.network != "unix" && !loopbackAddr(s.iface) {
+		return fmt.Errorf("forward collector address '%s' is not a loopback address, forwarded events are not authenticated", s.iface)
+	}
+	if s.network == "unix" {
+		if err := os.Remove(s.iface); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	ln, err := net.Listen(s.network, s.iface)
+	if err != nil {
+		return err
+	}
+	s.addr = ln.Addr()
+
+	go func() {
+		var backoff time.Duration
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				stats.Add("forwardAcceptError", 1)
+				backoff = nextBackoff(backoff)
+				time.Sleep(backoff)
+				continue
+			}
+			backoff = 0
+			if s.Breaker.Err() != nil {
+				stats.Add("forwardConnRefused", 1)
+				conn.Close()
+				continue
+			}
+			go s.handleConnection(conn, c)
+		}
+	}()
+	return nil
+}
+
+// Addr returns the net.Addr that the Collector is bound to.
+func (s *ForwardCollector) Addr() net.Addr {
+	return s.addr
+}
+
+func (s *ForwardCollector) handleConnection(conn net.Conn, c chan<- ekanite.Document) {
+	stats.Add("forwardConnections", 1)
+	defer func() {
+		stats.Add("forwardConnections", -1)
+		conn.Close()
+	}()
+
+	reader := getReader(conn)
+	defer putReader(reader)
+	var buf []byte
+	for {
+		if s.Breaker.Err() != nil {
+			// The frames in flight are lost, the forwarder sending again
+			// those it then fails to write.
+			stats.Add("forwardConnRefused", 1)
+			return
+		}
+		n, err := binary.ReadUvarint(reader)
+		if err != nil {
+			if err != io.EOF {
+				stats.Add("forwardReadError", 1)
+			}
+			return
+		}
+		if n > maxForwardFrame {
+			stats.Add("forwardDecodeError", 1)
+			return
+		}
+		if uint64(cap(buf)) < n {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			stats.Add("forwardReadError", 1)
+			return
+		}
+
+		e := NewEvent()
+		if err := e.UnmarshalBinary(buf); err != nil {
+			// Frames are delimited, so the following ones can still be
+			// read.
+			stats.Add("forwardDecodeError", 1)
+			e.Release()
+			continue
+		}
+		if e.Sequence, err = s.Pipeline.NextSequence(); err != nil {
+			stats.Add("forwardEventsDropped", 1)
+			e.Release()
+			continue
+		}
+		stats.Add("forwardEventsRx", 1)
+		c <- e
+	}
+}
@@ -0,0 +1,100 @@
+package input
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ekanite/ekanite"
+)
+
+func TestEvent_MarshalBinary(t *testing.T) {
+	received := time.Date(1982, 2, 5, 4, 43, 0, 0, time.UTC)
+	e := &Event{
+		Text:          "<134>1 1982-02-05T04:43:00Z host app 12 - - auth accepted",
+		ReceptionTime: received,
+		SourceIP:      "10.0.0.1",
+		Parsed: map[string]interface{}{
+			"priority":  134,
+			"host":      "host",
+			"latency":   1.5,
+			"success":   true,
+			"timestamp": received,
+		},
+	}
+	b, err := e.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to encode event: %s", err.Error())
+	}
+
+	var d Event
+	if err := d.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to decode event: %s", err.Error())
+	}
+	if d.Text != e.Text || !d.ReceptionTime.Equal(received) || d.SourceIP != e.SourceIP {
+		t.Fatalf("decoded event %+v, expected %+v", d, e)
+	}
+	if !reflect.DeepEqual(d.Parsed, e.Parsed) {
+		t.Fatalf("decoded fields %v, expected %v", d.Parsed, e.Parsed)
+	}
+
+	for i := range b {
+		var d Event
+		if err := d.UnmarshalBinary(b[:i]); !errors.Is(err, ErrInvalidFrame) {
+			t.Fatalf("decoding %d of %d bytes returned %v, expected ErrInvalidFrame", i, len(b), err)
+		}
+	}
+}
+
+func TestForwarder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ekanite-forward-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "forward.sock")
+
+	collector := NewForwardCollector("unix", path)
+	c := make(chan ekanite.Document, 2)
+	if err := collector.Start(c); err != nil {
+		t.Fatalf("failed to start forward collector: %s", err.Error())
+	}
+
+	network, addr := ParseForwardAddr("unix:" + path)
+	forwarder := NewForwarder(network, addr, 0)
+	forwarder.Start()
+	for _, text := range []string{"auth password accepted", "auth key accepted"} {
+		e := NewEvent()
+		e.Text = text
+		e.ReceptionTime = time.Now().UTC()
+		e.Parsed = map[string]interface{}{"message": text}
+		forwarder.C() <- e
+	}
+
+	for _, text := range []string{"auth password accepted", "auth key accepted"} {
+		select {
+		case doc := <-c:
+			e := doc.(*Event)
+			if e.Text != text || e.Parsed["message"] != text || e.Sequence == 0 {
+				t.Fatalf("unexpected forwarded event %+v", e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for forwarded event %q", text)
+		}
+	}
+}
+
+func TestForwardCollector_Loopback(t *testing.T) {
+	for _, iface := range []string{":0", "0.0.0.0:0", "[::]:0", "192.0.2.1:5515", "indexer:5515"} {
+		if err := NewForwardCollector("tcp", iface).Start(make(chan ekanite.Document)); err == nil {
+			t.Errorf("forward collector started on %s, expected a non-loopback error", iface)
+		}
+	}
+	if err := NewForwardCollector("tcp", "127.0.0.1:0").Start(make(chan ekanite.Document)); err != nil {
+		t.Fatalf("failed to start forward collector on loopback: %s", err.Error())
+	}
+}
@@ -0,0 +1,206 @@
+package input
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ekanite/ekanite"
+)
+
+// ListenerField is the field events are stamped with the tag of their
+// listener in.
+const ListenerField = "listener"
+
+// DefaultListenerDrainTimeout is how long stopping a listener waits for the
+// events in flight.
+const DefaultListenerDrainTimeout = 10 * time.Second
+
+// ErrUnknownListener is returned for listeners not configured.
+var ErrUnknownListener = errors.New("unknown listener")
+
+// ListenerConfig configures a listener, a collector stamping its events with
+// its tag, so that sources sent to different ports can be told apart.
+type ListenerConfig struct {
+	Tag      string `json:"tag"`
+	Protocol string `json:"protocol"` // tcp or udp.
+	Format   string `json:"format"`
+	Address  string `json:"address"` // Address bound, as host:port.
+}
+
+// ListenerInfo describes a listener.
+type ListenerInfo struct {
+	ListenerConfig
+	Running bool   `json:"running"`
+	Addr    string `json:"addr,omitempty"` // Address bound, if running.
+}
+
+// ParseListenerConfigs parses a comma-separated list of
+// tag:protocol:format:address listeners, such as
+// "firewalls:udp:rfc3164:0.0.0.0:5514,apps:tcp:rfc5424:0.0.0.0:6514".
+func ParseListenerConfigs(s string) ([]ListenerConfig, error) {
+	var configs []ListenerConfig
+	for _, spec := range strings.Split(s, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		ss := strings.SplitN(spec, ":", 4)
+		if len(ss) != 4 {
+			return nil, fmt.Errorf("listener '%s' is not in the form tag:protocol:format:address", spec)
+		}
+		config := ListenerConfig{Tag: ss[0], Protocol: ss[1], Format: ss[2], Address: ss[3]}
+		if err := config.validate(); err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// validate checks the config, defaulting its format to syslog.
+func (c *ListenerConfig) validate() error {
+	if c.Format == "" {
+		c.Format = "syslog"
+	}
+	c.Protocol = strings.ToLower(c.Protocol)
+	switch {
+	case c.Tag == "":
+		return fmt.Errorf("listener on %s has no tag", c.Address)
+	case c.Protocol != "tcp" && c.Protocol != "udp":
+		return fmt.Errorf("listener %s has protocol '%s', not tcp or udp", c.Tag, c.Protocol)
+	case !ValidFormat(c.Format):
+		return fmt.Errorf("listener %s has format '%s', not one of %s", c.Tag, c.Format, strings.Join(fmtsByStandard, ", "))
+	case c.Address == "":
+		return fmt.Errorf("listener %s has no address", c.Tag)
+	}
+	return nil
+}
+
+// listener is a configured listener, and its collector while running.
+type listener struct {
+	config    ListenerConfig
+	collector Collector
+}
+
+// Listeners manages listeners sending their events to a channel, which may be
+// started and stopped while running.
+type Listeners struct {
+	DrainTimeout time.Duration // How long stopping a listener waits for the events in flight.
+	Pipeline     *Pipeline     // Coerces and enriches the fields of the events of the listeners started.
+	Breaker      *Breaker      // Refuses the events of the listeners started while it holds an error.
+
+	c         chan<- ekanite.Document
+	mu        sync.Mutex
+	listeners map[string]*listener
+}
+
+// NewListeners returns Listeners sending their events to c.
+func NewListeners(c chan<- ekanite.Document) *Listeners {
+	return &Listeners{
+		DrainTimeout: DefaultListenerDrainTimeout,
+		c:            c,
+		listeners:    map[string]*listener{},
+	}
+}
+
+// Add adds the listener of config, stopped.
+func (l *Listeners) Add(config ListenerConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.listeners[config.Tag]; ok {
+		return fmt.Errorf("listener %s already exists", config.Tag)
+	}
+	l.listeners[config.Tag] = &listener{config: config}
+	return nil
+}
+
+// Start starts the listener tag, unless running.
+func (l *Listeners) Start(tag string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ln, ok := l.listeners[tag]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownListener, tag)
+	}
+	if ln.collector != nil {
+		return nil
+	}
+
+	// Drained collectors cannot be started again, so each start creates one.
+	collector, err := NewCollector(ln.config.Protocol, ln.config.Address, ln.config.Format, nil)
+	if err != nil {
+		return fmt.Errorf("listener %s: %w", tag, err)
+	}
+	switch c := collector.(type) {
+	case *TCPCollector:
+		c.Tag = tag
+		c.Pipeline = l.Pipeline
+		c.Breaker = l.Breaker
+	case *UDPCollector:
+		c.Tag = tag
+		c.Pipeline = l.Pipeline
+		c.Breaker = l.Breaker
+	}
+	if err := collector.Start(l.c); err != nil {
+		return fmt.Errorf("listener %s: %w", tag, err)
+	}
+	ln.collector = collector
+	return nil
+}
+
+// Stop stops the listener tag, once the events in flight are sent or after
+// the DrainTimeout.
+func (l *Listeners) Stop(tag string) error {
+	l.mu.Lock()
+	ln, ok := l.listeners[tag]
+	if !ok {
+		l.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrUnknownListener, tag)
+	}
+	collector := ln.collector
+	ln.collector = nil
+	l.mu.Unlock()
+
+	if d, ok := collector.(interface {
+		Drain(time.Duration) error
+	}); ok {
+		return d.Drain(l.DrainTimeout)
+	}
+	return nil
+}
+
+// StopAll stops all listeners, returning the first error.
+func (l *Listeners) StopAll() error {
+	var firstErr error
+	for _, info := range l.List() {
+		if err := l.Stop(info.Tag); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// List describes the listeners, ordered by tag.
+func (l *Listeners) List() []ListenerInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	infos := make([]ListenerInfo, 0, len(l.listeners))
+	for _, ln := range l.listeners {
+		info := ListenerInfo{ListenerConfig: ln.config}
+		if ln.collector != nil {
+			info.Running = true
+			if addr := ln.collector.Addr(); addr != nil {
+				info.Addr = addr.String()
+			}
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Tag < infos[j].Tag })
+	return infos
+}
@@ -0,0 +1,77 @@
+package input
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ekanite/ekanite"
+)
+
+func TestParseListenerConfigs(t *testing.T) {
+	configs, err := ParseListenerConfigs("firewalls:udp:rfc3164:0.0.0.0:5514, apps:TCP::[::1]:6514")
+	if err != nil {
+		t.Fatalf("failed to parse listeners: %s", err)
+	}
+	if len(configs) != 2 || configs[0] != (ListenerConfig{Tag: "firewalls", Protocol: "udp", Format: "rfc3164", Address: "0.0.0.0:5514"}) ||
+		configs[1] != (ListenerConfig{Tag: "apps", Protocol: "tcp", Format: "syslog", Address: "[::1]:6514"}) {
+		t.Fatalf("parsed listeners %+v", configs)
+	}
+	for _, s := range []string{"apps:tcp:5514", "apps:sctp:syslog:0.0.0.0:5514", "apps:tcp:json:0.0.0.0:5514", ":udp:syslog:0.0.0.0:5514"} {
+		if _, err := ParseListenerConfigs(s); err == nil {
+			t.Errorf("listeners %q parsed", s)
+		}
+	}
+}
+
+func TestListeners(t *testing.T) {
+	c := make(chan ekanite.Document, 1)
+	l := NewListeners(c)
+	l.DrainTimeout = time.Second
+	if err := l.Add(ListenerConfig{Tag: "firewalls", Protocol: "udp", Address: "127.0.0.1:0"}); err != nil {
+		t.Fatalf("failed to add listener: %s", err)
+	}
+	if err := l.Add(ListenerConfig{Tag: "firewalls", Protocol: "tcp", Address: "127.0.0.1:0"}); err == nil {
+		t.Fatal("listener of the same tag added")
+	}
+	if err := l.Start("apps"); !errors.Is(err, ErrUnknownListener) {
+		t.Fatalf("unknown listener started with %v", err)
+	}
+
+	if err := l.Start("firewalls"); err != nil {
+		t.Fatalf("failed to start listener: %s", err)
+	}
+	infos := l.List()
+	if len(infos) != 1 || !infos[0].Running || infos[0].Addr == "" {
+		t.Fatalf("listeners are %+v", infos)
+	}
+	conn, err := net.Dial("udp", infos[0].Addr)
+	if err != nil {
+		t.Fatalf("failed to connect to listener: %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("<134>1 2003-10-11T22:14:15.003Z host1 app - - - denied"))
+	select {
+	case d := <-c:
+		if tag := d.(*Event).Parsed[ListenerField]; tag != "firewalls" {
+			t.Errorf("event stamped with listener %v", tag)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+
+	if err := l.Stop("firewalls"); err != nil {
+		t.Fatalf("failed to stop listener: %s", err)
+	}
+	if infos := l.List(); infos[0].Running {
+		t.Fatalf("stopped listener running: %+v", infos)
+	}
+	// Listeners start again after being stopped.
+	if err := l.Start("firewalls"); err != nil {
+		t.Fatalf("failed to restart listener: %s", err)
+	}
+	if err := l.StopAll(); err != nil {
+		t.Fatalf("failed to stop listeners: %s", err)
+	}
+}
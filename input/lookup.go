@@ -0,0 +1,338 @@
+package input
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LookupTable adds fields to the events whose key field has a row in the
+// table, such as the datacenter of an address or the owner of a host, so that
+// the mapping is indexed rather than repeated in every query.
+//
+// Tables are loaded from CSV files, whose header names the key column and the
+// fields added, or from JSON files holding either an object of rows by key or
+// an array of rows holding the key. Keys which are CIDRs, such as
+// 10.1.0.0/16, match the addresses within them, the most specific first.
+type LookupTable struct {
+	Name string // Name the table is managed by.
+	Key  string // Field of the events looked up.
+	Path string // File the rows are loaded from.
+
+	rows    map[string]map[string]interface{}
+	nets    []lookupNet
+	modTime time.Time
+	size    int64
+}
+
+// lookupNet is a row keyed by a CIDR.
+type lookupNet struct {
+	ipnet  *net.IPNet
+	ones   int
+	fields map[string]interface{}
+}
+
+// LookupTableInfo describes a lookup table in use.
+type LookupTableInfo struct {
+	Name     string    `json:"name"`
+	Key      string    `json:"key"`
+	Path     string    `json:"path"`
+	Rows     int       `json:"rows"`
+	Modified time.Time `json:"modified"` // Modification time of the file loaded.
+}
+
+// LoadLookupTable loads the lookup table name of the file at path, adding
+// its fields to the events by their key field.
+func LoadLookupTable(name, key, path string) (*LookupTable, error) {
+	if name == "" || key == "" || path == "" {
+		return nil, fmt.Errorf("lookup table needs a name, a key field and a path")
+	}
+	t := &LookupTable{Name: name, Key: key, Path: path}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ParseLookupTables parses and loads a comma-separated list of name:key:path
+// tables, such as "dc:address:/etc/ekanite/dc.csv,owners:host:owners.json".
+func ParseLookupTables(s string) ([]*LookupTable, error) {
+	var tables []*LookupTable
+	for _, spec := range strings.Split(s, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		ss := strings.SplitN(spec, ":", 3)
+		if len(ss) != 3 {
+			return nil, fmt.Errorf("lookup table '%s' is not in the form name:key:path", spec)
+		}
+		t, err := LoadLookupTable(ss[0], ss[1], ss[2])
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// load reads the rows of the table from its file.
+func (t *LookupTable) load() error {
+	f, err := os.Open(t.Path)
+	if err != nil {
+		return fmt.Errorf("lookup table %s: %w", t.Name, err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("lookup table %s: %w", t.Name, err)
+	}
+
+	var rows map[string]map[string]interface{}
+	if strings.EqualFold(filepath.Ext(t.Path), ".json") {
+		rows, err = readJSONRows(f, t.Key)
+	} else {
+		rows, err = readCSVRows(f, t.Key)
+	}
+	if err != nil {
+		return fmt.Errorf("lookup table %s: %w", t.Name, err)
+	}
+
+	var nets []lookupNet
+	for key, fields := range rows {
+		if !strings.Contains(key, "/") {
+			continue
+		}
+		if _, ipnet, err := net.ParseCIDR(key); err == nil {
+			ones, _ := ipnet.Mask.Size()
+			nets = append(nets, lookupNet{ipnet: ipnet, ones: ones, fields: fields})
+		}
+	}
+	sort.Slice(nets, func(i, j int) bool { return nets[i].ones > nets[j].ones })
+
+	t.rows, t.nets, t.modTime, t.size = rows, nets, fi.ModTime(), fi.Size()
+	return nil
+}
+
+// readCSVRows reads rows by the key column of a CSV file with a header.
+func readCSVRows(r io.Reader, key string) (map[string]map[string]interface{}, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	col := -1
+	for i, name := range header {
+		if name == key {
+			col = i
+		}
+	}
+	if col < 0 {
+		return nil, fmt.Errorf("CSV header has no '%s' column", key)
+	}
+
+	rows := map[string]map[string]interface{}{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]interface{}, len(record)-1)
+		for i, value := range record {
+			if i != col && value != "" {
+				fields[header[i]] = value
+			}
+		}
+		rows[record[col]] = fields
+	}
+}
+
+// readJSONRows reads rows from a JSON object of rows by key, or an array of
+// rows holding the key field.
+func readJSONRows(r io.Reader, key string) (map[string]map[string]interface{}, error) {
+	var v interface{}
+	if err := json.NewDecoder(r).Decode(&v); err != nil {
+		return nil, err
+	}
+	rows := map[string]map[string]interface{}{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, row := range v {
+			fields, ok := row.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("row '%s' is not an object", k)
+			}
+			rows[k] = fields
+		}
+	case []interface{}:
+		for i, row := range v {
+			fields, ok := row.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("row %d is not an object", i)
+			}
+			k, ok := fields[key]
+			if !ok {
+				return nil, fmt.Errorf("row %d has no '%s' field", i, key)
+			}
+			delete(fields, key)
+			rows[fmt.Sprint(k)] = fields
+		}
+	default:
+		return nil, fmt.Errorf("JSON is neither an object nor an array of rows")
+	}
+	return rows, nil
+}
+
+// lookup returns the fields of the row of value, nil if none.
+func (t *LookupTable) lookup(value string) map[string]interface{} {
+	if fields, ok := t.rows[value]; ok {
+		return fields
+	}
+	if len(t.nets) == 0 {
+		return nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		// Addresses are received with their port.
+		host, _, err := net.SplitHostPort(value)
+		if err != nil {
+			return nil
+		}
+		if ip = net.ParseIP(host); ip == nil {
+			return nil
+		}
+	}
+	for _, n := range t.nets {
+		if n.ipnet.Contains(ip) {
+			return n.fields
+		}
+	}
+	return nil
+}
+
+// SetLookupTable adds the table applied by the pipeline, replacing any of the
+// same name.
+func (p *Pipeline) SetLookupTable(t *LookupTable) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tables := make([]*LookupTable, 0, len(p.tables)+1)
+	for _, old := range p.tables {
+		if old.Name != t.Name {
+			tables = append(tables, old)
+		}
+	}
+	tables = append(tables, t)
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	p.tables = tables
+}
+
+// RemoveLookupTable removes the table name, returning whether there was one.
+func (p *Pipeline) RemoveLookupTable(name string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, t := range p.tables {
+		if t.Name == name {
+			tables := make([]*LookupTable, 0, len(p.tables)-1)
+			tables = append(tables, p.tables[:i]...)
+			p.tables = append(tables, p.tables[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// lookupTables returns the tables applied, in order of name, which the
+// caller must not change.
+func (p *Pipeline) lookupTables() []*LookupTable {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.tables
+}
+
+// LookupTables describes the tables applied by the pipeline, ordered by name.
+func (p *Pipeline) LookupTables() []LookupTableInfo {
+	tables := p.lookupTables()
+	infos := make([]LookupTableInfo, 0, len(tables))
+	for _, t := range tables {
+		infos = append(infos, LookupTableInfo{
+			Name:     t.Name,
+			Key:      t.Key,
+			Path:     t.Path,
+			Rows:     len(t.rows),
+			Modified: t.modTime,
+		})
+	}
+	return infos
+}
+
+// ReloadLookupTables reloads the tables whose file changed since loaded. A
+// table failing to reload keeps its rows, the first failure being returned.
+func (p *Pipeline) ReloadLookupTables() error {
+	var firstErr error
+	for _, t := range p.lookupTables() {
+		fi, err := os.Stat(t.Path)
+		if err == nil && fi.ModTime().Equal(t.modTime) && fi.Size() == t.size {
+			continue
+		}
+		reloaded := &LookupTable{Name: t.Name, Key: t.Key, Path: t.Path}
+		if err == nil {
+			err = reloaded.load()
+		}
+		if err != nil {
+			stats.Add("lookupReloadError", 1)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		// Enrich reads the slice unlocked, so it is replaced, not changed.
+		p.mu.Lock()
+		replaced := make([]*LookupTable, len(p.tables))
+		for i, old := range p.tables {
+			if replaced[i] = old; old == t {
+				replaced[i] = reloaded
+			}
+		}
+		p.tables = replaced
+		p.mu.Unlock()
+	}
+	return firstErr
+}
+
+// Enrich adds the fields of the rows the event fields have in the lookup
+// tables, fields already set being left unchanged, then rewrites the severity
+// by the severity rules of the pipeline.
+func (p *Pipeline) Enrich(fields map[string]interface{}) {
+	for _, t := range p.lookupTables() {
+		v, ok := fields[t.Key]
+		if !ok || v == nil {
+			continue
+		}
+		value, ok := v.(string)
+		if !ok {
+			value = fmt.Sprint(v)
+		}
+		for field, fv := range t.lookup(value) {
+			if _, ok := fields[field]; !ok {
+				fields[field] = fv
+			}
+		}
+	}
+	p.rewriteSeverity(fields)
+}
@@ -0,0 +1,99 @@
+package input
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLookupTables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ekanite-lookup-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dcPath := filepath.Join(dir, "dc.csv")
+	write := func(path, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write lookup table: %s", err)
+		}
+	}
+	write(dcPath, "address,dc,rack\n10.1.0.0/16,ams1,\n10.1.2.0/24,ams1,r2\n192.168.0.9,lab,r0\n")
+	ownersPath := filepath.Join(dir, "owners.json")
+	write(ownersPath, `[{"host": "web1", "owner": "web", "oncall": 3}]`)
+
+	tables, err := ParseLookupTables("dc:address:" + dcPath + ", owners:host:" + ownersPath)
+	if err != nil {
+		t.Fatalf("failed to load lookup tables: %s", err)
+	}
+	p := NewPipeline(PipelineSettings{})
+	for _, table := range tables {
+		p.SetLookupTable(table)
+	}
+	if infos := p.LookupTables(); len(infos) != 2 || infos[0].Name != "dc" || infos[0].Rows != 3 || infos[1].Rows != 1 {
+		t.Fatalf("wrong lookup tables in use: %+v", infos)
+	}
+
+	for _, tt := range []struct {
+		fields   map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			fields:   map[string]interface{}{"address": "10.1.2.3:5514", "host": "web1"},
+			expected: map[string]interface{}{"dc": "ams1", "rack": "r2", "owner": "web", "oncall": float64(3)},
+		},
+		{
+			fields:   map[string]interface{}{"address": "10.1.9.9:5514"},
+			expected: map[string]interface{}{"dc": "ams1"},
+		},
+		{
+			fields:   map[string]interface{}{"address": "192.168.0.9", "dc": "parsed"},
+			expected: map[string]interface{}{"dc": "parsed", "rack": "r0"},
+		},
+		{
+			fields:   map[string]interface{}{"address": "172.16.0.1:5514", "host": "db1"},
+			expected: map[string]interface{}{},
+		},
+	} {
+		n := len(tt.fields)
+		if _, ok := tt.fields["dc"]; ok {
+			n--
+		}
+		p.Enrich(tt.fields)
+		for field, value := range tt.expected {
+			if tt.fields[field] != value {
+				t.Errorf("enriched %s with %s %v, expected %v", tt.fields["address"], field, tt.fields[field], value)
+			}
+		}
+		if len(tt.fields) != n+len(tt.expected) {
+			t.Errorf("enriched %s with unexpected fields: %v", tt.fields["address"], tt.fields)
+		}
+	}
+
+	// Changed files are reloaded, broken ones keep the rows last loaded.
+	write(dcPath, "address,dc\n10.1.0.0/16,fra2\n")
+	os.Chtimes(dcPath, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	write(ownersPath, `{"web1": "web"}`)
+	os.Chtimes(ownersPath, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if err := p.ReloadLookupTables(); err == nil {
+		t.Error("reloading broken lookup table did not fail")
+	}
+	fields := map[string]interface{}{"address": "10.1.2.3:5514", "host": "web1"}
+	p.Enrich(fields)
+	if fields["dc"] != "fra2" || fields["owner"] != "web" {
+		t.Errorf("lookup tables not reloaded as expected: %v", fields)
+	}
+
+	if !p.RemoveLookupTable("owners") || p.RemoveLookupTable("owners") {
+		t.Error("lookup table not removed once")
+	}
+	if _, err := ParseLookupTables("dc:address"); err == nil {
+		t.Error("lookup table without path did not fail")
+	}
+	if _, err := LoadLookupTable("dc", "ip", dcPath); err == nil {
+		t.Error("lookup table without key column did not fail")
+	}
+}
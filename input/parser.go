@@ -42,16 +42,16 @@ func ValidFormat(format string) bool {
 	return false
 }
 
-// A Parser parses the raw input as a map with a timestamp field.
+// A LogParser parses the raw input as a map with a timestamp field. It holds
+// no per-message state, so a single LogParser may be used concurrently by
+// multiple goroutines.
 type LogParser struct {
-	fmt    string
-	Raw    []byte
-	Result map[string]interface{}
+	fmt string
 	//rfc5424 *RFC5424
 	formatByAddress map[string]func() Parser
 }
 
-// NewParser returns a new Parser instance.
+// NewLogParser returns a new LogParser instance.
 func NewLogParser(f string) (*LogParser, error) {
 	if !ValidFormat(f) {
 		return nil, fmt.Errorf("%s is not a valid format", f)
@@ -77,28 +77,29 @@ func (p *LogParser) detectFmt(f string) {
 	return
 }
 
-// Parse the given byte slice.
-func (p *LogParser) Parse(address string, b []byte) {
-	//p.Result = map[string]interface{}{}
-	p.Raw = b
+// Parse parses the given byte slice, received from address. If the line
+// cannot be parsed, the returned map still holds the whole line as the
+// message, and the parse error is returned alongside it.
+func (p *LogParser) Parse(address string, b []byte) (map[string]interface{}, error) {
 	var r Parser
-
 	if format := p.formatByAddress[address]; format != nil {
 		r = format()
 	} else {
 		r = CreateParser(p.fmt)
 	}
-	p.Result, err = r.Parse(b)
+
+	result, err := r.Parse(b)
 	if err != nil {
-		p.Result = map[string]interface{}{
+		return map[string]interface{}{
 			"priority":  0,
 			"facility":  0,
 			"severity":  0,
 			"version":   NO_VERSION,
 			"timestamp": time.Now(),
 			"message":   string(b),
-		}
+		}, err
 	}
+	return result, nil
 }
 
 type Parser interface {
@@ -322,6 +323,9 @@ func ParseHostname(bs []byte) ([]byte, string) {
 			break
 		}
 	}
+	if to >= len(bs) {
+		return bs[to:], ""
+	}
 	if bs[to] == '-' {
 		return bytes.TrimSpace(bs[to+1:]), ""
 	}
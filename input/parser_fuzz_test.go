@@ -0,0 +1,99 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// malformedLines are inputs which used to index past the end of the parsers'
+// buffers.
+var malformedLines = []string{
+	"",
+	" ",
+	"   ",
+	`"`,
+	`""`,
+	`"<`,
+	`"<1>"`,
+	"<1>",
+	"<1>1",
+	"<1>1 ",
+	"<1>1  ",
+	"<1>1 2003",
+	"<1>1 2003-",
+	"<1>1 2003-10",
+	"<1>1 2003-10-11",
+	"<1>1 2003-10-11T",
+	"<1>1 2003-10-11T22",
+	"<1>1 2003-10-11T22:14",
+	"<1>1 2003-10-11T22:14:15",
+	"<1>1 2003-10-11T22:14:15+",
+	"<1>1 2003-10-11T22:14:15+01",
+	"<1>1 2003-10-11T22:14:15.003Z",
+	"<1>1 2003-10-11T22:14:15.003Z   ",
+	"<34>Oct 11 22:14:15",
+	"<34>Oct 11 22:14:15 ",
+	"<34>Oct 11 22:14:15    ",
+	"<34>Oct 11 22:14:15 mymachine",
+}
+
+func TestParsers_Malformed(t *testing.T) {
+	for _, format := range fmtsByStandard {
+		p, err := NewLogParser(format)
+		if err != nil {
+			t.Fatalf("failed to create %s parser: %s", format, err.Error())
+		}
+		for _, line := range malformedLines {
+			func() {
+				defer func() {
+					if o := recover(); o != nil {
+						t.Errorf("%s parser panicked on %q: %v", format, line, o)
+					}
+				}()
+				if result, _ := p.Parse("", []byte(line)); result == nil {
+					t.Errorf("%s parser returned no result for %q", format, line)
+				}
+			}()
+		}
+	}
+}
+
+func TestParseHostname_Spaces(t *testing.T) {
+	next, host := ParseHostname([]byte("   "))
+	if len(next) != 0 || host != "" {
+		t.Fatalf("parsed %q, %q from spaces, expected nothing", next, host)
+	}
+}
+
+func TestRFC6587ScannerSplit_InvalidLength(t *testing.T) {
+	for _, frame := range []string{"-5 abc", "9223372036854775807 abc"} {
+		scanner := bufio.NewScanner(bytes.NewBufferString(frame))
+		scanner.Split(rfc6587ScannerSplit)
+		for scanner.Scan() {
+			t.Fatalf("scanned frame %q from %q", scanner.Text(), frame)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, line := range malformedLines {
+		f.Add([]byte(line))
+	}
+	f.Add([]byte("<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\"] An application event"))
+	f.Add([]byte("<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8"))
+	f.Add([]byte(`"<34>Oct 11 22:14:15 mymachine su: quoted"`))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		for _, format := range fmtsByStandard {
+			if result, _ := CreateParser(format).Parse(b); result == nil {
+				t.Fatalf("%s parser returned no result for %q", format, b)
+			}
+		}
+		ParseHostname(b)
+		ParseTag(b)
+		ParseTimestamp(b)
+		parseStructuredData(b)
+		rfc6587ScannerSplit(b, true)
+	})
+}
@@ -13,7 +13,7 @@ type rfc3164 struct {
 func (self *rfc3164) Parse(bs []byte) (map[string]interface{}, error) {
 	next, pri, err := ParsePriority(bs)
 	if err != nil {
-		if '"' == bs[0] {
+		if len(bs) > 0 && '"' == bs[0] {
 			next, pri, err = ParsePriority(bs[1:]) // p.parsePriority()
 			if err != nil {
 				txt := string(bs)
@@ -27,7 +27,7 @@ func (self *rfc3164) Parse(bs []byte) (map[string]interface{}, error) {
 				}, nil
 			}
 
-			if bs[len(bs)-1] == '"' && next[len(next)-1] == '"' {
+			if len(next) > 0 && bs[len(bs)-1] == '"' && next[len(next)-1] == '"' {
 				next = next[:len(next)-1]
 			}
 		}
@@ -47,13 +47,12 @@ func (self *rfc3164) Parse(bs []byte) (map[string]interface{}, error) {
 		}
 	}
 	content := bytes.TrimSpace(next)
-	result := map[string]interface{}{
-		"priority": pri.P,
-		"facility": pri.F.Value,
-		"severity": pri.S.Value,
-		"version":  NO_VERSION,
-		"message":  string(content),
-	}
+	result := newResult()
+	result["priority"] = pri.P
+	result["facility"] = pri.F.Value
+	result["severity"] = pri.S.Value
+	result["version"] = NO_VERSION
+	result["message"] = string(content)
 	if "" != hostname {
 		result["host"] = hostname
 	}
@@ -15,19 +15,20 @@ const (
 )
 
 var (
-	ErrYearInvalid       = &ParserError{"Invalid year in timestamp"}
-	ErrMonthInvalid      = &ParserError{"Invalid month in timestamp"}
-	ErrDayInvalid        = &ParserError{"Invalid day in timestamp"}
-	ErrHourInvalid       = &ParserError{"Invalid hour in timestamp"}
-	ErrMinuteInvalid     = &ParserError{"Invalid minute in timestamp"}
-	ErrSecondInvalid     = &ParserError{"Invalid second in timestamp"}
-	ErrSecFracInvalid    = &ParserError{"Invalid fraction of second in timestamp"}
-	ErrTimeZoneInvalid   = &ParserError{"Invalid time zone in timestamp"}
-	ErrInvalidTimeFormat = &ParserError{"Invalid time format"}
-	ErrInvalidAppName    = &ParserError{"Invalid app name"}
-	ErrInvalidProcId     = &ParserError{"Invalid proc ID"}
-	ErrInvalidMsgId      = &ParserError{"Invalid msg ID"}
-	ErrNoStructuredData  = &ParserError{"No structured data"}
+	ErrYearInvalid        = &ParserError{"Invalid year in timestamp"}
+	ErrMonthInvalid       = &ParserError{"Invalid month in timestamp"}
+	ErrDayInvalid         = &ParserError{"Invalid day in timestamp"}
+	ErrHourInvalid        = &ParserError{"Invalid hour in timestamp"}
+	ErrMinuteInvalid      = &ParserError{"Invalid minute in timestamp"}
+	ErrSecondInvalid      = &ParserError{"Invalid second in timestamp"}
+	ErrSecFracInvalid     = &ParserError{"Invalid fraction of second in timestamp"}
+	ErrTimeZoneInvalid    = &ParserError{"Invalid time zone in timestamp"}
+	ErrInvalidTimeFormat  = &ParserError{"Invalid time format"}
+	ErrInvalidAppName     = &ParserError{"Invalid app name"}
+	ErrInvalidProcId      = &ParserError{"Invalid proc ID"}
+	ErrInvalidMsgId       = &ParserError{"Invalid msg ID"}
+	ErrNoStructuredData   = &ParserError{"No structured data"}
+	ErrInvalidFrameLength = &ParserError{"Invalid frame length"}
 )
 
 // RFC5424V2 represents a parser for RFC5424V2-compliant log messages
@@ -80,7 +81,7 @@ type rfc5424 struct {
 func (p *rfc5424) Parse(bs []byte) (map[string]interface{}, error) {
 	next, pri, err := ParsePriority(bs)
 	if err != nil {
-		if '"' == bs[0] {
+		if len(bs) > 0 && '"' == bs[0] {
 			next, pri, err = ParsePriority(bs[1:]) // p.parsePriority()
 			if err != nil {
 				txt := string(bs)
@@ -94,7 +95,7 @@ func (p *rfc5424) Parse(bs []byte) (map[string]interface{}, error) {
 				}, nil
 			}
 
-			if bs[len(bs)-1] == '"' && next[len(next)-1] == '"' {
+			if len(next) > 0 && bs[len(bs)-1] == '"' && next[len(next)-1] == '"' {
 				next = next[:len(next)-1]
 			}
 		}
@@ -125,7 +126,7 @@ func (p *rfc5424) Parse(bs []byte) (map[string]interface{}, error) {
 
 	//result := sd
 	//if result == nil {
-	result := map[string]interface{}{}
+	result := newResult()
 	//}
 	result["priority"] = pri.P
 	result["facility"] = pri.F.Value
@@ -153,6 +154,9 @@ func (p *rfc5424) parseTimestamp(bs []byte) ([]byte, time.Time, error) {
 			break
 		}
 	}
+	if to >= len(bs) {
+		return bs, ts, nil
+	}
 	if bs[to] == '-' {
 		return bs[to+1:], ts, nil
 	}
@@ -165,7 +169,7 @@ func (p *rfc5424) parseTimestamp(bs []byte) ([]byte, time.Time, error) {
 
 		return bs, ts, err
 	}
-	if next[0] != 'T' {
+	if len(next) == 0 || next[0] != 'T' {
 		return bs, ts, ErrInvalidTimeFormat
 	}
 	next, ft, err := parseFullTime(next[1:])
@@ -242,7 +246,7 @@ func parseFullDate(bs []byte) ([]byte, fullDate, error) {
 	if err != nil {
 		return bs, fd, err
 	}
-	if next[0] != '-' {
+	if len(next) == 0 || next[0] != '-' {
 		//fmt.Println(string(next))
 		return bs, fd, ErrTimestampUnknownFormat
 	}
@@ -251,7 +255,7 @@ func parseFullDate(bs []byte) ([]byte, fullDate, error) {
 		//fmt.Println(string(next))
 		return bs, fd, err
 	}
-	if next[0] != '-' {
+	if len(next) == 0 || next[0] != '-' {
 		//fmt.Println(string(next))
 		return bs, fd, ErrTimestampUnknownFormat
 	}
@@ -323,7 +327,7 @@ func parsePartialTime(bs []byte) ([]byte, partialTime, error) {
 		//fmt.Println("===========1", string(bs), err)
 		return bs, pt, err
 	}
-	if next[0] != ':' {
+	if len(next) == 0 || next[0] != ':' {
 		//fmt.Println("===========2", string(next), err)
 		return bs, pt, ErrInvalidTimeFormat
 	}
@@ -332,7 +336,7 @@ func parsePartialTime(bs []byte) ([]byte, partialTime, error) {
 		//fmt.Println("===========3", string(next), err)
 		return bs, pt, err
 	}
-	if next[0] != ':' {
+	if len(next) == 0 || next[0] != ':' {
 		//fmt.Println("===========4", string(next), err)
 		return bs, pt, ErrInvalidTimeFormat
 	}
@@ -346,7 +350,7 @@ func parsePartialTime(bs []byte) ([]byte, partialTime, error) {
 		minute:  minute,
 		seconds: seconds,
 	}
-	if next[0] != '.' {
+	if len(next) == 0 || next[0] != '.' {
 		return next, pt, nil
 	}
 	next, secFrac, err := parseSecFrac(next[1:])
@@ -396,6 +400,9 @@ func parseSecFrac(bs []byte) ([]byte, float64, error) {
 
 // TIME-OFFSET = "Z" / TIME-NUMOFFSET
 func parseTimeOffset(bs []byte) ([]byte, *time.Location, error) {
+	if len(bs) == 0 {
+		return bs, time.UTC, ErrTimeZoneInvalid
+	}
 	if bs[0] == 'Z' {
 		if len(bs) >= 2 && unicode.IsSpace(rune(bs[1])) {
 			return bs[2:], time.UTC, nil
@@ -407,14 +414,14 @@ func parseTimeOffset(bs []byte) ([]byte, *time.Location, error) {
 
 // TIME-NUMOFFSET  = ("+" / "-") TIME-HOUR ":" TIME-MINUTE
 func parseNumericalTimeOffset(bs []byte) ([]byte, *time.Location, error) {
-	if (bs[0] != '+') && (bs[0] != '-') {
+	if len(bs) == 0 || (bs[0] != '+') && (bs[0] != '-') {
 		return bs, time.UTC, ErrTimeZoneInvalid
 	}
 	next, hour, err := parseHour(bs[1:])
 	if err != nil {
 		return bs, time.UTC, err
 	}
-	if next[0] != ':' {
+	if len(next) == 0 || next[0] != ':' {
 		return bs, time.UTC, ErrInvalidTimeFormat
 	}
 	next, minute, err := parseMinute(next[1:])
@@ -501,8 +508,12 @@ func rfc6587ScannerSplit(data []byte, atEOF bool) (advance int, token []byte, er
 		if err != nil {
 			return 0, nil, err
 		}
-		end := length + i + 1
-		if len(data) >= end {
+		if length < 0 {
+			return 0, nil, ErrInvalidFrameLength
+		}
+		// Compare before adding, so that huge lengths cannot overflow.
+		if length <= len(data)-i-1 {
+			end := length + i + 1
 			//Return the frame with the length removed
 			return end, data[i+1 : end], nil
 		}
@@ -2,7 +2,9 @@ package input
 
 import (
 	"bytes"
+	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -440,6 +442,47 @@ func Test_Parsing(t *testing.T) {
 		AssertDeepEquals(t, "", result, tt.expected)
 	}
 }
+func TestLogParser_Concurrent(t *testing.T) {
+	p, err := NewLogParser("rfc5424")
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err.Error())
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				host := fmt.Sprintf("host%d", i)
+				line := fmt.Sprintf("<134>1 2003-08-24T05:14:15.000003-07:00 %s sshd 8710 - message %d", host, n)
+				result, err := p.Parse("127.0.0.1", []byte(line))
+				if err != nil {
+					t.Errorf("failed to parse '%s': %s", line, err.Error())
+					return
+				}
+				if result["host"] != host {
+					t.Errorf("parsed host of '%s', got %v, expected %s", line, result["host"], host)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestLogParser_Fallback(t *testing.T) {
+	p, err := NewLogParser("rfc5424")
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err.Error())
+	}
+
+	result, _ := p.Parse("127.0.0.1", []byte("not a syslog line"))
+	if result["message"] != "not a syslog line" {
+		t.Fatalf("fallback message, got %v, expected whole line", result["message"])
+	}
+}
+
 func Benchmark_Parsing(b *testing.B) {
 	p := CreateParser("syslog")
 	for n := 0; n < b.N; n++ {
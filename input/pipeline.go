@@ -0,0 +1,119 @@
+package input
+
+import (
+	"sync"
+	"time"
+)
+
+// Pipeline coerces and enriches the fields parsed from events before they are
+// sent to be indexed. The collectors, listeners and backfills of a process
+// share one, which may be reconfigured while they run, and which numbers
+// their events. A nil Pipeline only coerces priority, facility and severity to
+// int, numbers events by the clock, and does not track senders or parse
+// quality.
+//
+// Lookup tables are set and removed apart from the other settings, so that
+// reconfiguring the pipeline keeps them.
+type Pipeline struct {
+	// QualityWindow is the number of messages of each sender whose parse
+	// quality is judged together, 0 not to track parse quality. QualityDrift
+	// is by how much the fraction of them poorly parsed may rise above the
+	// baseline of the sender before an event is raised. Both are set before
+	// the pipeline is used.
+	QualityWindow int
+	QualityDrift  float64
+
+	mu     sync.RWMutex
+	types  map[string]FieldType
+	rules  []*SeverityRule
+	tables []*LookupTable // Applied in order of name.
+
+	qualityMu sync.Mutex
+	qualities map[string]*sourceQuality
+
+	sendersMu    sync.Mutex
+	senders      map[string]*SenderStats
+	sendersSince time.Time
+
+	sequence *sequence
+}
+
+// PipelineSettings are the settings of a Pipeline which may change while it
+// runs.
+type PipelineSettings struct {
+	// FieldTypes are the fields coerced besides priority, facility and
+	// severity, which are coerced to int unless FieldTypes has them.
+	FieldTypes map[string]FieldType
+
+	// SeverityRules rewrite the severity of the events, the first matching
+	// an event applying.
+	SeverityRules []*SeverityRule
+}
+
+// NewPipeline returns a Pipeline with settings.
+func NewPipeline(settings PipelineSettings) *Pipeline {
+	p := &Pipeline{
+		QualityWindow: DefaultQualityWindow,
+		QualityDrift:  DefaultQualityDrift,
+		qualities:     map[string]*sourceQuality{},
+		senders:       map[string]*SenderStats{},
+		sendersSince:  time.Now(),
+		sequence:      newSequence(),
+	}
+	p.Reconfigure(settings)
+	return p
+}
+
+// Reconfigure replaces the settings of the pipeline, the events in flight
+// being processed with either. Fields coerced before and not in the field
+// types of settings are no longer coerced.
+func (p *Pipeline) Reconfigure(settings PipelineSettings) {
+	types := make(map[string]FieldType, len(defaultFieldTypes)+len(settings.FieldTypes))
+	for field, typ := range defaultFieldTypes {
+		types[field] = typ
+	}
+	for field, typ := range settings.FieldTypes {
+		types[field] = typ
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.types = types
+	p.rules = settings.SeverityRules
+}
+
+// OpenSequence makes the sequence numbers of the events persistent, using the
+// file at path, so that they keep increasing across restarts.
+func (p *Pipeline) OpenSequence(path string) error {
+	return p.sequence.open(path)
+}
+
+// NextSequence returns the sequence number of the next event. It fails while
+// no more numbers can be reserved on disk, the event then being dropped
+// rather than risking its DocID colliding with one issued after a restart.
+func (p *Pipeline) NextSequence() (int64, error) {
+	if p == nil {
+		return time.Now().UnixNano(), nil
+	}
+	return p.sequence.next()
+}
+
+// fieldTypes returns the fields coerced, which the caller must not change.
+func (p *Pipeline) fieldTypes() map[string]FieldType {
+	if p == nil {
+		return defaultFieldTypes
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.types
+}
+
+// severityRules returns the severity rules applied.
+func (p *Pipeline) severityRules() []*SeverityRule {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.rules
+}
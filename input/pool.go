@@ -0,0 +1,63 @@
+package input
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+const readerBufSize = 4096
+
+var (
+	eventPool = sync.Pool{
+		New: func() interface{} { return new(Event) },
+	}
+	resultPool = sync.Pool{
+		New: func() interface{} { return make(map[string]interface{}, 16) },
+	}
+	readerPool = sync.Pool{
+		New: func() interface{} { return bufio.NewReaderSize(nil, readerBufSize) },
+	}
+)
+
+// NewEvent returns an empty Event, reusing a released one if available.
+func NewEvent() *Event {
+	return eventPool.Get().(*Event)
+}
+
+// Release returns the event, and its parsed fields, to the pool. It is called
+// by the Batcher once the event has been indexed, and the event must not be
+// used afterwards.
+func (e *Event) Release() {
+	if e.Parsed != nil {
+		releaseResult(e.Parsed)
+	}
+	*e = Event{}
+	eventPool.Put(e)
+}
+
+// newResult returns an empty map for parsed fields.
+func newResult() map[string]interface{} {
+	return resultPool.Get().(map[string]interface{})
+}
+
+// releaseResult empties the map and returns it to the pool.
+func releaseResult(m map[string]interface{}) {
+	for k := range m {
+		delete(m, k)
+	}
+	resultPool.Put(m)
+}
+
+// getReader returns a buffered reader for r, reusing a released one if available.
+func getReader(r io.Reader) *bufio.Reader {
+	br := readerPool.Get().(*bufio.Reader)
+	br.Reset(r)
+	return br
+}
+
+// putReader returns the buffered reader to the pool.
+func putReader(br *bufio.Reader) {
+	br.Reset(nil)
+	readerPool.Put(br)
+}
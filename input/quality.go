@@ -0,0 +1,191 @@
+package input
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Defaults of the parse quality settings of a Pipeline.
+const (
+	DefaultQualityWindow = 500
+	DefaultQualityDrift  = 0.2
+)
+
+// qualityBaselineWeight is the weight of the last window of a source in the
+// baseline of its parse quality, so that a lasting change becomes the new
+// baseline after a few windows, rather than being reported forever.
+const qualityBaselineWeight = 0.25
+
+// ParseQualityEvent is the message ID of the events raised when the parse
+// quality of a source degrades, which continuous queries and subscriptions
+// can match with a term filter on message_id.
+const ParseQualityEvent = "parse_quality_degraded"
+
+// ParseQuality is how well the messages of a sender address were parsed. A
+// message is poorly parsed if it fell back to being kept whole, or it has no
+// host or no timestamp.
+type ParseQuality struct {
+	Address         string    `json:"address"`
+	Events          int64     `json:"events"`
+	Fallbacks       int64     `json:"fallbacks"`
+	EmptyHosts      int64     `json:"empty_hosts"`
+	EmptyTimestamps int64     `json:"empty_timestamps"`
+	Poor            float64   `json:"poor"`     // Fraction of the last window poorly parsed.
+	Baseline        float64   `json:"baseline"` // Fraction of the windows before it poorly parsed.
+	Degraded        bool      `json:"degraded"`
+	DegradedAt      time.Time `json:"degraded_at,omitempty"`
+}
+
+// sourceQuality is the parse quality of a source, with the counts of its
+// current window.
+type sourceQuality struct {
+	ParseQuality
+	windows int
+	window  struct {
+		events, poor, fallbacks, emptyHosts, emptyTimestamps int64
+	}
+}
+
+// countQuality accounts for the fields parsed from a message of address,
+// which fell back to being kept whole if err is set. It returns the event to
+// send on with the message if the message completes a window whose parse
+// quality degraded, nil otherwise.
+func (p *Pipeline) countQuality(address string, parsed map[string]interface{}, err error) *Event {
+	if p == nil || p.QualityWindow <= 0 {
+		return nil
+	}
+	p.qualityMu.Lock()
+	defer p.qualityMu.Unlock()
+	q := p.qualities[address]
+	if q == nil {
+		if len(p.qualities) >= MaxSenders {
+			return nil
+		}
+		q = &sourceQuality{ParseQuality: ParseQuality{Address: address}}
+		p.qualities[address] = q
+	}
+
+	fallback, noHost, noTimestamp := err != nil, emptyHost(parsed), emptyTimestamp(parsed)
+	w := &q.window
+	w.events++
+	q.Events++
+	if fallback {
+		w.fallbacks++
+		q.Fallbacks++
+	}
+	if noHost {
+		w.emptyHosts++
+		q.EmptyHosts++
+	}
+	if noTimestamp {
+		w.emptyTimestamps++
+		q.EmptyTimestamps++
+	}
+	if fallback || noHost || noTimestamp {
+		w.poor++
+	}
+	if w.events < int64(p.QualityWindow) {
+		return nil
+	}
+
+	// The window is complete, judge it against the windows before.
+	var drift *Event
+	q.Poor = float64(w.poor) / float64(w.events)
+	switch {
+	case q.windows == 0:
+		q.Baseline = q.Poor
+	case q.Poor-q.Baseline > p.QualityDrift:
+		if !q.Degraded {
+			q.Degraded, q.DegradedAt = true, time.Now().UTC()
+			stats.Add("parseQualityDegraded", 1)
+			if sequence, err := p.NextSequence(); err == nil {
+				drift = qualityEvent(q, sequence)
+			}
+		}
+		q.Baseline += qualityBaselineWeight * (q.Poor - q.Baseline)
+	default:
+		q.Degraded = false
+		q.Baseline += qualityBaselineWeight * (q.Poor - q.Baseline)
+	}
+	q.windows++
+	w.events, w.poor, w.fallbacks, w.emptyHosts, w.emptyTimestamps = 0, 0, 0, 0, 0
+	return drift
+}
+
+// qualityEvent returns the event, numbered sequence, reporting that the parse
+// quality of q degraded in its last window.
+func qualityEvent(q *sourceQuality, sequence int64) *Event {
+	w := q.window
+	now := time.Now().UTC()
+	e := NewEvent()
+	e.Text = fmt.Sprintf("parse quality of %s degraded: %.0f%% of its last %d messages poorly parsed, up from %.0f%%",
+		q.Address, 100*q.Poor, w.events, 100*q.Baseline)
+	e.ReceptionTime = now
+	e.Sequence = sequence
+	e.SourceIP = q.Address
+	e.Parsed = map[string]interface{}{
+		"timestamp":             now,
+		"reception":             now,
+		"message":               e.Text,
+		"app":                   "ekanite",
+		"message_id":            ParseQualityEvent,
+		"severity":              4, // Warning.
+		"address":               q.Address,
+		"poor_ratio":            q.Poor,
+		"baseline_ratio":        q.Baseline,
+		"fallback_ratio":        float64(w.fallbacks) / float64(w.events),
+		"empty_host_ratio":      float64(w.emptyHosts) / float64(w.events),
+		"empty_timestamp_ratio": float64(w.emptyTimestamps) / float64(w.events),
+	}
+	return e
+}
+
+// emptyHost returns whether the parsed fields have no host.
+func emptyHost(parsed map[string]interface{}) bool {
+	host, _ := parsed["host"].(string)
+	return host == "" || host == "-"
+}
+
+// emptyTimestamp returns whether the parsed fields have no timestamp.
+func emptyTimestamp(parsed map[string]interface{}) bool {
+	switch ts := parsed["timestamp"].(type) {
+	case time.Time:
+		return ts.IsZero()
+	case string:
+		return ts == "" || ts == "-"
+	default:
+		return ts == nil
+	}
+}
+
+// ParseQualities returns the parse quality of the n sources, all of them if n
+// is negative, whose last window was the most poorly parsed, degraded sources
+// first.
+func (p *Pipeline) ParseQualities(n int) []ParseQuality {
+	if p == nil {
+		return []ParseQuality{}
+	}
+	p.qualityMu.Lock()
+	list := make([]ParseQuality, 0, len(p.qualities))
+	for _, q := range p.qualities {
+		if q.windows > 0 {
+			list = append(list, q.ParseQuality)
+		}
+	}
+	p.qualityMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Degraded != list[j].Degraded {
+			return list[i].Degraded
+		}
+		if list[i].Poor != list[j].Poor {
+			return list[i].Poor > list[j].Poor
+		}
+		return list[i].Address < list[j].Address
+	})
+	if n >= 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
@@ -0,0 +1,78 @@
+package input
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCountQuality(t *testing.T) {
+	p := NewPipeline(PipelineSettings{})
+	p.QualityWindow, p.QualityDrift = 10, 0.2
+
+	const address = "192.0.2.10"
+	good := func() map[string]interface{} {
+		return map[string]interface{}{"host": "fw1", "timestamp": time.Now()}
+	}
+	send := func(n, poor int) []*Event {
+		var events []*Event
+		for i := 0; i < n; i++ {
+			parsed, err := good(), error(nil)
+			switch {
+			case i >= poor:
+			case i%3 == 0:
+				err = errors.New("bad")
+			case i%3 == 1:
+				parsed["host"] = "-"
+			default:
+				delete(parsed, "timestamp")
+			}
+			if e := p.countQuality(address, parsed, err); e != nil {
+				events = append(events, e)
+			}
+		}
+		return events
+	}
+
+	// The first windows set the baseline.
+	if events := send(20, 1); len(events) != 0 {
+		t.Fatalf("baseline windows raised %d events", len(events))
+	}
+	events := send(10, 6)
+	if len(events) != 1 {
+		t.Fatalf("degraded window raised %d events", len(events))
+	}
+	e := events[0]
+	if e.Parsed["message_id"] != ParseQualityEvent || e.Parsed["address"] != address || e.Parsed["poor_ratio"] != 0.6 {
+		t.Fatalf("degradation event has fields %v", e.Parsed)
+	}
+	if e.Parsed["fallback_ratio"] != 0.2 || e.Parsed["empty_host_ratio"] != 0.2 || e.Parsed["empty_timestamp_ratio"] != 0.2 {
+		t.Fatalf("degradation event has ratios %v", e.Parsed)
+	}
+	if events := send(10, 6); len(events) != 0 {
+		t.Fatalf("lasting degradation raised %d more events", len(events))
+	}
+
+	quality := func() ParseQuality {
+		for _, q := range p.ParseQualities(-1) {
+			if q.Address == address {
+				return q
+			}
+		}
+		t.Fatal("source parse quality not tracked")
+		return ParseQuality{}
+	}
+	if q := quality(); !q.Degraded || q.Events != 40 || q.Fallbacks != 5 || q.Poor != 0.6 {
+		t.Fatalf("source has parse quality %+v", q)
+	}
+
+	// A source recovers once its windows are back within drift of the
+	// baseline, and can degrade again.
+	send(10, 0)
+	if q := quality(); q.Degraded {
+		t.Fatalf("recovered source still degraded: %+v", q)
+	}
+	if events := send(10, 10); len(events) != 1 {
+		t.Fatalf("second degradation raised %d events", len(events))
+	}
+}
@@ -0,0 +1,135 @@
+package input
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is returned when ingesting would take a tenant over one of
+// its quotas.
+var ErrQuotaExceeded = errors.New("ingest quota exceeded")
+
+// QuotaLimits bounds the events and bytes a tenant may ingest per hour and per
+// day. Zero means no limit.
+type QuotaLimits struct {
+	HourlyEvents int64 `json:"hourly_events"`
+	HourlyBytes  int64 `json:"hourly_bytes"`
+	DailyEvents  int64 `json:"daily_events"`
+	DailyBytes   int64 `json:"daily_bytes"`
+}
+
+// QuotaUsage is what a tenant ingested in the current hour and day.
+type QuotaUsage struct {
+	Tenant       string      `json:"tenant"`
+	Limits       QuotaLimits `json:"limits"`
+	Hour         time.Time   `json:"hour"`
+	HourlyEvents int64       `json:"hourly_events"`
+	HourlyBytes  int64       `json:"hourly_bytes"`
+	Day          time.Time   `json:"day"`
+	DailyEvents  int64       `json:"daily_events"`
+	DailyBytes   int64       `json:"daily_bytes"`
+	Rejected     int64       `json:"rejected"`
+}
+
+// Quotas tracks the ingestion of tenants against their quotas. It is safe for
+// concurrent use.
+type Quotas struct {
+	mu     sync.Mutex
+	def    QuotaLimits
+	limits map[string]QuotaLimits
+	usage  map[string]*QuotaUsage
+}
+
+// NewQuotas returns Quotas applying def to tenants without limits of their own.
+func NewQuotas(def QuotaLimits) *Quotas {
+	return &Quotas{
+		def:    def,
+		limits: map[string]QuotaLimits{},
+		usage:  map[string]*QuotaUsage{},
+	}
+}
+
+// SetLimits sets the quotas of tenant.
+func (q *Quotas) SetLimits(tenant string, limits QuotaLimits) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limits[tenant] = limits
+}
+
+// Allow accounts for tenant ingesting events totalling bytes, returning
+// ErrQuotaExceeded, and accounting for nothing, if that would exceed one of
+// its quotas.
+func (q *Quotas) Allow(tenant string, events, bytes int64) error {
+	return q.allow(tenant, events, bytes, time.Now())
+}
+
+func (q *Quotas) allow(tenant string, events, bytes int64, now time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u := q.usage[tenant]
+	if u == nil {
+		u = &QuotaUsage{Tenant: tenant}
+		q.usage[tenant] = u
+	}
+	u.roll(now)
+
+	l := q.limitsOf(tenant)
+	if exceeds(u.HourlyEvents+events, l.HourlyEvents) || exceeds(u.HourlyBytes+bytes, l.HourlyBytes) ||
+		exceeds(u.DailyEvents+events, l.DailyEvents) || exceeds(u.DailyBytes+bytes, l.DailyBytes) {
+		u.Rejected += events
+		stats.Add("quotaEventsRejected", events)
+		return ErrQuotaExceeded
+	}
+	u.HourlyEvents += events
+	u.HourlyBytes += bytes
+	u.DailyEvents += events
+	u.DailyBytes += bytes
+	return nil
+}
+
+// Usage returns the usage of the tenants which ingested anything, ordered by
+// tenant.
+func (q *Quotas) Usage() []QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	usage := make([]QuotaUsage, 0, len(q.usage))
+	for tenant, u := range q.usage {
+		u.roll(now)
+		v := *u
+		v.Limits = q.limitsOf(tenant)
+		usage = append(usage, v)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Tenant < usage[j].Tenant
+	})
+	return usage
+}
+
+// roll resets the counters of the hour and day which ended before now.
+func (u *QuotaUsage) roll(now time.Time) {
+	if hour := now.Truncate(time.Hour); !hour.Equal(u.Hour) {
+		u.Hour, u.HourlyEvents, u.HourlyBytes = hour, 0, 0
+	}
+	year, month, day := now.Date()
+	if today := time.Date(year, month, day, 0, 0, 0, 0, now.Location()); !today.Equal(u.Day) {
+		u.Day, u.DailyEvents, u.DailyBytes = today, 0, 0
+	}
+}
+
+// limitsOf returns the quotas of tenant. q.mu must be held.
+func (q *Quotas) limitsOf(tenant string) QuotaLimits {
+	if l, ok := q.limits[tenant]; ok {
+		return l
+	}
+	return q.def
+}
+
+// exceeds returns whether n is over limit, 0 meaning no limit.
+func exceeds(n, limit int64) bool {
+	return limit > 0 && n > limit
+}
@@ -0,0 +1,39 @@
+package input
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuotas(t *testing.T) {
+	q := NewQuotas(QuotaLimits{HourlyEvents: 10, DailyBytes: 1000})
+	q.SetLimits("big", QuotaLimits{})
+	now := time.Date(2018, 6, 1, 10, 30, 0, 0, time.UTC)
+
+	if err := q.allow("small", 8, 100, now); err != nil {
+		t.Fatalf("ingest within quota rejected: %s", err.Error())
+	}
+	if err := q.allow("small", 3, 100, now); err != ErrQuotaExceeded {
+		t.Fatalf("ingest over hourly events quota returned %v", err)
+	}
+	if err := q.allow("big", 100, 100000, now); err != nil {
+		t.Fatalf("ingest of unlimited tenant rejected: %s", err.Error())
+	}
+
+	// The hourly quota is reset the next hour, the daily one is not.
+	now = now.Add(time.Hour)
+	if err := q.allow("small", 3, 100, now); err != nil {
+		t.Fatalf("ingest in next hour rejected: %s", err.Error())
+	}
+	if err := q.allow("small", 1, 900, now); err != ErrQuotaExceeded {
+		t.Fatalf("ingest over daily bytes quota returned %v", err)
+	}
+
+	usage := q.Usage()
+	if len(usage) != 2 || usage[0].Tenant != "big" || usage[1].Tenant != "small" {
+		t.Fatalf("usage incorrect: %v", usage)
+	}
+	if u := usage[1]; u.Rejected != 4 || u.Limits.HourlyEvents != 10 {
+		t.Fatalf("usage of small incorrect: %+v", u)
+	}
+}
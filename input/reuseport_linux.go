@@ -0,0 +1,18 @@
+package input
+
+import "syscall"
+
+// soReusePort is SO_REUSEPORT, missing from the syscall package on Linux.
+const soReusePort = 0xf
+
+// reusePort sets SO_REUSEPORT on the socket, so that other processes may bind
+// the same address while it is open.
+func reusePort(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
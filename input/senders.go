@@ -0,0 +1,95 @@
+package input
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MaxSenders is the number of sender addresses whose events are tracked.
+// Events of senders beyond it are only counted in sendersUntracked.
+const MaxSenders = 10000
+
+// Orders of TopSenders.
+const (
+	SenderEvents      = "events"
+	SenderBytes       = "bytes"
+	SenderParseErrors = "parse_errors"
+)
+
+// SenderStats is what a sender address sent through the syslog collectors.
+type SenderStats struct {
+	Address     string    `json:"address"`
+	Events      int64     `json:"events"`
+	Bytes       int64     `json:"bytes"`
+	ParseErrors int64     `json:"parse_errors"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// countSender accounts for address sending an event of n bytes, which failed
+// to be parsed if err is set.
+func (p *Pipeline) countSender(address string, n int, err error) {
+	if p == nil {
+		return
+	}
+	p.sendersMu.Lock()
+	defer p.sendersMu.Unlock()
+	s := p.senders[address]
+	if s == nil {
+		if len(p.senders) >= MaxSenders {
+			stats.Add("sendersUntracked", 1)
+			return
+		}
+		s = &SenderStats{Address: address}
+		p.senders[address] = s
+	}
+	s.Events++
+	s.Bytes += int64(n)
+	if err != nil {
+		s.ParseErrors++
+	}
+	s.LastSeen = time.Now()
+}
+
+// TopSenders returns the n senders, all of them if n is negative, which sent
+// the most events, bytes or parse errors, as given by by, most first, and
+// since when senders are tracked.
+func (p *Pipeline) TopSenders(n int, by string) ([]SenderStats, time.Time, error) {
+	var value func(s *SenderStats) int64
+	switch by {
+	case SenderEvents:
+		value = func(s *SenderStats) int64 { return s.Events }
+	case SenderBytes:
+		value = func(s *SenderStats) int64 { return s.Bytes }
+	case SenderParseErrors:
+		value = func(s *SenderStats) int64 { return s.ParseErrors }
+	default:
+		return nil, time.Time{}, fmt.Errorf("unknown sender order %q", by)
+	}
+
+	if p == nil {
+		return []SenderStats{}, time.Time{}, nil
+	}
+
+	p.sendersMu.Lock()
+	top := make([]SenderStats, 0, len(p.senders))
+	for _, s := range p.senders {
+		if value(s) > 0 {
+			top = append(top, *s)
+		}
+	}
+	since := p.sendersSince
+	p.sendersMu.Unlock()
+
+	sort.Slice(top, func(i, j int) bool {
+		vi, vj := value(&top[i]), value(&top[j])
+		if vi != vj {
+			return vi > vj
+		}
+		return top[i].Address < top[j].Address
+	})
+	if n >= 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top, since, nil
+}
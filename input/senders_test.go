@@ -0,0 +1,37 @@
+package input
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPipeline_TopSenders(t *testing.T) {
+	p := NewPipeline(PipelineSettings{})
+	p.countSender("192.0.2.1", 100, nil)
+	p.countSender("192.0.2.1", 100, nil)
+	p.countSender("192.0.2.2", 1000, errors.New("bad"))
+
+	order := func(by string) []string {
+		top, _, err := p.TopSenders(-1, by)
+		if err != nil {
+			t.Fatalf("failed to rank senders by %s: %s", by, err)
+		}
+		var addresses []string
+		for _, s := range top {
+			addresses = append(addresses, s.Address)
+		}
+		return addresses
+	}
+	if a := order(SenderEvents); len(a) != 2 || a[0] != "192.0.2.1" {
+		t.Errorf("senders by events %v", a)
+	}
+	if a := order(SenderBytes); len(a) != 2 || a[0] != "192.0.2.2" {
+		t.Errorf("senders by bytes %v", a)
+	}
+	if a := order(SenderParseErrors); len(a) != 1 || a[0] != "192.0.2.2" {
+		t.Errorf("senders by parse errors %v", a)
+	}
+	if _, _, err := p.TopSenders(1, "latency"); err == nil {
+		t.Error("senders ranked by unknown order")
+	}
+}
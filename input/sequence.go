@@ -0,0 +1,95 @@
+package input
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sequenceBlock is the number of sequence numbers reserved on disk at a time.
+const sequenceBlock = 1 << 20
+
+// sequence issues the sequence numbers of events, which order the events
+// received at the same time and keep their DocIDs apart.
+type sequence struct {
+	number int64 // Last issued sequence number
+	limit  int64 // Sequence numbers below this are reserved on disk, 0 if not persistent
+
+	mu   sync.Mutex
+	path string
+}
+
+func newSequence() *sequence {
+	return &sequence{number: time.Now().UnixNano()}
+}
+
+// open makes the sequence numbers persistent, using the file at path.
+// Sequence numbers are reserved on disk in blocks, so after a restart, even an
+// unclean one, numbers continue past anything that may have been issued before,
+// and DocIDs of events with identical timestamps cannot collide.
+func (s *sequence) open(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := time.Now().UnixNano()
+	bs, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read sequence file %s: %w", path, err)
+	}
+	if v := strings.TrimSpace(string(bs)); v != "" {
+		last, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse sequence file %s: %w", path, err)
+		}
+		if last > start {
+			start = last
+		}
+	}
+	if current := atomic.LoadInt64(&s.number); current > start {
+		start = current
+	}
+
+	atomic.StoreInt64(&s.number, start)
+	s.path = path
+	return s.reserve(start)
+}
+
+// reserve records on disk that sequence numbers up to a block past from may
+// be issued. Must be called with mu held.
+func (s *sequence) reserve(from int64) error {
+	limit := from + sequenceBlock
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(limit, 10)), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&s.limit, limit)
+	return nil
+}
+
+// next returns the next sequence number. No number past those reserved on
+// disk is issued: while reserving more fails, the error is returned instead,
+// and reserving is tried again by the next call.
+func (s *sequence) next() (int64, error) {
+	n := atomic.AddInt64(&s.number, 1)
+	if limit := atomic.LoadInt64(&s.limit); limit == 0 || n < limit {
+		return n, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n < atomic.LoadInt64(&s.limit) {
+		return n, nil
+	}
+	if err := s.reserve(n); err != nil {
+		stats.Add("sequenceReserveError", 1)
+		return 0, fmt.Errorf("failed to reserve event sequence numbers: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,84 @@
+package input
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPipeline_SequencePersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ekanite_")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".sequence")
+
+	// A sequence reserved by a previous run, ahead of the clock.
+	last := time.Now().Add(time.Hour).UnixNano()
+	if err := ioutil.WriteFile(path, []byte(strconv.FormatInt(last, 10)), 0644); err != nil {
+		t.Fatalf("failed to write sequence file: %s", err.Error())
+	}
+
+	p := NewPipeline(PipelineSettings{})
+	if err := p.OpenSequence(path); err != nil {
+		t.Fatalf("failed to open sequence: %s", err.Error())
+	}
+	n, err := p.NextSequence()
+	if err != nil {
+		t.Fatalf("failed to issue sequence number: %s", err.Error())
+	}
+	if n <= last {
+		t.Fatalf("sequence regressed after restart, got %d, expected more than %d", n, last)
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read sequence file: %s", err.Error())
+	}
+	limit, err := strconv.ParseInt(string(bs), 10, 64)
+	if err != nil {
+		t.Fatalf("failed to parse sequence file: %s", err.Error())
+	}
+	if limit <= n {
+		t.Fatalf("issued sequence not reserved on disk, got %d, expected more than %d", limit, n)
+	}
+}
+
+func TestPipeline_SequenceReserveError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ekanite_")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".sequence")
+
+	p := NewPipeline(PipelineSettings{})
+	if err := p.OpenSequence(path); err != nil {
+		t.Fatalf("failed to open sequence: %s", err.Error())
+	}
+	limit := p.sequence.limit
+	p.sequence.number = limit - 1
+
+	// Numbers past the limit are not issued while more cannot be reserved.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("failed to remove temp dir: %s", err.Error())
+	}
+	if n, err := p.NextSequence(); err == nil {
+		t.Fatalf("issued %d past the reserved limit %d, expected an error", n, limit)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	n, err := p.NextSequence()
+	if err != nil {
+		t.Fatalf("failed to issue sequence number once reserving works again: %s", err.Error())
+	}
+	if n < limit || n >= p.sequence.limit {
+		t.Fatalf("issued %d, expected from %d and below the new limit %d", n, limit, p.sequence.limit)
+	}
+}
@@ -0,0 +1,136 @@
+package input
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/ekanite/ekanite"
+)
+
+// OriginalSeverityField is the field events whose severity was rewritten keep
+// their severity as received in.
+const OriginalSeverityField = "original_severity"
+
+// SeverityRule rewrites the severity of the events it matches at ingest,
+// such as a known noisy error which is only informational, so that alerts on
+// severity can be trusted. Rules are loaded from JSON files holding an array
+// of them, such as
+//
+//	[{"app": "nginx", "message": "upstream timed out", "from": "err", "severity": "info"}]
+type SeverityRule struct {
+	App      string `json:"app,omitempty"`     // App of the events matched, any if empty.
+	Message  string `json:"message,omitempty"` // Regular expression matching the message of the events matched, any if empty.
+	From     string `json:"from,omitempty"`    // Severity of the events matched, as a keyword or a number, any if empty.
+	Severity string `json:"severity"`          // Severity set, as a keyword or a number.
+
+	message  *regexp.Regexp
+	from     int // -1 if any.
+	severity int
+}
+
+// compile validates the rule.
+func (r *SeverityRule) compile() error {
+	var err error
+	if r.Message != "" {
+		if r.message, err = regexp.Compile(r.Message); err != nil {
+			return fmt.Errorf("severity rule message '%s' is invalid: %w", r.Message, err)
+		}
+	}
+	r.from = -1
+	if r.From != "" {
+		if r.from, err = ekanite.ParseSeverity(r.From); err != nil {
+			return fmt.Errorf("severity rule from: %w", err)
+		}
+	}
+	if r.Severity == "" {
+		return fmt.Errorf("severity rule for app '%s' and message '%s' sets no severity", r.App, r.Message)
+	}
+	if r.severity, err = ekanite.ParseSeverity(r.Severity); err != nil {
+		return fmt.Errorf("severity rule: %w", err)
+	}
+	return nil
+}
+
+// ReadSeverityRules reads a JSON array of severity rules.
+func ReadSeverityRules(r io.Reader) ([]*SeverityRule, error) {
+	var rules []*SeverityRule
+	if err := json.NewDecoder(r).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("failed to read severity rules: %w", err)
+	}
+	for _, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// LoadSeverityRules reads the severity rules of the file at path, none if
+// path is empty.
+func LoadSeverityRules(path string) ([]*SeverityRule, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadSeverityRules(f)
+}
+
+// match returns whether the rule applies to the fields of an event of
+// severity.
+func (r *SeverityRule) match(fields map[string]interface{}, severity int) bool {
+	if r.from >= 0 && r.from != severity {
+		return false
+	}
+	if r.App != "" {
+		if app, _ := fields["app"].(string); app != r.App {
+			return false
+		}
+	}
+	if r.message != nil {
+		message, _ := fields["message"].(string)
+		if !r.message.MatchString(message) {
+			return false
+		}
+	}
+	return true
+}
+
+// rewriteSeverity applies the first severity rule of the pipeline matching
+// the fields of an event, keeping the priority in line with the severity set.
+func (p *Pipeline) rewriteSeverity(fields map[string]interface{}) {
+	rules := p.severityRules()
+	if len(rules) == 0 {
+		return
+	}
+	v, ok := fields["severity"]
+	if !ok || v == nil {
+		return
+	}
+	f, err := toFloat(v)
+	if err != nil {
+		return
+	}
+	severity := int(f)
+	for _, r := range rules {
+		if !r.match(fields, severity) {
+			continue
+		}
+		if r.severity == severity {
+			return
+		}
+		fields[OriginalSeverityField] = severity
+		fields["severity"] = r.severity
+		if facility, err := toFloat(fields["facility"]); err == nil {
+			fields["priority"] = int(facility)*8 + r.severity
+		}
+		stats.Add("severityRewritten", 1)
+		return
+	}
+}
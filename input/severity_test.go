@@ -0,0 +1,44 @@
+package input
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSeverityRules(t *testing.T) {
+	rules, err := ReadSeverityRules(strings.NewReader(`[
+		{"app": "nginx", "message": "upstream timed out", "from": "err", "severity": "info"},
+		{"message": "^disk .* failed$", "severity": "2"}
+	]`))
+	if err != nil {
+		t.Fatalf("failed to read severity rules: %s", err)
+	}
+	p := NewPipeline(PipelineSettings{SeverityRules: rules})
+
+	tests := []struct {
+		fields   map[string]interface{}
+		severity int
+		priority int
+	}{
+		{map[string]interface{}{"app": "nginx", "message": "upstream timed out (110)", "facility": 1, "severity": 3, "priority": 11}, 6, 14},
+		{map[string]interface{}{"app": "nginx", "message": "upstream timed out (110)", "facility": 1, "severity": 4, "priority": 12}, 4, 12},
+		{map[string]interface{}{"app": "haproxy", "message": "upstream timed out (110)", "facility": 1, "severity": 3, "priority": 11}, 3, 11},
+		{map[string]interface{}{"app": "smartd", "message": "disk sda failed", "facility": 0, "severity": 6, "priority": 6}, 2, 2},
+	}
+	for i, tt := range tests {
+		before := tt.fields["severity"]
+		p.Enrich(tt.fields)
+		if tt.fields["severity"] != tt.severity || tt.fields["priority"] != tt.priority {
+			t.Errorf("test %d: severity %v and priority %v, expected %d and %d", i, tt.fields["severity"], tt.fields["priority"], tt.severity, tt.priority)
+		}
+		if original, ok := tt.fields[OriginalSeverityField]; ok != (before != tt.severity) || ok && original != before {
+			t.Errorf("test %d: original severity %v", i, original)
+		}
+	}
+
+	for _, s := range []string{`[{"severity": "loud"}]`, `[{"message": "(", "severity": "info"}]`, `[{"app": "nginx"}]`, `{}`} {
+		if _, err := ReadSeverityRules(strings.NewReader(s)); err == nil {
+			t.Errorf("rules %s read", s)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package input
+
+import (
+	"bytes"
+	"expvar"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidPriority       = &ParserError{"Invalid priority"}
+	ErrInvalidVersion        = &ParserError{"Invalid version"}
+	ErrInvalidHostname       = &ParserError{"Invalid hostname"}
+	ErrInvalidStructuredData = &ParserError{"Invalid structured data"}
+	ErrInvalidMessage        = &ParserError{"Invalid UTF-8 message"}
+
+	// Messages rejected in strict mode, by violation.
+	strictRejections = new(expvar.Map).Init()
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+func init() {
+	stats.Set("rfc5424Rejected", strictRejections)
+}
+
+// ValidateRFC5424 checks that b is a syslog message conforming to RFC5424
+// section 6, returning the first violation found. Unlike the rfc5424 parser,
+// which makes the best of whatever it is given, it accepts nothing the
+// grammar does not allow.
+//
+// SYSLOG-MSG = HEADER SP STRUCTURED-DATA [SP MSG]
+func ValidateRFC5424(b []byte) error {
+	next, pri, err := ParsePriority(b)
+	if err != nil {
+		return err
+	}
+	if pri.P > 191 {
+		return ErrInvalidPriority
+	}
+
+	// VERSION = NONZERO-DIGIT 0*2DIGIT
+	i := 0
+	for i < len(next) && IsDigit(next[i]) {
+		i++
+	}
+	if i == 0 || i > 3 || next[0] == '0' || i == len(next) || next[i] != ' ' {
+		return ErrInvalidVersion
+	}
+	next = next[i+1:]
+
+	ts, next, err := strictField(next, len(next), ErrInvalidTimeFormat)
+	if err != nil {
+		return err
+	}
+	if !validTimestamp(ts) {
+		return ErrInvalidTimeFormat
+	}
+	if _, next, err = strictField(next, 255, ErrInvalidHostname); err != nil {
+		return err
+	}
+	if _, next, err = strictField(next, 48, ErrInvalidAppName); err != nil {
+		return err
+	}
+	if _, next, err = strictField(next, 128, ErrInvalidProcId); err != nil {
+		return err
+	}
+	if _, next, err = strictField(next, 32, ErrInvalidMsgId); err != nil {
+		return err
+	}
+
+	next, err = validStructuredData(next)
+	if err != nil {
+		return err
+	}
+	if len(next) == 0 {
+		return nil
+	}
+	if next[0] != ' ' {
+		return ErrInvalidStructuredData
+	}
+	// MSG = MSG-ANY / MSG-UTF8, the latter being flagged by a BOM.
+	if msg := next[1:]; bytes.HasPrefix(msg, utf8BOM) && !utf8.Valid(msg) {
+		return ErrInvalidMessage
+	}
+	return nil
+}
+
+// rejectRFC5424 reports whether b fails strict RFC5424 validation, counting
+// the violation if so.
+func rejectRFC5424(b []byte) bool {
+	err := ValidateRFC5424(b)
+	if err == nil {
+		return false
+	}
+	strictRejections.Add(err.Error(), 1)
+	return true
+}
+
+// strictField splits the SP terminated header field off b, which must be
+// 1 to max printable US-ASCII characters.
+func strictField(b []byte, max int, e error) ([]byte, []byte, error) {
+	i := 0
+	for i < len(b) && b[i] != ' ' {
+		if b[i] < 33 || b[i] > 126 {
+			return nil, b, e
+		}
+		i++
+	}
+	if i == 0 || i > max || i == len(b) {
+		return nil, b, e
+	}
+	return b[:i], b[i+1:], nil
+}
+
+// TIMESTAMP = NILVALUE / FULL-DATE "T" FULL-TIME
+func validTimestamp(b []byte) bool {
+	if len(b) == 1 && b[0] == NILVALUE {
+		return true
+	}
+	if len(b) < 20 || b[10] != 'T' {
+		return false
+	}
+
+	// TIME-SECFRAC = "." 1*6DIGIT
+	offset := b[19:]
+	if offset[0] == '.' {
+		n := 1
+		for n < len(offset) && IsDigit(offset[n]) {
+			n++
+		}
+		if n == 1 || n > 7 {
+			return false
+		}
+		offset = offset[n:]
+	}
+
+	// TIME-OFFSET = "Z" / TIME-NUMOFFSET
+	if !(len(offset) == 1 && offset[0] == 'Z') &&
+		!(len(offset) == 6 && (offset[0] == '+' || offset[0] == '-')) {
+		return false
+	}
+	_, err := time.Parse(time.RFC3339Nano, string(b))
+	return err == nil
+}
+
+// STRUCTURED-DATA = NILVALUE / 1*SD-ELEMENT
+// SD-ELEMENT      = "[" SD-ID *(SP SD-PARAM) "]"
+// SD-PARAM        = PARAM-NAME "=" %d34 PARAM-VALUE %d34
+func validStructuredData(b []byte) ([]byte, error) {
+	if len(b) > 0 && b[0] == NILVALUE {
+		return b[1:], nil
+	}
+	if len(b) == 0 || b[0] != '[' {
+		return b, ErrInvalidStructuredData
+	}
+	for len(b) > 0 && b[0] == '[' {
+		var ok bool
+		if b, ok = skipSDName(b[1:]); !ok {
+			return b, ErrInvalidStructuredData
+		}
+		for len(b) > 0 && b[0] == ' ' {
+			if b, ok = skipSDName(b[1:]); !ok {
+				return b, ErrInvalidStructuredData
+			}
+			if len(b) < 2 || b[0] != '=' || b[1] != '"' {
+				return b, ErrInvalidStructuredData
+			}
+			b = b[2:]
+
+			// PARAM-VALUE is UTF-8, with '"', '\' and ']' escaped.
+			i := 0
+			for ; i < len(b) && b[i] != '"'; i++ {
+				if b[i] == '\\' {
+					i++
+				} else if b[i] == ']' {
+					return b, ErrInvalidStructuredData
+				}
+			}
+			if i >= len(b) || !utf8.Valid(b[:i]) {
+				return b, ErrInvalidStructuredData
+			}
+			b = b[i+1:]
+		}
+		if len(b) == 0 || b[0] != ']' {
+			return b, ErrInvalidStructuredData
+		}
+		b = b[1:]
+	}
+	return b, nil
+}
+
+// skipSDName skips the SD-NAME at the start of b, 1 to 32 printable US-ASCII
+// characters other than '=', SP, ']' and '"'.
+func skipSDName(b []byte) ([]byte, bool) {
+	i := 0
+	for i < len(b) && b[i] > 32 && b[i] < 127 &&
+		b[i] != '=' && b[i] != ']' && b[i] != '"' {
+		i++
+	}
+	if i == 0 || i > 32 {
+		return b, false
+	}
+	return b[i:], true
+}
@@ -0,0 +1,83 @@
+package input
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ekanite/ekanite"
+)
+
+func TestValidateRFC5424(t *testing.T) {
+	tests := []struct {
+		line string
+		err  error
+	}{
+		{`<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - 'su root' failed`, nil},
+		{`<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - %% It's time to make the do-nuts.`, nil},
+		{`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high"]`, nil},
+		{`<165>1 - - - - - [id a="x\]y"] hello`, nil},
+		{`<34>1 - - - - - -`, nil},
+		{`34>1 - - - - - msg`, ErrPriorityNoStart},
+		{`<192>1 - - - - - msg`, ErrInvalidPriority},
+		{`<34> - - - - - msg`, ErrInvalidVersion},
+		{`<34>01 - - - - - msg`, ErrInvalidVersion},
+		{`<34>1 Oct 11 22:14:15 mymachine su: failed`, ErrInvalidTimeFormat},
+		{`<34>1 2003-10-11T22:14:15 mymachine su - ID47 - msg`, ErrInvalidTimeFormat},
+		{`<34>1 2003-10-11T22:14:15.0000003Z mymachine su - ID47 - msg`, ErrInvalidTimeFormat},
+		{`<34>1 2003-13-11T22:14:15Z mymachine su - ID47 - msg`, ErrInvalidTimeFormat},
+		{`<34>1 - host  - ID47 - msg`, ErrInvalidAppName},
+		{`<34>1 - host su - 0123456789012345678901234567890123 - msg`, ErrInvalidMsgId},
+		{`<34>1 - host su - ID47 msg`, ErrInvalidStructuredData},
+		{`<34>1 - host su - ID47 [id a=x] msg`, ErrInvalidStructuredData},
+		{`<34>1 - host su - ID47 [id a="x]"] msg`, ErrInvalidStructuredData},
+		{`<34>1 - host su - ID47 [id a="x"]msg`, ErrInvalidStructuredData},
+		{"<34>1 - host su - ID47 - \xEF\xBB\xBFbad \xff", ErrInvalidMessage},
+	}
+	for _, tt := range tests {
+		if err := ValidateRFC5424([]byte(tt.line)); err != tt.err {
+			t.Errorf("ValidateRFC5424(%q) returned %v, expected %v", tt.line, err, tt.err)
+		}
+	}
+}
+
+func TestTCPCollector_Strict(t *testing.T) {
+	collector, err := NewCollector("tcp", "127.0.0.1:0", "syslog", nil)
+	if err != nil {
+		t.Fatalf("failed to create collector: %s", err.Error())
+	}
+	tcp := collector.(*TCPCollector)
+	tcp.Strict = true
+
+	c := make(chan ekanite.Document, 10)
+	if err := tcp.Start(c); err != nil {
+		t.Fatalf("failed to start collector: %s", err.Error())
+	}
+	conn, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to collector: %s", err.Error())
+	}
+	defer conn.Close()
+
+	before := strictRejections.Get(ErrInvalidTimeFormat.Error())
+	conn.Write([]byte("<34>1 Oct 11 22:14:15 mymachine su: 'su root' failed\n"))
+	conn.Write([]byte("<34>1 2003-10-11T22:14:15.003Z mymachine su - ID47 - 'su root' failed\n"))
+
+	select {
+	case e := <-c:
+		if id := e.(*Event).Parsed["message_id"]; id != "ID47" {
+			t.Fatalf("received wrong event, message ID %v", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("conforming event not received")
+	}
+	select {
+	case e := <-c:
+		t.Fatalf("received unexpected event %v", e)
+	default:
+	}
+	after := strictRejections.Get(ErrInvalidTimeFormat.Error())
+	if after == nil || (before != nil && after.String() == before.String()) {
+		t.Fatalf("rejection not counted, got %v", after)
+	}
+}
@@ -0,0 +1,63 @@
+package ekanite
+
+import (
+	"unicode"
+
+	"github.com/blevesearch/bleve/analysis/lang/cjk"
+	"github.com/blevesearch/bleve/mapping"
+)
+
+// cjkMessageField is the field holding a second copy of the message, tokenized
+// by the CJK analyzer, when the message is detected to contain Chinese text.
+const cjkMessageField = "message_cjk"
+
+// isChinese returns whether s contains any Han characters.
+func isChinese(s string) bool {
+	for _, r := range s {
+		if unicode.Is(unicode.Han, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// routeLanguage returns the data of a document as the shard indexes it: with
+// the CJK copy of the message added if the shard detects the language of
+// messages, and the message is Chinese text. All messages continue to be
+// indexed through the default analyzer.
+func (s *Shard) routeLanguage(data interface{}) interface{} {
+	if !s.detectLanguage {
+		return data
+	}
+	return routeMessageLanguage(data)
+}
+
+// routeMessageLanguage returns data with the CJK copy of the message added, if
+// the message is Chinese text. The fields of data may be shared, such as with
+// the parsed event of the document, so they are copied rather than changed.
+func routeMessageLanguage(data interface{}) interface{} {
+	fields, ok := data.(map[string]interface{})
+	if !ok {
+		return data
+	}
+	message, ok := fields["message"].(string)
+	if !ok || !isChinese(message) {
+		return data
+	}
+	routed := make(map[string]interface{}, len(fields)+1)
+	for k, v := range fields {
+		routed[k] = v
+	}
+	routed[cjkMessageField] = message
+	return routed
+}
+
+// newCJKMessageMapping returns the field mapping of the CJK message copy.
+func newCJKMessageMapping() *mapping.FieldMapping {
+	m := mapping.NewTextFieldMapping()
+	m.Analyzer = cjk.AnalyzerName
+	m.Store = false
+	m.IncludeInAll = true
+	m.IncludeTermVectors = false
+	return m
+}
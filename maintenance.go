@@ -0,0 +1,162 @@
+package ekanite
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// deletingPrefix starts the names of the directories of the indexes being
+// deleted. Open skips them, and finishes removing them.
+const deletingPrefix = ".deleting-"
+
+// maintenancePoll is how often a maintenance task yielding to indexing checks
+// whether indexing is done.
+const maintenancePoll = 10 * time.Millisecond
+
+// tokenBucket limits the bytes per second gone through, allowing bursts of up
+// to a second's worth.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second, 0 for no limit.
+	tokens float64
+	last   time.Time
+}
+
+// setRate changes the rate of the bucket, 0 for no limit.
+func (b *tokenBucket) setRate(rate int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate = float64(rate)
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+}
+
+// wait blocks until n bytes may go through, or ctx is done. More than a
+// second's worth of bytes is let through once the bucket is full.
+func (b *tokenBucket) wait(ctx context.Context, n int64) error {
+	b.mu.Lock()
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if b.last.IsZero() {
+		b.tokens = b.rate
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+	}
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+
+	need := float64(n)
+	if need > b.rate {
+		need = b.rate
+	}
+	b.tokens -= need
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	stats.Add("maintenanceThrottled", 1)
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// maintenanceStep lets a maintenance task go on with n bytes of disk I/O,
+// once indexing in progress is done or MaintenanceYield has passed, and the
+// maintenance bandwidth allows it.
+func (e *Engine) maintenanceStep(ctx context.Context, n int64) error {
+	yield := e.MaintenanceYield
+	if yield > 0 && atomic.LoadInt64(&e.indexing) > 0 {
+		stats.Add("maintenanceYields", 1)
+		deadline := time.Now().Add(yield)
+		for atomic.LoadInt64(&e.indexing) > 0 && time.Now().Before(deadline) {
+			select {
+			case <-time.After(maintenancePoll):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	e.maintenance.setRate(e.MaintenanceBandwidth)
+	return e.maintenance.wait(ctx, n)
+}
+
+// deleteIndex closes the index and removes its files as a maintenance task,
+// once out of the way of Open under another name. It waits for the snapshots
+// reading the index to be closed first.
+func (e *Engine) deleteIndex(i *Index) error {
+	i.readers.Wait()
+	if err := i.Close(); err != nil {
+		return fmt.Errorf("failed to close index before deleting it: %w", err)
+	}
+	path := filepath.Join(filepath.Dir(i.path), deletingPrefix+filepath.Base(i.path))
+	if err := os.Rename(i.path, path); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-e.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return e.removeIndexFiles(ctx, path)
+}
+
+// removeIndexFiles removes the files of a closed index one by one, largest
+// first, each being a maintenance step of its size, then the index directory.
+func (e *Engine) removeIndexFiles(ctx context.Context, path string) error {
+	type file struct {
+		path string
+		size int64
+	}
+	var files []file
+	err := filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			files = append(files, file{p, fi.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].size > files[j].size })
+
+	for _, f := range files {
+		if err := e.maintenanceStep(ctx, f.size); err != nil {
+			return err
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.RemoveAll(path)
+}
@@ -0,0 +1,95 @@
+package ekanite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var b tokenBucket
+	ctx := context.Background()
+
+	// Without a rate, nothing is throttled.
+	start := time.Now()
+	if err := b.wait(ctx, 1<<30); err != nil || time.Since(start) > 100*time.Millisecond {
+		t.Fatalf("unlimited bucket waited %s, %v", time.Since(start), err)
+	}
+
+	// A full bucket lets a second's worth through, then throttles.
+	b.setRate(1000)
+	start = time.Now()
+	if err := b.wait(ctx, 1000); err != nil {
+		t.Fatalf("failed to wait: %s", err)
+	}
+	if err := b.wait(ctx, 300); err != nil {
+		t.Fatalf("failed to wait: %s", err)
+	}
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("bucket let 1300 bytes through in %s at 1000 bytes per second", d)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := b.wait(cctx, 1000); err != context.Canceled {
+		t.Errorf("wait on a cancelled context returned %v", err)
+	}
+}
+
+func TestEngine_MaintenanceStepYields(t *testing.T) {
+	e := NewEngine(tempPath())
+	e.MaintenanceYield = 100 * time.Millisecond
+
+	// Indexing in progress holds maintenance off until it is done.
+	atomic.AddInt64(&e.indexing, 1)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		atomic.AddInt64(&e.indexing, -1)
+	}()
+	start := time.Now()
+	if err := e.maintenanceStep(context.Background(), 0); err != nil {
+		t.Fatalf("failed to step: %s", err)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond || d >= 100*time.Millisecond {
+		t.Errorf("step waited %s, expected it to wait for indexing only", d)
+	}
+
+	// Maintenance goes on anyway once it has yielded long enough.
+	atomic.AddInt64(&e.indexing, 1)
+	defer atomic.AddInt64(&e.indexing, -1)
+	start = time.Now()
+	if err := e.maintenanceStep(context.Background(), 0); err != nil {
+		t.Fatalf("failed to step: %s", err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("step waited %s, expected it to yield for 100ms", d)
+	}
+}
+
+func TestEngine_RemoveIndexFiles(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	path := filepath.Join(dataDir, deletingPrefix+"20180101_0000")
+	for _, name := range []string{"shard_0/store/00000001.zap", "shard_0/store/root.bolt", "endtime"} {
+		p := filepath.Join(path, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("failed to create directory: %s", err)
+		}
+		if err := ioutil.WriteFile(p, make([]byte, 100), 0644); err != nil {
+			t.Fatalf("failed to write file: %s", err)
+		}
+	}
+
+	e := NewEngine(dataDir)
+	e.MaintenanceBandwidth = 1 << 20
+	if err := e.removeIndexFiles(context.Background(), path); err != nil {
+		t.Fatalf("failed to remove index files: %s", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("index directory still exists, %v", err)
+	}
+}
@@ -0,0 +1,291 @@
+package ekanite
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// manifestFileName is the name of the file, in the directory of an index,
+// listing the checksums of the files of the index as it was archived.
+const manifestFileName = "manifest.json"
+
+// IndexManifest lists the files of an index, with their sizes and SHA-256
+// checksums, so that an index restored from an archive can be checked before
+// it is searched.
+type IndexManifest struct {
+	Index     string         `json:"index"`
+	CreatedAt time.Time      `json:"created_at"`
+	Files     []ManifestFile `json:"files"`
+}
+
+// ManifestFile is a file of an index, its path relative to the index
+// directory, with forward slashes.
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BuildManifest returns the manifest of the files under the index directory
+// at path, ordered by path. The files must not change meanwhile.
+func BuildManifest(path string) (*IndexManifest, error) {
+	m := &IndexManifest{Index: filepath.Base(path), CreatedAt: time.Now().UTC(), Files: []ManifestFile{}}
+	err := filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || rel == manifestFileName {
+			return nil
+		}
+		sum, err := fileSHA256(p)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, ManifestFile{Path: filepath.ToSlash(rel), Size: fi.Size(), SHA256: sum})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(m.Files, func(u, v int) bool { return m.Files[u].Path < m.Files[v].Path })
+	return m, nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 checksum of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readManifest reads the manifest of the index directory at path, returning
+// nil if there is none.
+func readManifest(path string) (*IndexManifest, error) {
+	bs, err := ioutil.ReadFile(filepath.Join(path, manifestFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	m := &IndexManifest{}
+	if err := json.Unmarshal(bs, m); err != nil {
+		return nil, fmt.Errorf("%w: unreadable manifest: %v", ErrCorruptIndex, err)
+	}
+	return m, nil
+}
+
+// VerifyManifest checks the files under the index directory at path against
+// its manifest, returning ErrCorruptIndex if a file is missing, has another
+// size or checksum, or is not listed. It returns the manifest checked, or nil
+// if the index has none.
+func VerifyManifest(path string) (*IndexManifest, error) {
+	want, err := readManifest(path)
+	if err != nil || want == nil {
+		return nil, err
+	}
+	got, err := BuildManifest(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]ManifestFile, len(got.Files))
+	for _, f := range got.Files {
+		files[f.Path] = f
+	}
+	var problems []string
+	for _, w := range want.Files {
+		g, ok := files[w.Path]
+		delete(files, w.Path)
+		switch {
+		case !ok:
+			problems = append(problems, w.Path+" is missing")
+		case g.Size != w.Size:
+			problems = append(problems, fmt.Sprintf("%s has %d bytes, not %d", w.Path, g.Size, w.Size))
+		case g.SHA256 != w.SHA256:
+			problems = append(problems, w.Path+" has another checksum")
+		}
+	}
+	for p := range files {
+		problems = append(problems, p+" is not in the manifest")
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, fmt.Errorf("%w: %s", ErrCorruptIndex, strings.Join(problems, ", "))
+	}
+	return want, nil
+}
+
+// verifyIndexFiles checks the index directory at path against its manifest,
+// if it has one, as it is opened after being restored from an archive. The
+// manifest is removed once checked, as the files of the index change as soon
+// as it is written to, or its segments merged.
+func verifyIndexFiles(path string) error {
+	m, err := VerifyManifest(path)
+	if err != nil {
+		stats.Add("indexManifestFailures", 1)
+		return err
+	}
+	if m == nil {
+		return nil
+	}
+	log.Printf("[ekanite] verified %d file(s) of index %s against its manifest of %s",
+		len(m.Files), path, m.CreatedAt.Format(time.RFC3339))
+	stats.Add("indexManifestVerified", 1)
+	return os.Remove(filepath.Join(path, manifestFileName))
+}
+
+// ArchiveIndex writes the named index to w as a tar stream of its directory,
+// with a manifest of the checksums of its files, checked when the index is
+// next opened after being extracted into the data path of an engine. The
+// index is only closed while its files are staged, with hard links to its
+// segments, which are never modified, and copies of its other files.
+func (e *Engine) ArchiveIndex(ctx context.Context, name string, w io.Writer) error {
+	staging, err := ioutil.TempDir(e.path, ".archive-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	dir := filepath.Join(staging, name)
+
+	if err := e.stageIndex(name, dir); err != nil {
+		return err
+	}
+	m, err := BuildManifest(dir)
+	if err != nil {
+		return err
+	}
+	bs, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, manifestFileName), bs, 0644); err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	err = filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(staging, p)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	stats.Add("indexArchives", 1)
+	return nil
+}
+
+// stageIndex links or copies the files of the named index under dir, with
+// the index closed and out of routing meanwhile.
+func (e *Engine) stageIndex(name, dir string) error {
+	return e.takeOffline(name, func(i *Index) error {
+		if err := i.Close(); err != nil {
+			return fmt.Errorf("failed to close index before archiving it: %w", err)
+		}
+		staged := linkTree(i.path, dir)
+		if err := i.reopen(); err != nil {
+			return fmt.Errorf("failed to reopen index after archiving it: %w", err)
+		}
+		return staged
+	})
+}
+
+// linkTree recreates the files under src under dst, hard linking the segment
+// files and copying the others, which are modified in place.
+func linkTree(src, dst string) error {
+	return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if rel == manifestFileName {
+			return nil
+		}
+		if strings.HasSuffix(fi.Name(), ".zap") {
+			if err := os.Link(p, target); err == nil {
+				return nil
+			}
+		}
+		return copyFile(p, target)
+	})
+}
+
+// copyFile copies the file at src to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,147 @@
+package ekanite
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestVerifyManifest tests that the files of an index are checked against
+// its manifest.
+func TestVerifyManifest(t *testing.T) {
+	path, err := ioutil.TempDir("", "ekanite-manifest-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(path)
+	write := func(name, data string) {
+		p := filepath.Join(path, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("failed to create dir: %s", err.Error())
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write %s: %s", name, err.Error())
+		}
+	}
+	write(endTimeFileName, "20160101_0000")
+	write("0000/store/00000001.zap", "segment")
+
+	if m, err := VerifyManifest(path); err != nil || m != nil {
+		t.Fatalf("index without manifest verified as %v, %v", m, err)
+	}
+
+	m, err := BuildManifest(path)
+	if err != nil {
+		t.Fatalf("failed to build manifest: %s", err.Error())
+	}
+	if len(m.Files) != 2 || m.Files[0].Path != "0000/store/00000001.zap" || m.Files[1].Path != endTimeFileName {
+		t.Fatalf("manifest lists %v", m.Files)
+	}
+	bs, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("failed to marshal manifest: %s", err.Error())
+	}
+	write(manifestFileName, string(bs))
+	if _, err := VerifyManifest(path); err != nil {
+		t.Fatalf("failed to verify intact index: %s", err.Error())
+	}
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "0000/store/00000001.zap", data: "segmenT"},
+		{name: "0000/store/00000001.zap", data: "segment!"},
+		{name: "0000/store/00000002.zap", data: "segment"},
+		{name: manifestFileName, data: "{"},
+	}
+	for _, tt := range tests {
+		write(manifestFileName, string(bs))
+		write("0000/store/00000001.zap", "segment")
+		os.Remove(filepath.Join(path, "0000/store/00000002.zap"))
+
+		write(tt.name, tt.data)
+		if _, err := VerifyManifest(path); !errors.Is(err, ErrCorruptIndex) {
+			t.Fatalf("writing %q to %s not detected: %v", tt.data, tt.name, err)
+		}
+	}
+
+	os.Remove(filepath.Join(path, "0000/store/00000002.zap"))
+	write("0000/store/00000001.zap", "segment")
+	write(manifestFileName, string(bs))
+	if err := verifyIndexFiles(path); err != nil {
+		t.Fatalf("failed to verify intact index: %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(path, manifestFileName)); !os.IsNotExist(err) {
+		t.Fatal("manifest not removed once verified")
+	}
+}
+
+// TestEngine_StageIndex tests that staging an index read by a snapshot leaves
+// the engine usable, and events for the index wait for it to be back.
+func TestEngine_StageIndex(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := newEngine(dataDir, 1, 24*time.Hour)
+	defer e.Close()
+
+	ev := newIndexableEvent("auth password accepted", parseTime("1982-02-05T04:43:00Z"))
+	if err := e.Index([]Document{ev}); err != nil {
+		t.Fatalf("failed to index event: %s", err.Error())
+	}
+	name := e.indexes[0].Name()
+	snap, err := e.Snapshot(time.Time{}, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("failed to take snapshot: %s", err.Error())
+	}
+
+	dir := filepath.Join(dataDir, ".staged", name)
+	staged := make(chan error, 1)
+	go func() { staged <- e.stageIndex(name, dir) }()
+	for {
+		e.mu.RLock()
+		n := len(e.staged)
+		e.mu.RUnlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The engine goes on while the snapshot is open.
+	if _, err := e.Indexes(); err != nil {
+		t.Fatalf("failed to list indexes while staging: %s", err.Error())
+	}
+	indexed := make(chan error, 1)
+	go func() {
+		indexed <- e.Index([]Document{newIndexableEvent("auth password rejected", parseTime("1982-02-05T05:43:00Z"))})
+	}()
+	select {
+	case err := <-staged:
+		t.Fatalf("index staged under an open snapshot: %v", err)
+	case err := <-indexed:
+		t.Fatalf("event indexed into a staged index: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	snap.Close()
+	if err := <-staged; err != nil {
+		t.Fatalf("failed to stage index: %s", err.Error())
+	}
+	if err := <-indexed; err != nil {
+		t.Fatalf("failed to index event once the index was back: %s", err.Error())
+	}
+	if len(e.indexes) != 1 || e.indexes[0].Name() != name {
+		t.Fatalf("indexes after staging: %v", e.indexes)
+	}
+	if total, err := e.Total(); err != nil || total != 2 {
+		t.Fatalf("engine total doc count %d, %v, expected 2", total, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, endTimeFileName)); err != nil {
+		t.Fatalf("index files not staged: %s", err.Error())
+	}
+}
@@ -0,0 +1,127 @@
+package ekanite
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/blevesearch/bleve/mapping"
+)
+
+// stagedMappingFileName is the file of the data directory the staged mapping
+// is kept in, so that it survives restarts.
+const stagedMappingFileName = "mapping.json"
+
+// stageMapping sets the mapping of the indexes created by the engine after
+// the call, the keyword and case folded fields then only being followed at
+// query time. Existing indexes keep theirs. A nil mapping restores the
+// built-in one.
+func (e *Engine) stageMapping(m *mapping.IndexMappingImpl) error {
+	var staged []byte
+	if m != nil {
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidMapping, err)
+		}
+		bs, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		staged = bs
+	}
+
+	e.mappingMu.Lock()
+	defer e.mappingMu.Unlock()
+	e.stagedMapping = staged
+	return nil
+}
+
+// parseIndexMapping decodes a mapping, checking that this engine has all the
+// analysis components it refers to.
+func parseIndexMapping(bs []byte) (*mapping.IndexMappingImpl, error) {
+	m := mapping.NewIndexMapping()
+	if err := json.Unmarshal(bs, m); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMapping, err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMapping, err)
+	}
+	return m, nil
+}
+
+// IndexMapping returns the mapping of the indexes created next: the staged
+// mapping if any, or else the built-in one, following the keyword and case
+// folded fields.
+func (e *Engine) IndexMapping() (*mapping.IndexMappingImpl, error) {
+	e.mappingMu.RLock()
+	staged := e.stagedMapping
+	e.mappingMu.RUnlock()
+	if staged == nil {
+		return buildIndexMapping(e.CaseFold, e.StoreMessage)
+	}
+	return parseIndexMapping(staged)
+}
+
+// CaseFoldFields returns the keyword fields lower-cased in the indexes.
+func (e *Engine) CaseFoldFields() CaseFold {
+	return e.CaseFold
+}
+
+// indexOptions returns the options the indexes of the engine are created and
+// opened with.
+func (e *Engine) indexOptions() (indexOptions, error) {
+	m, err := e.IndexMapping()
+	if err != nil {
+		return indexOptions{}, err
+	}
+	return indexOptions{
+		mapping:        m,
+		caseFold:       e.CaseFold,
+		detectLanguage: e.DetectLanguage,
+		durability:     e.Durability,
+		syncInterval:   e.SyncInterval,
+	}, nil
+}
+
+// StageIndexMapping sets the mapping of the indexes created next, keeping it
+// in the data directory so that it is staged again when the engine is opened.
+// A nil mapping restores the built-in one.
+func (e *Engine) StageIndexMapping(m *mapping.IndexMappingImpl) error {
+	path := filepath.Join(e.path, stagedMappingFileName)
+	if m == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return e.stageMapping(nil)
+	}
+
+	if err := e.stageMapping(m); err != nil {
+		return err
+	}
+	bs, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, bs, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadStagedMapping stages the mapping kept in the data directory, if any.
+func (e *Engine) loadStagedMapping() error {
+	bs, err := ioutil.ReadFile(filepath.Join(e.path, stagedMappingFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	m, err := parseIndexMapping(bs)
+	if err != nil {
+		return fmt.Errorf("staged mapping: %w", err)
+	}
+	return e.stageMapping(m)
+}
@@ -0,0 +1,101 @@
+package ekanite
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/standard"
+)
+
+func TestEngine_StageIndexMapping(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	e := NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine: %s", err)
+	}
+	m, err := e.IndexMapping()
+	if err != nil {
+		t.Fatalf("failed to get index mapping: %s", err)
+	}
+	if m.DefaultAnalyzer != "ekanite" {
+		t.Fatalf("built-in mapping has default analyzer %s", m.DefaultAnalyzer)
+	}
+
+	invalid := bleve.NewIndexMapping()
+	invalid.DefaultAnalyzer = "nope"
+	if err := e.StageIndexMapping(invalid); !errors.Is(err, ErrInvalidMapping) {
+		t.Fatalf("staged mapping with unknown analyzer, got %v", err)
+	}
+
+	staged := bleve.NewIndexMapping()
+	staged.DefaultAnalyzer = standard.Name
+	if err := e.StageIndexMapping(staged); err != nil {
+		t.Fatalf("failed to stage index mapping: %s", err)
+	}
+	ev := newIndexableEvent("auth password accepted for user philip", parseTime("1982-02-05T04:43:00Z"))
+	if err := e.Index([]Document{ev}); err != nil {
+		t.Fatalf("failed to index event: %s", err)
+	}
+	infos, err := e.Indexes()
+	if err != nil {
+		t.Fatalf("failed to list indexes: %s", err)
+	}
+	if len(infos) != 1 || infos[0].Metadata == nil || infos[0].Metadata.DefaultAnalyzer != standard.Name {
+		t.Fatalf("index not created with staged mapping: %+v", infos)
+	}
+	e.Close()
+
+	// The staged mapping survives restarts, and indexes created with it
+	// open along with it reset.
+	e = NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to reopen engine: %s", err)
+	}
+	if m, err := e.IndexMapping(); err != nil || m.DefaultAnalyzer != standard.Name {
+		t.Fatalf("staged mapping not restored on open, got %v", err)
+	}
+	if err := e.StageIndexMapping(nil); err != nil {
+		t.Fatalf("failed to reset index mapping: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, stagedMappingFileName)); !os.IsNotExist(err) {
+		t.Errorf("staged mapping file left after reset, %v", err)
+	}
+	e.Close()
+
+	e = NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine with index of another mapping: %s", err)
+	}
+	defer e.Close()
+	if m, err := e.IndexMapping(); err != nil || m.DefaultAnalyzer != "ekanite" {
+		t.Fatalf("built-in mapping not restored by reset, got %v", err)
+	}
+}
+
+func TestEngine_StoreMessage(t *testing.T) {
+	e := NewEngine(tempPath())
+
+	stored := func() bool {
+		m, err := e.IndexMapping()
+		if err != nil {
+			t.Fatalf("failed to get index mapping: %s", err)
+		}
+		fields := m.DefaultMapping.Properties["message"].Fields
+		if len(fields) != 1 || !fields[0].Index {
+			t.Fatalf("message not indexed: %+v", fields)
+		}
+		return fields[0].Store
+	}
+	if !stored() {
+		t.Fatal("message not stored by default")
+	}
+	e.StoreMessage = false
+	if stored() {
+		t.Fatal("message stored once disabled")
+	}
+}
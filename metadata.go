@@ -0,0 +1,168 @@
+package ekanite
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blevesearch/bleve/mapping"
+)
+
+const metadataFileName = "metadata.json"
+
+// IndexFormat is the version of the layout of the indexes created. Indexes of
+// a later format are refused, as this engine may search them wrongly.
+const IndexFormat = 1
+
+// Version is the version of ekanite recorded in the metadata of the indexes
+// created, set at build time with
+// -ldflags "-X github.com/ekanite/ekanite.Version=...".
+var Version = "dev"
+
+// IndexMetadata records the settings an index was created with, in a sidecar
+// file of the index.
+type IndexMetadata struct {
+	Format          int               `json:"format"`
+	EngineVersion   string            `json:"engine_version"`
+	CreatedAt       time.Time         `json:"created_at"`
+	Shards          int               `json:"shards"`
+	DefaultAnalyzer string            `json:"default_analyzer"`
+	Analyzers       map[string]string `json:"analyzers"` // Analyzer of each field mapped.
+	CaseFold        []string          `json:"case_fold,omitempty"`
+	Mapping         json.RawMessage   `json:"mapping,omitempty"`
+}
+
+// newIndexMetadata returns the metadata of an index created now with the given
+// shards, mapping and case folded fields.
+func newIndexMetadata(shards int, m *mapping.IndexMappingImpl, fold CaseFold) (*IndexMetadata, error) {
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	md := &IndexMetadata{
+		Format:          IndexFormat,
+		EngineVersion:   Version,
+		CreatedAt:       time.Now().UTC(),
+		Shards:          shards,
+		DefaultAnalyzer: m.DefaultAnalyzer,
+		Analyzers:       fieldAnalyzers(m),
+		CaseFold:        fold.Fields(),
+		Mapping:         bs,
+	}
+	return md, nil
+}
+
+// fieldAnalyzers returns the analyzer of each text field of the default
+// document mapping of m.
+func fieldAnalyzers(m *mapping.IndexMappingImpl) map[string]string {
+	analyzers := map[string]string{}
+	if m.DefaultMapping == nil {
+		return analyzers
+	}
+	for name, dm := range m.DefaultMapping.Properties {
+		for _, f := range dm.Fields {
+			if f.Type != "text" {
+				continue
+			}
+			analyzer := f.Analyzer
+			if analyzer == "" {
+				analyzer = m.DefaultAnalyzer
+			}
+			analyzers[name] = analyzer
+		}
+	}
+	return analyzers
+}
+
+// compatible returns an error wrapping ErrIncompatibleIndex if an index with
+// the metadata would be searched wrongly by this engine: if it is of a later
+// format, has lost shards, or uses analyzers this engine does not have. The
+// engine's own mapping may differ, as it only applies to the indexes created
+// next.
+func (md *IndexMetadata) compatible(shards int) error {
+	if md.Format > IndexFormat {
+		return fmt.Errorf("%w: format %d, engine supports up to %d", ErrIncompatibleIndex, md.Format, IndexFormat)
+	}
+	if shards != md.Shards {
+		return fmt.Errorf("%w: %d shard(s) found, %d created", ErrIncompatibleIndex, shards, md.Shards)
+	}
+	if len(md.Mapping) == 0 {
+		return nil
+	}
+
+	// Queries are analyzed as the documents were, so the analyzers of the
+	// index must all be available.
+	m, err := parseIndexMapping(md.Mapping)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrIncompatibleIndex, err)
+	}
+	if m.AnalyzerNamed(md.DefaultAnalyzer) == nil {
+		return fmt.Errorf("%w: default analyzer %s is unknown", ErrIncompatibleIndex, md.DefaultAnalyzer)
+	}
+	for field, analyzer := range md.Analyzers {
+		if m.AnalyzerNamed(analyzer) == nil {
+			return fmt.Errorf("%w: analyzer %s of field %s is unknown", ErrIncompatibleIndex, analyzer, field)
+		}
+	}
+	return nil
+}
+
+// readMetadata reads the metadata of an index from the file at path,
+// returning nil if there is no such file, as with the indexes created before
+// metadata were recorded.
+func readMetadata(path string) (*IndexMetadata, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to read metadata of index: %w", err)
+	}
+	var md IndexMetadata
+	if err := json.Unmarshal(bs, &md); err != nil {
+		return nil, fmt.Errorf("unable to decode metadata of index: %w", err)
+	}
+	return &md, nil
+}
+
+// writeMetadata atomically writes the metadata of an index to the file at
+// path.
+func writeMetadata(path string, md *IndexMetadata) error {
+	bs, err := json.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, bs, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Metadata returns the settings the index was created with, nil if they were
+// not recorded.
+func (i *Index) Metadata() *IndexMetadata {
+	return i.metadata
+}
+
+// IndexMetadata returns the settings the named index was created with.
+func (e *Engine) IndexMetadata(name string) (*IndexMetadata, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	i := e.indexByName(name)
+	if i == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownIndex, name)
+	}
+	if i.metadata == nil {
+		return nil, fmt.Errorf("%w: index %s has no metadata", ErrUnknownIndex, name)
+	}
+	return i.metadata, nil
+}
+
+// metadataFile returns the path of the metadata file of the index at path.
+func metadataFile(path string) string {
+	return filepath.Join(path, metadataFileName)
+}
@@ -0,0 +1,93 @@
+package ekanite
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIndex_Metadata(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+
+	startTime := parseTime("2006-01-04T00:04:00Z").UTC()
+	n, err := NewIndex(path, startTime, startTime.Add(time.Hour), 2)
+	if err != nil {
+		t.Fatalf("failed to create new index at %s %s", path, err)
+	}
+	n.Close()
+
+	indexPath := path + "/20060104_0004"
+	i, err := OpenIndex(indexPath, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to open index: %s", err)
+	}
+	i.Close()
+	md := i.Metadata()
+	if md == nil {
+		t.Fatal("opened index has no metadata")
+	}
+	if md.Format != IndexFormat || md.Shards != 2 || md.DefaultAnalyzer != "ekanite" || len(md.Mapping) == 0 {
+		t.Fatalf("wrong metadata recorded: %+v", md)
+	}
+	if md.Analyzers["message"] != "ekanite" {
+		t.Errorf("message field analyzer recorded as %q", md.Analyzers["message"])
+	}
+	if len(i.Shards) != 2 {
+		t.Errorf("index with metadata padded to %d shards, expected 2", len(i.Shards))
+	}
+
+	refused := func(desc string, change func(md *IndexMetadata)) {
+		changed := *md
+		change(&changed)
+		if err := writeMetadata(metadataFile(indexPath), &changed); err != nil {
+			t.Fatalf("failed to write metadata: %s", err)
+		}
+		if i, err := OpenIndex(indexPath, time.Hour); !errors.Is(err, ErrIncompatibleIndex) {
+			if err == nil {
+				i.Close()
+			}
+			t.Errorf("opened index with %s, got %v", desc, err)
+		}
+	}
+	refused("a later format", func(md *IndexMetadata) { md.Format = IndexFormat + 1 })
+	refused("a shard missing", func(md *IndexMetadata) { md.Shards = 3 })
+	refused("an unknown default analyzer", func(md *IndexMetadata) { md.DefaultAnalyzer = "nope" })
+	refused("an unknown analyzer", func(md *IndexMetadata) { md.Analyzers = map[string]string{"host": "nope"} })
+	refused("an unknown tokenizer", func(md *IndexMetadata) {
+		var m map[string]interface{}
+		json.Unmarshal(md.Mapping, &m)
+		m["analysis"].(map[string]interface{})["tokenizers"] = map[string]interface{}{
+			"ekanite_tk": map[string]interface{}{"type": "nope"},
+		}
+		md.Mapping, _ = json.Marshal(m)
+	})
+
+	// Case folding only applies to the indexes created after it changes.
+	changed := *md
+	changed.CaseFold = []string{"host"}
+	if err := writeMetadata(metadataFile(indexPath), &changed); err != nil {
+		t.Fatalf("failed to write metadata: %s", err)
+	}
+	if i, err := OpenIndex(indexPath, time.Hour); err != nil {
+		t.Errorf("failed to open index folded differently: %s", err)
+	} else {
+		i.Close()
+	}
+
+	// Indexes created before metadata were recorded open as before.
+	if err := os.Remove(filepath.Join(indexPath, metadataFileName)); err != nil {
+		t.Fatalf("failed to remove metadata: %s", err)
+	}
+	i, err = OpenIndex(indexPath, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to open index without metadata: %s", err)
+	}
+	defer i.Close()
+	if i.Metadata() != nil {
+		t.Errorf("index without metadata file has metadata")
+	}
+}
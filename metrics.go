@@ -0,0 +1,64 @@
+package ekanite
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// lagSamples is the number of recent time-to-searchable samples kept to
+// compute percentiles.
+const lagSamples = 1024
+
+// Receiver is implemented by documents which know when they were received, so
+// that the time until they are searchable can be measured.
+type Receiver interface {
+	Received() time.Time
+}
+
+// lagRecorder keeps the most recent time-to-searchable samples.
+type lagRecorder struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+// record adds the time from reception to now of every document in batch
+// which implements Receiver.
+func (r *lagRecorder) record(now time.Time, batch []Document) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range batch {
+		rd, ok := d.(Receiver)
+		if !ok {
+			continue
+		}
+		lag := now.Sub(rd.Received())
+		if len(r.samples) < lagSamples {
+			r.samples = append(r.samples, lag)
+			continue
+		}
+		r.samples[r.next] = lag
+		r.next = (r.next + 1) % lagSamples
+	}
+}
+
+// percentiles returns the given percentiles, from 0 to 100, of the recorded
+// samples. It returns zeros if nothing has been recorded.
+func (r *lagRecorder) percentiles(ps ...float64) []time.Duration {
+	r.mu.Lock()
+	sorted := make([]time.Duration, len(r.samples))
+	copy(sorted, r.samples)
+	r.mu.Unlock()
+
+	rv := make([]time.Duration, len(ps))
+	if len(sorted) == 0 {
+		return rv
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for i, p := range ps {
+		n := int(p / 100 * float64(len(sorted)-1))
+		rv[i] = sorted[n]
+	}
+	return rv
+}
@@ -0,0 +1,169 @@
+package ekanite
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// MonitoringTier is the tier of the indexes heartbeats are indexed in. Tier
+// names starting with "_" are reserved for it.
+const MonitoringTier = "_monitoring"
+
+// errorCounters are the stats, by expvar map, summed into the errors of a
+// heartbeat.
+var errorCounters = map[string][]string{
+	"engine": {"batchIndexedError", "eventsDropped"},
+	"input":  {"tcpAcceptError", "tcpConnReadError", "tcpEventsParseError", "udpEventsParseError"},
+}
+
+// Heartbeat is a document about the health of ekanite itself, indexed in the
+// MonitoringTier so that it can be searched and alerted on like any event.
+type Heartbeat struct {
+	Time     time.Time
+	Fields   map[string]interface{}
+	sequence int64
+}
+
+// ID returns a unique ID for the heartbeat.
+func (h *Heartbeat) ID() DocID {
+	return DocID(fmt.Sprintf("%016x%016x", uint64(h.Time.UnixNano()), uint64(h.sequence)))
+}
+
+// Data returns the indexable data.
+func (h *Heartbeat) Data() interface{} {
+	return h.Fields
+}
+
+// ReferenceTime returns the time of the heartbeat.
+func (h *Heartbeat) ReferenceTime() time.Time {
+	return h.Time
+}
+
+// Monitor periodically indexes a heartbeat reporting the ingest rate, queue
+// depth and errors of the engine since the previous one.
+type Monitor struct {
+	Engine   *Engine
+	Batcher  *Batcher      // Batcher whose queue is reported, if set.
+	Interval time.Duration // Time between heartbeats.
+	Host     string        // Address of the heartbeats, the hostname by default.
+
+	last struct {
+		time    time.Time
+		indexed int64
+		queries int64
+		errors  int64
+	}
+	sequence int64 // Orders the heartbeats indexed at the same time.
+
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+// NewMonitor returns a monitor indexing a heartbeat about e and b every
+// interval.
+func NewMonitor(e *Engine, b *Batcher, interval time.Duration) *Monitor {
+	host, _ := os.Hostname()
+	return &Monitor{
+		Engine:   e,
+		Batcher:  b,
+		Interval: interval,
+		Host:     host,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start starts indexing heartbeats.
+func (m *Monitor) Start() {
+	m.heartbeat(time.Now()) // Take the first counts as the baseline.
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				if err := m.Engine.Index([]Document{m.heartbeat(now)}); err != nil {
+					log.Printf("[ekanite] failed to index heartbeat: %s", err)
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops indexing heartbeats.
+func (m *Monitor) Stop() {
+	close(m.done)
+	m.wg.Wait()
+}
+
+// heartbeat returns a heartbeat at now, with the counts since the previous
+// one.
+func (m *Monitor) heartbeat(now time.Time) *Heartbeat {
+	indexed := counterValue("engine", "eventsIndexed")
+	queries := counterValue("engine", "queriesRx")
+	var errors int64
+	for name, keys := range errorCounters {
+		for _, key := range keys {
+			errors += counterValue(name, key)
+		}
+	}
+
+	var rate float64
+	if elapsed := now.Sub(m.last.time).Seconds(); !m.last.time.IsZero() && elapsed > 0 {
+		rate = float64(indexed-m.last.indexed) / elapsed
+	}
+	var queueDepth int
+	var oldestPending time.Duration
+	if m.Batcher != nil {
+		queueDepth = m.Batcher.QueueDepth()
+		oldestPending = m.Batcher.OldestPending()
+	}
+	m.Engine.mu.RLock()
+	indexes := len(m.Engine.indexes)
+	m.Engine.mu.RUnlock()
+
+	h := &Heartbeat{
+		Time: now,
+		Fields: map[string]interface{}{
+			"message": fmt.Sprintf("heartbeat ingest_rate=%.1f queue_depth=%d errors=%d",
+				rate, queueDepth, errors-m.last.errors),
+			"source":            "ekanite",
+			"address":           m.Host,
+			"timestamp":         now,
+			"reception":         now,
+			"ingest_rate":       rate,
+			"events_indexed":    indexed - m.last.indexed,
+			"queue_depth":       queueDepth,
+			"oldest_pending_ms": int64(oldestPending / time.Millisecond),
+			"queries":           queries - m.last.queries,
+			"errors":            errors - m.last.errors,
+			"indexes":           indexes,
+		},
+	}
+	m.sequence++
+	h.sequence = m.sequence
+	m.last.time, m.last.indexed, m.last.queries, m.last.errors = now, indexed, queries, errors
+	return h
+}
+
+// counterValue returns the value of the integer stat key of the named expvar
+// map, 0 if there is no such stat.
+func counterValue(name, key string) int64 {
+	vars, ok := expvar.Get(name).(*expvar.Map)
+	if !ok {
+		return 0
+	}
+	v, ok := vars.Get(key).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
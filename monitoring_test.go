@@ -0,0 +1,49 @@
+package ekanite
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMonitor_Heartbeat(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine: %s", err)
+	}
+	defer e.Close()
+
+	m := NewMonitor(e, nil, time.Minute)
+	m.Host = "node1"
+	start := time.Now()
+	m.heartbeat(start)
+	stats.Add("eventsIndexed", 120)
+	stats.Add("batchIndexedError", 2)
+	h := m.heartbeat(start.Add(time.Minute))
+
+	if rate := h.Fields["ingest_rate"].(float64); rate != 2 {
+		t.Errorf("ingest rate is %v, expected 2 events per second", rate)
+	}
+	if n := h.Fields["events_indexed"].(int64); n != 120 {
+		t.Errorf("%d events indexed, expected 120", n)
+	}
+	if n := h.Fields["errors"].(int64); n != 2 {
+		t.Errorf("%d errors, expected 2", n)
+	}
+	if h.Fields["address"] != "node1" || h.Fields["source"] != "ekanite" {
+		t.Errorf("heartbeat not about ekanite on node1: %v", h.Fields)
+	}
+
+	if err := e.Index([]Document{h}); err != nil {
+		t.Fatalf("failed to index heartbeat: %s", err)
+	}
+	infos, err := e.Indexes()
+	if err != nil {
+		t.Fatalf("failed to list indexes: %s", err)
+	}
+	if len(infos) != 1 || infos[0].Tier != MonitoringTier || infos[0].Documents != 1 {
+		t.Fatalf("heartbeat not indexed in the monitoring tier: %+v", infos)
+	}
+}
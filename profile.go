@@ -0,0 +1,114 @@
+package ekanite
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// searchProfileKey is the context key of the SearchProfile searches record
+// their profile into.
+type searchProfileKey struct{}
+
+// SearchProfile breaks the time and hits of searches down by the indexes and
+// shards searched, so that slow searches can be attributed to specific time
+// buckets.
+type SearchProfile struct {
+	Took    time.Duration  `json:"took"`
+	Indexes []IndexProfile `json:"indexes"` // Indexes searched, by name.
+
+	mu sync.Mutex
+}
+
+// IndexProfile is the part an index took in a search.
+type IndexProfile struct {
+	Index  string         `json:"index"`
+	Took   time.Duration  `json:"took"`             // Longest time a shard took, the shards being searched at once.
+	Total  uint64         `json:"total_hits"`       // Documents matched.
+	Hits   int            `json:"hits"`             // Hits among those returned.
+	Error  string         `json:"error,omitempty"`  // Error of the first shard failing.
+	Shards []ShardProfile `json:"shards,omitempty"` // Shards of the index, by name.
+}
+
+// ShardProfile is the part a shard took in a search.
+type ShardProfile struct {
+	Shard string        `json:"shard"`
+	Took  time.Duration `json:"took"`
+	Total uint64        `json:"total_hits"`
+	Hits  int           `json:"hits"`
+	Error string        `json:"error,omitempty"`
+}
+
+// WithSearchProfile returns a context whose searches record the part each
+// index and shard took into the returned profile.
+func WithSearchProfile(ctx context.Context) (context.Context, *SearchProfile) {
+	p := &SearchProfile{}
+	return context.WithValue(ctx, searchProfileKey{}, p), p
+}
+
+// searchProfile returns the profile searches with ctx record into, nil if
+// none.
+func searchProfile(ctx context.Context) *SearchProfile {
+	p, _ := ctx.Value(searchProfileKey{}).(*SearchProfile)
+	return p
+}
+
+// record adds the profile of a search across children, each its own index,
+// to p.
+func (p *SearchProfile) record(took time.Duration, children []IndexProfile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Took += took
+	p.Indexes = append(p.Indexes, children...)
+	sort.Slice(p.Indexes, func(i, j int) bool { return p.Indexes[i].Index < p.Indexes[j].Index })
+}
+
+// groupShards turns the children of p named by locations, the shards of an
+// index, into the shards of their index.
+func (p *SearchProfile) groupShards(locations map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var indexes []IndexProfile
+	byName := map[string]int{}
+	for _, child := range p.Indexes {
+		location, ok := locations[child.Index]
+		if !ok {
+			indexes = append(indexes, child)
+			continue
+		}
+		name, shard := location, ""
+		if i := strings.LastIndex(location, "/"); i >= 0 {
+			name, shard = location[:i], location[i+1:]
+		}
+		k, ok := byName[name]
+		if !ok {
+			k = len(indexes)
+			byName[name] = k
+			indexes = append(indexes, IndexProfile{Index: name})
+		}
+		idx := &indexes[k]
+		if child.Took > idx.Took {
+			idx.Took = child.Took
+		}
+		idx.Total += child.Total
+		idx.Hits += child.Hits
+		if idx.Error == "" {
+			idx.Error = child.Error
+		}
+		idx.Shards = append(idx.Shards, ShardProfile{
+			Shard: shard,
+			Took:  child.Took,
+			Total: child.Total,
+			Hits:  child.Hits,
+			Error: child.Error,
+		})
+	}
+	for k := range indexes {
+		shards := indexes[k].Shards
+		sort.Slice(shards, func(i, j int) bool { return shards[i].Shard < shards[j].Shard })
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i].Index < indexes[j].Index })
+	p.Indexes = indexes
+}
@@ -0,0 +1,36 @@
+package ekanite
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSearchProfile_GroupShards(t *testing.T) {
+	_, p := WithSearchProfile(context.Background())
+	p.record(5*time.Millisecond, []IndexProfile{
+		{Index: "shard-b", Took: 4 * time.Millisecond, Total: 10, Hits: 3},
+		{Index: "shard-a", Took: 2 * time.Millisecond, Total: 5, Hits: 2},
+		{Index: "shard-c", Took: time.Millisecond, Error: "shard closed"},
+		{Index: "remote/20240101", Took: 3 * time.Millisecond, Total: 1, Hits: 1},
+	})
+	p.groupShards(map[string]string{
+		"shard-a": "20240102/0",
+		"shard-b": "20240102/1",
+		"shard-c": "20240103/0",
+	})
+
+	if p.Took != 5*time.Millisecond || len(p.Indexes) != 3 {
+		t.Fatalf("profile took %v over %+v, expected 5ms over 3 indexes", p.Took, p.Indexes)
+	}
+	if idx := p.Indexes[0]; idx.Index != "20240102" || idx.Took != 4*time.Millisecond ||
+		idx.Total != 15 || idx.Hits != 5 || len(idx.Shards) != 2 || idx.Shards[0].Shard != "0" {
+		t.Errorf("index 20240102 profiled as %+v", idx)
+	}
+	if idx := p.Indexes[1]; idx.Index != "20240103" || idx.Error != "shard closed" || len(idx.Shards) != 1 {
+		t.Errorf("index 20240103 profiled as %+v", idx)
+	}
+	if idx := p.Indexes[2]; idx.Index != "remote/20240101" || idx.Hits != 1 || idx.Shards != nil {
+		t.Errorf("remote index profiled as %+v", idx)
+	}
+}
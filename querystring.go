@@ -0,0 +1,294 @@
+package ekanite
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/blevesearch/bleve"
+)
+
+// QueryStringError is a problem of a query string, at a character offset.
+type QueryStringError struct {
+	Position   int    `json:"position"` // Offset in characters, -1 if unknown.
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// QueryStringValidation is the outcome of validating a query string.
+type QueryStringValidation struct {
+	Valid    bool               `json:"valid"`
+	Errors   []QueryStringError `json:"errors,omitempty"`
+	Warnings []QueryStringError `json:"warnings,omitempty"` // Valid syntax which likely does not do what was meant.
+}
+
+// ValidateQueryString validates the bleve query string q. Its errors are
+// located by scanning q the way bleve lexes it, bleve's own error, without
+// position, being returned when the scan finds none.
+func ValidateQueryString(q string) *QueryStringValidation {
+	v := &QueryStringValidation{}
+	errs, warnings := checkQueryString(q)
+	v.Warnings = warnings
+	if strings.TrimSpace(q) == "" {
+		v.Errors = []QueryStringError{{Position: 0, Message: "query is empty", Suggestion: "search all documents with *"}}
+		return v
+	}
+	if err := bleve.NewQueryStringQuery(q).Validate(); err != nil {
+		v.Errors = errs
+		if len(v.Errors) == 0 {
+			v.Errors = []QueryStringError{{Position: -1, Message: err.Error()}}
+		}
+		return v
+	}
+	v.Valid = true
+	return v
+}
+
+// Kinds of query string tokens.
+const (
+	qsString = iota
+	qsNumber
+	qsPhrase
+	qsPlus
+	qsMinus
+	qsColon
+	qsBoost
+	qsTilde
+	qsGreater
+	qsLess
+	qsEqual
+)
+
+// qsToken is a token of a query string, between character offsets.
+type qsToken struct {
+	kind     int
+	text     string
+	pos, end int
+}
+
+// lexQueryString splits q into tokens as bleve does. A phrase left open or an
+// escape at the end of q is returned as an error.
+func lexQueryString(q string) ([]qsToken, *QueryStringError) {
+	rs := []rune(q)
+	var tokens []qsToken
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '"':
+			start := i
+			var b strings.Builder
+			for i++; i < len(rs) && rs[i] != '"'; i++ {
+				if rs[i] == '\\' && i+1 < len(rs) {
+					i++
+				}
+				b.WriteRune(rs[i])
+			}
+			if i == len(rs) {
+				return tokens, &QueryStringError{Position: start, Message: "phrase is not closed",
+					Suggestion: `close it with ", or escape the quote as \"`}
+			}
+			i++
+			tokens = append(tokens, qsToken{kind: qsPhrase, text: b.String(), pos: start, end: i})
+			continue
+		}
+
+		if kind, ok := qsOperators[r]; ok {
+			tokens = append(tokens, qsToken{kind: kind, text: string(r), pos: i, end: i + 1})
+			i++
+			continue
+		}
+
+		start := i
+		var b strings.Builder
+		number := true
+		for ; i < len(rs); i++ {
+			if rs[i] == '\\' {
+				if i+1 == len(rs) {
+					return tokens, &QueryStringError{Position: i, Message: "escape is not followed by a character",
+						Suggestion: `remove the \, or escape it as \\`}
+				}
+				i++
+				number = false
+			} else if unicode.IsSpace(rs[i]) || rs[i] == ':' || rs[i] == '^' || rs[i] == '~' {
+				break
+			} else if !unicode.IsDigit(rs[i]) && rs[i] != '.' {
+				number = false
+			}
+			b.WriteRune(rs[i])
+		}
+		kind := qsString
+		if number {
+			kind = qsNumber
+		}
+		tokens = append(tokens, qsToken{kind: kind, text: b.String(), pos: start, end: i})
+	}
+	return tokens, nil
+}
+
+// qsOperators are the characters lexed as operators when starting a token.
+var qsOperators = map[rune]int{
+	'+': qsPlus,
+	'-': qsMinus,
+	':': qsColon,
+	'^': qsBoost,
+	'~': qsTilde,
+	'>': qsGreater,
+	'<': qsLess,
+	'=': qsEqual,
+}
+
+// checkQueryString returns the syntax errors of q, and the warnings about
+// valid syntax likely meant otherwise, following the grammar of bleve query
+// strings: clauses of an optional + or -, then a term, number or phrase,
+// optionally of a field and with fuzziness, and then an optional boost.
+func checkQueryString(q string) (errs, warnings []QueryStringError) {
+	tokens, lexErr := lexQueryString(q)
+	for _, t := range tokens {
+		if t.kind != qsString {
+			continue
+		}
+		switch t.text {
+		case "AND", "OR", "NOT", "&&", "||":
+			warnings = append(warnings, QueryStringError{Position: t.pos,
+				Message:    fmt.Sprintf("%s is searched as a term, query strings have no boolean operators", t.text),
+				Suggestion: "prefix the clauses which must match with +, and those which must not with -"})
+		}
+		if strings.ContainsAny(t.text, "()") {
+			warnings = append(warnings, QueryStringError{Position: t.pos,
+				Message:    "parentheses do not group clauses, they are searched as part of the term",
+				Suggestion: `escape them as \( and \) to search them, or remove them`})
+		}
+	}
+
+	p := qsChecker{tokens: tokens}
+	for p.i < len(p.tokens) && p.err == nil {
+		p.clause()
+	}
+	// The tokens stop at a lexing error, the clause they end with being cut
+	// short by it.
+	if p.err != nil && (lexErr == nil || p.i < len(p.tokens)) {
+		errs = append(errs, *p.err)
+	} else if lexErr != nil {
+		errs = append(errs, *lexErr)
+	}
+	return errs, warnings
+}
+
+// qsChecker checks query string tokens against the grammar, stopping at the
+// first error.
+type qsChecker struct {
+	tokens []qsToken
+	i      int
+	err    *QueryStringError
+}
+
+// peek returns the kind of the next token, -1 at the end.
+func (p *qsChecker) peek() int {
+	if p.i < len(p.tokens) {
+		return p.tokens[p.i].kind
+	}
+	return -1
+}
+
+// fail records an error at the next token, or at the end of the last one.
+func (p *qsChecker) fail(message, suggestion string) {
+	pos := 0
+	if p.i < len(p.tokens) {
+		pos = p.tokens[p.i].pos
+	} else if n := len(p.tokens); n > 0 {
+		pos = p.tokens[n-1].end
+	}
+	p.err = &QueryStringError{Position: pos, Message: message, Suggestion: suggestion}
+}
+
+// clause checks a clause: [+|-] base [^number].
+func (p *qsChecker) clause() {
+	if k := p.peek(); k == qsPlus || k == qsMinus {
+		p.i++
+		if k := p.peek(); k != qsString && k != qsNumber && k != qsPhrase {
+			p.fail("+ and - must be followed by a term or phrase", "remove the space after the operator, or escape it")
+			return
+		}
+	}
+
+	switch p.peek() {
+	case qsString:
+		p.i++
+		if p.peek() == qsColon {
+			p.i++
+			p.value(p.tokens[p.i-2].text)
+		} else {
+			p.fuzziness()
+		}
+	case qsNumber, qsPhrase:
+		p.i++
+	case qsColon:
+		p.fail("field name is missing before :", `put a field name before the colon, or escape it as \:`)
+	case qsBoost, qsTilde:
+		p.fail(p.tokens[p.i].text+" must follow a term", `attach it to a term, as in error^2 or eror~1, or escape it`)
+	default:
+		p.fail(p.tokens[p.i].text+" must follow a field and colon", "compare a field, as in bytes:>1024")
+	}
+	if p.err != nil {
+		return
+	}
+
+	if p.peek() == qsBoost {
+		p.i++
+		if p.peek() != qsNumber {
+			p.fail("boost must be a number", "give it as in error^2")
+			return
+		}
+		p.i++
+	}
+}
+
+// value checks the value of a field clause.
+func (p *qsChecker) value(field string) {
+	switch p.peek() {
+	case qsString:
+		p.i++
+		p.fuzziness()
+	case qsNumber, qsPhrase:
+		p.i++
+	case qsMinus:
+		p.i++
+		if p.peek() != qsNumber {
+			p.fail("- in a field value must be followed by a number", fmt.Sprintf(`quote the value, as in %s:"-value"`, field))
+			return
+		}
+		p.i++
+	case qsGreater, qsLess:
+		p.i++
+		if p.peek() == qsEqual {
+			p.i++
+		}
+		if p.peek() == qsMinus {
+			p.i++
+			if p.peek() != qsNumber {
+				p.fail("range bound must be a number or a quoted date", fmt.Sprintf(`give it as in %s:>=10 or %s:>"2006-01-02"`, field, field))
+				return
+			}
+		}
+		if k := p.peek(); k != qsNumber && k != qsPhrase {
+			p.fail("range bound must be a number or a quoted date", fmt.Sprintf(`give it as in %s:>=10 or %s:>"2006-01-02"`, field, field))
+			return
+		}
+		p.i++
+	default:
+		p.fail(fmt.Sprintf("field %s has no value", field), fmt.Sprintf(`give it a value, as in %s:value, or escape the colon as \:`, field))
+	}
+}
+
+// fuzziness checks the optional fuzziness of a term: ~[number].
+func (p *qsChecker) fuzziness() {
+	if p.peek() == qsTilde {
+		p.i++
+		if p.peek() == qsNumber {
+			p.i++
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package ekanite
+
+import "testing"
+
+func TestCheckQueryString(t *testing.T) {
+	for _, tt := range []struct {
+		q        string
+		pos      int // Position of the error, -1 if none.
+		warnings int
+	}{
+		{q: `error`, pos: -1},
+		{q: `+host:web1 -app:cron severity:<=3 message:"disk full"^2 eror~1`, pos: -1},
+		{q: `bytes:>-10 reception:>="2017-06-01"`, pos: -1},
+		{q: `message:"disk full`, pos: 8},
+		{q: `host:`, pos: 5},
+		{q: `host: app:cron`, pos: 9},
+		{q: `:web1`, pos: 0},
+		{q: `^2 error`, pos: 0},
+		{q: `error^high`, pos: 6},
+		{q: `bytes:>big`, pos: 7},
+		{q: `error + `, pos: 7},
+		{q: `path:c\`, pos: 6},
+		{q: `error AND host:web1`, pos: -1, warnings: 1},
+		{q: `(error OR warning)`, pos: -1, warnings: 3},
+	} {
+		errs, warnings := checkQueryString(tt.q)
+		if tt.pos < 0 && len(errs) > 0 {
+			t.Errorf("%s: unexpected error %+v", tt.q, errs[0])
+		} else if tt.pos >= 0 && (len(errs) != 1 || errs[0].Position != tt.pos) {
+			t.Errorf("%s: got errors %+v, expected one at %d", tt.q, errs, tt.pos)
+		}
+		if len(warnings) != tt.warnings {
+			t.Errorf("%s: got warnings %+v, expected %d", tt.q, warnings, tt.warnings)
+		}
+	}
+}
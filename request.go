@@ -0,0 +1,103 @@
+package ekanite
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// ReceptionField is the field holding the time events were received, which
+// searches are restricted to a time range and sorted by.
+const ReceptionField = "reception"
+
+// DefaultSort orders the hits of searches which ask for no order, most
+// recently received first.
+var DefaultSort = []string{"-" + ReceptionField}
+
+// WithTimeRange returns q restricted to the documents received between start
+// and end, inclusive. A zero start or end leaves that side open, and a nil q
+// matches every document in the range. A conjunction q gets the time range
+// added to it.
+func WithTimeRange(q query.Query, start, end time.Time) query.Query {
+	inclusive := true
+	timeQuery := bleve.NewDateRangeInclusiveQuery(start, end, &inclusive, &inclusive)
+	timeQuery.SetField(ReceptionField)
+
+	if q == nil {
+		return timeQuery
+	}
+	if conjunctionQuery, ok := q.(*query.ConjunctionQuery); ok {
+		conjunctionQuery.AddQuery(timeQuery)
+		return conjunctionQuery
+	}
+	return bleve.NewConjunctionQuery(q, timeQuery)
+}
+
+// NewSearchRequest returns a request for the given fields, all of them if
+// none, of the documents matching q, sorted by sortBy or by DefaultSort.
+func NewSearchRequest(q query.Query, fields, sortBy []string) *bleve.SearchRequest {
+	searchRequest := bleve.NewSearchRequest(q)
+	searchRequest.Fields = fields
+	if len(searchRequest.Fields) == 0 {
+		searchRequest.Fields = []string{"*"}
+	}
+	if len(sortBy) == 0 {
+		sortBy = DefaultSort
+	}
+	searchRequest.SortBy(sortBy)
+	return searchRequest
+}
+
+// SetPage makes req return limit hits, skipping offset of them. A limit of 0
+// or less returns up to MaxSearchHitSize hits, and a negative offset skips
+// none.
+func SetPage(req *bleve.SearchRequest, limit, offset int) {
+	if limit <= 0 {
+		limit = MaxSearchHitSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	req.Size = limit
+	req.From = offset
+}
+
+// Execute validates req and runs it on the indexes named by indexNames or,
+// if none, on those covering start to end. Naming indexes needs searcher to
+// be an IndexSearcher, otherwise ErrIndexesNotSupported is returned.
+func Execute(ctx context.Context, searcher Searcher, start, end time.Time, indexNames []string,
+	req *bleve.SearchRequest, cb func(*bleve.SearchRequest, *bleve.SearchResult) error) error {
+	if srqv, ok := req.Query.(query.ValidatableQuery); ok {
+		if err := srqv.Validate(); err != nil {
+			return fmt.Errorf("error validating query: %w", err)
+		}
+	}
+
+	if len(indexNames) == 0 {
+		return searcher.Query(ctx, start, end, req, cb)
+	}
+	indexSearcher, ok := searcher.(IndexSearcher)
+	if !ok {
+		return ErrIndexesNotSupported
+	}
+	return indexSearcher.QueryIndexes(ctx, indexNames, req, cb)
+}
+
+// RetentionWarning returns why a search from startTime finds fewer documents
+// than asked for if startTime predates the oldest data searcher retains, and
+// is empty if it does not or searcher cannot tell.
+func RetentionWarning(searcher Searcher, startTime time.Time) string {
+	r, ok := searcher.(RetentionReporter)
+	if !ok || startTime.IsZero() {
+		return ""
+	}
+	earliest, ok := r.EarliestRetained()
+	if !ok || !startTime.Before(earliest) {
+		return ""
+	}
+	return fmt.Sprintf("requested range truncated to available data: %s predates the earliest retained index, starting %s",
+		startTime.Format(time.RFC3339), earliest.Format(time.RFC3339))
+}
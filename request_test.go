@@ -0,0 +1,110 @@
+package ekanite
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	bleve_index "github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// timeSearcher is a Searcher which cannot search indexes by name.
+type timeSearcher struct {
+	start, end time.Time
+}
+
+func (s *timeSearcher) Query(ctx context.Context, startTime, endTime time.Time, req *bleve.SearchRequest,
+	cb func(*bleve.SearchRequest, *bleve.SearchResult) error) error {
+	s.start, s.end = startTime, endTime
+	return cb(req, &bleve.SearchResult{})
+}
+
+func (s *timeSearcher) Fields(ctx context.Context, startTime, endTime time.Time) ([]string, error) {
+	return nil, nil
+}
+
+func (s *timeSearcher) FieldDict(ctx context.Context, startTime, endTime time.Time, field string) ([]bleve_index.DictEntry, error) {
+	return nil, nil
+}
+
+func TestNewSearchRequest(t *testing.T) {
+	start, end := parseTime("1982-02-05T04:00:00Z"), parseTime("1982-02-05T05:00:00Z")
+
+	conjunction := bleve.NewConjunctionQuery(bleve.NewTermQuery("auth"))
+	if q := WithTimeRange(conjunction, start, end); q != conjunction || len(conjunction.Conjuncts) != 2 {
+		t.Fatalf("time range not added to the conjunction: %#v", q)
+	}
+	if q, ok := WithTimeRange(nil, start, end).(*query.DateRangeQuery); !ok || q.FieldVal != ReceptionField {
+		t.Fatalf("unexpected time range query: %#v", q)
+	}
+
+	req := NewSearchRequest(bleve.NewMatchAllQuery(), nil, nil)
+	if len(req.Fields) != 1 || req.Fields[0] != "*" {
+		t.Fatalf("unexpected fields %v", req.Fields)
+	}
+	if len(req.Sort) != 1 || req.Sort[0].(*search.SortField).Field != ReceptionField || !req.Sort[0].(*search.SortField).Desc {
+		t.Fatalf("unexpected sort %v", req.Sort)
+	}
+
+	SetPage(req, 0, -1)
+	if req.Size != MaxSearchHitSize || req.From != 0 {
+		t.Fatalf("unexpected page size %d from %d", req.Size, req.From)
+	}
+
+	s := &timeSearcher{}
+	called := false
+	err := Execute(context.Background(), s, start, end, nil, req, func(*bleve.SearchRequest, *bleve.SearchResult) error {
+		called = true
+		return nil
+	})
+	if err != nil || !called || !s.start.Equal(start) || !s.end.Equal(end) {
+		t.Fatalf("search by time range failed: %v", err)
+	}
+	err = Execute(context.Background(), s, start, end, []string{"19820205_0400"}, req, func(*bleve.SearchRequest, *bleve.SearchResult) error {
+		return nil
+	})
+	if !errors.Is(err, ErrIndexesNotSupported) {
+		t.Fatalf("search by index name returned %v, expected ErrIndexesNotSupported", err)
+	}
+}
+
+func TestRetentionWarning(t *testing.T) {
+	if w := RetentionWarning(&timeSearcher{}, time.Now()); w != "" {
+		t.Fatalf("searcher without retention warned %q", w)
+	}
+
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine: %s", err)
+	}
+	defer e.Close()
+	if _, ok := e.EarliestRetained(); ok {
+		t.Fatal("empty engine reports retained data")
+	}
+
+	oldest := time.Date(2020, 3, 10, 12, 0, 0, 0, time.UTC)
+	if err := e.Index([]Document{
+		newIndexableEvent("old", oldest),
+		newIndexableEvent("new", oldest.Add(72*time.Hour)),
+	}); err != nil {
+		t.Fatalf("failed to index events: %s", err)
+	}
+	earliest, ok := e.EarliestRetained()
+	if !ok || !earliest.Equal(oldest.Truncate(DefaultIndexDuration)) {
+		t.Fatalf("earliest retained data is %s, expected %s", earliest, oldest.Truncate(DefaultIndexDuration))
+	}
+	if w := RetentionWarning(e, earliest); w != "" {
+		t.Errorf("search from the earliest index warned %q", w)
+	}
+	if w := RetentionWarning(e, earliest.Add(-time.Hour)); !strings.Contains(w, "truncated") {
+		t.Errorf("search before the earliest index warned %q", w)
+	}
+}
@@ -0,0 +1,138 @@
+package ekanite
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/blevesearch/bleve"
+	bleve_index "github.com/blevesearch/bleve/index"
+)
+
+// Sampling limits
+const (
+	MaxSampleSize = 1000
+	sampleTerms   = 20
+)
+
+// IndexSample holds randomly chosen documents, and the most frequent terms of
+// a field, of an index.
+type IndexSample struct {
+	Name      string                   `json:"name"`
+	Documents uint64                   `json:"documents"`
+	Samples   []map[string]interface{} `json:"samples"`
+	Terms     []bleve_index.DictEntry  `json:"terms"`
+}
+
+// SampleIndex returns up to n random stored documents of the named index, and
+// the most frequent terms of field in it.
+func (e *Engine) SampleIndex(ctx context.Context, name, field string, n int) (*IndexSample, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	stats.Add("queriesRx", 1)
+
+	i := e.indexByName(name)
+	if i == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownIndex, name)
+	}
+	if n > MaxSampleSize {
+		n = MaxSampleSize
+	}
+
+	total, err := i.Total()
+	if err != nil {
+		return nil, err
+	}
+	sample := &IndexSample{Name: name, Documents: total}
+
+	for _, offset := range sampleOffsets(int(total), n) {
+		req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), 1, offset, false)
+		req.SortBy([]string{"_id"})
+		req.Fields = []string{"*"}
+		resp, err := i.Alias.SearchInContext(ctx, req)
+		if err != nil {
+			return nil, wrapQueryError(ctx, err)
+		}
+		for _, hit := range resp.Hits {
+			doc := map[string]interface{}{"_id": hit.ID}
+			for k, v := range hit.Fields {
+				doc[k] = v
+			}
+			sample.Samples = append(sample.Samples, doc)
+		}
+	}
+
+	if field != "" {
+		sample.Terms, err = topTerms(i, field, sampleTerms)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sample, nil
+}
+
+// sampleOffsets returns n distinct random offsets below total, in increasing
+// order, or all of them if there are no more than n.
+func sampleOffsets(total, n int) []int {
+	if n <= 0 || total <= 0 {
+		return nil
+	}
+	if total <= n {
+		offsets := make([]int, total)
+		for k := range offsets {
+			offsets[k] = k
+		}
+		return offsets
+	}
+	picked := make(map[int]bool, n)
+	offsets := make([]int, 0, n)
+	for len(offsets) < n {
+		k := rand.Intn(total)
+		if !picked[k] {
+			picked[k] = true
+			offsets = append(offsets, k)
+		}
+	}
+	sort.Ints(offsets)
+	return offsets
+}
+
+// topTerms returns the max most frequent terms of field across the shards of
+// the index.
+func topTerms(i *Index, field string, max int) ([]bleve_index.DictEntry, error) {
+	counts := map[string]uint64{}
+	for _, shard := range i.Shards {
+		dict, err := shard.b.FieldDict(field)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			entry, err := dict.Next()
+			if err != nil {
+				dict.Close()
+				return nil, err
+			}
+			if entry == nil {
+				break
+			}
+			counts[entry.Term] += entry.Count
+		}
+		dict.Close()
+	}
+
+	terms := make([]bleve_index.DictEntry, 0, len(counts))
+	for term, count := range counts {
+		terms = append(terms, bleve_index.DictEntry{Term: term, Count: count})
+	}
+	sort.Slice(terms, func(u, v int) bool {
+		if terms[u].Count != terms[v].Count {
+			return terms[u].Count > terms[v].Count
+		}
+		return terms[u].Term < terms[v].Term
+	})
+	if len(terms) > max {
+		terms = terms[:max]
+	}
+	return terms, nil
+}
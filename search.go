@@ -1,8 +1,9 @@
 package ekanite
 
 import (
+	"container/heap"
 	"context"
-	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,9 +12,18 @@ import (
 )
 
 type asyncSearchResult struct {
-	Index  bleve.Index
+	Child  childSearch
 	Result *bleve.SearchResult
 	Err    error
+	Took   time.Duration
+}
+
+// childSearch is one of the targets a SearchRequest is executed across, such
+// as a bleve index or a remote node.
+type childSearch struct {
+	Name   string
+	Index  bleve.Index // The index searched, nil if not a local one.
+	Search func(ctx context.Context, req *bleve.SearchRequest) (*bleve.SearchResult, error)
 }
 
 // createChildSearchRequest creates a separate
@@ -24,7 +34,7 @@ type asyncSearchResult struct {
 // the actual final results.
 // Perhaps that part needs to be optional,
 // could be slower in remote usages.
-func createChildSearchRequest(req *bleve.SearchRequest) *bleve.SearchRequest {
+func createChildSearchRequest(req *bleve.SearchRequest, order search.SortOrder) *bleve.SearchRequest {
 	rv := bleve.SearchRequest{
 		Query:            req.Query,
 		Size:             req.Size + req.From,
@@ -33,31 +43,66 @@ func createChildSearchRequest(req *bleve.SearchRequest) *bleve.SearchRequest {
 		Fields:           req.Fields,
 		Facets:           req.Facets,
 		Explain:          req.Explain,
-		Sort:             req.Sort,
+		Sort:             order.Copy(), // Sort keys hold the state of the search using them.
 		IncludeLocations: req.IncludeLocations,
 	}
 	return &rv
 }
 
+// tieBreakOrder returns the sort order of req, by descending score if none,
+// with the document ID as its last key. Hits ranked equal by the requested
+// order, such as events received at the same time on different shards, are
+// then ranked by the time and sequence their IDs encode, in the direction of
+// the first key, so that paging over them never skips or repeats one.
+func tieBreakOrder(req *bleve.SearchRequest) search.SortOrder {
+	if len(req.Sort) == 0 {
+		return search.SortOrder{&search.SortScore{Desc: true}, &search.SortDocID{Desc: true}}
+	}
+	for _, s := range req.Sort {
+		if _, ok := s.(*search.SortDocID); ok {
+			return req.Sort
+		}
+	}
+	order := make(search.SortOrder, 0, len(req.Sort)+1)
+	order = append(order, req.Sort...)
+	return append(order, &search.SortDocID{Desc: req.Sort[0].Descending()})
+}
+
 // MultiSearch executes a SearchRequest across multiple Index objects,
 // then merges the results.  The indexes must honor any ctx deadline.
 func MultiSearch(ctx context.Context, req *bleve.SearchRequest, indexes ...bleve.Index) (*SearchResult, error) {
+	children := make([]childSearch, 0, len(indexes))
+	for _, in := range indexes {
+		children = append(children, childSearch{Name: in.Name(), Index: in, Search: in.SearchInContext})
+	}
+	return multiSearch(ctx, req, children)
+}
+
+// multiSearch executes a SearchRequest across the children, then merges the
+// results. The children must honor any ctx deadline.
+func multiSearch(ctx context.Context, req *bleve.SearchRequest, children []childSearch) (*SearchResult, error) {
 	searchStart := time.Now()
-	asyncResults := make(chan *asyncSearchResult, len(indexes))
+	asyncResults := make(chan *asyncSearchResult, len(children))
 
-	// run search on each index in separate go routine
+	// run search on each child in separate go routine
 	var waitGroup sync.WaitGroup
 
-	var searchChildIndex = func(in bleve.Index, childReq *bleve.SearchRequest) {
-		rv := asyncSearchResult{Index: in}
-		rv.Result, rv.Err = in.SearchInContext(ctx, childReq)
+	var searchChild = func(child childSearch, childReq *bleve.SearchRequest) {
+		rv := asyncSearchResult{Child: child}
+		childStart := time.Now()
+		rv.Result, rv.Err = child.Search(ctx, childReq)
+		rv.Took = time.Since(childStart)
 		asyncResults <- &rv
 		waitGroup.Done()
 	}
 
-	waitGroup.Add(len(indexes))
-	for _, in := range indexes {
-		go searchChildIndex(in, createChildSearchRequest(req))
+	// every child ranks its hits in the same total order the merge does,
+	// through its own copy of it
+	order := tieBreakOrder(req)
+
+	waitGroup.Add(len(children))
+	for _, child := range children {
+		go searchChild(child, createChildSearchRequest(req, order))
 	}
 
 	// on another go routine, close after finished
@@ -68,30 +113,42 @@ func MultiSearch(ctx context.Context, req *bleve.SearchRequest, indexes ...bleve
 
 	var sr *SearchResult
 	indexErrors := make(map[string]error)
+	profile := searchProfile(ctx)
+	var childResults []*asyncSearchResult
+
+	// keep only the best From+Size hits, however many indexes are searched
+	best := newHitHeap(order, req.From+req.Size)
 
 	for asr := range asyncResults {
+		if profile != nil {
+			childResults = append(childResults, asr)
+		}
 		if asr.Err == nil {
+			for _, hit := range asr.Result.Hits {
+				best.add(&DocumentMatch{
+					Index: asr.Child.Index,
+					Doc:   hit,
+				})
+			}
+
+			result := *asr.Result
+			result.Hits = nil
 			if sr == nil {
 				// first result
-				sr = &SearchResult{SearchResult: asr.Result}
+				sr = &SearchResult{SearchResult: &result}
 			} else {
 				// merge with previous
-				sr.Merge(asr.Result)
-			}
-
-			for _, hit := range asr.Result.Hits {
-				sr.DocumentHits = append(sr.DocumentHits, &DocumentMatch{
-					Index: asr.Index,
-					Doc:   hit,
-				})
+				sr.Merge(&result)
 			}
 		} else {
-			indexErrors[asr.Index.Name()] = asr.Err
+			indexErrors[asr.Child.Name] = asr.Err
 		}
 	}
 
-	// merge just concatenated all the hits
-	// now lets clean it up
+	// children which gave up on the ctx deadline fail the whole search
+	if err := ctx.Err(); err != nil && len(indexErrors) > 0 {
+		return nil, err
+	}
 
 	// handle case where no results were successful
 	if sr == nil {
@@ -102,25 +159,17 @@ func MultiSearch(ctx context.Context, req *bleve.SearchRequest, indexes ...bleve
 		}}
 	}
 
-	// sort all hits with the requested order
-	if len(req.Sort) > 0 {
-		sorter := newMultiSearchHitSorter(req.Sort, sr.DocumentHits, sr.Hits)
-		sort.Sort(sorter)
-	}
-
-	// now skip over the correct From
-	if req.From > 0 && len(sr.Hits) > req.From {
-		sr.Hits = sr.Hits[req.From:]
+	// hits come out of the heap in the requested order, skip over the
+	// correct From
+	sr.DocumentHits = best.sorted()
+	if req.From > 0 && len(sr.DocumentHits) > req.From {
 		sr.DocumentHits = sr.DocumentHits[req.From:]
 	} else if req.From > 0 {
-		sr.Hits = search.DocumentMatchCollection{}
 		sr.DocumentHits = DocumentMatchCollection{}
 	}
-
-	// now trim to the correct size
-	if req.Size > 0 && len(sr.Hits) > req.Size {
-		sr.Hits = sr.Hits[0:req.Size]
-		sr.DocumentHits = sr.DocumentHits[0:req.Size]
+	sr.Hits = make(search.DocumentMatchCollection, 0, len(sr.DocumentHits))
+	for _, hit := range sr.DocumentHits {
+		sr.Hits = append(sr.Hits, hit.Doc)
 	}
 
 	// fix up facets
@@ -133,6 +182,11 @@ func MultiSearch(ctx context.Context, req *bleve.SearchRequest, indexes ...bleve
 	searchDuration := time.Since(searchStart)
 	sr.Took = searchDuration
 
+	if profile != nil {
+		profile.record(searchDuration, profileChildren(childResults, sr.Hits))
+		sr.Profile = profile
+	}
+
 	// fix up errors
 	if len(indexErrors) > 0 {
 		if sr.Status.Errors == nil {
@@ -148,32 +202,86 @@ func MultiSearch(ctx context.Context, req *bleve.SearchRequest, indexes ...bleve
 	return sr, nil
 }
 
-type multiSearchHitSorter struct {
-	documentHits  DocumentMatchCollection
-	hits          search.DocumentMatchCollection
+// profileChildren returns the part each child took in a search, hits being
+// the hits returned.
+func profileChildren(results []*asyncSearchResult, hits search.DocumentMatchCollection) []IndexProfile {
+	children := make([]IndexProfile, 0, len(results))
+	for _, asr := range results {
+		child := IndexProfile{Index: asr.Child.Name, Took: asr.Took}
+		if asr.Err != nil {
+			child.Error = asr.Err.Error()
+		} else {
+			child.Total = asr.Result.Total
+		}
+		for _, hit := range hits {
+			if hit.Index == child.Index || strings.HasPrefix(hit.Index, child.Index+"/") || hit.Fields[NodeField] == child.Index {
+				child.Hits++
+			}
+		}
+		children = append(children, child)
+	}
+	return children
+}
+
+// hitHeap keeps the best max hits added to it. The worst of them is at the
+// root, so that a better hit replaces it in O(log max).
+type hitHeap struct {
+	hits          DocumentMatchCollection
+	max           int
 	sort          search.SortOrder
 	cachedScoring []bool
 	cachedDesc    []bool
 }
 
-func newMultiSearchHitSorter(sort search.SortOrder, docHits DocumentMatchCollection, hits search.DocumentMatchCollection) *multiSearchHitSorter {
-	return &multiSearchHitSorter{
+func newHitHeap(sort search.SortOrder, max int) *hitHeap {
+	return &hitHeap{
+		max:           max,
 		sort:          sort,
-		documentHits:  docHits,
-		hits:          hits,
 		cachedScoring: sort.CacheIsScore(),
 		cachedDesc:    sort.CacheDescending(),
 	}
 }
 
-func (m *multiSearchHitSorter) Len() int { return len(m.hits) }
-func (m *multiSearchHitSorter) Swap(i, j int) {
-	m.hits[i], m.hits[j] = m.hits[j], m.hits[i]
-	m.documentHits[i], m.documentHits[j] = m.documentHits[j], m.documentHits[i]
+// before returns whether a ranks before b. The sort order must end with the
+// document ID, hits of different children being ranked by their hit number
+// otherwise.
+func (h *hitHeap) before(a, b *DocumentMatch) bool {
+	return h.sort.Compare(h.cachedScoring, h.cachedDesc, a.Doc, b.Doc) < 0
+}
+
+func (h *hitHeap) Len() int           { return len(h.hits) }
+func (h *hitHeap) Swap(i, j int)      { h.hits[i], h.hits[j] = h.hits[j], h.hits[i] }
+func (h *hitHeap) Less(i, j int) bool { return h.before(h.hits[j], h.hits[i]) }
+func (h *hitHeap) Push(x interface{}) { h.hits = append(h.hits, x.(*DocumentMatch)) }
+func (h *hitHeap) Pop() interface{} {
+	n := len(h.hits) - 1
+	x := h.hits[n]
+	h.hits = h.hits[:n]
+	return x
+}
+
+// add adds m to the heap if it ranks among the best max hits.
+func (h *hitHeap) add(m *DocumentMatch) {
+	if h.max <= 0 {
+		return
+	}
+	if len(h.hits) < h.max {
+		heap.Push(h, m)
+		return
+	}
+	if h.before(m, h.hits[0]) {
+		h.hits[0] = m
+		heap.Fix(h, 0)
+	}
 }
-func (m *multiSearchHitSorter) Less(i, j int) bool {
-	c := m.sort.Compare(m.cachedScoring, m.cachedDesc, m.hits[i], m.hits[j])
-	return c < 0
+
+// sorted empties the heap, returning its hits in rank order.
+func (h *hitHeap) sorted() DocumentMatchCollection {
+	rv := make(DocumentMatchCollection, len(h.hits))
+	for i := len(rv) - 1; i >= 0; i-- {
+		rv[i] = heap.Pop(h).(*DocumentMatch)
+	}
+	return rv
 }
 
 // A SearchResult describes the results of executing
@@ -181,6 +289,7 @@ func (m *multiSearchHitSorter) Less(i, j int) bool {
 type SearchResult struct {
 	*bleve.SearchResult
 	DocumentHits DocumentMatchCollection `json:"-"`
+	Profile      *SearchProfile          `json:"profile,omitempty"` // Set if the search context asked for it.
 }
 
 type DocumentMatch struct {
@@ -190,6 +299,11 @@ type DocumentMatch struct {
 
 type DocumentMatchCollection []*DocumentMatch
 
-func (c DocumentMatchCollection) Len() int           { return len(c) }
-func (c DocumentMatchCollection) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
-func (c DocumentMatchCollection) Less(i, j int) bool { return c[i].Doc.Score > c[j].Doc.Score }
+func (c DocumentMatchCollection) Len() int      { return len(c) }
+func (c DocumentMatchCollection) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c DocumentMatchCollection) Less(i, j int) bool {
+	if c[i].Doc.Score != c[j].Doc.Score {
+		return c[i].Doc.Score > c[j].Doc.Score
+	}
+	return c[i].Doc.ID > c[j].Doc.ID
+}
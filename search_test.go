@@ -0,0 +1,96 @@
+package ekanite
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+)
+
+func TestMultiSearch_BoundedMerge(t *testing.T) {
+	var indexes []bleve.Index
+	for i := 0; i < 3; i++ {
+		idx, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+		if err != nil {
+			t.Fatalf("failed to create index: %s", err.Error())
+		}
+		defer idx.Close()
+		for n := i; n < 15; n += 3 {
+			if err := idx.Index(fmt.Sprintf("doc%02d", n), map[string]interface{}{"n": float64(n)}); err != nil {
+				t.Fatalf("failed to index document: %s", err.Error())
+			}
+		}
+		indexes = append(indexes, idx)
+	}
+
+	req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), 3, 2, false)
+	req.SortBy([]string{"n"})
+	sr, err := MultiSearch(context.Background(), req, indexes...)
+	if err != nil {
+		t.Fatalf("failed to search: %s", err.Error())
+	}
+	if sr.Total != 15 {
+		t.Fatalf("total hits incorrect, got %d, expected 15", sr.Total)
+	}
+	exp := []string{"doc02", "doc03", "doc04"}
+	if len(sr.Hits) != len(exp) || len(sr.DocumentHits) != len(exp) {
+		t.Fatalf("hit count incorrect, got %d/%d, expected %d", len(sr.Hits), len(sr.DocumentHits), len(exp))
+	}
+	for i, id := range exp {
+		if sr.Hits[i].ID != id || sr.DocumentHits[i].Doc.ID != id {
+			t.Fatalf("hit %d incorrect, got %s, expected %s", i, sr.Hits[i].ID, id)
+		}
+	}
+}
+
+func TestMultiSearch_TieBreak(t *testing.T) {
+	var indexes []bleve.Index
+	for i := 0; i < 3; i++ {
+		idx, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+		if err != nil {
+			t.Fatalf("failed to create index: %s", err.Error())
+		}
+		defer idx.Close()
+		// every document has the same sort value and score
+		for n := i; n < 12; n += 3 {
+			if err := idx.Index(fmt.Sprintf("doc%02d", n), map[string]interface{}{"n": float64(1)}); err != nil {
+				t.Fatalf("failed to index document: %s", err.Error())
+			}
+		}
+		indexes = append(indexes, idx)
+	}
+
+	tests := []struct {
+		sort  []string
+		first string
+	}{
+		{sort: []string{"n"}, first: "doc00"},
+		{sort: []string{"-n"}, first: "doc11"},
+		{first: "doc11"},
+	}
+	for _, tt := range tests {
+		var ids []string
+		for from := 0; from < 12; from += 5 {
+			req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), 5, from, false)
+			if tt.sort != nil {
+				req.SortBy(tt.sort)
+			}
+			sr, err := MultiSearch(context.Background(), req, indexes...)
+			if err != nil {
+				t.Fatalf("failed to search: %s", err.Error())
+			}
+			for _, hit := range sr.Hits {
+				ids = append(ids, hit.ID)
+			}
+		}
+		if len(ids) != 12 || ids[0] != tt.first {
+			t.Fatalf("sort %v paged over %v, expected 12 hits from %s", tt.sort, ids, tt.first)
+		}
+		for i := 1; i < len(ids); i++ {
+			if ids[i] == ids[i-1] || (ids[i] < ids[i-1]) != (ids[0] > ids[1]) {
+				t.Fatalf("sort %v paged over %v, not ordered by ID", tt.sort, ids)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of the definitions of the meta store a DanglingReference is found in.
+const (
+	KindFilter          = "filter"
+	KindContinuousQuery = "continuous_query"
+	KindSubscription    = "subscription"
+	KindPanel           = "panel"
+)
+
+// DanglingReference is a reference of a definition of the meta store to a
+// field, saved filter or target type which does not exist.
+type DanglingReference struct {
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	Field  string `json:"field,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// ConsistencyReport lists the dangling references of the meta store. Fields
+// are not checked while the indexes hold no field at all, as with a new data
+// directory.
+type ConsistencyReport struct {
+	CheckedAt     time.Time           `json:"checked_at"`
+	FieldsChecked bool                `json:"fields_checked"`
+	Dangling      []DanglingReference `json:"dangling"`
+}
+
+// Check cross-checks the saved filters, their continuous queries, the
+// subscriptions and the panels against the fields of the indexes, the saved filters, and
+// the target types for which isTarget returns true, if not nil. Query string
+// filters are not checked, since the fields they refer to are only known to
+// the index.
+func (h *MetaStore) Check(fields []string, isTarget func(typ string) bool) *ConsistencyReport {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	report := &ConsistencyReport{
+		CheckedAt:     time.Now(),
+		FieldsChecked: len(fields) > 0,
+		Dangling:      []DanglingReference{},
+	}
+	known := map[string]bool{}
+	for _, field := range fields {
+		known[field] = true
+	}
+	checkField := func(kind, id, field string) {
+		if report.FieldsChecked && field != "" && field != "*" && !known[field] {
+			report.Dangling = append(report.Dangling, DanglingReference{
+				Kind: kind, ID: id, Field: field, Reason: "field is not in any index",
+			})
+		}
+	}
+
+	queryIDs := make([]string, 0, len(h.queries))
+	for id := range h.queries {
+		queryIDs = append(queryIDs, id)
+	}
+	sort.Strings(queryIDs)
+	for _, id := range queryIDs {
+		q := h.queries[id]
+		for _, f := range q.Filters {
+			if f.Op == OpQueryString {
+				continue
+			}
+			checkField(KindFilter, id, f.Field)
+		}
+		if _, err := q.ToQueries(nil); err != nil {
+			report.Dangling = append(report.Dangling, DanglingReference{
+				Kind: KindFilter, ID: id, Reason: "filter is invalid: " + err.Error(),
+			})
+		}
+
+		cqIDs := make([]string, 0, len(q.ContinuousQueries))
+		for cqID := range q.ContinuousQueries {
+			cqIDs = append(cqIDs, cqID)
+		}
+		sort.Strings(cqIDs)
+		for _, cqID := range cqIDs {
+			cq := q.ContinuousQueries[cqID]
+			for _, field := range cq.Fields {
+				checkField(KindContinuousQuery, id+"/"+cqID, field)
+			}
+			checkField(KindContinuousQuery, id+"/"+cqID, cq.GroupBy)
+			if isTarget == nil {
+				continue
+			}
+			for _, target := range cq.Targets {
+				if !isTarget(target.Type) {
+					report.Dangling = append(report.Dangling, DanglingReference{
+						Kind: KindContinuousQuery, ID: id + "/" + cqID, Reason: "target '" + target.Type + "' is unsupported",
+					})
+				}
+			}
+		}
+	}
+
+	subIDs := make([]string, 0, len(h.subscriptions))
+	for id := range h.subscriptions {
+		subIDs = append(subIDs, id)
+	}
+	sort.Strings(subIDs)
+	for _, id := range subIDs {
+		sub := h.subscriptions[id]
+		if sub.Query != "" {
+			if _, ok := h.queries[sub.Query]; !ok {
+				report.Dangling = append(report.Dangling, DanglingReference{
+					Kind: KindSubscription, ID: id, Reason: "saved filter '" + sub.Query + "' does not exist",
+				})
+			}
+		}
+		for _, field := range sub.Fields {
+			checkField(KindSubscription, id, field)
+		}
+	}
+
+	panelIDs := make([]string, 0, len(h.panels))
+	for id := range h.panels {
+		panelIDs = append(panelIDs, id)
+	}
+	sort.Strings(panelIDs)
+	for _, id := range panelIDs {
+		p := h.panels[id]
+		if p.Query != "" {
+			if _, ok := h.queries[p.Query]; !ok {
+				report.Dangling = append(report.Dangling, DanglingReference{
+					Kind: KindPanel, ID: id, Reason: "saved filter '" + p.Query + "' does not exist",
+				})
+			}
+		}
+		if ss := strings.Fields(p.GroupBy); len(ss) == 1 || len(ss) == 3 {
+			checkField(KindPanel, id, ss[len(ss)-1])
+		}
+	}
+	return report
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestMetaStore_Check(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "ekanite-meta-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dataDir)
+
+	var q Query
+	if err := json.Unmarshal([]byte(`{
+		"name": "errors",
+		"filters": [
+			{"field": "host", "op": "Term", "values": ["web"]},
+			{"field": "gone", "op": "Term", "values": ["x"]},
+			{"op": "QueryString", "values": ["missing:field"]}
+		],
+		"continuous_queries": {
+			"count": {"groupBy": "app", "targets": [{"type": "mail"}]}
+		}
+	}`), &q); err != nil {
+		t.Fatalf("failed to decode query: %s", err.Error())
+	}
+
+	store := NewMetaStore(dataDir)
+	queryID, err := store.CreateQuery(q)
+	if err != nil {
+		t.Fatalf("failed to create query: %s", err.Error())
+	}
+	otherID, err := store.CreateQuery(Query{Name: "other"})
+	if err != nil {
+		t.Fatalf("failed to create query: %s", err.Error())
+	}
+	subID, err := store.CreateSubscription(Subscription{Query: otherID, Fields: []string{"message"}, Interval: "1m", Webhook: "http://example.com/hook"})
+	if err != nil {
+		t.Fatalf("failed to create subscription: %s", err.Error())
+	}
+	if err := store.DeleteQuery(otherID); err != nil {
+		t.Fatalf("failed to delete query: %s", err.Error())
+	}
+
+	report := store.Check([]string{"host", "message"}, func(typ string) bool { return typ == "log" })
+	if !report.FieldsChecked {
+		t.Errorf("fields were not checked")
+	}
+	expected := []DanglingReference{
+		{Kind: KindFilter, ID: queryID, Field: "gone", Reason: "field is not in any index"},
+		{Kind: KindContinuousQuery, ID: queryID + "/count", Field: "app", Reason: "field is not in any index"},
+		{Kind: KindContinuousQuery, ID: queryID + "/count", Reason: "target 'mail' is unsupported"},
+		{Kind: KindSubscription, ID: subID, Reason: "saved filter '" + otherID + "' does not exist"},
+	}
+	if !reflect.DeepEqual(report.Dangling, expected) {
+		t.Errorf("dangling references are %+v, expected %+v", report.Dangling, expected)
+	}
+
+	// Without fields in the indexes, only the other references are checked.
+	report = store.Check(nil, nil)
+	if report.FieldsChecked || len(report.Dangling) != 1 || report.Dangling[0].Kind != KindSubscription {
+		t.Errorf("report without fields is %+v", report)
+	}
+}
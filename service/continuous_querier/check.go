@@ -0,0 +1,40 @@
+package continuous_querier
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ekanite/ekanite"
+	"github.com/ekanite/ekanite/service"
+)
+
+// CheckMeta cross-checks the definitions of the meta store against the fields
+// of all the indexes of the searcher and the registered target types, so that
+// dangling references are reported up front rather than failing when run.
+func CheckMeta(ctx context.Context, searcher ekanite.Searcher, metaStore *service.MetaStore) (*service.ConsistencyReport, error) {
+	fields, err := searcher.Fields(ctx, time.Time{}, time.Time{})
+	if err != nil && !errors.Is(err, ekanite.ErrIndexNotFound) {
+		return nil, err
+	}
+	return metaStore.Check(fields, IsRegistered), nil
+}
+
+// checkMeta logs the dangling references of the meta store.
+func (s *Service) checkMeta(ctx context.Context) {
+	report, err := CheckMeta(ctx, s.searcher, s.metaStore)
+	if err != nil {
+		s.Logger.Println("consistency check of meta store fail,", err)
+		return
+	}
+	for _, d := range report.Dangling {
+		if d.Field != "" {
+			s.Logger.Println(d.Kind+"(id="+d.ID+") references field '"+d.Field+"',", d.Reason)
+		} else {
+			s.Logger.Println(d.Kind+"(id="+d.ID+"),", d.Reason)
+		}
+	}
+	if !report.FieldsChecked {
+		s.Logger.Println("consistency check of meta store skipped fields, no index has any")
+	}
+}
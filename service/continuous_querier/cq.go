@@ -2,7 +2,9 @@ package continuous_querier
 
 import (
 	"context"
+	"errors"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/blevesearch/bleve"
@@ -17,6 +19,9 @@ type Service struct {
 	searcher    ekanite.Searcher
 	runInterval time.Duration
 
+	mu       sync.Mutex
+	compiled map[string]*compiledQuery // By query ID, until the query changes.
+
 	// RunCh can be used by clients to signal service to run CQs.
 	// runCh chan struct{}
 }
@@ -29,10 +34,74 @@ func NewService(logger *log.Logger, searcher ekanite.Searcher, metaStore *servic
 		searcher:    searcher,
 		metaStore:   metaStore,
 		runInterval: runInterval,
+		compiled:    map[string]*compiledQuery{},
 		//runCh:       make(chan struct{}),
 	}
 }
 
+// compiledQuery is a query of the meta store compiled for its continuous
+// queries to run.
+type compiledQuery struct {
+	queries   []query.Query
+	err       error // Why the filters failed to compile.
+	cqs       map[string]service.ContinuousQuery
+	callbacks map[string]CQHandleFunc
+	cbErrs    map[string]error // Why the targets of each CQ failed to be created.
+}
+
+// compile compiles the filters of the query and the targets of its continuous
+// queries.
+func (s *Service) compile(qu *service.Query) *compiledQuery {
+	c := &compiledQuery{
+		cqs:       make(map[string]service.ContinuousQuery, len(qu.ContinuousQueries)),
+		callbacks: make(map[string]CQHandleFunc, len(qu.ContinuousQueries)),
+		cbErrs:    map[string]error{},
+	}
+	c.queries, c.err = qu.ToQueries(ekanite.CaseFoldOf(s.searcher))
+	for key, cq := range qu.ContinuousQueries {
+		cb, err := s.createCallBack(&cq)
+		if err != nil {
+			c.cbErrs[key] = err
+			continue
+		}
+		c.cqs[key] = cq
+		c.callbacks[key] = cb
+	}
+	return c
+}
+
+// compiledQuery returns the query id compiled, compiling it if it was not
+// yet or changed since.
+func (s *Service) compiledQuery(id string, qu *service.Query) *compiledQuery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.compiled[id]
+	if !ok {
+		c = s.compile(qu)
+		s.compiled[id] = c
+	}
+	return c
+}
+
+// queryChanged recompiles the query changed in the meta store, so that its
+// continuous queries run with the change from the next run on.
+func (s *Service) queryChanged(change service.QueryChange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if change.Deleted || len(change.Query.ContinuousQueries) == 0 {
+		delete(s.compiled, change.ID)
+		return
+	}
+	c := s.compile(&change.Query)
+	if c.err != nil {
+		s.Logger.Println("load queries of query(id="+change.ID+") fail,", c.err)
+	}
+	for key, err := range c.cbErrs {
+		s.Logger.Println("load callbacks of cq(query="+change.ID+", id="+key+") fail,", err)
+	}
+	s.compiled[change.ID] = c
+}
+
 // func (s *Service) Signal() {
 // 	select {
 // 	case s.runCh <- struct{}{}:
@@ -40,8 +109,12 @@ func NewService(logger *log.Logger, searcher ekanite.Searcher, metaStore *servic
 // 	}
 // }
 
-// runs on a go routine and periodically executes CQs.
+// runs on a go routine, logs the dangling references of the meta store, and
+// periodically executes CQs.
 func (s *Service) RunLoop(stop chan struct{}) {
+	s.checkMeta(context.Background())
+	defer s.metaStore.Watch(s.queryChanged)()
+
 	t := time.NewTicker(s.runInterval)
 	defer t.Stop()
 
@@ -87,52 +160,46 @@ func (s *Service) runContinuousQueries(startAt, endAt time.Time) {
 	})
 
 	for idx, key := range keys {
-		s.runQuery(context.Background(), startAt, endAt, key, &qList[idx])
+		s.runQuery(context.Background(), startAt, endAt, key, s.compiledQuery(key, &qList[idx]))
 	}
 }
 
 func (s *Service) isTimeField(field string) bool {
-	return field == "reception"
+	return field == ekanite.ReceptionField
 }
 
 // runContinuousQueries gets CQs from the meta store and runs them.
-func (s *Service) runQuery(ctx context.Context, startTime, endTime time.Time, id string, qu *service.Query) {
-	inclusive := true
-	timeQuery := bleve.NewDateRangeInclusiveQuery(startTime, endTime, &inclusive, &inclusive)
-	timeQuery.SetField("reception")
-
+func (s *Service) runQuery(ctx context.Context, startTime, endTime time.Time, id string, c *compiledQuery) {
 	var q query.Query
-	if queries, err := qu.ToQueries(); err != nil {
-		s.Logger.Println("load queries of query(id="+id+") fail,", err)
+	if c.err != nil {
+		s.Logger.Println("load queries of query(id="+id+") fail,", c.err)
 		return
-	} else if len(queries) == 0 {
-		q = timeQuery
-	} else {
-		conjunction := bleve.NewConjunctionQuery(queries...)
-		conjunction.AddQuery(timeQuery)
-		q = conjunction
+	} else if len(c.queries) > 0 {
+		// WithTimeRange adds to the conjunction, which must not share the
+		// queries compiled.
+		q = bleve.NewConjunctionQuery(append([]query.Query(nil), c.queries...)...)
 	}
+	q = ekanite.WithTimeRange(q, startTime, endTime)
 
-	for key, cq := range qu.ContinuousQueries {
-
-		cb, err := s.createCallBack(&cq)
-		if err != nil {
-			s.Logger.Println("load callbacks of cq(query="+id+", id="+key+") fail,", err)
-			continue
-		}
+	for key, err := range c.cbErrs {
+		s.Logger.Println("load callbacks of cq(query="+id+", id="+key+") fail,", err)
+	}
+	for key, cq := range c.cqs {
+		cq := cq
+		cb := c.callbacks[key]
+		ctx := ekanite.WithSearchCaller(ctx, "continuous_query/"+id+"/"+key)
 
 		if cq.GroupBy == "" {
-			searchRequest := bleve.NewSearchRequest(q)
-			searchRequest.Fields = cq.Fields
-			if len(searchRequest.Fields) == 0 {
-				searchRequest.Fields = []string{"*"}
-			}
-			err := s.searcher.Query(ctx, startTime, endTime, searchRequest, toHandler(&cq, cb))
+			searchRequest := ekanite.NewSearchRequest(q, cq.Fields, nil)
+			err := ekanite.Execute(ctx, s.searcher, startTime, endTime, nil, searchRequest, toHandler(&cq, cb))
 			if err != nil {
 				s.Logger.Println("cq(query="+id+", id="+key+") execute fail,", err)
 			}
 		} else {
 			err := ekanite.GroupBy(s.searcher, ctx, startTime, endTime, q, cq.GroupBy, toGroupByHandler(&cq, cb))
+			if errors.Is(err, ekanite.ErrFieldNotFound) {
+				err = toGroupByHandler(&cq, cb)(map[string]uint64{})
+			}
 			if err != nil {
 				s.Logger.Println("cq(query="+id+", id="+key+") execute fail,", err)
 			}
@@ -22,6 +22,14 @@ func Register(typ string, create func(*service.ContinuousQuery, []string) (CQHan
 	factory[typ] = create
 }
 
+// IsRegistered returns whether targets of the type typ can be created.
+func IsRegistered(typ string) bool {
+	factoryLock.Lock()
+	defer factoryLock.Unlock()
+	_, ok := factory[typ]
+	return ok
+}
+
 func (s *Service) createCallBack(cq *service.ContinuousQuery) (CQHandleFunc, error) {
 	if cq.Callback != nil {
 		return cq.Callback, nil
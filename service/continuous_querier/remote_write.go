@@ -0,0 +1,217 @@
+package continuous_querier
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/ekanite/ekanite/service"
+	"github.com/golang/snappy"
+)
+
+// RemoteWriteTarget is the type of the targets pushing the results of their
+// continuous queries to a Prometheus remote-write endpoint. Its arguments are
+// the URL of the endpoint, the metric name, then labels as name=value added to
+// every sample. A continuous query grouping by a field pushes one sample per
+// group, the count of its documents, labeled by the field. The others push a
+// single sample, the count of the documents matched.
+const RemoteWriteTarget = "prometheus"
+
+// remoteWriteTimeout bounds a push to a remote-write endpoint.
+const remoteWriteTimeout = 30 * time.Second
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRe  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	labelCharRe  = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+func init() {
+	Register(RemoteWriteTarget, newRemoteWriteTarget)
+}
+
+// label is a label of a time series.
+type label struct {
+	name, value string
+}
+
+// timeSeries is a time series of a remote-write request, with one sample.
+type timeSeries struct {
+	labels    []label
+	value     float64
+	timestamp int64 // Milliseconds since the epoch.
+}
+
+// remoteWriter pushes the results of a continuous query to a remote-write
+// endpoint.
+type remoteWriter struct {
+	url     string
+	metric  string
+	labels  []label
+	groupBy string // Label of the groups, empty if not grouped.
+	client  *http.Client
+	now     func() time.Time
+}
+
+// newRemoteWriteTarget creates a target pushing to the remote-write endpoint
+// of the arguments.
+func newRemoteWriteTarget(cq *service.ContinuousQuery, args []string) (CQHandleFunc, error) {
+	if len(args) < 2 {
+		return nil, errors.New("target '" + RemoteWriteTarget + "' needs the url of the endpoint and a metric name")
+	}
+	u, err := url.Parse(args[0])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("target '" + RemoteWriteTarget + "' has an invalid url '" + args[0] + "'")
+	}
+	if !metricNameRe.MatchString(args[1]) {
+		return nil, errors.New("target '" + RemoteWriteTarget + "' has an invalid metric name '" + args[1] + "'")
+	}
+
+	w := &remoteWriter{
+		url:    args[0],
+		metric: args[1],
+		client: &http.Client{Timeout: remoteWriteTimeout},
+		now:    time.Now,
+	}
+	if cq.GroupBy != "" {
+		w.groupBy = labelCharRe.ReplaceAllString(cq.GroupBy, "_")
+		if !labelNameRe.MatchString(w.groupBy) {
+			w.groupBy = "_" + w.groupBy
+		}
+	}
+	for _, arg := range args[2:] {
+		ss := strings.SplitN(arg, "=", 2)
+		if len(ss) != 2 || !labelNameRe.MatchString(ss[0]) || strings.HasPrefix(ss[0], "__") || ss[0] == w.groupBy {
+			return nil, errors.New("target '" + RemoteWriteTarget + "' has an invalid label '" + arg + "'")
+		}
+		w.labels = append(w.labels, label{name: ss[0], value: ss[1]})
+	}
+	return w.push, nil
+}
+
+// push pushes the value of a run of the continuous query.
+func (w *remoteWriter) push(cq *service.ContinuousQuery, value interface{}) error {
+	series, err := w.series(value)
+	if err != nil || len(series) == 0 {
+		return err
+	}
+	return w.post(context.Background(), encodeWriteRequest(series))
+}
+
+// series returns the time series of the value of a run, a count by group or
+// a search result, sampled now.
+func (w *remoteWriter) series(value interface{}) ([]timeSeries, error) {
+	timestamp := w.now().UnixNano() / int64(time.Millisecond)
+	sample := func(value float64, extra ...label) timeSeries {
+		labels := append([]label{{name: "__name__", value: w.metric}}, w.labels...)
+		labels = append(labels, extra...)
+		sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+		return timeSeries{labels: labels, value: value, timestamp: timestamp}
+	}
+
+	switch v := value.(type) {
+	case map[string]uint64:
+		groups := make([]string, 0, len(v))
+		for group := range v {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+		series := make([]timeSeries, 0, len(v))
+		for _, group := range groups {
+			series = append(series, sample(float64(v[group]), label{name: w.groupBy, value: group}))
+		}
+		return series, nil
+	case *bleve.SearchResult:
+		return []timeSeries{sample(float64(v.Total))}, nil
+	default:
+		return nil, fmt.Errorf("target '%s' cannot push %T", RemoteWriteTarget, value)
+	}
+}
+
+// post posts the encoded write request, failing on other statuses than 2xx.
+func (w *remoteWriter) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(snappy.Encode(nil, body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("remote-write endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// encodeWriteRequest encodes the series as the protocol buffer of a
+// prometheus.WriteRequest, without the schema compiler:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []timeSeries) []byte {
+	var req, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = appendString(msg[:0], 1, l.name)
+			msg = appendString(msg, 2, l.value)
+			ts = appendBytes(ts, 1, msg)
+		}
+		msg = appendKey(msg[:0], 1, 1)
+		msg = appendFixed64(msg, math.Float64bits(s.value))
+		msg = appendKey(msg, 2, 0)
+		msg = appendUvarint(msg, uint64(s.timestamp))
+		ts = appendBytes(ts, 2, msg)
+		req = appendBytes(req, 1, ts)
+	}
+	return req
+}
+
+// appendUvarint appends v as a varint.
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// appendFixed64 appends v as a little-endian fixed64.
+func appendFixed64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// appendKey appends the key of a field of the wire type.
+func appendKey(b []byte, field, wireType uint64) []byte {
+	return appendUvarint(b, field<<3|wireType)
+}
+
+// appendBytes appends a length-delimited field.
+func appendBytes(b []byte, field uint64, v []byte) []byte {
+	b = appendKey(b, field, 2)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendString appends a string field.
+func appendString(b []byte, field uint64, v string) []byte {
+	b = appendKey(b, field, 2)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
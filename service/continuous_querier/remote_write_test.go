@@ -0,0 +1,93 @@
+package continuous_querier
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ekanite/ekanite/service"
+	"github.com/golang/snappy"
+)
+
+func TestEncodeWriteRequest(t *testing.T) {
+	got := encodeWriteRequest([]timeSeries{{
+		labels:    []label{{name: "__name__", value: "m"}},
+		value:     1,
+		timestamp: 2,
+	}})
+	want := []byte{
+		0x0a, 0x1c, // timeseries
+		0x0a, 0x0d, // labels
+		0x0a, 0x08, '_', '_', 'n', 'a', 'm', 'e', '_', '_',
+		0x12, 0x01, 'm',
+		0x12, 0x0b, // samples
+		0x09, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f,
+		0x10, 0x02,
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("encoded as % x, want % x", got, want)
+	}
+}
+
+func TestRemoteWriteTarget(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"http://localhost:9090/api/v1/write"},
+		{"localhost:9090", "m"},
+		{"http://localhost:9090/api/v1/write", "bad-name"},
+		{"http://localhost:9090/api/v1/write", "m", "env"},
+		{"http://localhost:9090/api/v1/write", "m", "__name__=x"},
+		{"http://localhost:9090/api/v1/write", "m", "host=x"},
+	} {
+		if _, err := newRemoteWriteTarget(&service.ContinuousQuery{GroupBy: "host"}, args); err == nil {
+			t.Fatalf("target with arguments %q created", args)
+		}
+	}
+
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("pushed with headers %v", r.Header)
+		}
+		bs, _ := ioutil.ReadAll(r.Body)
+		var err error
+		if body, err = snappy.Decode(nil, bs); err != nil {
+			t.Errorf("failed to decode body: %s", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	cq := &service.ContinuousQuery{GroupBy: "host"}
+	cb, err := newRemoteWriteTarget(cq, []string{srv.URL, "ekanite_events", "env=prod"})
+	if err != nil {
+		t.Fatalf("failed to create target: %s", err)
+	}
+	if err := cb(cq, map[string]uint64{"b": 3, "a": 2}); err != nil {
+		t.Fatalf("failed to push: %s", err)
+	}
+	if len(body) == 0 {
+		t.Fatal("nothing pushed")
+	}
+
+	now := time.Unix(1500000000, 0)
+	w := &remoteWriter{metric: "ekanite_events", labels: []label{{"env", "prod"}}, groupBy: "host", now: func() time.Time { return now }}
+	series, err := w.series(map[string]uint64{"b": 3, "a": 2})
+	if err != nil {
+		t.Fatalf("failed to get series: %s", err)
+	}
+	want := []timeSeries{
+		{labels: []label{{"__name__", "ekanite_events"}, {"env", "prod"}, {"host", "a"}}, value: 2, timestamp: 1500000000000},
+		{labels: []label{{"__name__", "ekanite_events"}, {"env", "prod"}, {"host", "b"}}, value: 3, timestamp: 1500000000000},
+	}
+	if !reflect.DeepEqual(series, want) {
+		t.Fatalf("got series %v, want %v", series, want)
+	}
+	if _, err := w.series("3"); err == nil {
+		t.Fatal("string value pushed")
+	}
+}
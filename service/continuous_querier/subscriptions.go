@@ -0,0 +1,240 @@
+package continuous_querier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/ekanite/ekanite"
+	"github.com/ekanite/ekanite/service"
+)
+
+const (
+	// MaxDeliverySize is the most documents pushed by a single delivery.
+	// Further documents are pushed by the next ones.
+	MaxDeliverySize = 1000
+
+	// subscriptionLag is how far deliveries stay behind now, so that the
+	// documents received just before are indexed by the time they are
+	// searched.
+	subscriptionLag = 10 * time.Second
+
+	// subscriptionTick is how often subscriptions are checked for delivery.
+	subscriptionTick = time.Second
+)
+
+// Delivery is the body posted to the webhook of a subscription.
+type Delivery struct {
+	Subscription string                   `json:"subscription"`
+	Query        string                   `json:"query,omitempty"`
+	From         time.Time                `json:"from"`
+	To           time.Time                `json:"to"`
+	Documents    []map[string]interface{} `json:"documents"`
+}
+
+// SubscriptionService pushes the documents newly matching subscriptions to
+// their webhooks. Deliveries are at least once: a failed delivery is retried
+// at the next interval, and documents received in the second of the last one
+// pushed by a truncated delivery may be pushed again.
+type SubscriptionService struct {
+	Logger *log.Logger
+	Client *http.Client
+
+	// Mask applies the field masks of a role to a delivered document, the
+	// role of the creator of the subscription. Without Mask, documents are
+	// delivered unmasked.
+	Mask func(role string, doc map[string]interface{})
+
+	metaStore *service.MetaStore
+	searcher  ekanite.Searcher
+	lastRun   map[string]time.Time
+}
+
+// NewSubscriptionService returns a new SubscriptionService instance.
+func NewSubscriptionService(logger *log.Logger, searcher ekanite.Searcher, metaStore *service.MetaStore) *SubscriptionService {
+	s := &SubscriptionService{
+		Logger:    logger,
+		metaStore: metaStore,
+		searcher:  searcher,
+		lastRun:   map[string]time.Time{},
+	}
+	s.Client = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{DialContext: s.dialWebhook},
+	}
+	return s
+}
+
+// dialWebhook dials the address of a webhook, refusing the loopback, private
+// and link-local addresses its host resolves to unless the host is allowed by
+// the meta store. The addresses are checked once resolved, as a host name may
+// resolve to any of them.
+func (s *SubscriptionService) dialWebhook(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	if service.WebhookAllowed(s.metaStore.WebhookHosts, host) {
+		return d.DialContext(ctx, network, addr)
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if service.PrivateAddress(ip.IP) {
+			return nil, fmt.Errorf("webhook host '%s' resolves to the private address %s", host, ip.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("webhook host '%s' has no address", host)
+	}
+	return d.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+}
+
+// RunLoop runs on a go routine and delivers the subscriptions which are due.
+func (s *SubscriptionService) RunLoop(stop chan struct{}) {
+	t := time.NewTicker(subscriptionTick)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			s.Logger.Println("subscription service terminating")
+			return
+		case now := <-t.C:
+			s.runSubscriptions(now)
+		}
+	}
+}
+
+// runSubscriptions delivers the subscriptions whose interval has passed since
+// their last run.
+func (s *SubscriptionService) runSubscriptions(now time.Time) {
+	for _, sub := range s.metaStore.ListSubscriptions() {
+		if last, ok := s.lastRun[sub.ID]; ok && now.Sub(last) < sub.IntervalDuration() {
+			continue
+		}
+		s.lastRun[sub.ID] = now
+
+		ctx := ekanite.WithSearchCaller(context.Background(), "subscription/"+sub.ID)
+		watermark, err := s.Deliver(ctx, &sub, now)
+		var lastError string
+		if err != nil {
+			lastError = err.Error()
+			s.Logger.Println("subscription(id="+sub.ID+") delivery fail,", err)
+		}
+		if err := s.metaStore.UpdateWatermark(sub.ID, watermark, lastError); err != nil &&
+			!errors.Is(err, service.ErrRecordNotFound) {
+			s.Logger.Println("subscription(id="+sub.ID+") save fail,", err)
+		}
+	}
+}
+
+// Deliver pushes the documents matching the subscription received after its
+// watermark, returning the watermark to deliver from next.
+func (s *SubscriptionService) Deliver(ctx context.Context, sub *service.Subscription, now time.Time) (time.Time, error) {
+	var q query.Query
+	if sub.Query != "" {
+		qu, err := s.metaStore.ReadQuery(sub.Query)
+		if err != nil {
+			return sub.Watermark, err
+		}
+		queries, err := qu.ToQueries(ekanite.CaseFoldOf(s.searcher))
+		if err != nil {
+			return sub.Watermark, err
+		}
+		if len(queries) > 0 {
+			q = bleve.NewConjunctionQuery(queries...)
+		}
+	}
+
+	from := sub.Watermark.Add(time.Nanosecond)
+	to := now.Add(-subscriptionLag)
+	if !to.After(from) {
+		return sub.Watermark, nil
+	}
+
+	fields := sub.Fields
+	if len(fields) > 0 {
+		fields = append([]string{ekanite.ReceptionField}, fields...)
+	}
+	req := ekanite.NewSearchRequest(ekanite.WithTimeRange(q, from, to), fields, []string{ekanite.ReceptionField})
+	ekanite.SetPage(req, MaxDeliverySize, 0)
+
+	delivery := Delivery{Subscription: sub.ID, Query: sub.Query, From: from, To: to, Documents: []map[string]interface{}{}}
+	var truncated bool
+	var last string // Reception of the last document, which masks may hide.
+	err := ekanite.Execute(ctx, s.searcher, from, to, nil, req, func(_ *bleve.SearchRequest, resp *bleve.SearchResult) error {
+		for _, hit := range resp.Hits {
+			doc := map[string]interface{}{"_id": hit.ID}
+			for k, v := range hit.Fields {
+				doc[k] = v
+			}
+			last, _ = hit.Fields[ekanite.ReceptionField].(string)
+			if s.Mask != nil {
+				s.Mask(sub.Role, doc)
+			}
+			delivery.Documents = append(delivery.Documents, doc)
+		}
+		truncated = resp.Total > uint64(len(resp.Hits))
+		return nil
+	})
+	if err != nil && !errors.Is(err, ekanite.ErrIndexNotFound) {
+		return sub.Watermark, err
+	}
+
+	// Stored times lose their fraction of a second, so a truncated delivery
+	// resumes from the start of the second of its last document.
+	watermark := to
+	if truncated {
+		t, err := time.Parse(time.RFC3339Nano, last)
+		if err != nil {
+			return sub.Watermark, fmt.Errorf("reception '%s' of the last document is invalid: %w", last, err)
+		}
+		delivery.To = t
+		watermark = t.Add(-time.Nanosecond)
+		if !watermark.After(sub.Watermark) {
+			// More than MaxDeliverySize documents in a second, skip the rest
+			// of them rather than stall.
+			watermark = t.Add(time.Second - time.Nanosecond)
+		}
+	}
+	if len(delivery.Documents) == 0 {
+		return watermark, nil
+	}
+	if err := s.post(ctx, sub.Webhook, &delivery); err != nil {
+		return sub.Watermark, err
+	}
+	return watermark, nil
+}
+
+// post posts the delivery to the webhook, failing on other statuses than 2xx.
+func (s *SubscriptionService) post(ctx context.Context, webhook string, delivery *Delivery) error {
+	body, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package continuous_querier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ekanite/ekanite"
+	"github.com/ekanite/ekanite/service"
+)
+
+type receivedEvent struct {
+	reception time.Time
+	sequence  int64
+	fields    map[string]interface{}
+}
+
+func (e *receivedEvent) ID() ekanite.DocID {
+	return ekanite.DocID(fmt.Sprintf("%016x%016x", uint64(e.reception.UnixNano()), uint64(e.sequence)))
+}
+func (e *receivedEvent) Data() interface{}        { return e.fields }
+func (e *receivedEvent) ReferenceTime() time.Time { return e.reception }
+
+func TestSubscriptionService_Deliver(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "ekanite-subscriptions-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dataDir)
+
+	e := ekanite.NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine: %s", err.Error())
+	}
+	defer e.Close()
+
+	received := time.Date(1982, 2, 5, 4, 43, 0, 0, time.UTC)
+	var events []ekanite.Document
+	for n, message := range []string{"auth accepted for root", "auth failed for root", "auth accepted for philip"} {
+		events = append(events, &receivedEvent{
+			reception: received.Add(time.Duration(n) * time.Second),
+			sequence:  int64(n + 1),
+			fields:    map[string]interface{}{"message": message, "reception": received.Add(time.Duration(n) * time.Second)},
+		})
+	}
+	if err := e.Index(events); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	metaStore := service.NewMetaStore(dataDir)
+	metaStore.WebhookHosts = []string{"127.0.0.1"}
+	queryID, err := metaStore.CreateQuery(service.Query{Name: "root", Filters: []service.Filter{
+		{Field: "message", Op: service.OpTerm, Values: []string{"root"}},
+	}})
+	if err != nil {
+		t.Fatalf("failed to save filter: %s", err.Error())
+	}
+
+	var deliveries []Delivery
+	status := http.StatusOK
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var d Delivery
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			t.Errorf("failed to decode delivery: %s", err.Error())
+		}
+		deliveries = append(deliveries, d)
+		w.WriteHeader(status)
+	}))
+	defer webhook.Close()
+
+	id, err := metaStore.CreateSubscription(service.Subscription{
+		Query:     queryID,
+		Interval:  "1m",
+		Webhook:   webhook.URL,
+		Watermark: received.Add(-time.Second),
+	})
+	if err != nil {
+		t.Fatalf("failed to create subscription: %s", err.Error())
+	}
+	sub, _ := metaStore.ReadSubscription(id)
+
+	s := NewSubscriptionService(log.New(ioutil.Discard, "", 0), e, metaStore)
+	now := received.Add(time.Hour)
+
+	status = http.StatusInternalServerError
+	if watermark, err := s.Deliver(context.Background(), &sub, now); err == nil || !watermark.Equal(sub.Watermark) {
+		t.Fatalf("failed delivery returned watermark %s, %v, expected the previous one and an error", watermark, err)
+	}
+
+	status = http.StatusOK
+	deliveries = nil
+	watermark, err := s.Deliver(context.Background(), &sub, now)
+	if err != nil {
+		t.Fatalf("failed to deliver subscription: %s", err.Error())
+	}
+	if len(deliveries) != 1 || len(deliveries[0].Documents) != 2 || deliveries[0].Subscription != id {
+		t.Fatalf("deliveries %+v, expected one of the 2 documents matching root", deliveries)
+	}
+	if !watermark.Equal(now.Add(-subscriptionLag)) {
+		t.Fatalf("watermark %s, expected %s", watermark, now.Add(-subscriptionLag))
+	}
+
+	// Nothing new was received since.
+	deliveries = nil
+	sub.Watermark = watermark
+	if _, err := s.Deliver(context.Background(), &sub, now.Add(time.Minute)); err != nil || len(deliveries) != 0 {
+		t.Fatalf("redelivery returned %v, posted %+v, expected nothing", err, deliveries)
+	}
+
+	// The documents are masked for the role of the creator.
+	deliveries = nil
+	masked := sub
+	masked.Role = "auditor"
+	masked.Watermark = received.Add(-time.Second)
+	s.Mask = func(role string, doc map[string]interface{}) {
+		if role == "auditor" {
+			delete(doc, "message")
+		}
+	}
+	if _, err := s.Deliver(context.Background(), &masked, now); err != nil {
+		t.Fatalf("failed to deliver masked subscription: %s", err.Error())
+	}
+	if len(deliveries) != 1 || len(deliveries[0].Documents) != 2 {
+		t.Fatalf("deliveries %+v, expected one of the 2 documents matching root", deliveries)
+	}
+	for _, doc := range deliveries[0].Documents {
+		if _, ok := doc["message"]; ok {
+			t.Fatalf("document %v delivered to an auditor has its message", doc)
+		}
+	}
+	s.Mask = nil
+
+	// Webhooks on private addresses are refused unless allowed.
+	deliveries = nil
+	metaStore.WebhookHosts = nil
+	masked.Role = ""
+	s.Client.CloseIdleConnections() // The address is checked when dialing.
+	if _, err := s.Deliver(context.Background(), &masked, now); err == nil || len(deliveries) != 0 {
+		t.Fatalf("delivery to a loopback webhook returned %v, posted %+v, expected an error", err, deliveries)
+	}
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -142,5 +143,5 @@ func searchIn(searcher ekanite.Searcher, start, end time.Time, searchRequest *bl
 	}
 
 	// execute the query
-	return searcher.Query(start, end, searchRequest, cb)
+	return searcher.Query(context.Background(), start, end, searchRequest, cb)
 }
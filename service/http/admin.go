@@ -0,0 +1,338 @@
+package http
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ekanite/ekanite"
+	"github.com/ekanite/ekanite/service/continuous_querier"
+)
+
+// readIndexNames returns the index names given by the comma-separated indexes
+// parameter.
+func readIndexNames(params url.Values) []string {
+	var names []string
+	for _, value := range params["indexes"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// indexSearcher returns the searcher as an ekanite.IndexSearcher, writing an
+// error to w if it is not one.
+func (s *Server) indexSearcher(w http.ResponseWriter) (ekanite.IndexSearcher, bool) {
+	indexSearcher, ok := s.Searcher.(ekanite.IndexSearcher)
+	if !ok {
+		http.Error(w, "index administration is not supported", http.StatusNotImplemented)
+	}
+	return indexSearcher, ok
+}
+
+// ListIndexes lists the indexes of the searcher.
+func (s *Server) ListIndexes(w http.ResponseWriter, r *http.Request) {
+	indexSearcher, ok := s.indexSearcher(w)
+	if !ok {
+		return
+	}
+	indexes, err := indexSearcher.Indexes()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error listing indexes: %v", err), errorStatus(w, err))
+		return
+	}
+	renderJSON(w, indexes)
+}
+
+// SampleIndex returns random documents, and the top terms of the field
+// parameter, of the named index. The n parameter sets the number of
+// documents, 10 by default.
+func (s *Server) SampleIndex(w http.ResponseWriter, r *http.Request, name string) {
+	indexSearcher, ok := s.indexSearcher(w)
+	if !ok {
+		return
+	}
+
+	queryParams := r.URL.Query()
+	n := 10
+	if nStr := queryParams.Get("n"); nStr != "" {
+		i64, err := strconv.ParseInt(nStr, 10, 0)
+		if err != nil || i64 < 0 {
+			http.Error(w, "n("+nStr+") is invalid.", http.StatusBadRequest)
+			return
+		}
+		n = int(i64)
+	}
+
+	field := queryParams.Get("field")
+	masks := s.fieldMasks(r)
+	if masked(masks, field) {
+		http.Error(w, "field("+field+") is restricted.", http.StatusForbidden)
+		return
+	}
+
+	sample, err := indexSearcher.SampleIndex(r.Context(), name, field, n)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error sampling index: %v", err), errorStatus(w, err))
+		return
+	}
+	for _, fields := range sample.Samples {
+		mask(masks, fields)
+	}
+	renderJSON(w, sample)
+}
+
+// FieldReport reports the types and cardinality of the fields of the n most
+// recent documents, 1000 by default, between the start_at and end_at
+// parameters. Fields whose values have several types or are nearly all
+// distinct are flagged.
+func (s *Server) FieldReport(w http.ResponseWriter, r *http.Request) {
+	reporter, ok := s.Searcher.(ekanite.FieldReporter)
+	if !ok {
+		http.Error(w, "field reports are not supported", http.StatusNotImplemented)
+		return
+	}
+	var n int
+	if nStr := r.URL.Query().Get("n"); nStr != "" {
+		i64, err := strconv.ParseInt(nStr, 10, 0)
+		if err != nil || i64 < 0 {
+			http.Error(w, "n("+nStr+") is invalid.", http.StatusBadRequest)
+			return
+		}
+		n = int(i64)
+	}
+	s.timeRange(w, r, func(w http.ResponseWriter, r *http.Request, start, end time.Time) {
+		report, err := reporter.FieldReport(r.Context(), start, end, n)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error reporting fields: %v", err), errorStatus(w, err))
+			return
+		}
+		masks := s.fieldMasks(r)
+		fields := report.Fields[:0]
+		for _, f := range report.Fields {
+			if !masked(masks, f.Name) {
+				fields = append(fields, f)
+			}
+		}
+		report.Fields = fields
+		renderJSON(w, report)
+	})
+}
+
+// IndexStorage returns the storage breakdown of the named index.
+func (s *Server) IndexStorage(w http.ResponseWriter, r *http.Request, name string) {
+	indexSearcher, ok := s.indexSearcher(w)
+	if !ok {
+		return
+	}
+	storage, err := indexSearcher.IndexStorage(r.Context(), name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading index storage: %v", err), errorStatus(w, err))
+		return
+	}
+	renderJSON(w, storage)
+}
+
+// IndexMetadata returns the settings the named index was created with,
+// including its mapping.
+func (s *Server) IndexMetadata(w http.ResponseWriter, r *http.Request, name string) {
+	indexSearcher, ok := s.indexSearcher(w)
+	if !ok {
+		return
+	}
+	md, err := indexSearcher.IndexMetadata(name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading index metadata: %v", err), errorStatus(w, err))
+		return
+	}
+	renderJSON(w, md)
+}
+
+// ArchiveIndex streams the named index as a tar archive, with a manifest of
+// the checksums of its files, checked when the index is opened after being
+// extracted into the data path of an engine.
+func (s *Server) ArchiveIndex(w http.ResponseWriter, r *http.Request, name string) {
+	archiver, ok := s.Searcher.(ekanite.IndexArchiver)
+	if !ok {
+		http.Error(w, "index archives are not supported", http.StatusNotImplemented)
+		return
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(archiver.ArchiveIndex(r.Context(), name, pw))
+	}()
+	defer pr.Close()
+
+	// Read the first bytes before writing the headers, so that failing to
+	// stage the index is reported with its status.
+	br := bufio.NewReader(pr)
+	if _, err := br.Peek(1); err != nil {
+		http.Error(w, fmt.Sprintf("error archiving index: %v", err), errorStatus(w, err))
+		return
+	}
+	s.audit(r, "index.archive", name, nil)
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.tar"`)
+	if _, err := io.Copy(w, br); err != nil {
+		log.Println("[WARN] archive of index", name, "stopped:", err)
+	}
+}
+
+// HoldIndex holds the named index, for the reason parameter, exempting it
+// from retention enforcement until released.
+func (s *Server) HoldIndex(w http.ResponseWriter, r *http.Request, name string) {
+	holder, ok := s.Searcher.(ekanite.IndexHolder)
+	if !ok {
+		http.Error(w, "index holds are not supported", http.StatusNotImplemented)
+		return
+	}
+	reason := strings.TrimSpace(r.URL.Query().Get("reason"))
+	if reason == "" {
+		http.Error(w, "reason is required.", http.StatusBadRequest)
+		return
+	}
+	if err := holder.HoldIndex(name, reason); err != nil {
+		http.Error(w, fmt.Sprintf("error holding index: %v", err), errorStatus(w, err))
+		return
+	}
+	s.audit(r, "index.hold", name, map[string]string{"reason": reason})
+	renderJSON(w, map[string]string{"name": name, "hold": reason})
+}
+
+// ReleaseIndex releases the hold on the named index.
+func (s *Server) ReleaseIndex(w http.ResponseWriter, r *http.Request, name string) {
+	holder, ok := s.Searcher.(ekanite.IndexHolder)
+	if !ok {
+		http.Error(w, "index holds are not supported", http.StatusNotImplemented)
+		return
+	}
+	if err := holder.ReleaseIndex(name); err != nil {
+		http.Error(w, fmt.Sprintf("error releasing index: %v", err), errorStatus(w, err))
+		return
+	}
+	s.audit(r, "index.release", name, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// activityMonitor returns the searcher as an ekanite.ActivityMonitor, writing
+// an error to w if it is not one.
+func (s *Server) activityMonitor(w http.ResponseWriter) (ekanite.ActivityMonitor, bool) {
+	monitor, ok := s.Searcher.(ekanite.ActivityMonitor)
+	if !ok {
+		http.Error(w, "search activity is not supported", http.StatusNotImplemented)
+	}
+	return monitor, ok
+}
+
+// ListActivity lists the searches running, oldest first, with the query,
+// caller and indexes of each. The caller parameter restricts them to those of
+// a caller.
+func (s *Server) ListActivity(w http.ResponseWriter, r *http.Request) {
+	monitor, ok := s.activityMonitor(w)
+	if !ok {
+		return
+	}
+	list := monitor.Activity()
+	if caller := r.URL.Query().Get("caller"); caller != "" {
+		filtered := list[:0]
+		for _, a := range list {
+			if a.Caller == caller {
+				filtered = append(filtered, a)
+			}
+		}
+		list = filtered
+	}
+	renderJSON(w, list)
+}
+
+// CancelSearch cancels the running search id, which fails as cancelled.
+func (s *Server) CancelSearch(w http.ResponseWriter, r *http.Request, id string) {
+	monitor, ok := s.activityMonitor(w)
+	if !ok {
+		return
+	}
+	if err := monitor.CancelSearch(id); err != nil {
+		http.Error(w, fmt.Sprintf("error cancelling search: %v", err), errorStatus(w, err))
+		return
+	}
+	s.audit(r, "search.cancel", id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CheckConsistency reports the references of the saved filters, continuous
+// queries and subscriptions to fields, filters or targets which do not exist.
+func (s *Server) CheckConsistency(w http.ResponseWriter, r *http.Request) {
+	report, err := continuous_querier.CheckMeta(r.Context(), s.Searcher, s.metaStore)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error checking consistency: %v", err), errorStatus(w, err))
+		return
+	}
+	renderJSON(w, report)
+}
+
+// ListQuotas lists what each tenant ingested against its quotas.
+func (s *Server) ListQuotas(w http.ResponseWriter, r *http.Request) {
+	if s.Quotas == nil {
+		http.Error(w, "ingest quotas are not enabled", http.StatusNotImplemented)
+		return
+	}
+	renderJSON(w, s.Quotas.Usage())
+}
+
+// ParseMessage parses the message of the body in its format, syslog by
+// default, as the collectors do, returning the fields it would be indexed with
+// and any errors, without indexing it.
+func (s *Server) ParseMessage(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Format  string `json:"format"`
+		Message string `json:"message"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if body.Message == "" {
+		http.Error(w, "message is empty", http.StatusBadRequest)
+		return
+	}
+	if body.Format == "" {
+		body.Format = "syslog"
+	}
+	result, err := s.Pipeline.DryRun(body.Format, body.Message)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	renderJSON(w, result)
+}
+
+// ReloadConfig re-reads the configuration, reporting the settings applied and
+// those which need a restart.
+func (s *Server) ReloadConfig(w http.ResponseWriter, r *http.Request) {
+	if s.Reload == nil {
+		http.Error(w, "config reload is not supported", http.StatusNotImplemented)
+		return
+	}
+	applied, restart, err := s.Reload()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reloading config: %v", err), http.StatusBadRequest)
+		return
+	}
+	if applied == nil {
+		applied = []string{}
+	}
+	if restart == nil {
+		restart = []string{}
+	}
+	s.audit(r, "config.reload", "", map[string]interface{}{"applied": applied, "restart_required": restart})
+	renderJSON(w, map[string]interface{}{"applied": applied, "restart_required": restart})
+}
@@ -0,0 +1,64 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ekanite/ekanite"
+)
+
+// actor returns who made req, for the audit log: its role if it has one, or
+// else its remote address.
+func (s *Server) actor(req *http.Request) string {
+	if s.Role != nil {
+		if role := s.Role(req); role != "" {
+			return role
+		}
+	}
+	return req.RemoteAddr
+}
+
+// audit records the action of req on target in the audit log, if any.
+func (s *Server) audit(req *http.Request, action, target string, payload interface{}) {
+	if err := s.Audit.Record(s.actor(req), action, target, payload); err != nil && s.Logger != nil {
+		s.Logger.Printf("failed to record %s of %s in audit log: %s", action, target, err.Error())
+	}
+}
+
+// ListAudit returns the entries of the audit log, oldest first. The since
+// parameter, a time or a duration back from now, and the limit parameter
+// restrict them to the latest ones.
+func (s *Server) ListAudit(w http.ResponseWriter, r *http.Request) {
+	if s.Audit == nil {
+		http.Error(w, "audit log is not enabled", http.StatusNotImplemented)
+		return
+	}
+	params := r.URL.Query()
+	var since time.Time
+	if v := strings.TrimSpace(params.Get("since")); v != "" {
+		if since = ekanite.ParseSince(v); since.IsZero() {
+			http.Error(w, "since '"+v+"' is invalid", http.StatusBadRequest)
+			return
+		}
+	}
+	var limit int
+	if v := params.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			http.Error(w, "limit '"+v+"' is invalid", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := s.Audit.Entries(since, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []ekanite.AuditEntry{}
+	}
+	renderJSON(w, entries)
+}
@@ -0,0 +1,218 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Scope is what a bearer token grants access to.
+type Scope string
+
+const (
+	ScopeIngest Scope = "ingest" // Sending events to the syslogs endpoint.
+	ScopeQuery  Scope = "query"  // Searches, fields and filters.
+	ScopeAdmin  Scope = "admin"  // The admin, debug and metrics endpoints.
+)
+
+// Action is what a request does to its resource, as given to the Authorizer.
+type Action string
+
+const (
+	ActionRead   Action = "read"   // Searches, and fetching or listing anything.
+	ActionWrite  Action = "write"  // Creating, changing or deleting anything.
+	ActionIngest Action = "ingest" // Sending events to the syslogs endpoint.
+)
+
+// ErrForbidden is wrapped by the errors of an Authorizer denying a request.
+var ErrForbidden = errors.New("forbidden")
+
+// Principal is who a request is made by, as far as the server can tell.
+type Principal struct {
+	Key    string  // Hash of its bearer token, empty without Tokens.
+	Scopes []Scope // Scopes granted by its bearer token, nil without Tokens.
+	Tenant string  // Given by Server.Tenant.
+	Role   string  // Given by Server.Role.
+}
+
+// Authorizer decides whether the principal of a request may take the action
+// on the resource, the path of the request under the URL prefix, such as
+// "filters/abc" or "admin/indexes/20160102_0000/hold". It returns nil to
+// allow the request, an error wrapping ErrForbidden to deny it, and any other
+// error when it fails to decide. Embedding applications implement it to apply
+// their own access control to the endpoints.
+type Authorizer interface {
+	Authorize(req *http.Request, principal Principal, action Action, resource string) error
+}
+
+// AuthorizerFunc is an Authorizer calling itself.
+type AuthorizerFunc func(req *http.Request, principal Principal, action Action, resource string) error
+
+// Authorize calls f.
+func (f AuthorizerFunc) Authorize(req *http.Request, principal Principal, action Action, resource string) error {
+	return f(req, principal, action, resource)
+}
+
+// Tokens maps the bearer tokens accepted to the scopes they grant. Shippers
+// can so be given tokens which can ingest events, but not read them.
+type Tokens map[string][]Scope
+
+// ParseTokens parses a comma-separated list of token:scope grants, such as
+// "s3cr3t:ingest,t0p:query,t0p:admin". A token appears once per scope.
+func ParseTokens(s string) (Tokens, error) {
+	tokens := Tokens{}
+	for _, grant := range strings.Split(s, ",") {
+		if grant = strings.TrimSpace(grant); grant == "" {
+			continue
+		}
+		i := strings.LastIndex(grant, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("token grant is not in the form token:scope")
+		}
+		token, scope := grant[:i], Scope(strings.ToLower(grant[i+1:]))
+		switch scope {
+		case ScopeIngest, ScopeQuery, ScopeAdmin:
+		default:
+			return nil, fmt.Errorf("token grant has unknown scope '%s'", grant[i+1:])
+		}
+		tokens[token] = append(tokens[token], scope)
+	}
+	return tokens, nil
+}
+
+// Allows returns whether token grants scope.
+func (t Tokens) Allows(token string, scope Scope) bool {
+	for _, s := range t[token] {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredScope returns the scope needed by requests to the endpoint name.
+func requiredScope(name string) Scope {
+	switch name {
+	case "syslogs":
+		return ScopeIngest
+	case "admin", "debug", "metrics":
+		return ScopeAdmin
+	default:
+		return ScopeQuery
+	}
+}
+
+// requestAction returns what req does to the endpoint name.
+func requestAction(req *http.Request, name string) Action {
+	switch {
+	case name == "syslogs":
+		return ActionIngest
+	case name == "query" || name == "raw":
+		return ActionRead // Searches are posted.
+	case req.Method == "GET" || req.Method == "HEAD":
+		return ActionRead
+	default:
+		return ActionWrite
+	}
+}
+
+// authorize checks that the bearer token of req grants the scope needed by the
+// endpoint name, and that the Authorizer allows it, writing an error to w if
+// they do not. All requests are authorized when no tokens and no Authorizer
+// are set.
+func (s *Server) authorize(w http.ResponseWriter, req *http.Request, name string) bool {
+	if !s.authorizeToken(w, req, name) {
+		return false
+	}
+	if s.Authorizer == nil {
+		return true
+	}
+
+	resource := strings.Trim(strings.TrimPrefix(req.URL.Path, s.urlPrefix), "/")
+	if err := s.Authorizer.Authorize(req, s.principal(req), requestAction(req, name), resource); err != nil {
+		http.Error(w, fmt.Sprintf("request not authorized: %v", err), errorStatus(w, err))
+		return false
+	}
+	return true
+}
+
+// authorizeToken checks that the bearer token of req grants the scope needed
+// by the endpoint name, writing an error to w if it does not. All requests
+// are authorized when no tokens are set.
+func (s *Server) authorizeToken(w http.ResponseWriter, req *http.Request, name string) bool {
+	if len(s.Tokens) == 0 {
+		return true
+	}
+	token, ok := bearerToken(req)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ekanite"`)
+		http.Error(w, "bearer token is required", http.StatusUnauthorized)
+		return false
+	}
+	if _, ok := s.Tokens[token]; !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ekanite", error="invalid_token"`)
+		http.Error(w, "bearer token is invalid", http.StatusUnauthorized)
+		return false
+	}
+	if scope := requiredScope(name); !s.Tokens.Allows(token, scope) {
+		http.Error(w, "bearer token lacks the "+string(scope)+" scope", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// bearerToken returns the bearer token of the Authorization header of req.
+func bearerToken(req *http.Request) (string, bool) {
+	auth := req.Header.Get("Authorization")
+	if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len("Bearer "):]), true
+}
+
+// owner returns the owner of the filters req creates, and whose filters it may
+// change: that given by Owner, or else the tenant of req, or else the API key
+// of its bearer token, a hash of the token so that the token is not stored.
+// Requests without an owner see and change all filters.
+func (s *Server) owner(req *http.Request) string {
+	if s.Owner != nil {
+		return s.Owner(req)
+	}
+	if s.Tenant != nil {
+		if tenant := s.Tenant(req); tenant != "" {
+			return tenant
+		}
+	}
+	if key := s.apiKey(req); key != "" {
+		return "key:" + key
+	}
+	return ""
+}
+
+// apiKey returns a hash of the bearer token of req, so that the token is not
+// stored, or an empty key without a token or without Tokens.
+func (s *Server) apiKey(req *http.Request) string {
+	if token, ok := bearerToken(req); ok && len(s.Tokens) > 0 {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:8])
+	}
+	return ""
+}
+
+// principal returns who req is made by.
+func (s *Server) principal(req *http.Request) Principal {
+	p := Principal{Key: s.apiKey(req)}
+	if token, ok := bearerToken(req); ok && len(s.Tokens) > 0 {
+		p.Scopes = s.Tokens[token]
+	}
+	if s.Tenant != nil {
+		p.Tenant = s.Tenant(req)
+	}
+	if s.Role != nil {
+		p.Role = s.Role(req)
+	}
+	return p
+}
@@ -0,0 +1,136 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	tokens, err := ParseTokens("shipper:ingest, reader:query,ops:query,ops:admin")
+	if err != nil {
+		t.Fatalf("failed to parse tokens: %s", err.Error())
+	}
+	s := &Server{Tokens: tokens}
+
+	for _, tt := range []struct {
+		token string
+		name  string
+		code  int
+	}{
+		{"", "query", http.StatusUnauthorized},
+		{"unknown", "query", http.StatusUnauthorized},
+		{"shipper", "syslogs", 0},
+		{"shipper", "query", http.StatusForbidden},
+		{"shipper", "fields", http.StatusForbidden},
+		{"reader", "query", 0},
+		{"reader", "syslogs", http.StatusForbidden},
+		{"reader", "admin", http.StatusForbidden},
+		{"ops", "admin", 0},
+		{"ops", "metrics", 0},
+	} {
+		req := httptest.NewRequest("GET", "/"+tt.name, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		ok := s.authorize(w, req, tt.name)
+		if ok != (tt.code == 0) || (!ok && w.Code != tt.code) {
+			t.Errorf("token %q on %s: authorized %v with status %d, expected %d", tt.token, tt.name, ok, w.Code, tt.code)
+		}
+	}
+
+	if _, err := ParseTokens("reader:write"); err == nil {
+		t.Error("unknown scope did not fail")
+	}
+	if _, err := ParseTokens("reader"); err == nil {
+		t.Error("grant without scope did not fail")
+	}
+}
+
+func TestServer_Owner(t *testing.T) {
+	req := httptest.NewRequest("GET", "/filters", nil)
+	req.Header.Set("Authorization", "Bearer reader")
+
+	s := &Server{}
+	if owner := s.owner(req); owner != "" {
+		t.Errorf("request has owner %q without tokens", owner)
+	}
+	s.Tokens = Tokens{"reader": {ScopeQuery}, "ops": {ScopeQuery}}
+	owner := s.owner(req)
+	if owner == "" || strings.Contains(owner, "reader") {
+		t.Errorf("request has owner %q, expected a hash of its token", owner)
+	}
+	other := httptest.NewRequest("GET", "/filters", nil)
+	other.Header.Set("Authorization", "Bearer ops")
+	if s.owner(other) == owner {
+		t.Errorf("requests with different tokens have the same owner %q", owner)
+	}
+	s.Tenant = func(*http.Request) string { return "team-a" }
+	if owner := s.owner(req); owner != "team-a" {
+		t.Errorf("request has owner %q, expected its tenant", owner)
+	}
+}
+
+func TestServer_Authorizer(t *testing.T) {
+	type call struct {
+		principal Principal
+		action    Action
+		resource  string
+	}
+	var got call
+	s := &Server{
+		urlPrefix: "/api",
+		Tokens:    Tokens{"ops": {ScopeQuery, ScopeAdmin}},
+		Role:      func(*http.Request) string { return "ops" },
+		Authorizer: AuthorizerFunc(func(req *http.Request, principal Principal, action Action, resource string) error {
+			got = call{principal, action, resource}
+			if action == ActionWrite && principal.Role != "admin" {
+				return fmt.Errorf("%w: %s cannot change %s", ErrForbidden, principal.Role, resource)
+			}
+			if resource == "filters/broken" {
+				return errors.New("policy store unavailable")
+			}
+			return nil
+		}),
+	}
+
+	for _, tt := range []struct {
+		method   string
+		path     string
+		name     string
+		action   Action
+		resource string
+		code     int
+	}{
+		{"GET", "/api/filters/abc", "filters", ActionRead, "filters/abc", 0},
+		{"POST", "/api/query/", "query", ActionRead, "query", 0},
+		{"DELETE", "/api/filters/abc", "filters", ActionWrite, "filters/abc", http.StatusForbidden},
+		{"POST", "/api/admin/indexes/20160102_0000/hold", "admin", ActionWrite, "admin/indexes/20160102_0000/hold", http.StatusForbidden},
+		{"GET", "/api/filters/broken", "filters", ActionRead, "filters/broken", http.StatusInternalServerError},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer ops")
+		w := httptest.NewRecorder()
+		ok := s.authorize(w, req, tt.name)
+		if ok != (tt.code == 0) || (!ok && w.Code != tt.code) {
+			t.Errorf("%s %s: authorized %v with status %d, expected %d", tt.method, tt.path, ok, w.Code, tt.code)
+		}
+		if got.action != tt.action || got.resource != tt.resource {
+			t.Errorf("%s %s: authorizer called for %s on %q, expected %s on %q", tt.method, tt.path, got.action, got.resource, tt.action, tt.resource)
+		}
+		if got.principal.Key == "" || got.principal.Role != "ops" || len(got.principal.Scopes) != 2 {
+			t.Errorf("%s %s: authorizer called for principal %+v", tt.method, tt.path, got.principal)
+		}
+	}
+
+	// The authorizer is not called for requests refused by their token.
+	got = call{}
+	req := httptest.NewRequest("GET", "/api/filters/abc", nil)
+	if s.authorize(httptest.NewRecorder(), req, "filters") || got.resource != "" {
+		t.Error("request without token authorized")
+	}
+}
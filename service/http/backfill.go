@@ -0,0 +1,80 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/ekanite/ekanite/input"
+)
+
+// Backfill indexes the historical log files of the body by their own
+// timestamps, parsed in the format parameter, syslog by default. The body is
+// a log file, a tar archive of them, or either gzipped; or a multipart form of
+// any number of those. It returns what was read, the lines rejected included.
+func (s *Server) Backfill(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	format := params.Get("format")
+	if format == "" {
+		format = "syslog"
+	}
+	b, err := input.NewBackfill(format, func(e *input.Event) error {
+		if err := s.allowIngest(r, 1, len(e.Text)); err != nil {
+			return err
+		}
+		select {
+		case s.c <- e:
+			return nil
+		case <-r.Context().Done():
+			return r.Context().Err()
+		}
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b.Pipeline = s.Pipeline
+
+	mediaType, mediaParams, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(r.Body, mediaParams["boundary"])
+		for {
+			part, partErr := mr.NextPart()
+			if partErr == io.EOF {
+				break
+			}
+			if partErr != nil {
+				err = partErr
+				break
+			}
+			name := part.FileName()
+			if name == "" {
+				name = part.FormName()
+			}
+			if err = b.ReadArchive(name, part); err != nil {
+				break
+			}
+		}
+	} else {
+		name := params.Get("name")
+		if name == "" {
+			name = "body"
+		}
+		err = b.ReadArchive(name, r.Body)
+	}
+
+	result := b.Result()
+	s.audit(r, "backfill", format, result)
+	if err != nil {
+		status := errorStatus(w, err)
+		if status == http.StatusInternalServerError {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, fmt.Sprintf("backfill stopped after %d events: %v", result.Events, err), status)
+		return
+	}
+	renderJSON(w, result)
+}
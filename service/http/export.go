@@ -0,0 +1,275 @@
+package http
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/ekanite/ekanite"
+	"github.com/ekanite/ekanite/service"
+)
+
+// ExportPageSize is the number of documents an export reads per search.
+var ExportPageSize = 1000
+
+// Export streams the documents matching the query in the body, all of them
+// unless the limit parameter is given, as JSON lines, or as CSV or a JSON
+// array if the Accept header prefers them. It reads a snapshot of the
+// indexes page by page, so that a long export neither holds them from being
+// written meanwhile nor sees the documents received after it started.
+func (s *Server) Export(w http.ResponseWriter, req *http.Request) {
+	snapshotter, ok := s.Searcher.(ekanite.Snapshotter)
+	if !ok {
+		http.Error(w, "export is not supported", http.StatusNotImplemented)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	mediaType := negotiate(req, mimeNDJSON, mimeCSV, mimeJSON)
+	if mediaType == "" {
+		http.Error(w, "export is available as "+mimeNDJSON+", "+mimeCSV+" or "+mimeJSON, http.StatusNotAcceptable)
+		return
+	}
+
+	var qu service.Query
+	if err := decodeJSON(req, &qu); err != nil {
+		s.RenderText(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+	queries, err := qu.ToQueries(s.caseFold())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Bucket: " + err.Error()))
+		return
+	}
+
+	queryParams := req.URL.Query()
+	filter, err := newPostFilter(queryParams)
+	if err != nil {
+		s.RenderText(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+	searchRequest := ekanite.NewSearchRequest(bleve.NewConjunctionQuery(queries...),
+		readStringArray(queryParams, "fields", nil), readStringArray(queryParams, "sort", nil))
+	start, end, indexNames, ok := s.prepareSearch(w, req, searchRequest)
+	if !ok {
+		return
+	}
+	if srqv, ok := searchRequest.Query.(query.ValidatableQuery); ok {
+		if err := srqv.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("error validating query: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	limit := 0
+	if limitStr := queryParams.Get("limit"); limitStr != "" {
+		limit, _ = strconv.Atoi(limitStr) // Validated by prepareSearch.
+	}
+
+	snapshot, err := snapshotter.Snapshot(start, end, indexNames)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error executing query: %v", err), errorStatus(w, err))
+		return
+	}
+	defer snapshot.Close()
+
+	s.warnRetention(w, req, start)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-type", mediaType)
+	w.Header().Set("X-Snapshot-Time", snapshot.Time.Format(time.RFC3339Nano))
+
+	masks, meta := s.fieldMasks(req), readMeta(queryParams)
+	e := newDocumentEncoder(w, mediaType, csvColumns(searchRequest.Fields, meta))
+	offset, exported, started := searchRequest.From, 0, false
+	for limit <= 0 || exported < limit {
+		size := ExportPageSize
+		if limit > 0 && limit-exported < size {
+			size = limit - exported
+		}
+		ekanite.SetPage(searchRequest, size, offset)
+
+		var hits int
+		err := snapshot.Search(req.Context(), searchRequest, func(_ *bleve.SearchRequest, resp *bleve.SearchResult) error {
+			hits = len(resp.Hits)
+			if !started {
+				started = true
+				w.WriteHeader(http.StatusOK)
+			}
+			for _, doc := range filter.documents(resp.Hits, masks, meta) {
+				if err := e.Encode(doc.(map[string]interface{})); err != nil {
+					return err
+				}
+			}
+			flusher.Flush()
+			return nil
+		})
+		if err != nil {
+			if !started {
+				http.Error(w, fmt.Sprintf("error executing query: %v", err), errorStatus(w, err))
+				return
+			}
+			// The status is sent, the export ends short.
+			log.Println("[WARN] export stopped after", exported, "documents:", err)
+			return
+		}
+		exported += hits
+		offset += hits
+		if hits < size {
+			break
+		}
+	}
+	if !started {
+		w.WriteHeader(http.StatusOK)
+	}
+	if err := e.Close(); err != nil {
+		log.Println("[WARN] export failed to end:", err)
+	}
+}
+
+// documentEncoder writes the documents of an export in a media type.
+type documentEncoder interface {
+	Encode(doc map[string]interface{}) error
+	Close() error // Ends the export.
+}
+
+// newDocumentEncoder returns the encoder of documents written to w as the
+// media type, with the columns given, or those of the first document, for CSV.
+func newDocumentEncoder(w io.Writer, mediaType string, columns []string) documentEncoder {
+	switch mediaType {
+	case mimeCSV:
+		return &csvEncoder{w: csv.NewWriter(w), columns: columns}
+	case mimeJSON:
+		return &jsonArrayEncoder{w: w}
+	default:
+		return ndjsonEncoder{json.NewEncoder(w)}
+	}
+}
+
+// csvColumns returns the columns of a CSV export of the fields, with the
+// location of the documents if meta is set, or nil if the fields are all
+// those of the documents.
+func csvColumns(fields []string, meta bool) []string {
+	var columns []string
+	for _, f := range fields {
+		if f == "*" {
+			return nil
+		}
+		columns = append(columns, f)
+	}
+	if len(columns) > 0 && meta {
+		columns = append(columns, "_index", "_shard", "_id")
+	}
+	return columns
+}
+
+// ndjsonEncoder writes documents as JSON lines.
+type ndjsonEncoder struct{ e *json.Encoder }
+
+func (e ndjsonEncoder) Encode(doc map[string]interface{}) error { return e.e.Encode(doc) }
+func (e ndjsonEncoder) Close() error                            { return nil }
+
+// jsonArrayEncoder writes documents as the elements of a JSON array.
+type jsonArrayEncoder struct {
+	w io.Writer
+	n int
+}
+
+func (e *jsonArrayEncoder) Encode(doc map[string]interface{}) error {
+	bs, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	sep := ","
+	if e.n == 0 {
+		sep = "["
+	}
+	e.n++
+	if _, err := io.WriteString(e.w, sep); err != nil {
+		return err
+	}
+	_, err = e.w.Write(bs)
+	return err
+}
+
+func (e *jsonArrayEncoder) Close() error {
+	end := "]\n"
+	if e.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+// csvEncoder writes documents as CSV records, after a header of the columns.
+// Without columns, those of the export are the sorted fields of its first
+// document, and the fields later documents have besides are left out.
+type csvEncoder struct {
+	w       *csv.Writer
+	columns []string
+	started bool
+}
+
+func (e *csvEncoder) Encode(doc map[string]interface{}) error {
+	if !e.started {
+		e.started = true
+		if e.columns == nil {
+			for k := range doc {
+				e.columns = append(e.columns, k)
+			}
+			sort.Strings(e.columns)
+		}
+		if err := e.w.Write(e.columns); err != nil {
+			return err
+		}
+	}
+	record := make([]string, len(e.columns))
+	for i, c := range e.columns {
+		record[i] = csvValue(doc[c])
+	}
+	if err := e.w.Write(record); err != nil {
+		return err
+	}
+	e.w.Flush() // Exports are flushed page by page.
+	return e.w.Error()
+}
+
+func (e *csvEncoder) Close() error {
+	if !e.started && e.columns != nil {
+		e.started = true
+		if err := e.w.Write(e.columns); err != nil {
+			return err
+		}
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvValue formats a field value as a CSV cell, arrays and objects as JSON.
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}, map[string]interface{}:
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(bs)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -9,16 +9,16 @@ import (
 )
 
 func (h *Server) ListFilters(w http.ResponseWriter, r *http.Request) {
-	rs := h.metaStore.ListQueries()
+	rs := h.metaStore.ListQueriesAs(h.owner(r))
 
 	w.WriteHeader(http.StatusOK)
 	renderJSON(w, rs)
 }
 
 func (h *Server) ListFilterIDs(w http.ResponseWriter, r *http.Request) {
-	rs, err := h.metaStore.ListQueryIDs()
+	rs, err := h.metaStore.ListQueryIDsAs(h.owner(r))
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(errorStatus(w, err))
 		w.Write([]byte(err.Error()))
 		return
 	}
@@ -28,9 +28,9 @@ func (h *Server) ListFilterIDs(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Server) ReadFilter(w http.ResponseWriter, r *http.Request, id string) {
-	q, err := h.metaStore.ReadQuery(id)
+	q, err := h.metaStore.ReadQueryAs(h.owner(r), id)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(errorStatus(w, err))
 		w.Write([]byte(err.Error()))
 		return
 	}
@@ -53,12 +53,16 @@ func (s *Server) CreateFilter(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if owner := s.owner(r); owner != "" {
+		q.Owner = owner
+	}
 	id, err := s.metaStore.CreateQuery(q)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(errorStatus(w, err))
 		w.Write([]byte(err.Error()))
 		return
 	}
+	s.audit(r, "filter.create", id, q)
 	w.WriteHeader(http.StatusOK)
 	renderJSON(w, map[string]interface{}{
 		"id":   id,
@@ -67,12 +71,13 @@ func (s *Server) CreateFilter(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Server) DeleteFilter(w http.ResponseWriter, r *http.Request, id string) {
-	err := h.metaStore.DeleteQuery(id)
+	err := h.metaStore.DeleteQueryAs(h.owner(r), id)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(errorStatus(w, err))
 		w.Write([]byte(err.Error()))
 		return
 	}
+	h.audit(r, "filter.delete", id, nil)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
@@ -92,12 +97,13 @@ func (s *Server) UpdateFilter(w http.ResponseWriter, r *http.Request, id string)
 		return
 	}
 
-	err = s.metaStore.UpdateQuery(id, q)
+	err = s.metaStore.UpdateQueryAs(s.owner(r), id, q)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(errorStatus(w, err))
 		w.Write([]byte(err.Error()))
 		return
 	}
+	s.audit(r, "filter.update", id, q)
 	w.WriteHeader(http.StatusAccepted)
 	w.Write([]byte("OK"))
 }
@@ -2,7 +2,10 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -18,14 +21,55 @@ import (
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/search/query"
 	"github.com/ekanite/ekanite"
-	"github.com/labstack/echo"
 )
 
-func isConsumeJSON(r *http.Request) bool {
-	accept := r.Header.Get("Accept")
-	contentType := r.Header.Get(echo.HeaderContentType)
-	return strings.Contains(contentType, "application/json") &&
-		strings.Contains(accept, "application/json")
+// retryAfterOverBudget is the delay, in seconds, clients are asked to wait
+// before retrying a search rejected by the engine memory budget.
+const retryAfterOverBudget = "5"
+
+// errorStatus maps an error returned by the engine or the meta store to the
+// HTTP status code reported to the client. Searches shed by the engine also
+// get a Retry-After header.
+func errorStatus(w http.ResponseWriter, err error) int {
+	switch {
+	case errors.Is(err, ekanite.ErrIndexNotFound):
+		return http.StatusNoContent
+	case errors.Is(err, ekanite.ErrUnknownIndex):
+		return http.StatusNotFound
+	case errors.Is(err, ekanite.ErrIndexesNotSupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, ekanite.ErrRefreshNotSupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, ekanite.ErrQueryTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ekanite.ErrSearchCancelled):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ekanite.ErrSearchNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ekanite.ErrInvalidMapping):
+		return http.StatusBadRequest
+	case errors.Is(err, ekanite.ErrOverBudget):
+		w.Header().Set("Retry-After", retryAfterOverBudget)
+		return http.StatusServiceUnavailable
+	case errors.Is(err, input.ErrQuotaExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrTimestampSkew):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ekanite.ErrDiskFull):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, service.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrNotOwner):
+		return http.StatusForbidden
+	case errors.Is(err, input.ErrUnknownListener):
+		return http.StatusNotFound
+	case service.IsBadArguments(err):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 func renderJSON(w http.ResponseWriter, i interface{}) {
@@ -54,9 +98,76 @@ type Server struct {
 	Searcher  ekanite.Searcher
 	metaStore *service.MetaStore
 
+	// FieldMasks restricts the fields of the search results returned to each
+	// role, the role of a request being given by Role. Without Role, all
+	// requests have the empty role.
+	FieldMasks FieldMasks
+	Role       func(*http.Request) string
+
+	// Quotas, if set, limits what each tenant may ingest through the syslogs
+	// endpoint, the tenant of a request being given by Tenant. Without Tenant,
+	// all requests are from the empty tenant.
+	Quotas *input.Quotas
+	Tenant func(*http.Request) string
+
+	// Pipeline numbers the events ingested through the syslogs and admin
+	// backfill endpoints and coerces and enriches their fields, and those of
+	// the messages the admin parse endpoint parses.
+	Pipeline *input.Pipeline
+
+	// Breaker, if set, refuses the events ingested through the syslogs and
+	// admin backfill endpoints while it holds an error.
+	Breaker *input.Breaker
+
+	// MaxTimestampSkew, if set, is how far from now the events ingested
+	// through the syslogs endpoint may be, so that a tenant cannot write
+	// into indexes of times it has no business in. Requests with an event
+	// further off are refused.
+	MaxTimestampSkew time.Duration
+
+	// Owner, if set, gives the owner of the filters a request creates.
+	// Requests see the global and shared filters and those of their owner,
+	// and change only the global ones and those of their owner. Without
+	// Owner, the owner of a request is its tenant, or else its API key.
+	Owner func(*http.Request) string
+
+	// Tokens, if set, are the bearer tokens accepted, each granting access
+	// to the ingest, query or admin endpoints. Requests without a token
+	// granting the scope of their endpoint are refused.
+	Tokens Tokens
+
+	// Authorizer, if set, decides whether each request may take its action
+	// on its resource, after its bearer token is checked against Tokens.
+	Authorizer Authorizer
+
+	// LiveTail, if set, publishes the events ingested to the tail endpoint,
+	// and the recent events it keeps to the recent endpoint. Tails of saved
+	// filters pick up their changes once it follows the meta store.
+	LiveTail *service.Tail
+
+	// Reload, if set, re-reads the configuration on POST /admin/reload. It
+	// returns the settings applied, and the changed settings which need a
+	// restart.
+	Reload func() (applied, restart []string, err error)
+
+	// Audit, if set, records the changes made to filters, subscriptions,
+	// holds and the configuration, and serves them on GET /admin/audit.
+	// The actor of a request is its role, or its remote address without one.
+	Audit *ekanite.AuditLog
+
+	// Listeners, if set, are the tagged listeners listed on GET
+	// /admin/listeners, and started and stopped on POST
+	// /admin/listeners/<tag>/start and /stop.
+	Listeners *input.Listeners
+
+	// Refresher, if set, makes the events received so far searchable before
+	// searches with refresh=true run, and before ingests with refresh=true
+	// return, usually the batcher feeding the engine. Without it, the
+	// Searcher is refreshed if it is a Refresher.
+	Refresher ekanite.Refresher
+
 	NoRoute http.Handler
-	//engine *echo.Echo
-	Logger *log.Logger
+	Logger  *log.Logger
 }
 
 // NewServer returns a new Server instance.
@@ -108,10 +219,132 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	name, pa := SplitURLPath(strings.TrimPrefix(r.URL.Path, s.urlPrefix))
+	if !s.authorize(w, r, name) {
+		return
+	}
+	r = r.WithContext(ekanite.WithSearchCaller(r.Context(), s.actor(r)))
 	switch name {
 	case "debug":
 		http.DefaultServeMux.ServeHTTP(w, r)
 		return
+	case "metrics":
+		expvar.Handler().ServeHTTP(w, r)
+		return
+	case "admin":
+		if r.Method == "POST" && strings.Trim(pa, "/") == "reload" {
+			s.ReloadConfig(w, r)
+			return
+		}
+		if r.Method == "POST" && strings.Trim(pa, "/") == "parse" {
+			s.ParseMessage(w, r)
+			return
+		}
+		if r.Method == "POST" && strings.Trim(pa, "/") == "backfill" {
+			s.Backfill(w, r)
+			return
+		}
+		if strings.Trim(pa, "/") == "mapping" {
+			switch r.Method {
+			case "GET":
+				s.IndexMapping(w, r)
+				return
+			case "POST", "PUT":
+				s.StageIndexMapping(w, r)
+				return
+			case "DELETE":
+				s.ResetIndexMapping(w, r)
+				return
+			}
+		}
+		if ss := strings.Split(strings.Trim(pa, "/"), "/"); len(ss) == 2 && ss[0] == "lookups" {
+			switch r.Method {
+			case "POST", "PUT":
+				s.SetLookupTable(w, r, ss[1])
+				return
+			case "DELETE":
+				s.DeleteLookupTable(w, r, ss[1])
+				return
+			}
+		}
+		if ss := strings.Split(strings.Trim(pa, "/"), "/"); len(ss) == 3 && ss[0] == "listeners" && r.Method == "POST" {
+			switch ss[2] {
+			case "start":
+				s.StartListener(w, r, ss[1])
+				return
+			case "stop":
+				s.StopListener(w, r, ss[1])
+				return
+			}
+		}
+		if ss := strings.Split(strings.Trim(pa, "/"), "/"); len(ss) == 2 && ss[0] == "activity" && r.Method == "DELETE" {
+			s.CancelSearch(w, r, ss[1])
+			return
+		}
+		if ss := strings.Split(strings.Trim(pa, "/"), "/"); len(ss) == 3 && ss[0] == "indexes" && ss[2] == "hold" {
+			switch r.Method {
+			case "POST", "PUT":
+				s.HoldIndex(w, r, ss[1])
+				return
+			case "DELETE":
+				s.ReleaseIndex(w, r, ss[1])
+				return
+			}
+		}
+		if r.Method == "GET" {
+			ss := strings.Split(strings.Trim(pa, "/"), "/")
+			if len(ss) == 1 && ss[0] == "audit" {
+				s.ListAudit(w, r)
+				return
+			}
+			if len(ss) == 1 && ss[0] == "activity" {
+				s.ListActivity(w, r)
+				return
+			}
+			if len(ss) == 1 && ss[0] == "consistency" {
+				s.CheckConsistency(w, r)
+				return
+			}
+			if len(ss) == 1 && ss[0] == "indexes" {
+				s.ListIndexes(w, r)
+				return
+			}
+			if len(ss) == 1 && ss[0] == "fields" {
+				s.FieldReport(w, r)
+				return
+			}
+			if len(ss) == 1 && ss[0] == "sources" {
+				s.SourceReport(w, r)
+				return
+			}
+			if len(ss) == 1 && ss[0] == "lookups" {
+				s.ListLookupTables(w, r)
+				return
+			}
+			if len(ss) == 1 && ss[0] == "quotas" {
+				s.ListQuotas(w, r)
+				return
+			}
+			if len(ss) == 1 && ss[0] == "listeners" {
+				s.ListListeners(w, r)
+				return
+			}
+			if len(ss) == 3 && ss[0] == "indexes" && ss[2] == "sample" {
+				s.SampleIndex(w, r, ss[1])
+				return
+			}
+			if len(ss) == 3 && ss[0] == "indexes" && ss[2] == "storage" {
+				s.IndexStorage(w, r, ss[1])
+				return
+			}
+			if len(ss) == 3 && ss[0] == "indexes" && ss[2] == "metadata" {
+				s.IndexMetadata(w, r, ss[1])
+				return
+			}
+			if len(ss) == 3 && ss[0] == "indexes" && ss[2] == "archive" {
+				s.ArchiveIndex(w, r, ss[1])
+				return
+			}
+		}
 	case "fields":
 		if pa == "" || pa == "/" {
 			s.Fields(w, r)
@@ -131,6 +364,21 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				s.SummaryByFiltersInBody(w, r)
 				return
 			}
+		case "/_estimate", "/_estimate/":
+			if r.Method == "POST" {
+				s.EstimateByFiltersInBody(w, r)
+				return
+			}
+		case "/_validate", "/_validate/":
+			if r.Method == "POST" {
+				s.ValidateQueryString(w, r)
+				return
+			}
+		case "/_export", "/_export/":
+			if r.Method == "POST" {
+				s.Export(w, r)
+				return
+			}
 		default:
 			if strings.HasSuffix(pa, "/count") {
 				s.SummaryByFilters(w, r, strings.Trim(strings.TrimSuffix(pa, "/count"), "/"))
@@ -176,6 +424,85 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+	case "subscriptions":
+		id := strings.Trim(pa, "/")
+		switch r.Method {
+		case "GET":
+			if id == "" {
+				s.ListSubscriptions(w, r)
+			} else {
+				s.ReadSubscription(w, r, id)
+			}
+			return
+		case "POST":
+			if id != "" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				w.Write([]byte("MethodNotAllowed"))
+			} else {
+				s.CreateSubscription(w, r)
+			}
+			return
+		case "DELETE":
+			if id == "" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				w.Write([]byte("MethodNotAllowed"))
+			} else {
+				s.DeleteSubscription(w, r, id)
+			}
+			return
+		}
+
+	case "panels":
+		id := strings.Trim(pa, "/")
+		switch r.Method {
+		case "GET":
+			if id == "" {
+				s.ListPanels(w, r)
+			} else {
+				s.ReadPanel(w, r, id)
+			}
+			return
+		case "POST":
+			if id != "" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				w.Write([]byte("MethodNotAllowed"))
+			} else {
+				s.CreatePanel(w, r)
+			}
+			return
+		case "PUT":
+			if id == "" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				w.Write([]byte("MethodNotAllowed"))
+			} else {
+				s.UpdatePanel(w, r, id)
+			}
+			return
+		case "DELETE":
+			if id == "" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				w.Write([]byte("MethodNotAllowed"))
+			} else {
+				s.DeletePanel(w, r, id)
+			}
+			return
+		}
+	case "dashboards":
+		if name := strings.Trim(pa, "/"); r.Method == "GET" && name != "" {
+			s.Dashboard(w, r, name)
+			return
+		}
+
+	case "tail":
+		if r.Method == "GET" {
+			s.Tail(w, r)
+			return
+		}
+	case "recent":
+		if r.Method == "GET" {
+			s.Recent(w, r)
+			return
+		}
 	case "syslogs":
 		if r.Method == "POST" || r.Method == "PUT" {
 			s.RecvSyslogs(w, r)
@@ -186,6 +513,11 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		case "count":
 			s.Summary(w, r)
 			return
+		case "/_search", "/_search/":
+			if r.Method == "POST" {
+				s.RawSearch(w, r)
+				return
+			}
 		case "":
 			s.Get(w, r)
 			return
@@ -218,36 +550,86 @@ func (s *Server) RecvSyslogs(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "http body is empty", http.StatusInternalServerError)
 		return
 	}
-	if bytes.HasPrefix(bs, []byte("[")) {
-		var events []input.Event
-		err := json.Unmarshal(bs, &events)
-		if err != nil {
+	var events []*input.Event
+	switch {
+	case bytes.HasPrefix(bs, []byte("[")):
+		var evts []input.Event
+		if err := json.Unmarshal(bs, &evts); err != nil {
 			http.Error(w, fmt.Sprintf("%v\r\n%s", err, bs), http.StatusInternalServerError)
 			return
 		}
-		for idx := range events {
-			s.c <- &events[idx]
+		for idx := range evts {
+			events = append(events, &evts[idx])
 		}
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-		return
-	}
-
-	if bytes.HasPrefix(bs, []byte("{")) {
+	case bytes.HasPrefix(bs, []byte("{")):
 		var evt input.Event
-		err := json.Unmarshal(bs, &evt)
-		if err != nil {
+		if err := json.Unmarshal(bs, &evt); err != nil {
 			http.Error(w, fmt.Sprintf("%v\r\n%s", err, bs), http.StatusInternalServerError)
 			return
 		}
+		events = []*input.Event{&evt}
+	default:
+		http.Error(w, fmt.Sprintf("http body is invalid event(s)\r\n%s", bs), http.StatusInternalServerError)
+		return
+	}
 
-		s.c <- &evt
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+	if err := s.prepareEvents(req, events); err != nil {
+		http.Error(w, err.Error(), errorStatus(w, err))
 		return
 	}
+	if err := s.allowIngest(req, len(events), len(bs)); err != nil {
+		http.Error(w, err.Error(), errorStatus(w, err))
+		return
+	}
+	var start, end time.Time
+	for _, e := range events {
+		if t := e.ReferenceTime(); start.IsZero() || t.Before(start) {
+			start = t
+		}
+		if t := e.ReferenceTime(); t.After(end) {
+			end = t
+		}
+		s.c <- e
+	}
+	if err := s.refresh(req, start, end); err != nil {
+		http.Error(w, fmt.Sprintf("error refreshing: %v", err), errorStatus(w, err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
 
-	http.Error(w, fmt.Sprintf("http body is invalid event(s)\r\n%s", bs), http.StatusInternalServerError)
+// refresh makes the events received so far searchable in the indexes covering
+// start to end, if req has refresh=true, so that tools read their writes.
+func (s *Server) refresh(req *http.Request, start, end time.Time) error {
+	if refresh, _ := strconv.ParseBool(req.URL.Query().Get("refresh")); !refresh {
+		return nil
+	}
+	r := s.Refresher
+	if r == nil {
+		var ok bool
+		if r, ok = s.Searcher.(ekanite.Refresher); !ok {
+			return ekanite.ErrRefreshNotSupported
+		}
+	}
+	return r.Refresh(req.Context(), start, end)
+}
+
+// allowIngest accounts for the tenant of req ingesting events totalling bytes,
+// returning input.ErrQuotaExceeded if that would exceed its quotas, or the
+// error of the Breaker while events are refused.
+func (s *Server) allowIngest(req *http.Request, events, bytes int) error {
+	if err := s.Breaker.Err(); err != nil {
+		return err
+	}
+	if s.Quotas == nil {
+		return nil
+	}
+	var tenant string
+	if s.Tenant != nil {
+		tenant = s.Tenant(req)
+	}
+	return s.Quotas.Allow(tenant, int64(events), int64(bytes))
 }
 
 func (s *Server) Summary(w http.ResponseWriter, req *http.Request) {
@@ -256,25 +638,82 @@ func (s *Server) Summary(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
+// RawSearch executes the bleve search request in the body as is, over the
+// indexes covering start_at to end_at or given by the indexes parameter, and
+// returns the whole bleve search result, scores and sort keys included. Other
+// nodes send their searches here through a ekanite.FederatedSearcher. Queries,
+// facets, sorts and highlights of the fields masked for the role of the request
+// are refused.
+//
+// With profile=true, the result also breaks the time and hits of the search
+// down by index and shard.
+func (s *Server) RawSearch(w http.ResponseWriter, req *http.Request) {
+	searchRequest := new(bleve.SearchRequest)
+	if err := decodeJSON(req, searchRequest); err != nil {
+		http.Error(w, fmt.Sprintf("error parsing query: %v", err), http.StatusBadRequest)
+		return
+	}
+	indexNames := readIndexNames(req.URL.Query())
+	masks := s.fieldMasks(req)
+	field := maskedQueryField(masks, searchRequest.Query)
+	if field == "" {
+		field = maskedRequestField(masks, searchRequest)
+	}
+	if field != "" {
+		http.Error(w, "field("+field+") is restricted.", http.StatusForbidden)
+		return
+	}
+	var profile *ekanite.SearchProfile
+	if ok, _ := strconv.ParseBool(req.URL.Query().Get("profile")); ok {
+		var ctx context.Context
+		ctx, profile = ekanite.WithSearchProfile(req.Context())
+		req = req.WithContext(ctx)
+	}
+	s.timeRange(w, req, func(w http.ResponseWriter, req *http.Request, start, end time.Time) {
+		err := ekanite.Execute(req.Context(), s.Searcher, start, end, indexNames, searchRequest,
+			func(_ *bleve.SearchRequest, resp *bleve.SearchResult) error {
+				for _, hit := range resp.Hits {
+					maskHit(masks, hit)
+				}
+				if profile != nil {
+					return encodeJSON(w, struct {
+						*bleve.SearchResult
+						Profile *ekanite.SearchProfile `json:"profile"`
+					}{resp, profile})
+				}
+				return encodeJSON(w, resp)
+			})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error executing query: %v", err), errorStatus(w, err))
+		}
+	})
+}
+
 func (s *Server) Get(w http.ResponseWriter, req *http.Request) {
+	filter, err := newPostFilter(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	masks, meta := s.fieldMasks(req), readMeta(req.URL.Query())
 	s.Search(w, req, true, func(req *bleve.SearchRequest, resp *bleve.SearchResult) error {
-		var documents = make([]interface{}, 0, resp.Hits.Len())
-		for _, doc := range resp.Hits {
-			documents = append(documents, doc.Fields)
-		}
-		return encodeJSON(w, documents)
+		return encodeJSON(w, filter.documents(resp.Hits, masks, meta))
 	})
 }
 
 func (s *Server) FieldDict(w http.ResponseWriter, req *http.Request, field string) {
+	if masked(s.fieldMasks(req), field) {
+		http.Error(w, "field("+field+") is restricted.", http.StatusForbidden)
+		return
+	}
 	s.timeRange(w, req, func(w http.ResponseWriter, req *http.Request, start, end time.Time) {
 		entries, err := s.Searcher.FieldDict(req.Context(), start, end, field)
 		if err != nil {
 
-			if err == bleve.ErrorAliasEmpty {
+			if errors.Is(err, ekanite.ErrIndexNotFound) {
 				encodeJSON(w, []interface{}{})
 			} else {
-				http.Error(w, fmt.Sprintf("error get field dicts: %v", err), http.StatusInternalServerError)
+				http.Error(w, fmt.Sprintf("error get field dicts: %v", err), errorStatus(w, err))
 			}
 			return
 		}
@@ -288,10 +727,10 @@ func (s *Server) Fields(w http.ResponseWriter, req *http.Request) {
 	s.timeRange(w, req, func(w http.ResponseWriter, req *http.Request, start, end time.Time) {
 		fields, err := s.Searcher.Fields(req.Context(), start, end)
 		if err != nil {
-			if err == bleve.ErrorAliasEmpty {
+			if errors.Is(err, ekanite.ErrIndexNotFound) {
 				encodeJSON(w, []interface{}{})
 			} else {
-				http.Error(w, fmt.Sprintf("error get fields: %v", err), http.StatusInternalServerError)
+				http.Error(w, fmt.Sprintf("error get fields: %v", err), errorStatus(w, err))
 			}
 			return
 		}
@@ -324,9 +763,24 @@ func (s *Server) timeRange(w http.ResponseWriter, req *http.Request,
 		}
 	}
 
+	s.warnRetention(w, req, start)
 	cb(w, req, start, end)
 }
 
+// warnRetention sets a Warning header on w if the time range of req, starting
+// at start, predates the oldest data retained, returning the warning. Searches
+// of indexes by name are not warned about.
+func (s *Server) warnRetention(w http.ResponseWriter, req *http.Request, start time.Time) string {
+	if len(readIndexNames(req.URL.Query())) > 0 {
+		return ""
+	}
+	warning := ekanite.RetentionWarning(s.Searcher, start)
+	if warning != "" {
+		w.Header().Set("Warning", `199 ekanite "`+warning+`"`)
+	}
+	return warning
+}
+
 func (s *Server) Search(w http.ResponseWriter, req *http.Request, allFields bool, cb func(req *bleve.SearchRequest, resp *bleve.SearchResult) error) {
 	var searchRequest *bleve.SearchRequest
 	if req.Method == "GET" {
@@ -361,19 +815,62 @@ func (s *Server) Search(w http.ResponseWriter, req *http.Request, allFields bool
 	s.SearchIn(w, req, searchRequest, cb)
 }
 
+// SearchIn performs the search request on the indexes covering the time range
+// given by the start_at and end_at parameters, or on the indexes named by the
+// comma-separated indexes parameter.
 func (s *Server) SearchIn(w http.ResponseWriter, req *http.Request, searchRequest *bleve.SearchRequest, cb func(req *bleve.SearchRequest, resp *bleve.SearchResult) error) {
+	s.searchWarned(w, req, searchRequest, func(_ string, req *bleve.SearchRequest, resp *bleve.SearchResult) error {
+		return cb(req, resp)
+	})
+}
+
+// searchWarned is SearchIn, also passing cb the warning of a time range
+// predating the oldest data retained, empty if none.
+func (s *Server) searchWarned(w http.ResponseWriter, req *http.Request, searchRequest *bleve.SearchRequest,
+	cb func(warning string, req *bleve.SearchRequest, resp *bleve.SearchResult) error) {
+	start, end, indexNames, ok := s.prepareSearch(w, req, searchRequest)
+	if !ok {
+		return
+	}
+
+	if err := s.refresh(req, start, end); err != nil {
+		http.Error(w, fmt.Sprintf("error refreshing: %v", err), errorStatus(w, err))
+		return
+	}
+	warning := s.warnRetention(w, req, start)
+	err := ekanite.Execute(req.Context(), s.Searcher, start, end, indexNames, searchRequest,
+		func(searchRequest *bleve.SearchRequest, resp *bleve.SearchResult) error {
+			return cb(warning, searchRequest, resp)
+		})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error executing query: %v", err), errorStatus(w, err))
+		return
+	}
+}
+
+// prepareSearch completes the search request from the request parameters, and
+// validates it. It returns the time range and index names to search, or false
+// after writing an error to w.
+func (s *Server) prepareSearch(w http.ResponseWriter, req *http.Request, searchRequest *bleve.SearchRequest) (start, end time.Time, indexNames []string, ok bool) {
 	queryParams := req.URL.Query()
 
-	var start, end time.Time
+	// The time range added below searches the reception, which the masks may
+	// restrict too.
+	if field := maskedQueryField(s.fieldMasks(req), searchRequest.Query); field != "" {
+		http.Error(w, "field("+field+") is restricted.", http.StatusForbidden)
+		return start, end, indexNames, false
+	}
+
+	indexNames = readIndexNames(queryParams)
 
 	startAt := queryParams.Get("start_at")
 	if startAt != "" {
 		start = ekanite.ParseTime(startAt)
 		if start.IsZero() {
 			http.Error(w, "start_at("+startAt+") is invalid.", http.StatusBadRequest)
-			return
+			return start, end, indexNames, false
 		}
-	} else {
+	} else if len(indexNames) == 0 {
 		year, month, day := time.Now().Date()
 		start = time.Date(year, month, day, 0, 0, 0, 0, time.Local)
 	}
@@ -382,66 +879,47 @@ func (s *Server) SearchIn(w http.ResponseWriter, req *http.Request, searchReques
 		end = ekanite.ParseTime(endAt)
 		if end.IsZero() {
 			http.Error(w, "end_at("+endAt+") is invalid.", http.StatusBadRequest)
-			return
+			return start, end, indexNames, false
 		}
 	}
 
 	if !start.IsZero() || !end.IsZero() {
-		inclusive := true
-		timeQuery := bleve.NewDateRangeInclusiveQuery(start, end, &inclusive, &inclusive)
-		timeQuery.SetField("reception")
-
-		if searchRequest.Query == nil {
-			searchRequest.Query = timeQuery
-		} else if conjunctionQuery, ok := searchRequest.Query.(*query.ConjunctionQuery); ok {
-			conjunctionQuery.AddQuery(timeQuery)
-		} else {
-			searchRequest.Query = bleve.NewConjunctionQuery(searchRequest.Query, timeQuery)
-		}
+		searchRequest.Query = ekanite.WithTimeRange(searchRequest.Query, start, end)
+	} else if searchRequest.Query == nil && len(indexNames) > 0 {
+		searchRequest.Query = bleve.NewMatchAllQuery()
 	} else if searchRequest.Query == nil {
-		inclusive := true
-		timeQuery := bleve.NewDateRangeInclusiveQuery(start, time.Now(), &inclusive, &inclusive)
-		timeQuery.SetField("reception")
-
-		searchRequest.Query = timeQuery
+		searchRequest.Query = ekanite.WithTimeRange(nil, start, time.Now())
 	}
 
-	// var searchRequest *bleve.SearchRequest
-	// query := bleve.NewConjunctionQuery(queries...)
-	// searchRequest = bleve.NewSearchRequest(query)
-	// searchRequest.SortBy([]string{"timestamp"})
-
-	{
-		if limitStr := queryParams.Get("limit"); limitStr != "" {
+	limitStr, offsetStr := queryParams.Get("limit"), queryParams.Get("offset")
+	if limitStr != "" || offsetStr != "" {
+		limit, offset := searchRequest.Size, searchRequest.From
+		if limitStr != "" {
 			i64, err := strconv.ParseInt(limitStr, 10, 0)
 			if err != nil {
 				http.Error(w, "limit("+limitStr+") is invalid.", http.StatusBadRequest)
-				return
+				return start, end, indexNames, false
 			}
-			limit := int(i64)
-			if limit <= 0 {
-				limit = ekanite.MaxSearchHitSize
-			}
-			searchRequest.Size = limit
+			limit = int(i64)
 		}
-
-		if offsetStr := queryParams.Get("offset"); offsetStr != "" {
+		if offsetStr != "" {
 			i64, err := strconv.ParseInt(offsetStr, 10, 0)
 			if err != nil {
 				http.Error(w, "offset("+offsetStr+") is invalid.", http.StatusBadRequest)
-				return
+				return start, end, indexNames, false
 			}
-			offset := int(i64)
-			if offset < 0 {
-				offset = 0
-			}
-			searchRequest.From = offset
+			offset = int(i64)
 		}
+		ekanite.SetPage(searchRequest, limit, offset)
 	}
 
 	if sortBy := queryParams.Get("sort_by"); sortBy != "" {
 		searchRequest.SortBy([]string{sortBy})
 	}
+	if field := maskedRequestField(s.fieldMasks(req), searchRequest); field != "" {
+		http.Error(w, "field("+field+") is restricted.", http.StatusForbidden)
+		return start, end, indexNames, false
+	}
 
 	// if allFields {
 	// 	searchRequest.Fields = []string{"*"}
@@ -458,20 +936,10 @@ func (s *Server) SearchIn(w http.ResponseWriter, req *http.Request, searchReques
 		err := srqv.Validate()
 		if err != nil {
 			http.Error(w, fmt.Sprintf("error validating query: %v", err), http.StatusBadRequest)
-			return
+			return start, end, indexNames, false
 		}
 	}
-
-	// execute the query
-	err = s.Searcher.Query(req.Context(), start, end, searchRequest, cb)
-	if err != nil {
-		if err == bleve.ErrorAliasEmpty {
-			http.Error(w, fmt.Sprintf("error executing query: %v", err), http.StatusNoContent)
-		} else {
-			http.Error(w, fmt.Sprintf("error executing query: %v", err), http.StatusInternalServerError)
-		}
-		return
-	}
+	return start, end, indexNames, true
 }
 
 /*
@@ -0,0 +1,51 @@
+package http
+
+import (
+	"net/http"
+)
+
+// ListListeners lists the tagged listeners, and whether each is running.
+func (s *Server) ListListeners(w http.ResponseWriter, r *http.Request) {
+	if s.Listeners == nil {
+		http.Error(w, "listeners are not managed", http.StatusNotImplemented)
+		return
+	}
+	renderJSON(w, s.Listeners.List())
+}
+
+// StartListener starts the listener tag, unless running.
+func (s *Server) StartListener(w http.ResponseWriter, r *http.Request, tag string) {
+	if s.Listeners == nil {
+		http.Error(w, "listeners are not managed", http.StatusNotImplemented)
+		return
+	}
+	if err := s.Listeners.Start(tag); err != nil {
+		http.Error(w, err.Error(), listenerStatus(w, err))
+		return
+	}
+	s.audit(r, "listener.start", tag, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StopListener stops the listener tag, once the events in flight are sent.
+func (s *Server) StopListener(w http.ResponseWriter, r *http.Request, tag string) {
+	if s.Listeners == nil {
+		http.Error(w, "listeners are not managed", http.StatusNotImplemented)
+		return
+	}
+	if err := s.Listeners.Stop(tag); err != nil {
+		http.Error(w, err.Error(), listenerStatus(w, err))
+		return
+	}
+	s.audit(r, "listener.stop", tag, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listenerStatus returns the status of a listener failing to start or stop,
+// such as its address being in use.
+func listenerStatus(w http.ResponseWriter, err error) int {
+	if status := errorStatus(w, err); status != http.StatusInternalServerError {
+		return status
+	}
+	return http.StatusConflict
+}
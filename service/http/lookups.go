@@ -0,0 +1,49 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ekanite/ekanite/input"
+)
+
+// ListLookupTables lists the lookup tables events are enriched from at ingest.
+func (s *Server) ListLookupTables(w http.ResponseWriter, r *http.Request) {
+	renderJSON(w, s.Pipeline.LookupTables())
+}
+
+// SetLookupTable loads the lookup table name from the key field and file path
+// of the body, replacing any table of that name. Tables set so are not kept
+// across restarts, unlike those of the lookups flag.
+func (s *Server) SetLookupTable(w http.ResponseWriter, r *http.Request, name string) {
+	if s.Pipeline == nil {
+		http.Error(w, "ingest pipeline is not configured", http.StatusNotImplemented)
+		return
+	}
+	var body struct {
+		Key  string `json:"key"`
+		Path string `json:"path"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+		return
+	}
+	t, err := input.LoadLookupTable(name, body.Key, body.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.Pipeline.SetLookupTable(t)
+	s.audit(r, "lookup.set", name, body)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteLookupTable stops enriching events from the lookup table name.
+func (s *Server) DeleteLookupTable(w http.ResponseWriter, r *http.Request, name string) {
+	if !s.Pipeline.RemoveLookupTable(name) {
+		http.Error(w, "lookup table "+name+" does not exist", http.StatusNotFound)
+		return
+	}
+	s.audit(r, "lookup.delete", name, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/ekanite/ekanite"
+)
+
+// indexMapper returns the searcher as an ekanite.IndexMapper, writing an error
+// to w if it is not one.
+func (s *Server) indexMapper(w http.ResponseWriter) (ekanite.IndexMapper, bool) {
+	mapper, ok := s.Searcher.(ekanite.IndexMapper)
+	if !ok {
+		http.Error(w, "index mappings are not supported", http.StatusNotImplemented)
+	}
+	return mapper, ok
+}
+
+// IndexMapping returns the bleve mapping the indexes created next get.
+func (s *Server) IndexMapping(w http.ResponseWriter, r *http.Request) {
+	mapper, ok := s.indexMapper(w)
+	if !ok {
+		return
+	}
+	m, err := mapper.IndexMapping()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error building index mapping: %v", err), errorStatus(w, err))
+		return
+	}
+	renderJSON(w, m)
+}
+
+// StageIndexMapping stages the bleve mapping in the body for the indexes
+// created next, existing indexes keeping theirs.
+func (s *Server) StageIndexMapping(w http.ResponseWriter, r *http.Request) {
+	mapper, ok := s.indexMapper(w)
+	if !ok {
+		return
+	}
+	bs, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m := mapping.NewIndexMapping()
+	if err := json.Unmarshal(bs, m); err != nil {
+		http.Error(w, fmt.Sprintf("invalid mapping: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := mapper.StageIndexMapping(m); err != nil {
+		http.Error(w, fmt.Sprintf("error staging index mapping: %v", err), errorStatus(w, err))
+		return
+	}
+	s.audit(r, "mapping.stage", "", json.RawMessage(bs))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetIndexMapping restores the built-in mapping for the indexes created
+// next.
+func (s *Server) ResetIndexMapping(w http.ResponseWriter, r *http.Request) {
+	mapper, ok := s.indexMapper(w)
+	if !ok {
+		return
+	}
+	if err := mapper.StageIndexMapping(nil); err != nil {
+		http.Error(w, fmt.Sprintf("error resetting index mapping: %v", err), errorStatus(w, err))
+		return
+	}
+	s.audit(r, "mapping.reset", "", nil)
+	w.WriteHeader(http.StatusNoContent)
+}
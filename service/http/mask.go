@@ -0,0 +1,241 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/ekanite/ekanite/service"
+)
+
+// MaskAction says how a masked field is shown.
+type MaskAction string
+
+const (
+	MaskHide   MaskAction = "hide"   // The field is left out.
+	MaskRedact MaskAction = "redact" // The value is replaced by RedactedValue.
+	MaskIP     MaskAction = "ip"     // The host part of an IP address is zeroed, its last octet for IPv4.
+)
+
+// RedactedValue replaces the value of redacted fields.
+const RedactedValue = "***"
+
+// FieldMask restricts how a field of the search results is shown.
+type FieldMask struct {
+	Field  string
+	Action MaskAction
+}
+
+// FieldMasks holds the field masks applied to the search results returned to
+// each role. The masked fields may not be queried, faceted, sorted or
+// highlighted by either, as the documents matched would tell their values.
+type FieldMasks map[string][]FieldMask
+
+// ParseFieldMasks parses a comma-separated list of role:field=action masks,
+// such as "auditor:message=hide,auditor:address=ip".
+func ParseFieldMasks(s string) (FieldMasks, error) {
+	masks := FieldMasks{}
+	for _, m := range strings.Split(s, ",") {
+		if m = strings.TrimSpace(m); m == "" {
+			continue
+		}
+		ss := strings.SplitN(m, ":", 2)
+		if len(ss) != 2 {
+			return nil, fmt.Errorf("field mask '%s' is not in the form role:field=action", m)
+		}
+		fa := strings.SplitN(ss[1], "=", 2)
+		if len(fa) != 2 || fa[0] == "" {
+			return nil, fmt.Errorf("field mask '%s' is not in the form role:field=action", m)
+		}
+		action := MaskAction(strings.ToLower(fa[1]))
+		switch action {
+		case MaskHide, MaskRedact, MaskIP:
+		default:
+			return nil, fmt.Errorf("field mask '%s' has unknown action '%s'", m, fa[1])
+		}
+		masks[ss[0]] = append(masks[ss[0]], FieldMask{Field: fa[0], Action: action})
+	}
+	return masks, nil
+}
+
+// Mask applies the masks of role to the fields of a document, such as one
+// pushed to a subscription.
+func (m FieldMasks) Mask(role string, fields map[string]interface{}) {
+	mask(m[role], fields)
+}
+
+// fieldMasks returns the masks applied to the results returned for req, given
+// the role of its user.
+func (s *Server) fieldMasks(req *http.Request) []FieldMask {
+	if len(s.FieldMasks) == 0 {
+		return nil
+	}
+	var role string
+	if s.Role != nil {
+		role = s.Role(req)
+	}
+	return s.FieldMasks[role]
+}
+
+// masked returns whether the masks restrict field. The terms of such fields are
+// not listed.
+func masked(masks []FieldMask, field string) bool {
+	for _, m := range masks {
+		if m.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// mask applies the masks to the fields of a document.
+func mask(masks []FieldMask, fields map[string]interface{}) {
+	for _, m := range masks {
+		v, ok := fields[m.Field]
+		if !ok {
+			continue
+		}
+		switch m.Action {
+		case MaskHide:
+			delete(fields, m.Field)
+		case MaskIP:
+			fields[m.Field] = maskIP(v)
+		default:
+			fields[m.Field] = RedactedValue
+		}
+	}
+}
+
+// maskedRequestField returns a masked field which the search request facets,
+// sorts or highlights by, empty if none. The terms and values of the field
+// would be returned besides the hits.
+func maskedRequestField(masks []FieldMask, req *bleve.SearchRequest) string {
+	for _, f := range req.Facets {
+		if masked(masks, f.Field) {
+			return f.Field
+		}
+	}
+	for _, so := range req.Sort {
+		var field string
+		switch so := so.(type) {
+		case *search.SortField:
+			field = so.Field
+		case *search.SortGeoDistance:
+			field = so.Field
+		}
+		if masked(masks, field) {
+			return field
+		}
+	}
+	if req.Highlight != nil {
+		for _, field := range req.Highlight.Fields {
+			if masked(masks, field) {
+				return field
+			}
+		}
+	}
+	return ""
+}
+
+// maskedQueryField returns a masked field which the query searches, empty if
+// none. Queries without a field search the default field, which holds all
+// fields, so that any mask restricts them.
+func maskedQueryField(masks []FieldMask, q query.Query) string {
+	if len(masks) == 0 || q == nil {
+		return ""
+	}
+	switch q := q.(type) {
+	case *query.ConjunctionQuery:
+		for _, c := range q.Conjuncts {
+			if field := maskedQueryField(masks, c); field != "" {
+				return field
+			}
+		}
+		return ""
+	case *query.DisjunctionQuery:
+		for _, d := range q.Disjuncts {
+			if field := maskedQueryField(masks, d); field != "" {
+				return field
+			}
+		}
+		return ""
+	case *query.BooleanQuery:
+		for _, c := range []query.Query{q.Must, q.Should, q.MustNot} {
+			if field := maskedQueryField(masks, c); field != "" {
+				return field
+			}
+		}
+		return ""
+	case *query.QueryStringQuery:
+		parsed, err := q.Parse()
+		if err != nil {
+			return "" // Refused as invalid.
+		}
+		return maskedQueryField(masks, parsed)
+	case *query.MatchAllQuery, *query.MatchNoneQuery, *query.DocIDQuery:
+		return ""
+	case *query.PhraseQuery:
+		return maskedSearchedField(masks, q.Field)
+	case *query.MultiPhraseQuery:
+		return maskedSearchedField(masks, q.Field)
+	case query.FieldableQuery:
+		return maskedSearchedField(masks, q.Field())
+	}
+	// Queries of unknown fields are refused like those of all fields.
+	return masks[0].Field
+}
+
+// maskedSearchedField returns field if the masks restrict it, or the first
+// masked field if field is the default one, empty otherwise.
+func maskedSearchedField(masks []FieldMask, field string) string {
+	if field == "" || field == "_all" {
+		return masks[0].Field
+	}
+	if masked(masks, field) {
+		return field
+	}
+	return ""
+}
+
+// maskedFilterField returns a masked field which a filter of the saved query
+// matches, empty if none.
+func maskedFilterField(masks []FieldMask, q *service.Query) string {
+	for _, f := range q.Filters {
+		if masked(masks, f.Field) {
+			return f.Field
+		}
+	}
+	return ""
+}
+
+// maskHit applies the masks to the fields of a hit, and removes the fragments
+// and term locations of the masked fields, which a search highlighting all
+// fields or including locations returns.
+func maskHit(masks []FieldMask, hit *search.DocumentMatch) {
+	mask(masks, hit.Fields)
+	for _, m := range masks {
+		delete(hit.Fragments, m.Field)
+		delete(hit.Locations, m.Field)
+	}
+}
+
+// maskIP zeroes the host part of an IP address, redacting values which are
+// not addresses.
+func maskIP(v interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		return RedactedValue
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return RedactedValue
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String()
+}
@@ -0,0 +1,192 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	bleve_index "github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/search"
+)
+
+func TestFieldMasks(t *testing.T) {
+	masks, err := ParseFieldMasks("auditor:message=hide, auditor:address=ip,auditor:host=redact,ops:host=hide")
+	if err != nil {
+		t.Fatalf("failed to parse field masks: %s", err.Error())
+	}
+	s := &Server{
+		FieldMasks: masks,
+		Role: func(req *http.Request) string {
+			return req.Header.Get("X-Role")
+		},
+	}
+	req, _ := http.NewRequest("GET", "/query", nil)
+	req.Header.Set("X-Role", "auditor")
+
+	hits := search.DocumentMatchCollection{
+		{ID: "a", Fields: map[string]interface{}{
+			"message": "auth password accepted",
+			"address": "192.168.1.27",
+			"host":    "web1",
+			"app":     "sshd",
+		}},
+		{ID: "b", Fields: map[string]interface{}{"address": "2001:db8::1:2"}},
+	}
+	documents := (*postFilter)(nil).documents(hits, s.fieldMasks(req), false)
+	fields := documents[0].(map[string]interface{})
+	if _, ok := fields["message"]; ok {
+		t.Error("hidden message returned")
+	}
+	if v := fields["address"]; v != "192.168.1.0" {
+		t.Errorf("address masked to %v, expected 192.168.1.0", v)
+	}
+	if v := fields["host"]; v != RedactedValue {
+		t.Errorf("host masked to %v, expected %s", v, RedactedValue)
+	}
+	if v := fields["app"]; v != "sshd" {
+		t.Errorf("unmasked app changed to %v", v)
+	}
+	if v := documents[1].(map[string]interface{})["address"]; v != "2001:db8::" {
+		t.Errorf("IPv6 address masked to %v, expected 2001:db8::", v)
+	}
+
+	// Requests without a masked role see everything.
+	req.Header.Del("X-Role")
+	if masks := s.fieldMasks(req); masks != nil {
+		t.Errorf("masks applied to unmasked role: %v", masks)
+	}
+
+	if _, err := ParseFieldMasks("auditor:message=scramble"); err == nil {
+		t.Error("unknown mask action did not fail")
+	}
+	if _, err := ParseFieldMasks("message=hide"); err == nil {
+		t.Error("mask without a role did not fail")
+	}
+}
+
+// maskTestSearcher returns a hit whose message is highlighted and located.
+type maskTestSearcher struct{}
+
+func (maskTestSearcher) Query(ctx context.Context, startTime, endTime time.Time, req *bleve.SearchRequest,
+	cb func(*bleve.SearchRequest, *bleve.SearchResult) error) error {
+	return cb(req, &bleve.SearchResult{
+		Status: &bleve.SearchStatus{Total: 1, Successful: 1},
+		Hits: search.DocumentMatchCollection{{
+			ID:        "a",
+			Fields:    map[string]interface{}{"message": "auth password accepted", "app": "sshd"},
+			Fragments: search.FieldFragmentMap{"message": {"auth <mark>password</mark> accepted"}, "app": {"<mark>sshd</mark>"}},
+			Locations: search.FieldTermLocationMap{"message": {"password": nil}, "app": {"sshd": nil}},
+		}},
+		Total: 1,
+	})
+}
+
+func (maskTestSearcher) Fields(ctx context.Context, startTime, endTime time.Time) ([]string, error) {
+	return nil, nil
+}
+
+func (maskTestSearcher) FieldDict(ctx context.Context, startTime, endTime time.Time, field string) ([]bleve_index.DictEntry, error) {
+	return nil, nil
+}
+
+// TestRawSearch_Masks tests that raw searches do not return masked fields
+// through their facets, sort keys, fragments or term locations.
+func TestRawSearch_Masks(t *testing.T) {
+	masks, err := ParseFieldMasks("auditor:message=hide")
+	if err != nil {
+		t.Fatalf("failed to parse field masks: %s", err.Error())
+	}
+	s := &Server{
+		Searcher:   maskTestSearcher{},
+		FieldMasks: masks,
+		Role: func(req *http.Request) string {
+			return req.Header.Get("X-Role")
+		},
+	}
+	rawSearch := func(searchRequest *bleve.SearchRequest) *httptest.ResponseRecorder {
+		bs, err := json.Marshal(searchRequest)
+		if err != nil {
+			t.Fatalf("failed to encode search request: %s", err.Error())
+		}
+		req := httptest.NewRequest("POST", "/raw/_search", bytes.NewReader(bs))
+		req.Header.Set("X-Role", "auditor")
+		w := httptest.NewRecorder()
+		s.RawSearch(w, req)
+		return w
+	}
+
+	faceted := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	faceted.AddFacet("messages", bleve.NewFacetRequest("message", 10))
+	sorted := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	sorted.SortBy([]string{"-message"})
+	highlighted := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	highlighted.Highlight = bleve.NewHighlight()
+	highlighted.Highlight.AddField("message")
+	term := bleve.NewTermQuery("password")
+	term.SetField("message")
+	for name, searchRequest := range map[string]*bleve.SearchRequest{
+		"facet":        faceted,
+		"sort":         sorted,
+		"highlight":    highlighted,
+		"query":        bleve.NewSearchRequest(bleve.NewConjunctionQuery(bleve.NewMatchAllQuery(), term)),
+		"query string": bleve.NewSearchRequest(bleve.NewQueryStringQuery("app:sshd message:password")),
+		"free text":    bleve.NewSearchRequest(bleve.NewQueryStringQuery("password")),
+	} {
+		if w := rawSearch(searchRequest); w.Code != http.StatusForbidden {
+			t.Errorf("%s by masked field returned status %d, expected %d", name, w.Code, http.StatusForbidden)
+		}
+	}
+	if w := rawSearch(bleve.NewSearchRequest(bleve.NewQueryStringQuery("app:sshd"))); w.Code != http.StatusOK {
+		t.Errorf("query of unmasked field returned status %d: %s", w.Code, w.Body.String())
+	}
+
+	// Nor can the masked fields be queried to get documents.
+	req := httptest.NewRequest("GET", "/get?q=message:password", nil)
+	req.Header.Set("X-Role", "auditor")
+	w := httptest.NewRecorder()
+	s.Get(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("get by masked field returned status %d, expected %d", w.Code, http.StatusForbidden)
+	}
+
+	// Highlighting all fields, with their term locations, leaves the masked
+	// ones out.
+	searchRequest := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	searchRequest.Highlight = bleve.NewHighlight()
+	searchRequest.IncludeLocations = true
+	w = rawSearch(searchRequest)
+	if w.Code != http.StatusOK {
+		t.Fatalf("search returned status %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Hits []struct {
+			Fields    map[string]interface{}         `json:"fields"`
+			Fragments map[string][]string            `json:"fragments"`
+			Locations map[string]map[string][]string `json:"locations"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode search result: %s", err.Error())
+	}
+	if len(resp.Hits) != 1 {
+		t.Fatalf("got %d hits, expected 1", len(resp.Hits))
+	}
+	hit := resp.Hits[0]
+	if _, ok := hit.Fields["message"]; ok {
+		t.Error("masked field returned")
+	}
+	if _, ok := hit.Fragments["message"]; ok {
+		t.Error("fragments of masked field returned")
+	}
+	if _, ok := hit.Locations["message"]; ok {
+		t.Error("term locations of masked field returned")
+	}
+	if _, ok := hit.Fragments["app"]; !ok {
+		t.Error("fragments of unmasked field left out")
+	}
+}
@@ -0,0 +1,97 @@
+package http
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Media types served.
+const (
+	mimeJSON   = "application/json"
+	mimeNDJSON = "application/x-ndjson"
+	mimeCSV    = "text/csv"
+)
+
+// acceptRange is a media range of an Accept header, with its quality.
+type acceptRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// parseAccept parses the media ranges of the Accept header value, skipping
+// those it cannot parse.
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		typ, subtype := mediaType, "*"
+		if i := strings.IndexByte(mediaType, '/'); i >= 0 {
+			typ, subtype = mediaType[:i], mediaType[i+1:]
+		}
+		q := 1.0
+		if qStr, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qStr, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{typ: typ, subtype: subtype, q: q})
+	}
+	return ranges
+}
+
+// quality returns the quality the ranges give to the media type offer, that
+// of the most specific range matching it, and whether one does.
+func quality(ranges []acceptRange, offer string) (float64, bool) {
+	typ, subtype := offer, ""
+	if i := strings.IndexByte(offer, '/'); i >= 0 {
+		typ, subtype = offer[:i], offer[i+1:]
+	}
+	q, specificity := 0.0, -1
+	for _, r := range ranges {
+		var n int
+		switch {
+		case r.typ == typ && r.subtype == subtype:
+			n = 2
+		case r.typ == typ && r.subtype == "*":
+			n = 1
+		case r.typ == "*" && r.subtype == "*":
+			n = 0
+		default:
+			continue
+		}
+		if n > specificity {
+			q, specificity = r.q, n
+		}
+	}
+	return q, specificity >= 0
+}
+
+// negotiate returns the media type of offers which the Accept header of req
+// prefers, the earliest of them on a tie, so that the first offer is the
+// default of requests without the header. It returns an empty string when
+// the header accepts none of them.
+func negotiate(req *http.Request, offers ...string) string {
+	accept := req.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		return offers[0]
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q, ok := quality(ranges, offer); ok && q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{mimeNDJSON, mimeCSV, mimeJSON}
+	for _, tt := range []struct {
+		accept string
+		want   string
+	}{
+		{"", mimeNDJSON},
+		{"*/*", mimeNDJSON},
+		{"text/csv", mimeCSV},
+		{"application/json", mimeJSON},
+		{"text/*", mimeCSV},
+		{"application/json;q=0.5, text/csv;q=0.8", mimeCSV},
+		{"application/*;q=0.5, application/json", mimeJSON},
+		{"text/csv;q=0, */*", mimeNDJSON},
+		{"text/html, */*;q=0.1", mimeNDJSON},
+		{"text/html", ""},
+		{"text/csv;q=0", ""},
+		{"not a media type", mimeNDJSON},
+	} {
+		req := httptest.NewRequest("POST", "/query/_export", nil)
+		req.Header.Set("Accept", tt.accept)
+		if got := negotiate(req, offers...); got != tt.want {
+			t.Errorf("Accept %q negotiated %q, expected %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestDocumentEncoder(t *testing.T) {
+	docs := []map[string]interface{}{
+		{"host": "a", "count": 2.0, "tag": []interface{}{"x", "y"}},
+		{"host": "b,c", "other": "left out"},
+	}
+	for _, tt := range []struct {
+		mediaType string
+		columns   []string
+		want      string
+	}{
+		{mimeNDJSON, nil, `{"count":2,"host":"a","tag":["x","y"]}` + "\n" + `{"host":"b,c","other":"left out"}` + "\n"},
+		{mimeJSON, nil, `[{"count":2,"host":"a","tag":["x","y"]},{"host":"b,c","other":"left out"}]` + "\n"},
+		{mimeCSV, nil, "count,host,tag\n2,a,\"[\"\"x\"\",\"\"y\"\"]\"\n,\"b,c\",\n"},
+		{mimeCSV, []string{"host"}, "host\na\n\"b,c\"\n"},
+	} {
+		var buf bytes.Buffer
+		e := newDocumentEncoder(&buf, tt.mediaType, tt.columns)
+		for _, doc := range docs {
+			if err := e.Encode(doc); err != nil {
+				t.Fatalf("failed to encode %s: %s", tt.mediaType, err.Error())
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Fatalf("failed to end %s: %s", tt.mediaType, err.Error())
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s export %v is %q, expected %q", tt.mediaType, tt.columns, buf.String(), tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	e := newDocumentEncoder(&buf, mimeJSON, nil)
+	if err := e.Close(); err != nil || buf.String() != "[]\n" {
+		t.Errorf("empty JSON export is %q, %v", buf.String(), err)
+	}
+}
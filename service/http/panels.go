@@ -0,0 +1,125 @@
+package http
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ekanite/ekanite/service"
+)
+
+// dashboard bundles the panels of a dashboard with the saved filters they
+// match, so that a frontend renders it from a single request.
+type dashboard struct {
+	Name    string                   `json:"name"`
+	Panels  []service.Panel          `json:"panels"`
+	Filters map[string]service.Query `json:"filters"`
+}
+
+// ListPanels lists the panels of the request owner, of the dashboard
+// parameter if given.
+func (s *Server) ListPanels(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	renderJSON(w, s.metaStore.ListPanelsAs(s.owner(r), r.URL.Query().Get("dashboard")))
+}
+
+// Dashboard returns the panels of the named dashboard, in order, with the
+// saved filters they match.
+func (s *Server) Dashboard(w http.ResponseWriter, r *http.Request, name string) {
+	owner := s.owner(r)
+	d := dashboard{
+		Name:    name,
+		Panels:  s.metaStore.ListPanelsAs(owner, name),
+		Filters: map[string]service.Query{},
+	}
+	if len(d.Panels) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("dashboard '" + name + "' has no panels"))
+		return
+	}
+	for _, p := range d.Panels {
+		if _, ok := d.Filters[p.Query]; ok || p.Query == "" {
+			continue
+		}
+		// Panels whose filter went missing are left for the consistency
+		// check, the frontend rendering them as broken.
+		if q, err := s.metaStore.ReadQueryAs(owner, p.Query); err == nil {
+			d.Filters[p.Query] = q
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	renderJSON(w, &d)
+}
+
+func (s *Server) ReadPanel(w http.ResponseWriter, r *http.Request, id string) {
+	p, err := s.metaStore.ReadPanelAs(s.owner(r), id)
+	if err != nil {
+		w.WriteHeader(errorStatus(w, err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	renderJSON(w, &p)
+}
+
+// CreatePanel saves a panel, of the saved filters the request owner sees.
+func (s *Server) CreatePanel(w http.ResponseWriter, r *http.Request) {
+	p, ok := readPanel(w, r)
+	if !ok {
+		return
+	}
+	p.Owner = s.owner(r)
+	id, err := s.metaStore.CreatePanel(p)
+	if err != nil {
+		w.WriteHeader(errorStatus(w, err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	s.audit(r, "panel.create", id, p)
+	w.WriteHeader(http.StatusCreated)
+	renderJSON(w, map[string]interface{}{
+		"id": id,
+	})
+}
+
+func (s *Server) UpdatePanel(w http.ResponseWriter, r *http.Request, id string) {
+	p, ok := readPanel(w, r)
+	if !ok {
+		return
+	}
+	if err := s.metaStore.UpdatePanelAs(s.owner(r), id, p); err != nil {
+		w.WriteHeader(errorStatus(w, err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	s.audit(r, "panel.update", id, p)
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("OK"))
+}
+
+func (s *Server) DeletePanel(w http.ResponseWriter, r *http.Request, id string) {
+	if err := s.metaStore.DeletePanelAs(s.owner(r), id); err != nil {
+		w.WriteHeader(errorStatus(w, err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	s.audit(r, "panel.delete", id, nil)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// readPanel reads the panel in the body of r, writing an error to w if it
+// cannot.
+func readPanel(w http.ResponseWriter, r *http.Request) (service.Panel, bool) {
+	var p service.Panel
+	bs, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(bs, &p)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return p, false
+	}
+	return p, true
+}
@@ -0,0 +1,115 @@
+package http
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Knetic/govaluate"
+	"github.com/blevesearch/bleve/search"
+	"github.com/ekanite/ekanite"
+)
+
+// computedField is a field derived from the other fields of a document.
+type computedField struct {
+	name string
+	expr *govaluate.EvaluableExpression
+}
+
+// postFilter filters, and adds computed fields to, the documents returned by a
+// search before they are encoded. Expressions may only use operators on the
+// document fields, no functions are available to them.
+//
+// The where parameter holds a boolean expression documents must match, e.g.
+// `bytes / 1024 > 100`, and each compute parameter a `name=expression` field
+// to add. Documents lacking a field used by where are dropped, and computed
+// fields which cannot be evaluated are left out.
+type postFilter struct {
+	where    *govaluate.EvaluableExpression
+	computed []computedField
+}
+
+// newPostFilter returns the post filter given by the request parameters, or nil
+// if there is none.
+func newPostFilter(params url.Values) (*postFilter, error) {
+	var f postFilter
+	if where := params.Get("where"); where != "" {
+		expr, err := govaluate.NewEvaluableExpression(where)
+		if err != nil {
+			return nil, fmt.Errorf("where(%s) is invalid: %w", where, err)
+		}
+		f.where = expr
+	}
+	for _, compute := range params["compute"] {
+		ss := strings.SplitN(compute, "=", 2)
+		if len(ss) != 2 || strings.TrimSpace(ss[0]) == "" {
+			return nil, fmt.Errorf("compute(%s) is not in the form name=expression", compute)
+		}
+		expr, err := govaluate.NewEvaluableExpression(ss[1])
+		if err != nil {
+			return nil, fmt.Errorf("compute(%s) is invalid: %w", compute, err)
+		}
+		f.computed = append(f.computed, computedField{name: strings.TrimSpace(ss[0]), expr: expr})
+	}
+	if f.where == nil && len(f.computed) == 0 {
+		return nil, nil
+	}
+	return &f, nil
+}
+
+// documents returns the fields of the hits which pass the filter, with the
+// computed fields added. The masks are applied first, so the filter never sees
+// what the user may not. If meta is set, the location of each document is
+// added as the _index, _shard and _id fields, besides the _node field of the
+// hits of other nodes, which is removed otherwise. A nil filter passes all
+// hits unchanged.
+func (f *postFilter) documents(hits search.DocumentMatchCollection, masks []FieldMask, meta bool) []interface{} {
+	documents := make([]interface{}, 0, len(hits))
+	for _, doc := range hits {
+		mask(masks, doc.Fields)
+		if meta {
+			addLocation(doc)
+		} else {
+			delete(doc.Fields, ekanite.NodeField)
+		}
+		if f == nil {
+			documents = append(documents, doc.Fields)
+			continue
+		}
+		if f.where != nil {
+			v, err := f.where.Evaluate(doc.Fields)
+			if matched, ok := v.(bool); err != nil || !ok || !matched {
+				continue
+			}
+		}
+		for _, c := range f.computed {
+			if v, err := c.expr.Evaluate(doc.Fields); err == nil {
+				doc.Fields[c.name] = v
+			}
+		}
+		documents = append(documents, doc.Fields)
+	}
+	return documents
+}
+
+// addLocation adds the location of the document of a hit to its fields. The
+// engine gives the index and shard of hits as index/shard.
+func addLocation(doc *search.DocumentMatch) {
+	if doc.Fields == nil {
+		doc.Fields = map[string]interface{}{}
+	}
+	ss := strings.SplitN(doc.Index, "/", 2)
+	doc.Fields["_index"] = ss[0]
+	if len(ss) == 2 {
+		doc.Fields["_shard"] = ss[1]
+	}
+	doc.Fields["_id"] = doc.ID
+}
+
+// readMeta returns whether the meta parameter asks for the location of the
+// documents.
+func readMeta(params url.Values) bool {
+	meta, _ := strconv.ParseBool(params.Get("meta"))
+	return meta
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/blevesearch/bleve/search"
+)
+
+func TestPostFilter(t *testing.T) {
+	params := url.Values{
+		"where":   {"bytes / 1024 > 100"},
+		"compute": {"kb = bytes / 1024"},
+	}
+	filter, err := newPostFilter(params)
+	if err != nil {
+		t.Fatalf("failed to create post filter: %s", err.Error())
+	}
+
+	hits := search.DocumentMatchCollection{
+		{ID: "a", Fields: map[string]interface{}{"bytes": float64(204800)}},
+		{ID: "b", Fields: map[string]interface{}{"bytes": float64(1024)}},
+		{ID: "c", Fields: map[string]interface{}{"message": "no bytes"}},
+	}
+	documents := filter.documents(hits, nil, false)
+	if len(documents) != 1 {
+		t.Fatalf("post filter kept %d documents, expected 1", len(documents))
+	}
+	if kb := documents[0].(map[string]interface{})["kb"]; kb != float64(200) {
+		t.Fatalf("computed field incorrect, got %v, expected 200", kb)
+	}
+
+	if filter, err := newPostFilter(url.Values{}); err != nil || filter != nil {
+		t.Fatalf("empty post filter returned %v, %v", filter, err)
+	}
+	if documents := (*postFilter)(nil).documents(hits, nil, false); len(documents) != 3 {
+		t.Fatalf("nil post filter kept %d documents, expected 3", len(documents))
+	}
+	hits = search.DocumentMatchCollection{
+		{ID: "d", Index: "19820205_0400/0002", Fields: map[string]interface{}{"bytes": float64(1)}},
+	}
+	fields := (*postFilter)(nil).documents(hits, nil, true)[0].(map[string]interface{})
+	if fields["_index"] != "19820205_0400" || fields["_shard"] != "0002" || fields["_id"] != "d" {
+		t.Fatalf("document location incorrect: %v", fields)
+	}
+	hits = search.DocumentMatchCollection{
+		{ID: "e", Index: "19820205_0400/0001", Fields: map[string]interface{}{"_node": "http://node:8080/ekanite"}},
+	}
+	fields = (*postFilter)(nil).documents(hits, nil, true)[0].(map[string]interface{})
+	if fields["_node"] != "http://node:8080/ekanite" || fields["_index"] != "19820205_0400" || fields["_shard"] != "0001" {
+		t.Fatalf("location of document of another node incorrect: %v", fields)
+	}
+	if _, ok := (*postFilter)(nil).documents(hits, nil, false)[0].(map[string]interface{})["_node"]; ok {
+		t.Fatal("node returned without meta")
+	}
+	if _, err := newPostFilter(url.Values{"compute": {"kb"}}); err == nil {
+		t.Fatal("compute without a name did not fail")
+	}
+}
@@ -1,9 +1,11 @@
 package http
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,21 +16,27 @@ import (
 	"github.com/ekanite/ekanite/service"
 )
 
+// caseFold returns the keyword fields the Searcher lower-cases, which the
+// filters are matched lower-cased on.
+func (s *Server) caseFold() ekanite.CaseFold {
+	return ekanite.CaseFoldOf(s.Searcher)
+}
+
 func readStringArray(params url.Values, field string, defaultValues []string) []string {
-	if sort := params["sort"]; len(sort) > 0 {
+	if values := params[field]; len(values) > 0 {
 		offset := 0
-		for idx := range sort {
-			if sort[idx] == "" {
+		for idx := range values {
+			if values[idx] == "" {
 				continue
 			}
 
 			if idx != offset {
-				sort[offset] = sort[idx]
+				values[offset] = values[idx]
 			}
 			offset++
 		}
 		if offset > 0 {
-			return sort[:offset]
+			return values[:offset]
 		}
 	}
 
@@ -37,13 +45,13 @@ func readStringArray(params url.Values, field string, defaultValues []string) []
 func (s *Server) SummaryByFilters(w http.ResponseWriter, req *http.Request, name string) {
 	var q query.Query
 	if name != "0" && name != "" {
-		var qu, err = s.metaStore.ReadQuery(name)
+		var qu, err = s.metaStore.ReadQueryAs(s.owner(req), name)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte("Bucket: " + err.Error()))
 			return
 		}
-		queries, err := qu.ToQueries()
+		queries, err := qu.ToQueries(s.caseFold())
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte("Bucket: " + err.Error()))
@@ -69,14 +77,14 @@ func (s *Server) SummaryByFilters(w http.ResponseWriter, req *http.Request, name
 func (s *Server) SearchByFilters(w http.ResponseWriter, req *http.Request, name string) {
 	var q query.Query
 	if name != "0" && name != "" {
-		var qu, err = s.metaStore.ReadQuery(name)
+		var qu, err = s.metaStore.ReadQueryAs(s.owner(req), name)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte("Bucket: " + err.Error()))
 			return
 		}
 
-		queries, err := qu.ToQueries()
+		queries, err := qu.ToQueries(s.caseFold())
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte("Bucket: " + err.Error()))
@@ -86,19 +94,31 @@ func (s *Server) SearchByFilters(w http.ResponseWriter, req *http.Request, name
 	}
 
 	queryParams := req.URL.Query()
-	searchRequest := bleve.NewSearchRequest(q)
-	searchRequest.Fields = readStringArray(queryParams, "fields", []string{"*"})
-	searchRequest.SortBy(readStringArray(queryParams, "sort", []string{"-reception"}))
+	filter, err := newPostFilter(queryParams)
+	if err != nil {
+		s.RenderText(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+	searchRequest := ekanite.NewSearchRequest(q,
+		readStringArray(queryParams, "fields", nil), readStringArray(queryParams, "sort", nil))
 
-	s.SearchIn(w, req, searchRequest, func(req *bleve.SearchRequest, resp *bleve.SearchResult) error {
-		var documents = make([]interface{}, 0, resp.Hits.Len())
-		for _, doc := range resp.Hits {
-			documents = append(documents, doc.Fields)
-		}
-		return encodeJSON(w, map[string]interface{}{"total": resp.Total, "documents": documents})
+	masks, meta := s.fieldMasks(req), readMeta(queryParams)
+	s.searchWarned(w, req, searchRequest, func(warning string, req *bleve.SearchRequest, resp *bleve.SearchResult) error {
+		documents := filter.documents(resp.Hits, masks, meta)
+		return encodeJSON(w, documentsResult(resp.Total, documents, warning))
 	})
 }
 
+// documentsResult is the response of searches for documents, warning of a
+// time range predating the oldest data retained, if not empty.
+func documentsResult(total uint64, documents []interface{}, warning string) map[string]interface{} {
+	result := map[string]interface{}{"total": total, "documents": documents}
+	if warning != "" {
+		result["warning"] = warning
+	}
+	return result
+}
+
 func (s *Server) SummaryByFiltersInBody(w http.ResponseWriter, req *http.Request) {
 	var qu service.Query
 	if err := decodeJSON(req, &qu); err != nil {
@@ -107,7 +127,7 @@ func (s *Server) SummaryByFiltersInBody(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	queries, err := qu.ToQueries()
+	queries, err := qu.ToQueries(s.caseFold())
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Bucket: " + err.Error()))
@@ -130,7 +150,7 @@ func (s *Server) SearchByFiltersInBody(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	queries, err := qu.ToQueries()
+	queries, err := qu.ToQueries(s.caseFold())
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Bucket: " + err.Error()))
@@ -140,28 +160,96 @@ func (s *Server) SearchByFiltersInBody(w http.ResponseWriter, req *http.Request)
 	q := bleve.NewConjunctionQuery(queries...)
 
 	queryParams := req.URL.Query()
-	searchRequest := bleve.NewSearchRequest(q)
-	searchRequest.Fields = readStringArray(queryParams, "fields", []string{"*"})
-	searchRequest.SortBy(readStringArray(queryParams, "sort", []string{"-reception"}))
+	filter, err := newPostFilter(queryParams)
+	if err != nil {
+		s.RenderText(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+	searchRequest := ekanite.NewSearchRequest(q,
+		readStringArray(queryParams, "fields", nil), readStringArray(queryParams, "sort", nil))
 
-	s.SearchIn(w, req, searchRequest, func(req *bleve.SearchRequest, resp *bleve.SearchResult) error {
-		var documents = make([]interface{}, 0, resp.Hits.Len())
-		for _, doc := range resp.Hits {
-			documents = append(documents, doc.Fields)
-		}
-		return encodeJSON(w, map[string]interface{}{"total": resp.Total, "documents": documents})
+	masks, meta := s.fieldMasks(req), readMeta(queryParams)
+	s.searchWarned(w, req, searchRequest, func(warning string, req *bleve.SearchRequest, resp *bleve.SearchResult) error {
+		documents := filter.documents(resp.Hits, masks, meta)
+		return encodeJSON(w, documentsResult(resp.Total, documents, warning))
 	})
 }
 
+// EstimateByFiltersInBody reports what searching with the query in the body
+// would cost, without running it.
+func (s *Server) EstimateByFiltersInBody(w http.ResponseWriter, req *http.Request) {
+	estimator, ok := s.Searcher.(ekanite.Estimator)
+	if !ok {
+		http.Error(w, "query estimation is not supported", http.StatusNotImplemented)
+		return
+	}
+
+	var qu service.Query
+	if err := decodeJSON(req, &qu); err != nil {
+		s.RenderText(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	queries, err := qu.ToQueries(s.caseFold())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Bucket: " + err.Error()))
+		return
+	}
+
+	searchRequest := bleve.NewSearchRequest(bleve.NewConjunctionQuery(queries...))
+	start, end, indexNames, ok := s.prepareSearch(w, req, searchRequest)
+	if !ok {
+		return
+	}
+
+	estimate, err := estimator.Estimate(start, end, indexNames, searchRequest)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error estimating query: %v", err), errorStatus(w, err))
+		return
+	}
+	renderJSON(w, estimate)
+}
+
+// ValidateQueryString validates the bleve query string of the query field of
+// the body, returning its errors with their character positions, and
+// suggestions, so that search boxes can be validated as they are typed.
+func (s *Server) ValidateQueryString(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Query string `json:"query"`
+	}
+	if err := decodeJSON(req, &body); err != nil {
+		s.RenderText(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+	renderJSON(w, ekanite.ValidateQueryString(body.Query))
+}
+
 func (s *Server) groupBy(w http.ResponseWriter, req *http.Request, q query.Query, params url.Values, groupBy string) {
+	if field := maskedQueryField(s.fieldMasks(req), q); field != "" {
+		s.RenderText(w, req, http.StatusForbidden, "field("+field+") is restricted.")
+		return
+	}
 	var start, end time.Time
 
+	// With a time zone, times are read in it and time buckets aligned on
+	// its days, rather than the server's.
+	var loc *time.Location
+	parseTime := ekanite.ParseTime
+	if tz := params.Get("tz"); tz != "" {
+		if loc = ekanite.ParseLocation(tz); loc == nil {
+			s.RenderText(w, req, http.StatusBadRequest, "tz("+tz+") is invalid.")
+			return
+		}
+		parseTime = func(v string) time.Time { return ekanite.ParseTimeIn(v, loc) }
+	}
+
 	startAt := params.Get("start_at")
 	if startAt == "" {
 		s.RenderText(w, req, http.StatusBadRequest, "start_at is missing.")
 		return
 	}
-	start = ekanite.ParseTime(startAt)
+	start = parseTime(startAt)
 	if start.IsZero() {
 		s.RenderText(w, req, http.StatusBadRequest, "start_at("+startAt+") is invalid.")
 		return
@@ -169,7 +257,7 @@ func (s *Server) groupBy(w http.ResponseWriter, req *http.Request, q query.Query
 
 	endAt := params.Get("end_at")
 	if endAt != "" {
-		end = ekanite.ParseTime(endAt)
+		end = parseTime(endAt)
 		if end.IsZero() {
 			s.RenderText(w, req, http.StatusBadRequest, "end_at("+endAt+") is invalid.")
 			return
@@ -178,34 +266,35 @@ func (s *Server) groupBy(w http.ResponseWriter, req *http.Request, q query.Query
 		end = time.Now()
 	}
 
-	inclusive := true
-	timeQuery := bleve.NewDateRangeInclusiveQuery(start, end, &inclusive, &inclusive)
-	timeQuery.SetField("reception")
-
-	if q == nil {
-		q = timeQuery
-	} else if conjunctionQuery, ok := q.(*query.ConjunctionQuery); ok {
-		conjunctionQuery.AddQuery(timeQuery)
-	} else {
-		q = bleve.NewConjunctionQuery(q, timeQuery)
+	q = ekanite.WithTimeRange(q, start, end)
+	if err := s.refresh(req, start, end); err != nil {
+		s.RenderText(w, req, errorStatus(w, err), "error refreshing: "+err.Error())
+		return
 	}
 
 	ss := strings.Fields(groupBy)
 	switch len(ss) {
 	case 1:
-		if ss[0] == "reception" {
+		if ss[0] == ekanite.ReceptionField {
 			s.RenderText(w, req, http.StatusBadRequest,
 				"group by("+groupBy+") is invalid format")
 			return
 		}
 		s.groupByAny(w, req, q, start, end, groupBy)
 	case 2:
-		if ss[0] != "reception" {
+		if ss[0] != ekanite.ReceptionField {
 			s.RenderText(w, req, http.StatusBadRequest,
 				"group by("+groupBy+") is invalid format")
 			return
 		}
-		s.groupByTimestamp(w, req, q, start, end, ss[0], ss[1])
+		s.groupByTimestamp(w, req, q, start, end, loc, ss[0], ss[1])
+	case 3:
+		if ss[0] != ekanite.ReceptionField || ss[2] == ekanite.ReceptionField {
+			s.RenderText(w, req, http.StatusBadRequest,
+				"group by("+groupBy+") is invalid format")
+			return
+		}
+		s.groupByTimeAndField(w, req, q, params, start, end, loc, ss[0], ss[1], ss[2])
 	default:
 		s.RenderText(w, req, http.StatusBadRequest,
 			"group by("+groupBy+") is invalid format")
@@ -213,6 +302,10 @@ func (s *Server) groupBy(w http.ResponseWriter, req *http.Request, q query.Query
 }
 
 func (s *Server) groupByAny(w http.ResponseWriter, req *http.Request, q query.Query, startAt, endAt time.Time, field string) {
+	if masked(s.fieldMasks(req), field) {
+		s.RenderText(w, req, http.StatusForbidden, "field("+field+") is restricted.")
+		return
+	}
 	var results []map[string]interface{}
 	err := ekanite.GroupBy(s.Searcher, req.Context(), startAt, endAt, q, field, func(stats map[string]uint64) error {
 		for key, value := range stats {
@@ -221,6 +314,13 @@ func (s *Server) groupByAny(w http.ResponseWriter, req *http.Request, q query.Qu
 		return nil
 	})
 
+	if errors.Is(err, ekanite.ErrFieldNotFound) {
+		// Not an error, the range just has nothing to group. Say why the
+		// result is empty.
+		w.Header().Set("Warning", "299 ekanite "+strconv.Quote(err.Error()))
+		renderJSON(w, []interface{}{})
+		return
+	}
 	if err != nil {
 		s.RenderText(w, req, http.StatusBadRequest, err.Error())
 		return
@@ -228,7 +328,7 @@ func (s *Server) groupByAny(w http.ResponseWriter, req *http.Request, q query.Qu
 	renderJSON(w, results)
 }
 
-func (s *Server) groupByTimestamp(w http.ResponseWriter, req *http.Request, q query.Query, startAt, endAt time.Time, field, value string) {
+func (s *Server) groupByTimestamp(w http.ResponseWriter, req *http.Request, q query.Query, startAt, endAt time.Time, loc *time.Location, field, value string) {
 	duration, err := time.ParseDuration(value)
 	if err != nil {
 		s.RenderText(w, req, http.StatusBadRequest,
@@ -236,17 +336,50 @@ func (s *Server) groupByTimestamp(w http.ResponseWriter, req *http.Request, q qu
 		return
 	}
 
-	err = ekanite.GroupByTime(s.Searcher, req.Context(), startAt, endAt, q, field, duration,
+	err = ekanite.GroupByTime(s.Searcher, req.Context(), startAt, endAt, q, field, duration, loc,
 		func(req *bleve.SearchRequest, resp *bleve.SearchResult, results []*search.DateRangeFacet) error {
 			return encodeJSON(w, results)
 		})
 	if err != nil {
-		if err == bleve.ErrorAliasEmpty {
+		if errors.Is(err, ekanite.ErrIndexNotFound) {
 			encodeJSON(w, []*search.DateRangeFacet{})
 		} else {
-			s.RenderText(w, req, http.StatusBadRequest,
+			s.RenderText(w, req, errorStatus(w, err),
 				fmt.Sprintf("error executing query: %v", err))
 		}
 		return
 	}
 }
+
+// groupByTimeAndField renders the count matrix of the documents by time
+// bucket and by the most frequent terms of field, size of them.
+func (s *Server) groupByTimeAndField(w http.ResponseWriter, req *http.Request, q query.Query, params url.Values,
+	startAt, endAt time.Time, loc *time.Location, timeField, value, field string) {
+	if masked(s.fieldMasks(req), field) {
+		s.RenderText(w, req, http.StatusForbidden, "field("+field+") is restricted.")
+		return
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		s.RenderText(w, req, http.StatusBadRequest,
+			"error executing query: `"+value+"' is invalid in 'group by'")
+		return
+	}
+	size := ekanite.DefaultMatrixTerms
+	if sizeStr := params.Get("size"); sizeStr != "" {
+		size, err = strconv.Atoi(sizeStr)
+		if err != nil || size <= 0 {
+			s.RenderText(w, req, http.StatusBadRequest, "size("+sizeStr+") is invalid.")
+			return
+		}
+	}
+
+	err = ekanite.GroupByTimeAndField(s.Searcher, req.Context(), startAt, endAt, q, timeField, duration, loc, field, size,
+		func(matrix *ekanite.CountMatrix) error {
+			return encodeJSON(w, matrix)
+		})
+	if err != nil {
+		s.RenderText(w, req, errorStatus(w, err),
+			fmt.Sprintf("error executing query: %v", err))
+	}
+}
@@ -0,0 +1,77 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ekanite/ekanite"
+	"github.com/ekanite/ekanite/input"
+)
+
+// sourceReport ranks the sources of events, for capacity chargeback.
+type sourceReport struct {
+	StartAt time.Time `json:"start_at"`
+	EndAt   time.Time `json:"end_at"`
+	*ekanite.SourceReport
+
+	// Senders ranks the sender addresses since Since, by what they sent
+	// through the syslog collectors.
+	Senders struct {
+		Since         time.Time           `json:"since"`
+		ByEvents      []input.SenderStats `json:"by_events"`
+		ByBytes       []input.SenderStats `json:"by_bytes"`
+		ByParseErrors []input.SenderStats `json:"by_parse_errors"`
+	} `json:"senders"`
+
+	// ParseQuality ranks the sender addresses whose messages were the most
+	// poorly parsed, those whose parse quality degraded first.
+	ParseQuality []input.ParseQuality `json:"parse_quality"`
+}
+
+// SourceReport renders the n hosts and apps, 10 by default, which sent the
+// most events between start_at and end_at, the last day by default, the n
+// sender addresses which sent the most events, bytes and messages failing to
+// be parsed since ekanite started, and the n whose messages were the most
+// poorly parsed.
+func (s *Server) SourceReport(w http.ResponseWriter, r *http.Request) {
+	n := ekanite.DefaultSourceReportSize
+	if nStr := r.URL.Query().Get("n"); nStr != "" {
+		i64, err := strconv.ParseInt(nStr, 10, 0)
+		if err != nil || i64 <= 0 {
+			http.Error(w, "n("+nStr+") is invalid.", http.StatusBadRequest)
+			return
+		}
+		n = int(i64)
+	}
+	s.timeRange(w, r, func(w http.ResponseWriter, r *http.Request, start, end time.Time) {
+		if end.IsZero() {
+			end = time.Now()
+		}
+		if start.IsZero() {
+			start = end.Add(-24 * time.Hour)
+		}
+		sources, err := ekanite.TopSources(s.Searcher, r.Context(), start, end, n)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error reporting sources: %v", err), errorStatus(w, err))
+			return
+		}
+		masks := s.fieldMasks(r)
+		if masked(masks, "host") {
+			sources.Hosts = []ekanite.TermCount{}
+		}
+		if masked(masks, "app") {
+			sources.Apps = []ekanite.TermCount{}
+		}
+
+		report := sourceReport{StartAt: start, EndAt: end, SourceReport: sources}
+		if !masked(masks, "address") {
+			report.Senders.ByEvents, report.Senders.Since, _ = s.Pipeline.TopSenders(n, input.SenderEvents)
+			report.Senders.ByBytes, _, _ = s.Pipeline.TopSenders(n, input.SenderBytes)
+			report.Senders.ByParseErrors, _, _ = s.Pipeline.TopSenders(n, input.SenderParseErrors)
+			report.ParseQuality = s.Pipeline.ParseQualities(n)
+		}
+		renderJSON(w, report)
+	})
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ekanite/ekanite/service"
+)
+
+func (s *Server) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	rs := s.metaStore.ListSubscriptionsAs(s.owner(r))
+	if rs == nil {
+		rs = []service.Subscription{}
+	}
+	w.WriteHeader(http.StatusOK)
+	renderJSON(w, rs)
+}
+
+func (s *Server) ReadSubscription(w http.ResponseWriter, r *http.Request, id string) {
+	sub, err := s.metaStore.ReadSubscriptionAs(s.owner(r), id)
+	if err != nil {
+		w.WriteHeader(errorStatus(w, err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	renderJSON(w, &sub)
+}
+
+// CreateSubscription registers a saved filter, interval and webhook, the
+// documents matching the filter received from now on being pushed to the
+// webhook at each interval.
+func (s *Server) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	bs, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	var sub service.Subscription
+	if err := json.Unmarshal(bs, &sub); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	sub.Owner = s.owner(r)
+	sub.Role = ""
+	if s.Role != nil {
+		sub.Role = s.Role(r)
+	}
+	id, err := s.metaStore.CreateSubscription(sub)
+	if err != nil {
+		w.WriteHeader(errorStatus(w, err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	s.audit(r, "subscription.create", id, sub)
+	w.WriteHeader(http.StatusCreated)
+	renderJSON(w, map[string]interface{}{
+		"id": id,
+	})
+}
+
+func (s *Server) DeleteSubscription(w http.ResponseWriter, r *http.Request, id string) {
+	if err := s.metaStore.DeleteSubscriptionAs(s.owner(r), id); err != nil {
+		w.WriteHeader(errorStatus(w, err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	s.audit(r, "subscription.delete", id, nil)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
@@ -0,0 +1,129 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ekanite/ekanite"
+	"github.com/ekanite/ekanite/service"
+)
+
+// Tail streams the events ingested from now on as newline-delimited JSON,
+// until the client goes away. The events are filtered by the saved filter
+// given by the filter parameter, all of them being sent without it. With the
+// since parameter, the recent events kept since then are sent first. If
+// LiveTail follows the meta store, the stream follows the changes of the
+// filter, and ends when it is deleted.
+func (s *Server) Tail(w http.ResponseWriter, req *http.Request) {
+	if s.LiveTail == nil {
+		http.Error(w, "live tail is not enabled", http.StatusNotImplemented)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	match, since, limit, err := s.readTailParams(req)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(w, err))
+		return
+	}
+
+	filter := strings.TrimSpace(req.URL.Query().Get("filter"))
+	if filter == "0" {
+		filter = ""
+	}
+	recent, events, cancel := s.LiveTail.SubscribeFilter(filter, s.owner(req), match, since, limit)
+	defer cancel()
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	masks := s.fieldMasks(req)
+	e := json.NewEncoder(w)
+	for _, event := range recent {
+		mask(masks, event)
+		if err := e.Encode(event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				// The filter was deleted, or can no longer be matched.
+				return
+			}
+			mask(masks, event)
+			if err := e.Encode(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// Recent returns the recent events kept in memory which match the saved filter
+// given by the filter parameter, oldest first, without searching the indexes.
+// The since parameter, a time or a duration back from now, and the limit
+// parameter restrict them to the latest ones.
+func (s *Server) Recent(w http.ResponseWriter, req *http.Request) {
+	if s.LiveTail == nil || s.LiveTail.Recent == nil {
+		http.Error(w, "recent events are not kept", http.StatusNotImplemented)
+		return
+	}
+	match, since, limit, err := s.readTailParams(req)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(w, err))
+		return
+	}
+
+	events := s.LiveTail.Recent.Events(since, match, limit)
+	if events == nil {
+		events = []map[string]interface{}{}
+	}
+	masks := s.fieldMasks(req)
+	for _, event := range events {
+		mask(masks, event)
+	}
+	renderJSON(w, events)
+}
+
+// readTailParams reads the filter, since and limit parameters of the tail and
+// recent endpoints.
+func (s *Server) readTailParams(req *http.Request) (match service.Matcher, since time.Time, limit int, err error) {
+	params := req.URL.Query()
+	if id := strings.TrimSpace(params.Get("filter")); id != "" && id != "0" {
+		q, err := s.metaStore.ReadQueryAs(s.owner(req), id)
+		if err != nil {
+			return nil, since, 0, err
+		}
+		if field := maskedFilterField(s.fieldMasks(req), &q); field != "" {
+			return nil, since, 0, fmt.Errorf("%w: field(%s) is restricted", ErrForbidden, field)
+		}
+		if match, err = q.Matcher(s.caseFold()); err != nil {
+			return nil, since, 0, err
+		}
+	}
+	if v := strings.TrimSpace(params.Get("since")); v != "" {
+		if since = ekanite.ParseSince(v); since.IsZero() {
+			return nil, since, 0, service.ErrBadArguments("since '" + v + "' is invalid")
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			return nil, since, 0, service.ErrBadArguments("limit '" + v + "' is invalid")
+		}
+	}
+	return match, since, limit, nil
+}
@@ -0,0 +1,87 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ekanite/ekanite/input"
+)
+
+// TenantField is the field the events ingested through the syslogs endpoint
+// are stamped with the tenant of their request, replacing any value the
+// events carry, so that a tenant cannot pass its events off as another's.
+const TenantField = "tenant"
+
+// ErrTimestampSkew is returned when an event ingested through the syslogs
+// endpoint is further from now than MaxTimestampSkew.
+var ErrTimestampSkew = errors.New("event timestamp outside the allowed skew")
+
+// TenantFromHeader returns a Server.Tenant taking the tenant of requests from
+// their header name. It is only to be trusted behind a proxy which sets the
+// header itself.
+func TenantFromHeader(name string) func(*http.Request) string {
+	return func(req *http.Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+// TenantFromTokens returns a Server.Tenant taking the tenant of requests from
+// their bearer token, mapped to its tenant by tenants. Requests with other
+// tokens, or none, have the empty tenant.
+func TenantFromTokens(tenants map[string]string) func(*http.Request) string {
+	return func(req *http.Request) string {
+		token, ok := bearerToken(req)
+		if !ok {
+			return ""
+		}
+		return tenants[token]
+	}
+}
+
+// prepareEvents numbers the events ingested by req, coerces and enriches their
+// fields, stamps them with its tenant, and checks that they fall within
+// MaxTimestampSkew of now, returning ErrTimestampSkew if one does not.
+func (s *Server) prepareEvents(req *http.Request, events []*input.Event) error {
+	var tenant string
+	if s.Tenant != nil {
+		tenant = s.Tenant(req)
+	}
+	now := time.Now()
+	for _, e := range events {
+		if e.ReceptionTime.IsZero() {
+			e.ReceptionTime = now.UTC()
+		}
+		if e.Sequence == 0 {
+			sequence, err := s.Pipeline.NextSequence()
+			if err != nil {
+				return err
+			}
+			e.Sequence = sequence
+		}
+		if e.Parsed != nil {
+			s.Pipeline.Coerce(e.Parsed)
+			s.Pipeline.Enrich(e.Parsed)
+		}
+		if s.Tenant != nil && e.Parsed != nil {
+			// Requests of the empty tenant cannot claim another either.
+			delete(e.Parsed, TenantField)
+		}
+		if tenant != "" {
+			if e.Parsed == nil {
+				e.Parsed = map[string]interface{}{}
+			}
+			e.Parsed[TenantField] = tenant
+		}
+
+		if s.MaxTimestampSkew <= 0 {
+			continue
+		}
+		if skew := e.ReferenceTime().Sub(now); skew > s.MaxTimestampSkew || -skew > s.MaxTimestampSkew {
+			return fmt.Errorf("%w: %s is %s from now, over %s", ErrTimestampSkew,
+				e.ReferenceTime().Format(time.RFC3339), skew.Round(time.Second), s.MaxTimestampSkew)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ekanite/ekanite"
+	"github.com/ekanite/ekanite/input"
+)
+
+func TestRecvSyslogs_Tenant(t *testing.T) {
+	c := make(chan ekanite.Document, 10)
+	s := &Server{
+		c:                c,
+		Tenant:           TenantFromTokens(map[string]string{"acme-shipper": "acme"}),
+		MaxTimestampSkew: time.Hour,
+	}
+	postAs := func(token, body string) int {
+		req := httptest.NewRequest("POST", "/syslogs", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.RecvSyslogs(w, req)
+		return w.Code
+	}
+	post := func(body string) int {
+		return postAs("acme-shipper", body)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	if code := post(`[{"Parsed": {"timestamp": "` + now + `", "tenant": "globex"}}, {"Parsed": {"timestamp": "` + now + `"}}]`); code != http.StatusOK {
+		t.Fatalf("ingest returned %d", code)
+	}
+	for i := 0; i < 2; i++ {
+		e := (<-c).(*input.Event)
+		if e.Parsed[TenantField] != "acme" {
+			t.Errorf("event %d has tenant %v", i, e.Parsed[TenantField])
+		}
+	}
+
+	// Tokens of no tenant cannot forge one either.
+	if code := postAs("unmapped", `[{"Parsed": {"timestamp": "`+now+`", "tenant": "globex"}}]`); code != http.StatusOK {
+		t.Fatalf("ingest with unmapped token returned %d", code)
+	}
+	if e := (<-c).(*input.Event); e.Parsed[TenantField] != nil {
+		t.Errorf("event of unmapped token has tenant %v", e.Parsed[TenantField])
+	}
+
+	if code := post(`[{"Parsed": {"timestamp": "` + now + `"}}, {"Parsed": {"timestamp": "2000-01-01T00:00:00Z"}}]`); code != http.StatusUnprocessableEntity {
+		t.Fatalf("ingest of skewed event returned %d", code)
+	}
+	if len(c) != 0 {
+		t.Fatal("events of refused request ingested")
+	}
+}
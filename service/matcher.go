@@ -0,0 +1,226 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ekanite/ekanite"
+)
+
+// Matcher reports whether the parsed fields of an event match a query.
+type Matcher func(fields map[string]interface{}) bool
+
+// wordPattern splits analyzed fields into words, as the index does.
+var wordPattern = regexp.MustCompile(`[^\W_]+`)
+
+// Matcher compiles the filters of the query into a Matcher of parsed events,
+// so that live events can be filtered by the definitions used to search the
+// indexed ones. The filters ToQueries skips are skipped as well. Query string
+// filters need the index to be evaluated, and cannot be compiled. The values
+// of the keyword fields of fold are matched lower-cased, as they are indexed.
+func (q *Query) Matcher(fold ekanite.CaseFold) (Matcher, error) {
+	var matchers []Matcher
+	for _, f := range q.Filters {
+		if len(f.Field) == 0 || len(f.Op) == 0 || len(f.Values) == 0 || f.Values[0] == "" {
+			continue
+		}
+		m, err := f.Matcher(fold)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return func(fields map[string]interface{}) bool {
+		for _, m := range matchers {
+			if !m(fields) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// Matcher compiles the filter into a Matcher of parsed events, which matches
+// the same terms as the query returned by ToQuery does in the index.
+func (f *Filter) Matcher(fold ekanite.CaseFold) (Matcher, error) {
+	field := f.Field
+	switch f.Op {
+	case OpPhrase:
+		phrase := f.Values
+		return f.termsMatcher(fold, func(terms []string) bool {
+			return containsPhrase(terms, phrase)
+		}), nil
+	case OpPrefix:
+		prefix := fold.Fold(field, f.Values[0])
+		return f.termMatcher(fold, func(term string) bool {
+			return strings.HasPrefix(term, prefix)
+		}), nil
+	case OpRegexp:
+		re, err := regexp.Compile("^(?:" + f.Values[0] + ")$")
+		if err != nil {
+			return nil, ErrBadArguments("regexp '" + f.Values[0] + "' is invalid: " + err.Error())
+		}
+		return f.termMatcher(fold, re.MatchString), nil
+	case OpTerm:
+		values := map[string]bool{}
+		for _, v := range f.Values {
+			if v == "" {
+				return nil, errors.New("'" + field + "' has empty value")
+			}
+			values[fold.Fold(field, v)] = true
+		}
+		return f.termMatcher(fold, func(term string) bool {
+			return values[term]
+		}), nil
+	case OpWildcard:
+		re, err := regexp.Compile("^" + wildcardToRegexp(fold.Fold(field, f.Values[0])) + "$")
+		if err != nil {
+			return nil, ErrBadArguments("wildcard '" + f.Values[0] + "' is invalid: " + err.Error())
+		}
+		return f.termMatcher(fold, re.MatchString), nil
+	case OpDateRange:
+		var start, end time.Time
+		if f.Values[0] != "" {
+			if start = ekanite.ParseTime(f.Values[0]); start.IsZero() {
+				return nil, errors.New("'" + f.Values[0] + "' is invalid datetime")
+			}
+		}
+		if len(f.Values) > 1 && f.Values[1] != "" {
+			if end = ekanite.ParseTime(f.Values[1]); end.IsZero() {
+				return nil, errors.New("'" + f.Values[1] + "' is invalid datetime")
+			}
+		}
+		return func(fields map[string]interface{}) bool {
+			t, ok := timeValue(fields[field])
+			return ok && (start.IsZero() || !t.Before(start)) && (end.IsZero() || !t.After(end))
+		}, nil
+	case OpNumericRange:
+		if len(f.Values) < 2 {
+			return nil, fmt.Errorf("NumericRange(%v) is invalid", f.Values)
+		}
+		start, err := strconv.ParseFloat(f.Values[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		end, err := strconv.ParseFloat(f.Values[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(start) || math.IsInf(start, 0) {
+			if math.IsNaN(end) || math.IsInf(end, 0) {
+				return nil, fmt.Errorf("NumericRange(%v) is invalid", f.Values)
+			}
+			start = math.Inf(-1)
+		}
+		if math.IsNaN(end) || math.IsInf(end, 0) {
+			end = math.Inf(1)
+		}
+		return func(fields map[string]interface{}) bool {
+			n, ok := numericValue(fields[field])
+			return ok && n >= start && n <= end
+		}, nil
+	default:
+		return nil, ErrBadArguments("filter '" + f.Op + "' cannot be matched against live events")
+	}
+}
+
+// termMatcher returns a Matcher of the events with a term of the field
+// accepted by match.
+func (f *Filter) termMatcher(fold ekanite.CaseFold, match func(term string) bool) Matcher {
+	return f.termsMatcher(fold, func(terms []string) bool {
+		for _, term := range terms {
+			if match(term) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// termsMatcher returns a Matcher of the events whose terms of the field, as
+// indexed with the fields of fold lower-cased, are accepted by match.
+func (f *Filter) termsMatcher(fold ekanite.CaseFold, match func(terms []string) bool) Matcher {
+	field := f.Field
+	keyword := ekanite.IsKeywordField(field)
+	return func(fields map[string]interface{}) bool {
+		v, ok := fields[field]
+		if !ok {
+			return false
+		}
+		s := fmt.Sprint(v)
+		if keyword {
+			return match([]string{fold.Fold(field, s)})
+		}
+		return match(wordPattern.FindAllString(strings.ToLower(s), -1))
+	}
+}
+
+// containsPhrase returns whether phrase appears, word after word, in terms.
+func containsPhrase(terms, phrase []string) bool {
+	if len(phrase) == 0 {
+		return false
+	}
+	for i := 0; i+len(phrase) <= len(terms); i++ {
+		found := true
+		for j, word := range phrase {
+			if terms[i+j] != word {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardToRegexp converts a wildcard pattern, where * matches any string and
+// ? any character, into a regular expression.
+func wildcardToRegexp(pattern string) string {
+	var sb strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return sb.String()
+}
+
+// timeValue returns the time held by a parsed field.
+func timeValue(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		t := ekanite.ParseTime(v)
+		return t, !t.IsZero()
+	}
+	return time.Time{}, false
+}
+
+// numericValue returns the number held by a parsed field.
+func numericValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		n, err := strconv.ParseFloat(v, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
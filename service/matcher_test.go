@@ -0,0 +1,98 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ekanite/ekanite"
+)
+
+func TestQuery_Matcher(t *testing.T) {
+	event := map[string]interface{}{
+		"host":      "web01",
+		"message":   "Failed password for root from 10.0.0.1",
+		"priority":  134,
+		"reception": time.Date(1982, 2, 5, 4, 43, 0, 0, time.UTC),
+	}
+
+	for _, tt := range []struct {
+		filters []Filter
+		match   bool
+	}{
+		{nil, true},
+		{[]Filter{{Field: "host", Op: OpTerm, Values: []string{"web01"}}}, true},
+		{[]Filter{{Field: "host", Op: OpTerm, Values: []string{"web02", "web01"}}}, true},
+		{[]Filter{{Field: "host", Op: OpTerm, Values: []string{"web"}}}, false},
+		{[]Filter{{Field: "message", Op: OpTerm, Values: []string{"password"}}}, true},
+		{[]Filter{{Field: "message", Op: OpPhrase, Values: []string{"password", "for", "root"}}}, true},
+		{[]Filter{{Field: "message", Op: OpPhrase, Values: []string{"root", "password"}}}, false},
+		{[]Filter{{Field: "host", Op: OpPrefix, Values: []string{"web"}}}, true},
+		{[]Filter{{Field: "host", Op: OpWildcard, Values: []string{"w?b*"}}}, true},
+		{[]Filter{{Field: "message", Op: OpRegexp, Values: []string{"pass.*"}}}, true},
+		{[]Filter{{Field: "message", Op: OpRegexp, Values: []string{"ass"}}}, false},
+		{[]Filter{{Field: "priority", Op: OpNumericRange, Values: []string{"100", "200"}}}, true},
+		{[]Filter{{Field: "priority", Op: OpNumericRange, Values: []string{"-Inf", "100"}}}, false},
+		{[]Filter{{Field: "reception", Op: OpDateRange, Values: []string{"1982-02-05T00:00:00Z", ""}}}, true},
+		{[]Filter{{Field: "reception", Op: OpDateRange, Values: []string{"1970-01-01T00:00:00Z", "1982-02-05T00:00:00Z"}}}, false},
+		{[]Filter{{Field: "app", Op: OpTerm, Values: []string{"sshd"}}}, false},
+		{[]Filter{{Field: "app", Op: OpTerm, Values: []string{""}}}, true},
+		{[]Filter{
+			{Field: "host", Op: OpTerm, Values: []string{"web01"}},
+			{Field: "message", Op: OpTerm, Values: []string{"accepted"}},
+		}, false},
+	} {
+		q := Query{Filters: tt.filters}
+		match, err := q.Matcher(nil)
+		if err != nil {
+			t.Fatalf("failed to compile %v: %s", tt.filters, err.Error())
+		}
+		if got := match(event); got != tt.match {
+			t.Errorf("%v matched %v, expected %v", tt.filters, got, tt.match)
+		}
+	}
+
+	q := Query{Filters: []Filter{{Field: "host", Op: OpTerm, Values: []string{"WEB01"}}}}
+	if match, err := q.Matcher(ekanite.ParseCaseFold("host")); err != nil || !match(event) {
+		t.Fatalf("case folded host not matched, %v", err)
+	}
+
+	q = Query{Filters: []Filter{{Op: OpQueryString, Field: "message", Values: []string{"+password"}}}}
+	if _, err := q.Matcher(nil); !IsBadArguments(err) {
+		t.Fatalf("query string filter compiled with %v, expected a bad arguments error", err)
+	}
+}
+
+func TestTail(t *testing.T) {
+	tail := NewTail()
+	q := Query{Filters: []Filter{{Field: "host", Op: OpTerm, Values: []string{"web01"}}}}
+	match, err := q.Matcher(nil)
+	if err != nil {
+		t.Fatalf("failed to compile query: %s", err.Error())
+	}
+	filtered, cancelFiltered := tail.Subscribe(match)
+	all, cancelAll := tail.Subscribe(nil)
+	defer cancelAll()
+
+	tail.Publish(map[string]interface{}{"host": "web01"})
+	tail.Publish(map[string]interface{}{"host": "web02"})
+
+	for _, expected := range []string{"web01"} {
+		if event := <-filtered; event["host"] != expected {
+			t.Fatalf("filtered subscriber got %v, expected host %s", event, expected)
+		}
+	}
+	for _, expected := range []string{"web01", "web02"} {
+		if event := <-all; event["host"] != expected {
+			t.Fatalf("subscriber got %v, expected host %s", event, expected)
+		}
+	}
+
+	cancelFiltered()
+	cancelFiltered()
+	tail.Publish(map[string]interface{}{"host": "web01"})
+	select {
+	case event := <-filtered:
+		t.Fatalf("cancelled subscriber got %v", event)
+	default:
+	}
+}
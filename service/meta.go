@@ -34,6 +34,7 @@ const (
 var (
 	ErrRecordNotFound = errors.New("record isnot found")
 	ErrNameIsExists   = errors.New("query name is exists")
+	ErrNotOwner       = errors.New("query is owned by another")
 )
 
 // OpList 一些过滤表达式的匹配操作
@@ -67,8 +68,15 @@ func ErrBadArguments(msg string) error {
 	return errBadArguments{msg: msg}
 }
 
-// ToQuery 转换为 query.Query
-func (f *Filter) ToQuery() (query.Query, error) {
+// IsBadArguments reports whether err, or any error it wraps, was created by
+// ErrBadArguments.
+func IsBadArguments(err error) bool {
+	var e errBadArguments
+	return errors.As(err, &e)
+}
+
+// ToQuery 转换为 query.Query, fold 中的关键字段的值转为小写
+func (f *Filter) ToQuery(fold ekanite.CaseFold) (query.Query, error) {
 	switch f.Op {
 	case OpPhrase:
 		return bleve.NewPhraseQuery(f.Values, f.Field), nil
@@ -77,7 +85,7 @@ func (f *Filter) ToQuery() (query.Query, error) {
 			return nil, ErrBadArguments("prefixQuery is empty")
 		}
 
-		q := bleve.NewPrefixQuery(f.Values[0])
+		q := bleve.NewPrefixQuery(fold.Fold(f.Field, f.Values[0]))
 		q.SetField(f.Field)
 		return q, nil
 	case OpRegexp:
@@ -98,7 +106,7 @@ func (f *Filter) ToQuery() (query.Query, error) {
 				return nil, errors.New("'" + f.Field + "' has empty value")
 			}
 
-			q := bleve.NewTermQuery(v)
+			q := bleve.NewTermQuery(fold.Fold(f.Field, v))
 			q.SetField(f.Field)
 			queries = append(queries, q)
 		}
@@ -107,7 +115,7 @@ func (f *Filter) ToQuery() (query.Query, error) {
 		if f.Values[0] == "" {
 			return nil, ErrBadArguments("wildcardQuery is empty")
 		}
-		q := bleve.NewWildcardQuery(f.Values[0])
+		q := bleve.NewWildcardQuery(fold.Fold(f.Field, f.Values[0]))
 		q.SetField(f.Field)
 		return q, nil
 	case OpDateRange:
@@ -185,12 +193,26 @@ type Query struct {
 	ID                string                     `json:"id,omitempty"`
 	Name              string                     `json:"name"`
 	Description       string                     `json:"description,omitempty"`
+	Owner             string                     `json:"owner,omitempty"`  // API key or tenant, empty for a global query.
+	Shared            bool                       `json:"shared,omitempty"` // Whether the query is visible to other owners.
 	Filters           []Filter                   `json:"filters,omitempty"`
 	ContinuousQueries map[string]ContinuousQuery `json:"continuous_queries,omitempty"`
 }
 
-// ToQueries 转换为 query.Query 列表
-func (q *Query) ToQueries() ([]query.Query, error) {
+// VisibleTo returns whether owner may read the query and its continuous
+// queries: it is global, shared or its own. The empty owner sees all queries.
+func (q *Query) VisibleTo(owner string) bool {
+	return owner == "" || q.Owner == "" || q.Owner == owner || q.Shared
+}
+
+// WritableBy returns whether owner may change or delete the query: it is
+// global or its own, the queries shared by other owners being read-only.
+func (q *Query) WritableBy(owner string) bool {
+	return owner == "" || q.Owner == "" || q.Owner == owner
+}
+
+// ToQueries 转换为 query.Query 列表, fold 中的关键字段的值转为小写
+func (q *Query) ToQueries(fold ekanite.CaseFold) ([]query.Query, error) {
 	var queries = make([]query.Query, 0, len(q.Filters))
 	for _, f := range q.Filters {
 		if len(f.Field) == 0 {
@@ -207,7 +229,7 @@ func (q *Query) ToQueries() ([]query.Query, error) {
 			continue
 		}
 
-		query, err := f.ToQuery()
+		query, err := f.ToQuery(fold)
 		if err != nil {
 			return nil, err
 		}
@@ -223,10 +245,21 @@ func NewMetaStore(dataPath string) *MetaStore {
 
 // MetaStore 对象
 type MetaStore struct {
+	// WebhookHosts are the hosts which subscriptions may post to although
+	// they are loopback or private addresses, such as an internal relay.
+	WebhookHosts []string
+
 	dataPath    string
 	backupCount int
 	mu          sync.RWMutex
 	queries     map[string]Query
+
+	subscriptions map[string]Subscription
+	panels        map[string]Panel
+
+	watchMu   sync.Mutex
+	watchers  map[int]func(QueryChange)
+	nextWatch int
 }
 
 func (h *MetaStore) Load() error {
@@ -237,13 +270,21 @@ func (h *MetaStore) Load() error {
 		if !os.IsNotExist(err) {
 			return err
 		}
-		return nil
+		return h.loadDefinitions()
 	}
 
 	h.mu.Lock()
 	h.queries = queries
 	h.mu.Unlock()
-	return nil
+	return h.loadDefinitions()
+}
+
+// loadDefinitions loads the definitions kept besides the queries.
+func (h *MetaStore) loadDefinitions() error {
+	if err := h.loadSubscriptions(); err != nil {
+		return err
+	}
+	return h.loadPanels()
 }
 
 func (h *MetaStore) save() error {
@@ -300,6 +341,11 @@ func (h *MetaStore) ForEach(cb func(id string, data Query)) {
 }
 
 func (h *MetaStore) ListQueries() []Query {
+	return h.ListQueriesAs("")
+}
+
+// ListQueriesAs 列出 owner 可见的查询
+func (h *MetaStore) ListQueriesAs(owner string) []Query {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
@@ -309,6 +355,9 @@ func (h *MetaStore) ListQueries() []Query {
 
 	var list []Query
 	for k, v := range h.queries {
+		if !v.VisibleTo(owner) {
+			continue
+		}
 		list = append(list, v)
 		vv := &list[len(list)-1]
 		vv.ID = k
@@ -317,6 +366,11 @@ func (h *MetaStore) ListQueries() []Query {
 }
 
 func (h *MetaStore) ListQueryIDs() ([]Query, error) {
+	return h.ListQueryIDsAs("")
+}
+
+// ListQueryIDsAs 列出 owner 可见的查询, 不含过滤器和持续查询
+func (h *MetaStore) ListQueryIDsAs(owner string) ([]Query, error) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
@@ -326,6 +380,9 @@ func (h *MetaStore) ListQueryIDs() ([]Query, error) {
 
 	var list []Query
 	for k, v := range h.queries {
+		if !v.VisibleTo(owner) {
+			continue
+		}
 		list = append(list, v)
 		vv := &list[len(list)-1]
 
@@ -337,6 +394,11 @@ func (h *MetaStore) ListQueryIDs() ([]Query, error) {
 }
 
 func (h *MetaStore) ReadQuery(id string) (Query, error) {
+	return h.ReadQueryAs("", id)
+}
+
+// ReadQueryAs 读取 owner 可见的查询, 不可见的查询视为不存在
+func (h *MetaStore) ReadQueryAs(owner, id string) (Query, error) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
@@ -345,65 +407,89 @@ func (h *MetaStore) ReadQuery(id string) (Query, error) {
 	}
 
 	q, ok := h.queries[id]
-	if !ok {
+	if !ok || !q.VisibleTo(owner) {
 		return Query{}, ErrRecordNotFound
 	}
 	q.ID = id
 	return q, nil
 }
 
-func (h *MetaStore) CreateQuery(q Query) (string, error) {
+func (h *MetaStore) CreateQuery(q Query) (id string, err error) {
+	var changed bool
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	defer func() { h.unlockNotify(id, changed) }()
 
 	if h.queries == nil {
 		h.queries = map[string]Query{}
 	}
 
+	// names are unique to each owner
 	for _, v := range h.queries {
-		if v.Name == q.Name {
-			return "", errors.New("query name is exists")
+		if v.Name == q.Name && v.Owner == q.Owner {
+			return "", ErrNameIsExists
 		}
 	}
 
-	id := GenerateID()
+	id = GenerateID()
 	h.queries[id] = q
+	changed = true
 	return id, h.save()
 }
 
 func (h *MetaStore) DeleteQuery(id string) error {
+	return h.DeleteQueryAs("", id)
+}
+
+// DeleteQueryAs 删除 owner 可修改的查询
+func (h *MetaStore) DeleteQueryAs(owner, id string) error {
+	var changed bool
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	defer func() { h.unlockNotify(id, changed) }()
 	if len(h.queries) == 0 {
 		return nil
 	}
-	if _, ok := h.queries[id]; ok {
+	if old, ok := h.queries[id]; ok && old.VisibleTo(owner) {
+		if !old.WritableBy(owner) {
+			return ErrNotOwner
+		}
 		delete(h.queries, id)
+		changed = true
 		return h.save()
 	}
 	return nil
 }
 
 func (h *MetaStore) UpdateQuery(id string, q Query) error {
+	return h.UpdateQueryAs("", id, q)
+}
+
+// UpdateQueryAs 修改 owner 可修改的查询, 查询的 owner 保持不变
+func (h *MetaStore) UpdateQueryAs(owner, id string, q Query) error {
+	var changed bool
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	defer func() { h.unlockNotify(id, changed) }()
 	if len(h.queries) == 0 {
 		return ErrRecordNotFound
 	}
 
-	_, ok := h.queries[id]
-	if !ok {
+	old, ok := h.queries[id]
+	if !ok || !old.VisibleTo(owner) {
 		return ErrRecordNotFound
 	}
+	if !old.WritableBy(owner) {
+		return ErrNotOwner
+	}
+	q.Owner = old.Owner
 
 	for key, v := range h.queries {
-		if v.Name == q.Name && id != key {
+		if v.Name == q.Name && v.Owner == q.Owner && id != key {
 			return ErrNameIsExists
 		}
 	}
 
 	//old = q
 	h.queries[id] = q
+	changed = true
 	return h.save()
 }
 
@@ -455,8 +541,9 @@ func (h *MetaStore) ReadCQ(query, id string) (ContinuousQuery, error) {
 }
 
 func (h *MetaStore) CreateCQ(query string, cq ContinuousQuery) (string, error) {
+	var changed bool
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	defer func() { h.unlockNotify(query, changed) }()
 
 	if len(h.queries) == 0 {
 		return "", ErrRecordNotFound
@@ -473,12 +560,14 @@ func (h *MetaStore) CreateCQ(query string, cq ContinuousQuery) (string, error) {
 	id := GenerateID()
 	q.ContinuousQueries[id] = cq
 	h.queries[id] = q
+	changed = true
 	return id, h.save()
 }
 
 func (h *MetaStore) DeleteCQ(query, id string) error {
+	var changed bool
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	defer func() { h.unlockNotify(query, changed) }()
 
 	if len(h.queries) == 0 {
 		return ErrRecordNotFound
@@ -493,12 +582,14 @@ func (h *MetaStore) DeleteCQ(query, id string) error {
 	}
 	delete(q.ContinuousQueries, id)
 	h.queries[query] = q
+	changed = true
 	return h.save()
 }
 
 func (h *MetaStore) UpdateCQ(query, id string, cq ContinuousQuery) error {
+	var changed bool
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	defer func() { h.unlockNotify(query, changed) }()
 
 	if len(h.queries) == 0 {
 		return ErrRecordNotFound
@@ -517,6 +608,7 @@ func (h *MetaStore) UpdateCQ(query, id string, cq ContinuousQuery) error {
 	}
 	q.ContinuousQueries[id] = cq
 	h.queries[query] = q
+	changed = true
 	return h.save()
 }
 
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMetaStore_Owners(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "ekanite-meta-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dataDir)
+
+	store := NewMetaStore(dataDir)
+	create := func(q Query) string {
+		id, err := store.CreateQuery(q)
+		if err != nil {
+			t.Fatalf("failed to create query %s of %q: %s", q.Name, q.Owner, err.Error())
+		}
+		return id
+	}
+	global := create(Query{Name: "errors"})
+	private := create(Query{Name: "errors", Owner: "ops"})
+	shared := create(Query{Name: "auth", Owner: "ops", Shared: true})
+	create(Query{Name: "errors", Owner: "web"})
+	if _, err := store.CreateQuery(Query{Name: "auth", Owner: "ops"}); err != ErrNameIsExists {
+		t.Fatalf("created query with the name of another of its owner, got %v", err)
+	}
+
+	for owner, expected := range map[string]int{"": 4, "ops": 3, "web": 3, "db": 2} {
+		if n := len(store.ListQueriesAs(owner)); n != expected {
+			t.Errorf("%q sees %d queries, expected %d", owner, n, expected)
+		}
+	}
+
+	if _, err := store.ReadQueryAs("web", private); err != ErrRecordNotFound {
+		t.Errorf("web read private query of ops, got %v", err)
+	}
+	if err := store.DeleteQueryAs("web", private); err != nil {
+		t.Errorf("deleting invisible query failed with %v", err)
+	}
+	if _, err := store.ReadQuery(private); err != nil {
+		t.Fatalf("web deleted private query of ops: %v", err)
+	}
+	if err := store.UpdateQueryAs("web", shared, Query{Name: "auth"}); err != ErrNotOwner {
+		t.Errorf("web updated shared query of ops, got %v", err)
+	}
+	if err := store.DeleteQueryAs("web", shared); err != ErrNotOwner {
+		t.Errorf("web deleted shared query of ops, got %v", err)
+	}
+	if err := store.UpdateQueryAs("web", global, Query{Name: "all errors"}); err != nil {
+		t.Errorf("web failed to update global query: %v", err)
+	}
+	if err := store.UpdateQueryAs("ops", shared, Query{Name: "logins"}); err != nil {
+		t.Fatalf("ops failed to update its query: %v", err)
+	}
+	if q, _ := store.ReadQueryAs("ops", shared); q.Owner != "ops" || q.Shared {
+		t.Errorf("update changed the owner or left the query shared: %+v", q)
+	}
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ekanite/ekanite"
+)
+
+// Panel is a visualization of a dashboard: the documents of a saved filter,
+// aggregated as by the group_by parameter of the count endpoints, over the
+// last TimeRange, with rendering hints the server keeps for the frontends.
+// Panels are visible to owners and shared as saved filters are.
+type Panel struct {
+	ID        string                 `json:"id,omitempty"`
+	Dashboard string                 `json:"dashboard"`
+	Title     string                 `json:"title"`
+	Position  int                    `json:"position"`         // Order of the panel in its dashboard.
+	Owner     string                 `json:"owner,omitempty"`  // API key or tenant, empty for a global panel.
+	Shared    bool                   `json:"shared,omitempty"` // Whether the panel is visible to other owners.
+	Query     string                 `json:"query,omitempty"`  // ID of the saved filter matched, empty for all documents.
+	GroupBy   string                 `json:"group_by,omitempty"`
+	Size      int                    `json:"size,omitempty"`       // Terms of a group by time and field.
+	TimeRange string                 `json:"time_range,omitempty"` // Duration before now shown, such as 24h.
+	Render    map[string]interface{} `json:"render,omitempty"`     // Chart type, colors, units and the like.
+}
+
+// Validate checks the dashboard, aggregation and time range of the panel.
+func (p *Panel) Validate() error {
+	if strings.TrimSpace(p.Dashboard) == "" {
+		return ErrBadArguments("dashboard is missing")
+	}
+	if p.TimeRange != "" {
+		if d, err := time.ParseDuration(p.TimeRange); err != nil || d <= 0 {
+			return ErrBadArguments("time_range '" + p.TimeRange + "' is invalid")
+		}
+	}
+	if p.Size < 0 {
+		return ErrBadArguments("size must not be negative")
+	}
+
+	ss := strings.Fields(p.GroupBy)
+	switch {
+	case len(ss) == 0:
+	case len(ss) == 1 && ss[0] != ekanite.ReceptionField:
+	case (len(ss) == 2 || len(ss) == 3) && ss[0] == ekanite.ReceptionField:
+		if d, err := time.ParseDuration(ss[1]); err != nil || d <= 0 {
+			return ErrBadArguments("group_by '" + p.GroupBy + "' has an invalid interval")
+		}
+		if len(ss) == 3 && ss[2] == ekanite.ReceptionField {
+			return ErrBadArguments("group_by '" + p.GroupBy + "' is invalid")
+		}
+	default:
+		return ErrBadArguments("group_by '" + p.GroupBy + "' is invalid")
+	}
+	return nil
+}
+
+// VisibleTo returns whether owner may read the panel.
+func (p *Panel) VisibleTo(owner string) bool {
+	return owner == "" || p.Owner == "" || p.Owner == owner || p.Shared
+}
+
+// WritableBy returns whether owner may change or delete the panel.
+func (p *Panel) WritableBy(owner string) bool {
+	return owner == "" || p.Owner == "" || p.Owner == owner
+}
+
+func (h *MetaStore) loadPanels() error {
+	var panels map[string]Panel
+	filename := filepath.Join(h.dataPath, "panels.json")
+	if err := readFromFile(filename, &panels); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	h.mu.Lock()
+	h.panels = panels
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *MetaStore) savePanels() error {
+	filename := filepath.Join(h.dataPath, "panels.json")
+	if err := os.MkdirAll(filepath.Dir(filename), 0666); err != nil {
+		if !os.IsExist(err) {
+			return err
+		}
+	}
+	if err := writeToFile(filename+".tmp", &h.panels); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// ListPanelsAs lists the panels owner sees, of the dashboard unless it is
+// empty, ordered by dashboard and position.
+func (h *MetaStore) ListPanelsAs(owner, dashboard string) []Panel {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	list := []Panel{}
+	for k, v := range h.panels {
+		if !v.VisibleTo(owner) || (dashboard != "" && v.Dashboard != dashboard) {
+			continue
+		}
+		v.ID = k
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Dashboard != list[j].Dashboard {
+			return list[i].Dashboard < list[j].Dashboard
+		}
+		if list[i].Position != list[j].Position {
+			return list[i].Position < list[j].Position
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// ReadPanelAs reads a panel owner sees, the others being taken as missing.
+func (h *MetaStore) ReadPanelAs(owner, id string) (Panel, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	p, ok := h.panels[id]
+	if !ok || !p.VisibleTo(owner) {
+		return Panel{}, ErrRecordNotFound
+	}
+	p.ID = id
+	return p, nil
+}
+
+// CreatePanel saves a panel of a saved filter its owner sees.
+func (h *MetaStore) CreatePanel(p Panel) (string, error) {
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+	if p.Query != "" {
+		if _, err := h.ReadQueryAs(p.Owner, p.Query); err != nil {
+			return "", err
+		}
+	}
+	p.ID = ""
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.panels == nil {
+		h.panels = map[string]Panel{}
+	}
+	id := GenerateID()
+	h.panels[id] = p
+	return id, h.savePanels()
+}
+
+// UpdatePanelAs replaces a panel owner may change, its owner staying the same.
+func (h *MetaStore) UpdatePanelAs(owner, id string, p Panel) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	old, ok := h.panels[id]
+	if !ok || !old.VisibleTo(owner) {
+		return ErrRecordNotFound
+	}
+	if !old.WritableBy(owner) {
+		return ErrNotOwner
+	}
+	p.ID = ""
+	p.Owner = old.Owner
+	if p.Query != "" {
+		if q, ok := h.queries[p.Query]; !ok || !q.VisibleTo(p.Owner) {
+			return ErrRecordNotFound
+		}
+	}
+	h.panels[id] = p
+	return h.savePanels()
+}
+
+// DeletePanelAs deletes a panel owner may change.
+func (h *MetaStore) DeletePanelAs(owner, id string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	old, ok := h.panels[id]
+	if !ok || !old.VisibleTo(owner) {
+		return nil
+	}
+	if !old.WritableBy(owner) {
+		return ErrNotOwner
+	}
+	delete(h.panels, id)
+	return h.savePanels()
+}
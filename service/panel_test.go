@@ -0,0 +1,90 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPanel_Validate(t *testing.T) {
+	for _, tt := range []struct {
+		panel Panel
+		valid bool
+	}{
+		{Panel{Dashboard: "ops"}, true},
+		{Panel{Dashboard: "ops", GroupBy: "host", TimeRange: "24h"}, true},
+		{Panel{Dashboard: "ops", GroupBy: "reception 1h"}, true},
+		{Panel{Dashboard: "ops", GroupBy: "reception 1h app", Size: 5}, true},
+		{Panel{}, false},
+		{Panel{Dashboard: "ops", GroupBy: "reception"}, false},
+		{Panel{Dashboard: "ops", GroupBy: "reception hourly"}, false},
+		{Panel{Dashboard: "ops", GroupBy: "reception 1h reception"}, false},
+		{Panel{Dashboard: "ops", GroupBy: "host app"}, false},
+		{Panel{Dashboard: "ops", TimeRange: "-1h"}, false},
+		{Panel{Dashboard: "ops", Size: -1}, false},
+	} {
+		if err := tt.panel.Validate(); (err == nil) != tt.valid {
+			t.Errorf("panel %+v validated with %v", tt.panel, err)
+		}
+	}
+}
+
+func TestMetaStore_Panels(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "ekanite-meta-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dataDir)
+
+	store := NewMetaStore(dataDir)
+	private, err := store.CreateQuery(Query{Name: "errors", Owner: "ops"})
+	if err != nil {
+		t.Fatalf("failed to create query: %s", err.Error())
+	}
+	if _, err := store.CreatePanel(Panel{Dashboard: "web", Owner: "web", Query: private}); err != ErrRecordNotFound {
+		t.Fatalf("web created a panel of a private query of ops, got %v", err)
+	}
+
+	create := func(p Panel) string {
+		id, err := store.CreatePanel(p)
+		if err != nil {
+			t.Fatalf("failed to create panel %s: %s", p.Title, err.Error())
+		}
+		return id
+	}
+	second := create(Panel{Dashboard: "ops", Title: "by host", Position: 2, Owner: "ops", Query: private, GroupBy: "host"})
+	first := create(Panel{Dashboard: "ops", Title: "rate", Position: 1, Owner: "ops", Shared: true, GroupBy: "reception 1h"})
+	create(Panel{Dashboard: "web", Title: "rate", Owner: "web"})
+
+	panels := store.ListPanelsAs("ops", "ops")
+	if len(panels) != 2 || panels[0].ID != first || panels[1].ID != second {
+		t.Fatalf("ops dashboard has panels %+v", panels)
+	}
+	if panels := store.ListPanelsAs("web", "ops"); len(panels) != 1 || panels[0].ID != first {
+		t.Fatalf("web sees ops panels %+v", panels)
+	}
+	if err := store.UpdatePanelAs("web", first, Panel{Dashboard: "ops"}); err != ErrNotOwner {
+		t.Errorf("web updated shared panel of ops, got %v", err)
+	}
+	if err := store.UpdatePanelAs("ops", first, Panel{Dashboard: "ops", Title: "events"}); err != nil {
+		t.Fatalf("ops failed to update its panel: %v", err)
+	}
+	if p, _ := store.ReadPanelAs("ops", first); p.Title != "events" || p.Owner != "ops" {
+		t.Errorf("update gave panel %+v", p)
+	}
+
+	// Panels are kept across loads.
+	store = NewMetaStore(dataDir)
+	if err := store.Load(); err != nil {
+		t.Fatalf("failed to load meta store: %s", err.Error())
+	}
+	if n := len(store.ListPanelsAs("", "")); n != 3 {
+		t.Fatalf("loaded %d panels, expected 3", n)
+	}
+	if err := store.DeletePanelAs("ops", second); err != nil {
+		t.Fatalf("failed to delete panel: %s", err.Error())
+	}
+	if _, err := store.ReadPanelAs("", second); err != ErrRecordNotFound {
+		t.Errorf("deleted panel read, got %v", err)
+	}
+}
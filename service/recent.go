@@ -0,0 +1,107 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// Recent holds the events published during the last Window, up to MaxEvents of
+// them, so that what happened just now can be looked at without searching the
+// indexes.
+type Recent struct {
+	Window    time.Duration
+	MaxEvents int
+
+	mu     sync.RWMutex
+	events []recentEvent // Ring of the events, oldest at start.
+	start  int
+	n      int
+}
+
+type recentEvent struct {
+	at     time.Time
+	fields map[string]interface{}
+}
+
+// NewRecent returns a Recent holding the events of the last window, up to max
+// of them.
+func NewRecent(window time.Duration, max int) *Recent {
+	return &Recent{Window: window, MaxEvents: max, events: make([]recentEvent, max)}
+}
+
+// Add appends the fields of an event, which must not be modified afterwards,
+// dropping the oldest event when full.
+func (r *Recent) Add(fields map[string]interface{}) {
+	r.AddAt(time.Now(), fields)
+}
+
+// AddAt appends the fields of an event published at the given time.
+func (r *Recent) AddAt(at time.Time, fields map[string]interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.events) == 0 {
+		return
+	}
+	r.expire(at)
+	if r.n == len(r.events) {
+		r.events[r.start] = recentEvent{}
+		r.start = (r.start + 1) % len(r.events)
+		r.n--
+	}
+	r.events[(r.start+r.n)%len(r.events)] = recentEvent{at: at, fields: fields}
+	r.n++
+}
+
+// expire drops the events older than the window.
+func (r *Recent) expire(now time.Time) {
+	cutoff := now.Add(-r.Window)
+	for r.n > 0 && r.events[r.start].at.Before(cutoff) {
+		r.events[r.start] = recentEvent{}
+		r.start = (r.start + 1) % len(r.events)
+		r.n--
+	}
+}
+
+// Len returns the number of events held.
+func (r *Recent) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.n
+}
+
+// Events returns copies of the events published since the given time which
+// match, all of them if match is nil, oldest first. Only the latest limit
+// events are returned if limit is positive.
+func (r *Recent) Events(since time.Time, match Matcher, limit int) []map[string]interface{} {
+	cutoff := time.Now().Add(-r.Window)
+	if since.Before(cutoff) {
+		since = cutoff
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var events []map[string]interface{}
+	for i := r.n - 1; i >= 0; i-- {
+		e := r.events[(r.start+i)%len(r.events)]
+		if e.at.Before(since) || (limit > 0 && len(events) >= limit) {
+			break
+		}
+		if match != nil && !match(e.fields) {
+			continue
+		}
+		events = append(events, copyFields(e.fields))
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events
+}
+
+// copyFields returns a shallow copy of the fields of an event.
+func copyFields(fields map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecent(t *testing.T) {
+	r := NewRecent(time.Minute, 3)
+	now := time.Now()
+	r.AddAt(now.Add(-2*time.Minute), map[string]interface{}{"host": "web00"})
+	for _, host := range []string{"web01", "web02", "web03", "web04"} {
+		r.AddAt(now, map[string]interface{}{"host": host})
+	}
+	if r.Len() != 3 {
+		t.Fatalf("recent holds %d events, expected 3", r.Len())
+	}
+
+	hosts := func(events []map[string]interface{}) []interface{} {
+		var hosts []interface{}
+		for _, e := range events {
+			hosts = append(hosts, e["host"])
+		}
+		return hosts
+	}
+	if got := hosts(r.Events(time.Time{}, nil, 0)); len(got) != 3 || got[0] != "web02" || got[2] != "web04" {
+		t.Fatalf("recent events are %v, expected [web02 web03 web04]", got)
+	}
+	if got := hosts(r.Events(time.Time{}, nil, 2)); len(got) != 2 || got[0] != "web03" || got[1] != "web04" {
+		t.Fatalf("latest recent events are %v, expected [web03 web04]", got)
+	}
+	match := func(fields map[string]interface{}) bool { return fields["host"] == "web03" }
+	if got := hosts(r.Events(time.Time{}, match, 0)); len(got) != 1 || got[0] != "web03" {
+		t.Fatalf("matching recent events are %v, expected [web03]", got)
+	}
+	if got := r.Events(now.Add(time.Second), nil, 0); len(got) != 0 {
+		t.Fatalf("recent events in the future are %v, expected none", got)
+	}
+
+	r.AddAt(now.Add(2*time.Minute), map[string]interface{}{"host": "web05"})
+	if r.Len() != 1 {
+		t.Fatalf("recent holds %d events after expiry, expected 1", r.Len())
+	}
+}
+
+func TestTail_SubscribeSince(t *testing.T) {
+	tail := NewTail()
+	tail.Recent = NewRecent(time.Minute, 10)
+	tail.Publish(map[string]interface{}{"host": "web01"})
+
+	recent, events, cancel := tail.SubscribeSince(nil, time.Now().Add(-time.Minute), 0)
+	defer cancel()
+	if len(recent) != 1 || recent[0]["host"] != "web01" {
+		t.Fatalf("replayed %v, expected web01", recent)
+	}
+	tail.Publish(map[string]interface{}{"host": "web02"})
+	if event := <-events; event["host"] != "web02" {
+		t.Fatalf("subscriber got %v, expected web02", event)
+	}
+}
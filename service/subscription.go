@@ -0,0 +1,237 @@
+package service
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MinSubscriptionInterval is the shortest interval at which a subscription
+// may be delivered.
+const MinSubscriptionInterval = 10 * time.Second
+
+// Subscription pushes the documents newly matching a saved filter to a
+// webhook, at each interval. Only the documents received after the watermark
+// are pushed, the watermark moving on with each successful delivery.
+type Subscription struct {
+	ID        string    `json:"id,omitempty"`
+	Query     string    `json:"query,omitempty"` // ID of the saved filter matched, empty for all documents.
+	Owner     string    `json:"owner,omitempty"` // API key or tenant, empty for a global subscription.
+	Role      string    `json:"role,omitempty"`  // Role of the creator, whose field masks apply to the deliveries.
+	Fields    []string  `json:"fields,omitempty"`
+	Interval  string    `json:"interval"`
+	Webhook   string    `json:"webhook"`
+	Watermark time.Time `json:"watermark"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Validate checks the interval and webhook of the subscription. The webhook
+// may not be a loopback, private or link-local address, such as the metadata
+// service of a cloud host, unless its host is allowed.
+func (s *Subscription) Validate(allowed []string) error {
+	interval, err := time.ParseDuration(s.Interval)
+	if err != nil {
+		return ErrBadArguments("interval '" + s.Interval + "' is invalid")
+	}
+	if interval < MinSubscriptionInterval {
+		return ErrBadArguments("interval must be at least " + MinSubscriptionInterval.String())
+	}
+	u, err := url.Parse(s.Webhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrBadArguments("webhook '" + s.Webhook + "' is not a http or https URL")
+	}
+	host := u.Hostname()
+	if WebhookAllowed(allowed, host) {
+		return nil
+	}
+	if strings.EqualFold(host, "localhost") || PrivateAddress(net.ParseIP(host)) {
+		return ErrBadArguments("webhook '" + s.Webhook + "' is a loopback, private or link-local address")
+	}
+	return nil
+}
+
+// privateNetworks are the networks of the private addresses, besides the
+// loopback and link-local ones.
+var privateNetworks = []*net.IPNet{
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("fc00::/7"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// PrivateAddress returns whether ip is an unspecified, loopback, private or
+// link-local address, which webhooks are not posted to. It is false for nil.
+func PrivateAddress(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return true
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookAllowed returns whether host is in the allowed hosts, which webhooks
+// are posted to whatever their address.
+func WebhookAllowed(allowed []string, host string) bool {
+	for _, a := range allowed {
+		if strings.EqualFold(a, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// VisibleTo returns whether owner may read and delete the subscription: it is
+// global or its own. The empty owner sees all subscriptions.
+func (s *Subscription) VisibleTo(owner string) bool {
+	return owner == "" || s.Owner == "" || s.Owner == owner
+}
+
+// IntervalDuration returns the interval of the subscription.
+func (s *Subscription) IntervalDuration() time.Duration {
+	interval, _ := time.ParseDuration(s.Interval)
+	return interval
+}
+
+func (h *MetaStore) loadSubscriptions() error {
+	var subscriptions map[string]Subscription
+	filename := filepath.Join(h.dataPath, "subscriptions.json")
+	if err := readFromFile(filename, &subscriptions); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	h.mu.Lock()
+	h.subscriptions = subscriptions
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *MetaStore) saveSubscriptions() error {
+	filename := filepath.Join(h.dataPath, "subscriptions.json")
+	if err := os.MkdirAll(filepath.Dir(filename), 0666); err != nil {
+		if !os.IsExist(err) {
+			return err
+		}
+	}
+	if err := writeToFile(filename+".tmp", &h.subscriptions); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+func (h *MetaStore) ListSubscriptions() []Subscription {
+	return h.ListSubscriptionsAs("")
+}
+
+// ListSubscriptionsAs 列出 owner 可见的订阅
+func (h *MetaStore) ListSubscriptionsAs(owner string) []Subscription {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var list []Subscription
+	for k, v := range h.subscriptions {
+		if !v.VisibleTo(owner) {
+			continue
+		}
+		v.ID = k
+		list = append(list, v)
+	}
+	return list
+}
+
+func (h *MetaStore) ReadSubscription(id string) (Subscription, error) {
+	return h.ReadSubscriptionAs("", id)
+}
+
+// ReadSubscriptionAs 读取 owner 可见的订阅, 不可见的订阅视为不存在
+func (h *MetaStore) ReadSubscriptionAs(owner, id string) (Subscription, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	s, ok := h.subscriptions[id]
+	if !ok || !s.VisibleTo(owner) {
+		return Subscription{}, ErrRecordNotFound
+	}
+	s.ID = id
+	return s, nil
+}
+
+// CreateSubscription saves a subscription, which starts with the documents
+// received from now on unless it has a watermark.
+func (h *MetaStore) CreateSubscription(s Subscription) (string, error) {
+	if err := s.Validate(h.WebhookHosts); err != nil {
+		return "", err
+	}
+	// subscribers only get the documents of the filters they can read
+	if s.Query != "" {
+		if _, err := h.ReadQueryAs(s.Owner, s.Query); err != nil {
+			return "", err
+		}
+	}
+	if s.Watermark.IsZero() {
+		s.Watermark = time.Now()
+	}
+	s.ID = ""
+	s.LastError = ""
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscriptions == nil {
+		h.subscriptions = map[string]Subscription{}
+	}
+	id := GenerateID()
+	h.subscriptions[id] = s
+	return id, h.saveSubscriptions()
+}
+
+func (h *MetaStore) DeleteSubscription(id string) error {
+	return h.DeleteSubscriptionAs("", id)
+}
+
+// DeleteSubscriptionAs 删除 owner 可见的订阅
+func (h *MetaStore) DeleteSubscriptionAs(owner, id string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.subscriptions[id]; !ok || !s.VisibleTo(owner) {
+		return nil
+	}
+	delete(h.subscriptions, id)
+	return h.saveSubscriptions()
+}
+
+// UpdateWatermark records the outcome of a delivery of the subscription, the
+// watermark only moving forward.
+func (h *MetaStore) UpdateWatermark(id string, watermark time.Time, lastError string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.subscriptions[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	if watermark.After(s.Watermark) {
+		s.Watermark = watermark
+	}
+	s.LastError = lastError
+	h.subscriptions[id] = s
+	return h.saveSubscriptions()
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSubscription_Validate(t *testing.T) {
+	for _, sub := range []Subscription{
+		{Interval: "1m", Webhook: "ftp://example.com/hook"},
+		{Interval: "1s", Webhook: "http://example.com/hook"},
+		{Interval: "often", Webhook: "http://example.com/hook"},
+		{Interval: "1m", Webhook: "http://localhost:8080/hook"},
+		{Interval: "1m", Webhook: "http://127.0.0.1/hook"},
+		{Interval: "1m", Webhook: "http://10.1.2.3/hook"},
+		{Interval: "1m", Webhook: "http://[::1]/hook"},
+		{Interval: "1m", Webhook: "http://169.254.169.254/latest/meta-data/"},
+		{Interval: "1m", Webhook: "http://0.0.0.0/hook"},
+	} {
+		if err := sub.Validate(nil); !IsBadArguments(err) {
+			t.Errorf("subscription %+v validated with %v, expected a bad arguments error", sub, err)
+		}
+	}
+
+	for _, sub := range []Subscription{
+		{Interval: "1m", Webhook: "https://example.com/hook"},
+		{Interval: "1m", Webhook: "http://8.8.8.8/hook"},
+		{Interval: "1m", Webhook: "http://10.1.2.3:8080/hook"},
+	} {
+		if err := sub.Validate([]string{"10.1.2.3"}); err != nil {
+			t.Errorf("subscription %+v failed to validate: %s", sub, err)
+		}
+	}
+}
+
+func TestMetaStore_Subscriptions(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "ekanite-meta-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dataDir)
+
+	store := NewMetaStore(dataDir)
+	if _, err := store.CreateSubscription(Subscription{Query: "missing", Interval: "1m", Webhook: "http://example.com/hook"}); err != ErrRecordNotFound {
+		t.Fatalf("subscription to a missing filter returned %v, expected ErrRecordNotFound", err)
+	}
+	id, err := store.CreateSubscription(Subscription{Interval: "1m", Webhook: "http://example.com/hook"})
+	if err != nil {
+		t.Fatalf("failed to create subscription: %s", err.Error())
+	}
+	sub, err := store.ReadSubscription(id)
+	if err != nil || sub.Watermark.IsZero() {
+		t.Fatalf("read subscription %+v, %v, expected a watermark of now", sub, err)
+	}
+
+	later := sub.Watermark.Add(time.Minute)
+	if err := store.UpdateWatermark(id, later, ""); err != nil {
+		t.Fatalf("failed to update watermark: %s", err.Error())
+	}
+	if err := store.UpdateWatermark(id, sub.Watermark, "webhook returned 500"); err != nil {
+		t.Fatalf("failed to update watermark: %s", err.Error())
+	}
+
+	// Subscriptions persist, and their watermarks only move forward.
+	store = NewMetaStore(dataDir)
+	if err := store.Load(); err != nil {
+		t.Fatalf("failed to load meta store: %s", err.Error())
+	}
+	sub, err = store.ReadSubscription(id)
+	if err != nil || !sub.Watermark.Equal(later) || sub.LastError != "webhook returned 500" {
+		t.Fatalf("reloaded subscription %+v, %v, expected watermark %s", sub, err, later)
+	}
+
+	// Subscriptions are scoped by owner like the queries.
+	private, err := store.CreateQuery(Query{Name: "errors", Owner: "web"})
+	if err != nil {
+		t.Fatalf("failed to create query: %s", err.Error())
+	}
+	if _, err := store.CreateSubscription(Subscription{Query: private, Owner: "ops", Interval: "1m", Webhook: "http://example.com/hook"}); err != ErrRecordNotFound {
+		t.Fatalf("subscription to the private filter of another returned %v, expected ErrRecordNotFound", err)
+	}
+	opsID, err := store.CreateSubscription(Subscription{Owner: "ops", Interval: "1m", Webhook: "http://example.com/hook"})
+	if err != nil {
+		t.Fatalf("failed to create subscription of ops: %s", err.Error())
+	}
+	for owner, expected := range map[string]int{"": 2, "ops": 2, "web": 1} {
+		if n := len(store.ListSubscriptionsAs(owner)); n != expected {
+			t.Errorf("%q sees %d subscriptions, expected %d", owner, n, expected)
+		}
+	}
+	if _, err := store.ReadSubscriptionAs("web", opsID); err != ErrRecordNotFound {
+		t.Errorf("web read subscription of ops, got %v", err)
+	}
+	if err := store.DeleteSubscriptionAs("web", opsID); err != nil {
+		t.Errorf("deleting invisible subscription failed with %v", err)
+	}
+	if _, err := store.ReadSubscription(opsID); err != nil {
+		t.Fatalf("web deleted subscription of ops: %v", err)
+	}
+	if err := store.DeleteSubscriptionAs("ops", opsID); err != nil {
+		t.Fatalf("ops failed to delete its subscription: %s", err.Error())
+	}
+
+	if err := store.DeleteSubscription(id); err != nil {
+		t.Fatalf("failed to delete subscription: %s", err.Error())
+	}
+	if subs := store.ListSubscriptions(); len(subs) != 0 {
+		t.Fatalf("subscriptions %+v left after delete", subs)
+	}
+}
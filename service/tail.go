@@ -0,0 +1,154 @@
+package service
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/ekanite/ekanite"
+)
+
+var stats = expvar.NewMap("tail")
+
+// DefaultTailBuffer is the number of events buffered for each subscriber of a
+// Tail. Events published while the buffer is full are dropped for that
+// subscriber.
+const DefaultTailBuffer = 256
+
+// Tail publishes the events ingested to live subscribers, each receiving the
+// events its Matcher accepts.
+type Tail struct {
+	// Recent, if set, keeps the events published for the quick look and tail
+	// endpoints to replay.
+	Recent *Recent
+
+	// CaseFold are the keyword fields the matchers of the saved queries
+	// followed match lower-cased, usually those of the engine.
+	CaseFold ekanite.CaseFold
+
+	mu   sync.RWMutex
+	subs map[*subscription]struct{}
+}
+
+type subscription struct {
+	match  Matcher
+	filter string // ID of the saved query match is compiled from, if any.
+	owner  string // Owner the saved query is read as.
+	c      chan map[string]interface{}
+}
+
+// NewTail returns a Tail without subscribers.
+func NewTail() *Tail {
+	return &Tail{subs: map[*subscription]struct{}{}}
+}
+
+// Tee returns a channel passing the documents written to it on to c, after
+// publishing them.
+func (t *Tail) Tee(c chan<- ekanite.Document) chan<- ekanite.Document {
+	in := make(chan ekanite.Document, cap(c))
+	go func() {
+		for doc := range in {
+			if fields, ok := doc.Data().(map[string]interface{}); ok {
+				t.Publish(fields)
+			}
+			c <- doc
+		}
+	}()
+	return in
+}
+
+// Publish sends the parsed fields of an event to the subscribers matching it,
+// and keeps them in Recent. Each gets a copy, as events are recycled once
+// indexed.
+func (t *Tail) Publish(fields map[string]interface{}) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.Recent != nil {
+		t.Recent.Add(copyFields(fields))
+	}
+	for sub := range t.subs {
+		if sub.match != nil && !sub.match(fields) {
+			continue
+		}
+		select {
+		case sub.c <- copyFields(fields):
+			stats.Add("eventsSent", 1)
+		default:
+			stats.Add("eventsDropped", 1)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the events published which match,
+// all of them if match is nil, and a function cancelling the subscription.
+func (t *Tail) Subscribe(match Matcher) (<-chan map[string]interface{}, func()) {
+	_, c, cancel := t.SubscribeSince(match, time.Time{}, 0)
+	return c, cancel
+}
+
+// SubscribeSince is like Subscribe, also returning the events kept in Recent
+// since the given time, up to limit of them if positive. No event is both
+// returned and sent to the channel.
+func (t *Tail) SubscribeSince(match Matcher, since time.Time, limit int) ([]map[string]interface{}, <-chan map[string]interface{}, func()) {
+	return t.SubscribeFilter("", "", match, since, limit)
+}
+
+// SubscribeFilter is SubscribeSince for match compiled from the saved query
+// filter, as read by owner. Once the tail follows the MetaStore of the query,
+// match is recompiled when the query changes, and the channel is closed when
+// the query is deleted or no longer visible to owner.
+func (t *Tail) SubscribeFilter(filter, owner string, match Matcher, since time.Time, limit int) ([]map[string]interface{}, <-chan map[string]interface{}, func()) {
+	sub := &subscription{match: match, filter: filter, owner: owner, c: make(chan map[string]interface{}, DefaultTailBuffer)}
+	var recent []map[string]interface{}
+	t.mu.Lock()
+	if t.Recent != nil && !since.IsZero() {
+		recent = t.Recent.Events(since, match, limit)
+	}
+	t.subs[sub] = struct{}{}
+	t.mu.Unlock()
+	stats.Add("subscribers", 1)
+
+	var once sync.Once
+	return recent, sub.c, func() {
+		once.Do(func() {
+			t.mu.Lock()
+			delete(t.subs, sub)
+			t.mu.Unlock()
+			stats.Add("subscribers", -1)
+		})
+	}
+}
+
+// Follow recompiles the matchers of the subscriptions to the saved queries of
+// store as they change, until the returned function is called.
+func (t *Tail) Follow(store *MetaStore) func() {
+	return store.Watch(t.queryChanged)
+}
+
+// queryChanged recompiles the matchers of the subscriptions to the query
+// changed, ending those which can no longer match it.
+func (t *Tail) queryChanged(change QueryChange) {
+	var match Matcher
+	var err error
+	if !change.Deleted {
+		match, err = change.Query.Matcher(t.CaseFold)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for sub := range t.subs {
+		if sub.filter != change.ID {
+			continue
+		}
+		if change.Deleted || err != nil || !change.Query.VisibleTo(sub.owner) {
+			// Publish sends under the read lock, so the channel is closed
+			// while no one sends to it.
+			delete(t.subs, sub)
+			close(sub.c)
+			stats.Add("subscriptionsEnded", 1)
+			continue
+		}
+		sub.match = match
+		stats.Add("subscriptionsRecompiled", 1)
+	}
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTail_Follow(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "ekanite-tail-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dataDir)
+
+	store := NewMetaStore(dataDir)
+	q := Query{Name: "web", Owner: "ops", Filters: []Filter{{Field: "host", Op: OpTerm, Values: []string{"web01"}}}}
+	id, err := store.CreateQuery(q)
+	if err != nil {
+		t.Fatalf("failed to create query: %s", err.Error())
+	}
+	var changes []QueryChange
+	stopWatching := store.Watch(func(c QueryChange) { changes = append(changes, c) })
+
+	tail := NewTail()
+	defer tail.Follow(store)()
+	match, err := q.Matcher(nil)
+	if err != nil {
+		t.Fatalf("failed to compile query: %s", err.Error())
+	}
+	_, events, cancel := tail.SubscribeFilter(id, "ops", match, time.Time{}, 0)
+	defer cancel()
+	receive := func(host string) bool {
+		tail.Publish(map[string]interface{}{"host": host})
+		select {
+		case e, ok := <-events:
+			return ok && e["host"] == host
+		default:
+			return false
+		}
+	}
+	if !receive("web01") || receive("web02") {
+		t.Fatal("subscription matched the wrong events")
+	}
+
+	// The subscription matches the query as changed at once.
+	q.Filters = []Filter{{Field: "host", Op: OpTerm, Values: []string{"web02"}}}
+	if err := store.UpdateQuery(id, q); err != nil {
+		t.Fatalf("failed to update query: %s", err.Error())
+	}
+	if receive("web01") || !receive("web02") {
+		t.Fatal("subscription not recompiled after the query changed")
+	}
+	if len(changes) != 1 || changes[0].ID != id || changes[0].Deleted || changes[0].Query.Filters[0].Values[0] != "web02" {
+		t.Fatalf("watcher got changes %+v", changes)
+	}
+	stopWatching()
+
+	// The subscription ends with its query.
+	if err := store.DeleteQuery(id); err != nil {
+		t.Fatalf("failed to delete query: %s", err.Error())
+	}
+	if _, ok := <-events; ok {
+		t.Fatal("subscription not ended after the query was deleted")
+	}
+	if len(changes) != 1 {
+		t.Fatalf("stopped watcher got changes %+v", changes)
+	}
+}
@@ -0,0 +1,57 @@
+package service
+
+// QueryChange describes a change of a query of a MetaStore, its continuous
+// queries included.
+type QueryChange struct {
+	ID      string
+	Query   Query // The query as changed, zero if deleted.
+	Deleted bool
+}
+
+// Watch calls fn with each change of the queries of the store, until the
+// returned function is called, so that the filters compiled from them are
+// recompiled at once. fn is called after the change, outside the lock of the
+// store, and may read it.
+func (h *MetaStore) Watch(fn func(QueryChange)) func() {
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+	if h.watchers == nil {
+		h.watchers = map[int]func(QueryChange){}
+	}
+	h.nextWatch++
+	n := h.nextWatch
+	h.watchers[n] = fn
+	return func() {
+		h.watchMu.Lock()
+		defer h.watchMu.Unlock()
+		delete(h.watchers, n)
+	}
+}
+
+// unlockNotify unlocks the store, then notifies the watchers of the query id
+// if it changed. Queries changed but failing to be saved are notified as
+// well, being in use until restart.
+func (h *MetaStore) unlockNotify(id string, changed bool) {
+	var change QueryChange
+	if changed {
+		q, ok := h.queries[id]
+		if ok {
+			q.ID = id
+		}
+		change = QueryChange{ID: id, Query: q, Deleted: !ok}
+	}
+	h.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	h.watchMu.Lock()
+	watchers := make([]func(QueryChange), 0, len(h.watchers))
+	for _, fn := range h.watchers {
+		watchers = append(watchers, fn)
+	}
+	h.watchMu.Unlock()
+	for _, fn := range watchers {
+		fn(change)
+	}
+}
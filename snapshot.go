@@ -0,0 +1,91 @@
+package ekanite
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// IndexSnapshot is a point-in-time view of indexes, for reads lasting minutes
+// such as exports. Searching it holds no lock of the engine, so that indexing
+// goes on meanwhile, creating indexes included. It only finds the documents
+// received by its Time, so that paging through it does not shift as events
+// arrive. Retention does not delete its indexes until it is closed.
+type IndexSnapshot struct {
+	Time time.Time // Reception time of the latest documents found.
+
+	engine    *Engine
+	indexes   []*Index
+	closeOnce sync.Once
+}
+
+// Snapshot takes a snapshot of the named indexes or, if none, of those
+// covering startTime to endTime. It must be closed once read.
+func (e *Engine) Snapshot(startTime, endTime time.Time, names []string) (*IndexSnapshot, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var indexes []*Index
+	if len(names) > 0 {
+		var err error
+		if indexes, err = e.indexesByName(names); err != nil {
+			return nil, err
+		}
+	} else {
+		indexes = e.getIndexs(startTime, endTime)
+	}
+	if len(indexes) == 0 {
+		return nil, ErrIndexNotFound
+	}
+
+	// Indexes are pinned while listed under the lock, so before retention
+	// can evict them and wait for their readers.
+	for _, i := range indexes {
+		i.readers.Add(1)
+	}
+	stats.Add("snapshotsOpened", 1)
+	return &IndexSnapshot{
+		Time:    time.Now(),
+		engine:  e,
+		indexes: append([]*Index(nil), indexes...),
+	}, nil
+}
+
+// Indexes returns the names of the indexes of the snapshot.
+func (s *IndexSnapshot) Indexes() []string {
+	names := make([]string, len(s.indexes))
+	for n, i := range s.indexes {
+		names[n] = i.Name()
+	}
+	return names
+}
+
+// Search performs the search request on the snapshot, restricted to the
+// documents received by its Time. The request is left unchanged, so that it
+// can be paged through.
+func (s *IndexSnapshot) Search(ctx context.Context, req *bleve.SearchRequest, cb func(*bleve.SearchRequest, *bleve.SearchResult) error) error {
+	stats.Add("queriesRx", 1)
+
+	// WithTimeRange would add the cutoff to the conjunction of the request
+	// itself, once more for every page.
+	r := *req
+	cutoff := WithTimeRange(nil, time.Time{}, s.Time)
+	if r.Query == nil {
+		r.Query = cutoff
+	} else {
+		r.Query = bleve.NewConjunctionQuery(r.Query, cutoff)
+	}
+	return s.engine.search(ctx, s.indexes, &r, cb)
+}
+
+// Close releases the indexes of the snapshot to retention.
+func (s *IndexSnapshot) Close() error {
+	s.closeOnce.Do(func() {
+		for _, i := range s.indexes {
+			i.readers.Done()
+		}
+	})
+	return nil
+}
@@ -0,0 +1,101 @@
+package ekanite
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// receivedDoc is a document received at a time of its own, apart from its
+// reference time.
+type receivedDoc struct {
+	id        string
+	reference time.Time
+	reception time.Time
+}
+
+func (d receivedDoc) ID() DocID { return DocID(d.id) }
+func (d receivedDoc) Data() interface{} {
+	return map[string]interface{}{ReceptionField: d.reception, "message": d.id}
+}
+func (d receivedDoc) ReferenceTime() time.Time { return d.reference }
+
+func TestEngine_Snapshot(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine at %s: %s", dataDir, err.Error())
+	}
+	defer e.Close()
+
+	ref := parseTime("1982-02-05T04:43:00Z")
+	if err := e.Index([]Document{receivedDoc{"before", ref, ref}}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+	if _, err := e.Snapshot(time.Time{}, time.Time{}, []string{"19700101_0000"}); err == nil {
+		t.Fatal("snapshot of unknown index taken")
+	}
+	snapshot, err := e.Snapshot(time.Time{}, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("failed to take snapshot: %s", err.Error())
+	}
+	defer snapshot.Close()
+
+	// Documents received after the snapshot are not seen by it, even in its
+	// indexes.
+	late := receivedDoc{"after", ref.Add(time.Second), snapshot.Time.Add(time.Minute)}
+	if err := e.Index([]Document{late}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+	count := func(search func(*bleve.SearchRequest, func(*bleve.SearchRequest, *bleve.SearchResult) error) error) (uint64, error) {
+		var total uint64
+		err := search(bleve.NewSearchRequest(bleve.NewMatchAllQuery()), func(_ *bleve.SearchRequest, resp *bleve.SearchResult) error {
+			total = resp.Total
+			return nil
+		})
+		return total, err
+	}
+	snapshotSearch := func(req *bleve.SearchRequest, cb func(*bleve.SearchRequest, *bleve.SearchResult) error) error {
+		return snapshot.Search(context.Background(), req, cb)
+	}
+	if n, err := count(snapshotSearch); err != nil || n != 1 {
+		t.Fatalf("snapshot found %d documents, %v, expected 1", n, err)
+	}
+	if n, err := count(func(req *bleve.SearchRequest, cb func(*bleve.SearchRequest, *bleve.SearchResult) error) error {
+		return e.Query(context.Background(), time.Time{}, time.Time{}, req, cb)
+	}); err != nil || n != 2 {
+		t.Fatalf("engine found %d documents, %v, expected 2", n, err)
+	}
+
+	// Retention evicts the index at once, but deletes it once the snapshot
+	// is closed.
+	e.RetentionPeriod = 24 * time.Hour
+	done := make(chan struct{})
+	go func() {
+		e.enforceRetention()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("retention deleted index read by snapshot")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if n, err := count(snapshotSearch); err != nil || n != 1 {
+		t.Fatalf("snapshot found %d documents after eviction, %v, expected 1", n, err)
+	}
+	if err := snapshot.Close(); err != nil {
+		t.Fatalf("failed to close snapshot: %s", err.Error())
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("retention not done after snapshot closed")
+	}
+	if infos, _ := e.Indexes(); len(infos) != 0 {
+		t.Fatalf("indexes left after retention: %+v", infos)
+	}
+}
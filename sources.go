@@ -0,0 +1,61 @@
+package ekanite
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// DefaultSourceReportSize is the number of hosts and apps ranked by
+// TopSources when not given.
+const DefaultSourceReportSize = 10
+
+// TermCount is the number of documents holding a term.
+type TermCount struct {
+	Term  string `json:"term"`
+	Count uint64 `json:"count"`
+}
+
+// SourceReport ranks the sources of the events of a time range.
+type SourceReport struct {
+	Events uint64      `json:"events"` // Events of the time range.
+	Hosts  []TermCount `json:"hosts"`  // Hosts which sent the most events, most first.
+	Apps   []TermCount `json:"apps"`   // Apps which sent the most events, most first.
+}
+
+// TopSources ranks the n hosts and apps which sent the most events between
+// startAt and endAt, with terms facets of a single search. The counts of
+// searchers merging the facets of several indexes may be approximate near
+// the bottom of the ranking, as those of bleve are.
+func TopSources(seacher Searcher, ctx context.Context, startAt, endAt time.Time, n int) (*SourceReport, error) {
+	if n <= 0 {
+		n = DefaultSourceReportSize
+	}
+	searchRequest := bleve.NewSearchRequest(WithTimeRange(nil, startAt, endAt))
+	searchRequest.Size = 0
+	searchRequest.AddFacet("host", bleve.NewFacetRequest("host", n))
+	searchRequest.AddFacet("app", bleve.NewFacetRequest("app", n))
+
+	report := &SourceReport{Hosts: []TermCount{}, Apps: []TermCount{}}
+	err := seacher.Query(ctx, startAt, endAt, searchRequest,
+		func(req *bleve.SearchRequest, resp *bleve.SearchResult) error {
+			report.Events = resp.Total
+			if facet := resp.Facets["host"]; facet != nil {
+				for _, term := range facet.Terms {
+					report.Hosts = append(report.Hosts, TermCount{Term: term.Term, Count: uint64(term.Count)})
+				}
+			}
+			if facet := resp.Facets["app"]; facet != nil {
+				for _, term := range facet.Terms {
+					report.Apps = append(report.Apps, TermCount{Term: term.Term, Count: uint64(term.Count)})
+				}
+			}
+			return nil
+		})
+	if err != nil && !errors.Is(err, ErrIndexNotFound) {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,175 @@
+package ekanite
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+)
+
+// storageSampleSize is the number of documents whose stored fields are
+// measured to estimate those of a whole index.
+const storageSampleSize = 100
+
+// IndexStorage breaks down what an index holds on disk. Segments compress
+// what they hold, so the term and stored field sizes are those before
+// compression, and only tell how the disk usage splits between fields.
+type IndexStorage struct {
+	Name             string         `json:"name"`
+	Documents        uint64         `json:"documents"`
+	DiskBytes        int64          `json:"disk_bytes"`
+	Segments         int            `json:"segments"`
+	BytesPerDocument float64        `json:"bytes_per_document"`
+	TermBytes        int64          `json:"term_bytes"`
+	StoredBytes      int64          `json:"stored_bytes"`
+	Fields           []FieldStorage `json:"fields"`
+}
+
+// FieldStorage is what a field takes in an index. Terms and TermBytes count
+// the term dictionaries of all the shards, StoredBytes is extrapolated from
+// a sample of the documents.
+type FieldStorage struct {
+	Field       string `json:"field"`
+	Terms       uint64 `json:"terms"`
+	TermBytes   int64  `json:"term_bytes"`
+	Postings    uint64 `json:"postings"`
+	StoredBytes int64  `json:"stored_bytes"`
+}
+
+// IndexStorage returns the storage breakdown of the named index, its fields
+// ordered by decreasing stored size.
+func (e *Engine) IndexStorage(ctx context.Context, name string) (*IndexStorage, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	i := e.indexByName(name)
+	if i == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownIndex, name)
+	}
+
+	total, err := i.Total()
+	if err != nil {
+		return nil, err
+	}
+	size, err := i.Size()
+	if err != nil {
+		return nil, err
+	}
+	storage := &IndexStorage{Name: name, Documents: total, DiskBytes: size}
+	if total > 0 {
+		storage.BytesPerDocument = float64(size) / float64(total)
+	}
+	if storage.Segments, err = countSegments(i.path); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]*FieldStorage{}
+	field := func(name string) *FieldStorage {
+		f := fields[name]
+		if f == nil {
+			f = &FieldStorage{Field: name}
+			fields[name] = f
+		}
+		return f
+	}
+
+	for _, shard := range i.Shards {
+		names, err := shard.b.Fields()
+		if err != nil {
+			return nil, err
+		}
+		for _, fieldName := range names {
+			if err := countTerms(shard.b, field(fieldName)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	stored, sampled, err := sampleStoredBytes(ctx, i, int(total))
+	if err != nil {
+		return nil, err
+	}
+	for fieldName, n := range stored {
+		field(fieldName).StoredBytes = n * int64(total) / int64(sampled)
+	}
+
+	storage.Fields = make([]FieldStorage, 0, len(fields))
+	for _, f := range fields {
+		storage.TermBytes += f.TermBytes
+		storage.StoredBytes += f.StoredBytes
+		storage.Fields = append(storage.Fields, *f)
+	}
+	sort.Slice(storage.Fields, func(u, v int) bool {
+		if storage.Fields[u].StoredBytes != storage.Fields[v].StoredBytes {
+			return storage.Fields[u].StoredBytes > storage.Fields[v].StoredBytes
+		}
+		return storage.Fields[u].Field < storage.Fields[v].Field
+	})
+	return storage, nil
+}
+
+// countTerms adds the terms of the dictionary of the field in b to f.
+func countTerms(b bleve.Index, f *FieldStorage) error {
+	dict, err := b.FieldDict(f.Field)
+	if err != nil {
+		return err
+	}
+	defer dict.Close()
+	for {
+		entry, err := dict.Next()
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return nil
+		}
+		f.Terms++
+		f.TermBytes += int64(len(entry.Term))
+		f.Postings += entry.Count
+	}
+}
+
+// sampleStoredBytes measures the stored fields of up to storageSampleSize
+// random documents of the index, returning the bytes of each field and the
+// number of documents measured.
+func sampleStoredBytes(ctx context.Context, i *Index, total int) (map[string]int64, int, error) {
+	stored := map[string]int64{}
+	offsets := sampleOffsets(total, storageSampleSize)
+	for _, offset := range offsets {
+		req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), 1, offset, false)
+		req.SortBy([]string{"_id"})
+		req.Fields = []string{"*"}
+		resp, err := i.Alias.SearchInContext(ctx, req)
+		if err != nil {
+			return nil, 0, wrapQueryError(ctx, err)
+		}
+		for _, hit := range resp.Hits {
+			for k, v := range hit.Fields {
+				stored[k] += int64(len(fmt.Sprint(v)))
+			}
+		}
+	}
+	return stored, len(offsets), nil
+}
+
+// countSegments returns the number of segment files under path.
+func countSegments(path string) (int, error) {
+	var n int
+	err := filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil // Segment files come and go as shards are written.
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".zap") {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
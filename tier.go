@@ -0,0 +1,140 @@
+package ekanite
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tier holds the documents of a range of severities in indexes of their own,
+// covering the same time windows as those of the other tiers, so that they
+// can be kept for a retention period of their own. Searches cover all tiers.
+type Tier struct {
+	Name        string
+	MaxSeverity int           // Least severe severity held, from 0 (emergency) to 7 (debug).
+	Retention   time.Duration // How long after index end-time to hang onto data, 0 for RetentionPeriod.
+}
+
+// tierSeparator separates the start time from the tier in the names of the
+// indexes of a tier.
+const tierSeparator = "."
+
+var tierNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// severityNames maps the syslog severity keywords to their values.
+var severityNames = map[string]int{
+	"emerg":   0,
+	"alert":   1,
+	"crit":    2,
+	"err":     3,
+	"error":   3,
+	"warning": 4,
+	"warn":    4,
+	"notice":  5,
+	"info":    6,
+	"debug":   7,
+}
+
+// ParseTiers parses a comma-separated list of name:severity:retention tiers,
+// such as "errors:err:2160h,info:debug:168h", the severity being the least
+// severe held by the tier, as a keyword or a number. Tiers are returned from
+// the most to the least severe.
+func ParseTiers(s string) ([]Tier, error) {
+	var tiers []Tier
+	names := map[string]bool{}
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t == "" {
+			continue
+		}
+		ss := strings.Split(t, ":")
+		if len(ss) != 3 {
+			return nil, fmt.Errorf("tier '%s' is not in the form name:severity:retention", t)
+		}
+		name := strings.TrimSpace(ss[0])
+		if !tierNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("tier name '%s' is invalid, only a-z, 0-9, _ and - are allowed", name)
+		}
+		if strings.HasPrefix(name, "_") {
+			return nil, fmt.Errorf("tier name '%s' is reserved, names starting with _ are", name)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("tier '%s' is given twice", name)
+		}
+		names[name] = true
+
+		severity, err := ParseSeverity(strings.TrimSpace(ss[1]))
+		if err != nil {
+			return nil, err
+		}
+		retention, err := time.ParseDuration(strings.TrimSpace(ss[2]))
+		if err != nil {
+			return nil, fmt.Errorf("tier '%s' has invalid retention: %w", name, err)
+		}
+		tiers = append(tiers, Tier{Name: name, MaxSeverity: severity, Retention: retention})
+	}
+	sort.SliceStable(tiers, func(u, v int) bool {
+		return tiers[u].MaxSeverity < tiers[v].MaxSeverity
+	})
+	return tiers, nil
+}
+
+// ParseSeverity parses a syslog severity given as a keyword, such as err, or a
+// number.
+func ParseSeverity(s string) (int, error) {
+	if v, ok := severityNames[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 || v > 7 {
+		return 0, fmt.Errorf("severity '%s' is invalid", s)
+	}
+	return v, nil
+}
+
+// tierOf returns the name of the tier of the document, given by its severity.
+// Documents without a severity go to the least severe tier. It is empty if no
+// tiers are set. Heartbeats go to the MonitoringTier.
+func (e *Engine) tierOf(d Document) string {
+	if _, ok := d.(*Heartbeat); ok {
+		return MonitoringTier
+	}
+	if len(e.Tiers) == 0 {
+		return ""
+	}
+	if fields, ok := d.Data().(map[string]interface{}); ok {
+		if severity, ok := fields["severity"].(int); ok {
+			for _, t := range e.Tiers {
+				if severity <= t.MaxSeverity {
+					return t.Name
+				}
+			}
+		}
+	}
+	return e.Tiers[len(e.Tiers)-1].Name
+}
+
+// tierNames returns the names of the tiers documents are indexed in.
+func (e *Engine) tierNames() []string {
+	if len(e.Tiers) == 0 {
+		return []string{""}
+	}
+	names := make([]string, len(e.Tiers))
+	for n, t := range e.Tiers {
+		names[n] = t.Name
+	}
+	return names
+}
+
+// retention returns how long after its end time the index is kept, that of
+// its tier or else RetentionPeriod.
+func (e *Engine) retention(i *Index) time.Duration {
+	for _, t := range e.Tiers {
+		if t.Name == i.tier && t.Retention > 0 {
+			return t.Retention
+		}
+	}
+	return e.RetentionPeriod
+}
@@ -0,0 +1,104 @@
+package ekanite
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTiers(t *testing.T) {
+	tiers, err := ParseTiers("info:debug:168h, errors:err:2160h")
+	if err != nil {
+		t.Fatalf("failed to parse tiers: %s", err.Error())
+	}
+	expected := []Tier{
+		{Name: "errors", MaxSeverity: 3, Retention: 2160 * time.Hour},
+		{Name: "info", MaxSeverity: 7, Retention: 168 * time.Hour},
+	}
+	if !reflect.DeepEqual(tiers, expected) {
+		t.Fatalf("parsed tiers %+v, expected %+v", tiers, expected)
+	}
+
+	for _, s := range []string{"errors:err", "errors:fatal:1h", "errors:9:1h", "errors:err:90d", "Errors:err:1h", "a:err:1h,a:info:1h", "_monitoring:err:1h"} {
+		if _, err := ParseTiers(s); err == nil {
+			t.Errorf("invalid tiers %q parsed", s)
+		}
+	}
+}
+
+type severityDoc struct {
+	id       DocID
+	severity interface{}
+	refTime  time.Time
+}
+
+func (d severityDoc) ID() DocID { return d.id }
+func (d severityDoc) Data() interface{} {
+	fields := map[string]interface{}{"message": "tiered"}
+	if d.severity != nil {
+		fields["severity"] = d.severity
+	}
+	return fields
+}
+func (d severityDoc) ReferenceTime() time.Time { return d.refTime }
+
+func TestEngine_Tiers(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	e := NewEngine(dataDir)
+	e.RetentionPeriod = 24 * time.Hour
+	e.Tiers = []Tier{
+		{Name: "errors", MaxSeverity: 3, Retention: 90 * 24 * time.Hour},
+		{Name: "info", MaxSeverity: 7},
+	}
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine at %s: %s", dataDir, err.Error())
+	}
+
+	old := time.Now().UTC().Add(-72 * time.Hour)
+	docs := []Document{
+		severityDoc{id: "00000000000000000000000000000001", severity: 2, refTime: old},
+		severityDoc{id: "00000000000000000000000000000002", severity: 6, refTime: old},
+		severityDoc{id: "00000000000000000000000000000003", refTime: old},
+	}
+	if err := e.Index(docs); err != nil {
+		t.Fatalf("failed to index documents: %s", err.Error())
+	}
+	infos, err := e.Indexes()
+	if err != nil {
+		t.Fatalf("failed to list indexes: %s", err.Error())
+	}
+	documents := map[string]uint64{}
+	for _, info := range infos {
+		documents[info.Tier] += info.Documents
+	}
+	if !reflect.DeepEqual(documents, map[string]uint64{"errors": 1, "info": 2}) {
+		t.Fatalf("documents by tier %v, expected 1 error and 2 info", documents)
+	}
+	if total, _ := e.Total(); total != 3 {
+		t.Fatalf("engine holds %d documents, expected 3 across tiers", total)
+	}
+
+	// Tiered indexes of the same time window reopen without overlapping.
+	if err := e.Close(); err != nil {
+		t.Fatalf("failed to close engine: %s", err.Error())
+	}
+	e = NewEngine(dataDir)
+	e.RetentionPeriod = 24 * time.Hour
+	e.Tiers = []Tier{
+		{Name: "errors", MaxSeverity: 3, Retention: 90 * 24 * time.Hour},
+		{Name: "info", MaxSeverity: 7},
+	}
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to reopen engine at %s: %s", dataDir, err.Error())
+	}
+	defer e.Close()
+
+	// Only the info tier has aged out.
+	e.enforceRetention()
+	if len(e.indexes) != 1 || e.indexes[0].Tier() != "errors" {
+		t.Fatalf("indexes after retention enforcement %v, expected the errors tier only", e.indexes)
+	}
+}
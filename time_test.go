@@ -1,6 +1,9 @@
 package ekanite
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestParseTime(t *testing.T) {
 	tt := ParseTime("now()-24h")
@@ -20,3 +23,149 @@ func TestParseTime(t *testing.T) {
 		t.Error(tt)
 	}
 }
+
+func TestParseTime_Epoch(t *testing.T) {
+	if tt := ParseTime("1136214245"); !tt.Equal(time.Unix(1136214245, 0)) {
+		t.Errorf("seconds parsed incorrectly, got %s", tt)
+	}
+	if tt := ParseTime("1136214245123"); !tt.Equal(time.Unix(1136214245, 123*int64(time.Millisecond))) {
+		t.Errorf("milliseconds parsed incorrectly, got %s", tt)
+	}
+	if tt := ParseTime("11362142451234"); !tt.IsZero() {
+		t.Errorf("too many digits parsed as %s", tt)
+	}
+}
+
+func TestParseTime_Days(t *testing.T) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	tests := []struct {
+		s   string
+		exp time.Time
+	}{
+		{"today()", today},
+		{"today() + 8h", today.Add(8 * time.Hour)},
+		{"yesterday()", today.AddDate(0, 0, -1)},
+		{"yesterday() -1h", today.AddDate(0, 0, -1).Add(-time.Hour)},
+	}
+	for _, tt := range tests {
+		if v := ParseTime(tt.s); !v.Equal(tt.exp) {
+			t.Errorf("ParseTime(%q) got %s, expected %s", tt.s, v, tt.exp)
+		}
+	}
+	if v := ParseTime("today() + soon"); !v.IsZero() {
+		t.Errorf("invalid offset parsed as %s", v)
+	}
+}
+
+func TestParseTime_Relative(t *testing.T) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	approx := func(s string, exp time.Time) {
+		if v := ParseTime(s); v.IsZero() || v.Sub(exp) > time.Second || exp.Sub(v) > time.Second {
+			t.Errorf("ParseTime(%q) got %s, expected %s", s, v, exp)
+		}
+	}
+	approx("-7d", now.AddDate(0, 0, -7))
+	approx("+1h", now.Add(time.Hour))
+	approx("now() - 1w2d", now.Add(-9*24*time.Hour))
+	approx("now()-1d12h", now.Add(-36*time.Hour))
+
+	tests := []struct {
+		s   string
+		exp time.Time
+	}{
+		{"@d", today},
+		{"now()@h", time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())},
+		{"today()+8h@d", today},
+		{"now()@M", time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())},
+		{"now()@y", time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, now.Location())},
+		{"2018-01-03T10:30:00Z@w", time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"2018-01-02 10:30:00 UTC", time.Date(2018, time.January, 2, 10, 30, 0, 0, time.UTC)},
+		{"2018-01-02 10:30:00 +08:00", time.Date(2018, time.January, 2, 2, 30, 0, 0, time.UTC)},
+		{"2018-01-02 Asia/Shanghai", time.Date(2018, time.January, 1, 16, 0, 0, 0, time.UTC)},
+		{"2018-01-02T10:30:00+08:00@d", time.Date(2018, time.January, 1, 16, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if v := ParseTime(tt.s); !v.Equal(tt.exp) {
+			t.Errorf("ParseTime(%q) got %s, expected %s", tt.s, v, tt.exp)
+		}
+	}
+
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err == nil {
+		inShanghai := now.In(shanghai)
+		exp := time.Date(inShanghai.Year(), inShanghai.Month(), inShanghai.Day(), 0, 0, 0, 0, shanghai)
+		if v := ParseTime("today() Asia/Shanghai"); !v.Equal(exp) {
+			t.Errorf("today in Shanghai got %s, expected %s", v, exp)
+		}
+	}
+
+	for _, s := range []string{"", "1d", "now()@x", "now() - 1d UTC+", "2018-01-02 Mars/Base"} {
+		if v := ParseTime(s); !v.IsZero() {
+			t.Errorf("ParseTime(%q) parsed as %s", s, v)
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Now()
+	for s, exp := range map[string]time.Time{
+		"90m":        now.Add(-90 * time.Minute),
+		"7d":         now.AddDate(0, 0, -7),
+		"now()-1h":   now.Add(-time.Hour),
+		"1136214245": time.Unix(1136214245, 0),
+	} {
+		if v := ParseSince(s); v.Sub(exp) > time.Second || exp.Sub(v) > time.Second {
+			t.Errorf("ParseSince(%q) got %s, expected %s", s, v, exp)
+		}
+	}
+	if v := ParseSince("soon"); !v.IsZero() {
+		t.Errorf("invalid since parsed as %s", v)
+	}
+}
+
+func TestTimeBuckets(t *testing.T) {
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %s", s, err)
+		}
+		return v
+	}
+	check := func(name string, bounds []time.Time, exp ...string) {
+		if len(bounds) != len(exp) {
+			t.Errorf("%s: got bounds %v, expected %v", name, bounds, exp)
+			return
+		}
+		for i := range exp {
+			if !bounds[i].Equal(utc(exp[i])) {
+				t.Errorf("%s: got bounds %v, expected %v", name, bounds, exp)
+				return
+			}
+		}
+	}
+
+	check("no zone", timeBuckets(utc("2018-01-01T04:40:00Z"), utc("2018-01-01T04:56:00Z"), 5*time.Minute, nil),
+		"2018-01-01T04:40:00Z", "2018-01-01T04:45:00Z", "2018-01-01T04:50:00Z", "2018-01-01T04:55:00Z")
+	check("offset hours", timeBuckets(utc("2018-01-01T04:40:00Z"), utc("2018-01-01T08:00:00Z"), 2*time.Hour, time.FixedZone("+0530", 5*3600+1800)),
+		"2018-01-01T04:30:00Z", "2018-01-01T06:30:00Z")
+
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skipf("no time zone database: %s", err)
+	}
+	check("days", timeBuckets(utc("2018-01-01T20:00:00Z"), utc("2018-01-04T12:00:00Z"), 24*time.Hour, shanghai),
+		"2018-01-01T16:00:00Z", "2018-01-02T16:00:00Z", "2018-01-03T16:00:00Z")
+
+	// The day DST starts in New York lasts 23 hours.
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone database: %s", err)
+	}
+	check("DST", timeBuckets(utc("2018-03-11T06:00:00Z"), utc("2018-03-12T11:00:00Z"), 5*time.Hour, newYork),
+		"2018-03-11T05:00:00Z", "2018-03-11T09:00:00Z", "2018-03-11T14:00:00Z", "2018-03-11T19:00:00Z",
+		"2018-03-12T00:00:00Z", "2018-03-12T04:00:00Z", "2018-03-12T09:00:00Z")
+	check("DST hours", timeBuckets(utc("2018-03-11T06:00:00Z"), utc("2018-03-11T09:00:00Z"), time.Hour, newYork),
+		"2018-03-11T06:00:00Z", "2018-03-11T07:00:00Z", "2018-03-11T08:00:00Z")
+}
@@ -3,6 +3,7 @@ package ekanite
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"math"
@@ -28,35 +29,234 @@ var (
 		"2006-01-02T15:04:05 07:00"}
 )
 
+// timeTokens are the relative times accepted by ParseTime, optionally followed
+// by a signed duration, e.g. `now() - 1h` or `today() + 8h`.
+var timeTokens = []struct {
+	token string
+	at    func(now time.Time) time.Time
+}{
+	{"now()", func(now time.Time) time.Time { return now }},
+	{"today()", func(now time.Time) time.Time {
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	}},
+	{"yesterday()", func(now time.Time) time.Time {
+		return time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, now.Location())
+	}},
+}
+
+// ParseTime parses s as one of timeFormats, a Unix timestamp in seconds or
+// milliseconds, or a relative time such as `now() - 24h`, `today()` or
+// `yesterday()`. A relative time may omit `now()`, as in `-7d`, take days and
+// weeks, and be snapped back to the start of its second, minute, hour, day,
+// week, month or year with `@s`, `@m`, `@h`, `@d`, `@w`, `@M` or `@y`, as in
+// `now()-24h@h`. A time zone, such as `UTC`, `+08:00` or `Asia/Shanghai`, may
+// follow after a space; times without an offset, days and snapping are then in
+// that zone rather than the local one. It returns the zero time if s cannot
+// be parsed.
 func ParseTime(s string) time.Time {
-	for _, layout := range timeFormats {
-		v, err := time.ParseInLocation(layout, s, time.Local)
-		if err == nil {
-			return v.Local()
+	return ParseTimeIn(s, time.Local)
+}
+
+// ParseTimeIn parses s as ParseTime does, times without an offset, days and
+// snapping being in loc rather than the local zone, unless s is followed by a
+// time zone of its own.
+func ParseTimeIn(s string, loc *time.Location) time.Time {
+	if t, ok := parseTimeIn(s, loc); ok {
+		return t
+	}
+
+	s = strings.TrimSpace(s)
+	if n := strings.LastIndexByte(s, ' '); n > 0 {
+		if loc := ParseLocation(s[n+1:]); loc != nil {
+			if t, ok := parseTimeIn(s[:n], loc); ok {
+				return t
+			}
 		}
 	}
+	return time.Time{}
+}
+
+// ParseSince parses s as a duration back from now, such as `90m` or `7d`, or
+// else as ParseTime does. It returns the zero time if s cannot be parsed.
+func ParseSince(s string) time.Time {
+	s = strings.TrimSpace(s)
+	if s != "" && s[0] != '-' && s[0] != '+' {
+		if d, err := parseRelativeDuration(s); err == nil {
+			return time.Now().Add(-d)
+		}
+	}
+	return ParseTime(s)
+}
+
+// parseTimeIn parses s as ParseTime does, times without an offset being in
+// loc.
+func parseTimeIn(s string, loc *time.Location) (time.Time, bool) {
+	if t, ok := parseAbsolute(s, loc); ok {
+		return t.Local(), true
+	}
 
 	s = strings.TrimSpace(s)
-	if strings.HasPrefix(s, "now()") {
-		durationStr := strings.TrimSpace(strings.TrimPrefix(s, "now()"))
-		if durationStr == "" {
-			return time.Now()
+	if t, ok := parseEpoch(s); ok {
+		return t, true
+	}
+	if s == "" {
+		return time.Time{}, false
+	}
+
+	var snap byte
+	if n := strings.LastIndexByte(s, '@'); n >= 0 && n == len(s)-2 {
+		snap = s[n+1]
+		s = strings.TrimSpace(s[:n])
+	}
+
+	now := time.Now().In(loc)
+	at, offset := now, s
+	for _, tok := range timeTokens {
+		if strings.HasPrefix(s, tok.token) {
+			at = tok.at(now)
+			offset = strings.TrimSpace(strings.TrimPrefix(s, tok.token))
+			break
+		}
+	}
+	if offset == s && s != "" && s[0] != '-' && s[0] != '+' {
+		// Only absolute times snapped are left.
+		t, ok := parseAbsolute(s, loc)
+		if !ok || snap == 0 {
+			return time.Time{}, false
 		}
+		at, offset = t, ""
+	}
+
+	if offset != "" {
 		neg := false
-		if strings.HasPrefix(durationStr, "-") {
+		if strings.HasPrefix(offset, "-") {
 			neg = true
-			durationStr = strings.TrimSpace(strings.TrimPrefix(durationStr, "-"))
+			offset = strings.TrimSpace(strings.TrimPrefix(offset, "-"))
+		} else {
+			offset = strings.TrimSpace(strings.TrimPrefix(offset, "+"))
+		}
+		duration, err := parseRelativeDuration(offset)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if neg {
+			duration = -1 * duration
 		}
+		at = at.Add(duration)
+	}
 
-		duration, err := time.ParseDuration(durationStr)
-		if err == nil {
-			if neg {
-				duration = -1 * duration
+	if snap != 0 {
+		var ok bool
+		if at, ok = snapTime(at, snap); !ok {
+			return time.Time{}, false
+		}
+	}
+	return at.Local(), true
+}
+
+// parseAbsolute parses s as one of timeFormats, in loc if it has no offset.
+func parseAbsolute(s string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range timeFormats {
+		if v, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return v, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseRelativeDuration parses a duration as time.ParseDuration does, led by
+// any number of days and weeks, as in `1w2d` or `1d12h`.
+func parseRelativeDuration(s string) (time.Duration, error) {
+	var total time.Duration
+	for {
+		n := 0
+		for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+			n++
+		}
+		if n == 0 || n == len(s) || (s[n] != 'd' && s[n] != 'w') {
+			break
+		}
+		v, err := strconv.ParseInt(s[:n], 10, 32)
+		if err != nil {
+			return 0, err
+		}
+		if s[n] == 'w' {
+			v *= 7
+		}
+		total += time.Duration(v) * 24 * time.Hour
+		s = s[n+1:]
+	}
+	if s == "" {
+		return total, nil
+	}
+	d, err := time.ParseDuration(s)
+	return total + d, err
+}
+
+// snapTime returns the start of the second, minute, hour, day, week (from
+// Monday), month or year of t, given by the snapping unit.
+func snapTime(t time.Time, unit byte) (time.Time, bool) {
+	y, mo, d := t.Date()
+	switch unit {
+	case 's':
+		return t.Truncate(time.Second), true
+	case 'm':
+		return time.Date(y, mo, d, t.Hour(), t.Minute(), 0, 0, t.Location()), true
+	case 'h':
+		return time.Date(y, mo, d, t.Hour(), 0, 0, 0, t.Location()), true
+	case 'd':
+		return time.Date(y, mo, d, 0, 0, 0, 0, t.Location()), true
+	case 'w':
+		return time.Date(y, mo, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location()), true
+	case 'M':
+		return time.Date(y, mo, 1, 0, 0, 0, 0, t.Location()), true
+	case 'y':
+		return time.Date(y, time.January, 1, 0, 0, 0, 0, t.Location()), true
+	}
+	return time.Time{}, false
+}
+
+// ParseLocation parses a time zone given as UTC, Z, a numeric offset such as
+// +08:00 or -0500, or an IANA name such as Asia/Shanghai. It returns nil if s
+// is none of these.
+func ParseLocation(s string) *time.Location {
+	switch {
+	case s == "UTC" || s == "Z":
+		return time.UTC
+	case len(s) > 0 && (s[0] == '+' || s[0] == '-'):
+		for _, layout := range []string{"-07:00", "-0700", "-07"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				_, offset := t.Zone()
+				return time.FixedZone(s, offset)
 			}
-			return time.Now().Add(duration)
+		}
+	case strings.Contains(s, "/"):
+		if loc, err := time.LoadLocation(s); err == nil {
+			return loc
 		}
 	}
-	return time.Time{}
+	return nil
+}
+
+// parseEpoch parses s as a Unix timestamp. Values of more than 10 digits are
+// taken as milliseconds, others as seconds.
+func parseEpoch(s string) (time.Time, bool) {
+	if s == "" || len(s) > 13 {
+		return time.Time{}, false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return time.Time{}, false
+		}
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if len(s) > 10 {
+		return time.Unix(v/1000, (v%1000)*int64(time.Millisecond)), true
+	}
+	return time.Unix(v, 0), true
 }
 
 func CloseWith(closer io.Closer) {
@@ -69,20 +269,26 @@ func GroupBy(seacher Searcher, ctx context.Context, startAt, endAt time.Time, q
 	cb func(map[string]uint64) error) error {
 	dict, err := seacher.FieldDict(ctx, startAt, endAt, field)
 	if err != nil {
-		if err == bleve.ErrorAliasEmpty {
+		if errors.Is(err, ErrIndexNotFound) {
 			return cb(map[string]uint64{})
 		}
-		return errors.New("read field dictionary fail," + err.Error())
+		return fmt.Errorf("read field dictionary fail, %w", err)
 	}
 
 	// validate the query
 	if srqv, ok := q.(query.ValidatableQuery); ok {
 		err := srqv.Validate()
 		if err != nil {
-			return errors.New("error validating query: " + err.Error())
+			return fmt.Errorf("error validating query: %w", err)
 		}
 	}
 
+	// Fields without a dictionary, such as booleans, are counted with a terms
+	// facet instead.
+	if len(dict) == 0 {
+		return groupByFacet(seacher, ctx, startAt, endAt, q, field, cb)
+	}
+
 	var stats = map[string]uint64{}
 	for _, entry := range dict {
 		var termQuery = bleve.NewTermQuery(entry.Term)
@@ -95,16 +301,45 @@ func GroupBy(seacher Searcher, ctx context.Context, startAt, endAt time.Time, q
 				return nil
 			})
 		if err != nil {
-			return errors.New("error executing query: " + err.Error())
+			return fmt.Errorf("error executing query: %w", err)
 		}
 	}
 
 	return cb(stats)
 }
 
-func GroupByTime(seacher Searcher, ctx context.Context, startAt, endAt time.Time, q query.Query, field string, value time.Duration,
+// groupByFacet counts the documents matching q by the terms of field, with a
+// terms facet. It returns ErrFieldNotFound if no document has the field.
+func groupByFacet(seacher Searcher, ctx context.Context, startAt, endAt time.Time, q query.Query, field string,
+	cb func(map[string]uint64) error) error {
+	searchRequest := bleve.NewSearchRequest(q)
+	searchRequest.Size = 0
+	searchRequest.AddFacet(field, bleve.NewFacetRequest(field, math.MaxInt32))
+
+	var stats = map[string]uint64{}
+	err := seacher.Query(ctx, startAt, endAt, searchRequest,
+		func(req *bleve.SearchRequest, resp *bleve.SearchResult) error {
+			for _, facet := range resp.Facets {
+				for _, term := range facet.Terms {
+					stats[term.Term] += uint64(term.Count)
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return fmt.Errorf("error executing query: %w", err)
+	}
+	if len(stats) == 0 {
+		return fmt.Errorf("group by %s: %w", field, ErrFieldNotFound)
+	}
+	return cb(stats)
+}
+
+// GroupByTime counts the documents matching q by buckets of value of field,
+// from startAt, or aligned on midnight in loc if set.
+func GroupByTime(seacher Searcher, ctx context.Context, startAt, endAt time.Time, q query.Query, field string, value time.Duration, loc *time.Location,
 	cb func(req *bleve.SearchRequest, resp *bleve.SearchResult, results []*search.DateRangeFacet) error) error {
-	facetRequest, err := facetByTime(startAt, endAt, field, value)
+	facetRequest, err := facetByTime(timeBuckets(startAt, endAt, value, loc), field)
 	if err != nil {
 		return err
 	}
@@ -118,7 +353,7 @@ func GroupByTime(seacher Searcher, ctx context.Context, startAt, endAt time.Time
 	if srqv, ok := searchRequest.Query.(query.ValidatableQuery); ok {
 		err := srqv.Validate()
 		if err != nil {
-			return errors.New("error validating query: " + err.Error())
+			return fmt.Errorf("error validating query: %w", err)
 		}
 	}
 
@@ -152,29 +387,196 @@ func GroupByTime(seacher Searcher, ctx context.Context, startAt, endAt time.Time
 					return true
 				}
 
-				return strings.Compare(*results[a].Start, *results[b].Start) < 0
+				// Bucket starts may differ in offset around DST changes,
+				// so are compared as times.
+				ta, erra := time.Parse(time.RFC3339Nano, *results[a].Start)
+				tb, errb := time.Parse(time.RFC3339Nano, *results[b].Start)
+				if erra != nil || errb != nil {
+					return strings.Compare(*results[a].Start, *results[b].Start) < 0
+				}
+				return ta.Before(tb)
 			})
 			return cb(req, resp, results)
 		})
 }
 
-func facetByTime(startAt, endAt time.Time, field string, value time.Duration) (*bleve.FacetRequest, error) {
-	facetRequest := bleve.NewFacetRequest(field, math.MaxInt32)
+// DefaultMatrixTerms is the number of terms counted by GroupByTimeAndField
+// when not given.
+const DefaultMatrixTerms = 10
+
+// CountMatrix counts documents by time bucket and by the most frequent terms
+// of a field, as shown by a heatmap.
+type CountMatrix struct {
+	Field    string      `json:"field"`
+	Buckets  []time.Time `json:"buckets"` // Start of the buckets, which last Interval unless aligned on a time zone.
+	Interval string      `json:"interval"`
+	Terms    []string    `json:"terms"`  // Counted terms, most frequent first.
+	Counts   [][]uint64  `json:"counts"` // Counts[i][j] is the count of Terms[i] in Buckets[j].
+	Totals   []uint64    `json:"totals"` // Count of all documents in each bucket, other terms included.
+}
+
+// GroupByTimeAndField counts the documents matching q by buckets of value of
+// timeField, laid out as GroupByTime does, and by the size most frequent
+// terms of field. A single search finds the terms and the bucket totals, then
+// one date range facet per term counts its buckets.
+func GroupByTimeAndField(seacher Searcher, ctx context.Context, startAt, endAt time.Time, q query.Query,
+	timeField string, value time.Duration, loc *time.Location, field string, size int, cb func(*CountMatrix) error) error {
+	if value <= 0 {
+		return errors.New("bucket interval must be positive")
+	}
+	if size <= 0 {
+		size = DefaultMatrixTerms
+	}
+	bounds := timeBuckets(startAt, endAt, value, loc)
+	facetRequest, err := facetByTime(bounds, timeField)
+	if err != nil {
+		return err
+	}
+
+	matrix := &CountMatrix{
+		Field:    field,
+		Buckets:  []time.Time{},
+		Interval: value.String(),
+		Terms:    []string{},
+		Counts:   [][]uint64{},
+	}
+	buckets := map[string]int{}
+	for i := 1; i < len(bounds); i++ {
+		buckets[bucketName(bounds[i-1], bounds[i])] = len(matrix.Buckets)
+		matrix.Buckets = append(matrix.Buckets, bounds[i-1])
+	}
+	matrix.Totals = make([]uint64, len(matrix.Buckets))
+
+	searchRequest := bleve.NewSearchRequest(q)
+	searchRequest.Size = 0
+	searchRequest.AddFacet(timeField, facetRequest)
+	searchRequest.AddFacet(field, bleve.NewFacetRequest(field, size))
+	if srqv, ok := searchRequest.Query.(query.ValidatableQuery); ok {
+		if err := srqv.Validate(); err != nil {
+			return fmt.Errorf("error validating query: %w", err)
+		}
+	}
+
+	err = seacher.Query(ctx, startAt, endAt, searchRequest,
+		func(req *bleve.SearchRequest, resp *bleve.SearchResult) error {
+			countBuckets(resp.Facets[timeField], buckets, matrix.Totals)
+			if facet := resp.Facets[field]; facet != nil {
+				for _, term := range facet.Terms {
+					matrix.Terms = append(matrix.Terms, term.Term)
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		if errors.Is(err, ErrIndexNotFound) {
+			return cb(matrix)
+		}
+		return err
+	}
 
-	nextStart := startAt
-	nextEnd := startAt.Add(value)
+	for _, term := range matrix.Terms {
+		termQuery := bleve.NewTermQuery(term)
+		termQuery.SetField(field)
 
-	for nextEnd.Before(endAt) {
-		name := strconv.FormatInt(nextStart.Unix(), 10) +
-			"-" +
-			strconv.FormatInt(nextEnd.Unix(), 10)
+		searchRequest := bleve.NewSearchRequest(termQuery)
+		if q != nil {
+			searchRequest = bleve.NewSearchRequest(bleve.NewConjunctionQuery(q, termQuery))
+		}
+		searchRequest.Size = 0
+		searchRequest.AddFacet(timeField, facetRequest)
 
-		facetRequest.AddDateTimeRange(name, nextStart, nextEnd)
+		counts := make([]uint64, len(matrix.Buckets))
+		err := seacher.Query(ctx, startAt, endAt, searchRequest,
+			func(req *bleve.SearchRequest, resp *bleve.SearchResult) error {
+				countBuckets(resp.Facets[timeField], buckets, counts)
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+		matrix.Counts = append(matrix.Counts, counts)
+	}
+	return cb(matrix)
+}
 
-		nextStart = nextEnd
-		nextEnd = nextStart.Add(value)
+// countBuckets adds the counts of the date ranges of facet, named by
+// bucketName, to the counts of their buckets.
+func countBuckets(facet *search.FacetResult, buckets map[string]int, counts []uint64) {
+	if facet == nil {
+		return
+	}
+	for _, r := range facet.DateRanges {
+		if idx, ok := buckets[r.Name]; ok {
+			counts[idx] += uint64(r.Count)
+		}
 	}
+}
+
+// bucketName names the date range of a time bucket.
+func bucketName(start, end time.Time) string {
+	return strconv.FormatInt(start.Unix(), 10) + "-" + strconv.FormatInt(end.Unix(), 10)
+}
+
+// timeBuckets returns the boundaries of the buckets of value from startAt,
+// the last bucket ending before endAt. If loc is set, the buckets are aligned
+// on its wall clock instead, the first being the one startAt falls in: buckets
+// of whole days start at midnight, and shorter ones at multiples of value from
+// each midnight. The last bucket of a day is cut short if value does not
+// divide it, and buckets across a DST change are an hour shorter or longer.
+func timeBuckets(startAt, endAt time.Time, value time.Duration, loc *time.Location) []time.Time {
+	next := func(t time.Time) time.Time { return t.Add(value) }
+	at := startAt
+	if loc != nil {
+		const day = 24 * time.Hour
+		// clock returns the time of the day of t, days later, at wall clock d.
+		clock := func(t time.Time, days int, d time.Duration) time.Time {
+			y, m, dd := t.Date()
+			return time.Date(y, m, dd+days, int(d/time.Hour), int(d%time.Hour/time.Minute),
+				int(d%time.Minute/time.Second), int(d%time.Second), loc)
+		}
+		// elapsed returns the wall clock of t since its midnight.
+		elapsed := func(t time.Time) time.Duration {
+			h, m, sec := t.Clock()
+			return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute +
+				time.Duration(sec)*time.Second + time.Duration(t.Nanosecond())
+		}
+
+		start := startAt.In(loc)
+		if value%day == 0 {
+			at = clock(start, 0, 0)
+			next = func(t time.Time) time.Time { return clock(t, int(value/day), 0) }
+		} else {
+			at = clock(start, 0, elapsed(start)/value*value)
+			next = func(t time.Time) time.Time {
+				end := clock(t, 1, 0)
+				for k := elapsed(t)/value + 1; k*value < day; k++ {
+					// Wall clocks skipped by DST are moved forward, and
+					// may not be after t.
+					if n := clock(t, 0, k*value); n.After(t) {
+						if n.Before(end) {
+							return n
+						}
+						break
+					}
+				}
+				return end
+			}
+		}
+	}
+
+	bounds := []time.Time{at}
+	for at = next(at); at.Before(endAt); at = next(at) {
+		bounds = append(bounds, at)
+	}
+	return bounds
+}
 
+// facetByTime returns a facet of field counting the buckets between bounds.
+func facetByTime(bounds []time.Time, field string) (*bleve.FacetRequest, error) {
+	facetRequest := bleve.NewFacetRequest(field, math.MaxInt32)
+	for i := 1; i < len(bounds); i++ {
+		facetRequest.AddDateTimeRange(bucketName(bounds[i-1], bounds[i]), bounds[i-1], bounds[i])
+	}
 	return facetRequest, nil
 }
 
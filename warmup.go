@@ -0,0 +1,110 @@
+package ekanite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// warmupSize is the number of hits fetched by each warm-up query. Sorting them
+// reads the whole sort field, so few are needed to load its pages.
+const warmupSize = 10
+
+// ParseWarmupQueries parses a semicolon-separated list of warm-up queries,
+// each in the query string syntax. An empty query, or *, matches all
+// documents.
+func ParseWarmupQueries(s string) ([]string, error) {
+	var queries []string
+	for _, q := range strings.Split(s, ";") {
+		q = strings.TrimSpace(q)
+		if q == "" {
+			continue
+		}
+		if _, err := warmupQuery(q); err != nil {
+			return nil, fmt.Errorf("invalid warm-up query '%s': %w", q, err)
+		}
+		queries = append(queries, q)
+	}
+	return queries, nil
+}
+
+// warmupQuery returns the bleve query of a warm-up query string.
+func warmupQuery(s string) (query.Query, error) {
+	if s == "" || s == "*" {
+		return bleve.NewMatchAllQuery(), nil
+	}
+	q := bleve.NewQueryStringQuery(s)
+	if _, err := q.Parse(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// WarmIndexes runs the WarmupQueries against each open index, sorted by
+// DefaultSort, so that the pages the searches read are cached before they
+// are needed. It is run in the background when the engine is opened, indexes
+// then being read from disk for the first time.
+func (e *Engine) WarmIndexes(ctx context.Context) error {
+	if len(e.WarmupQueries) == 0 {
+		return nil
+	}
+	e.mu.RLock()
+	indexes := make([]*Index, len(e.indexes))
+	copy(indexes, e.indexes)
+	e.mu.RUnlock()
+
+	for _, i := range indexes {
+		for _, s := range e.WarmupQueries {
+			if err := e.maintenanceStep(ctx, 0); err != nil {
+				return err
+			}
+			if err := e.warmIndex(ctx, i, s); err != nil {
+				return fmt.Errorf("failed to warm up index %s: %w", i.Path(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// warmIndex runs a warm-up query against the index, unless it has been deleted
+// since.
+func (e *Engine) warmIndex(ctx context.Context, i *Index, s string) error {
+	q, err := warmupQuery(s)
+	if err != nil {
+		return err
+	}
+	req := NewSearchRequest(q, nil, DefaultSort)
+	req.Size = warmupSize
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.indexByName(i.Name()) != i {
+		return nil
+	}
+	if _, err := i.Alias.SearchInContext(ctx, req); err != nil {
+		return wrapQueryError(ctx, err)
+	}
+	stats.Add("warmupQueries", 1)
+	return nil
+}
+
+// runWarmup warms the indexes up, until done or the engine is closed.
+func (e *Engine) runWarmup() {
+	defer e.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-e.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := e.WarmIndexes(ctx); err != nil && ctx.Err() == nil {
+		e.Logger.Println(err.Error())
+	}
+}